@@ -0,0 +1,163 @@
+// Package cors is the CORS middleware shared by todo-service and
+// image-service.
+package cors
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+type Options struct {
+	// AllowedOrigins holds exact origins ("https://app.example.com"),
+	// wildcard subdomain patterns ("https://*.example.com") or "*" for any.
+	AllowedOrigins   []string
+	AllowedMethods   []string
+	AllowedHeaders   []string
+	ExposedHeaders   []string
+	AllowCredentials bool
+	MaxAge           time.Duration
+}
+
+// ValidateOrigins checks an ALLOWED_ORIGINS list. "*" cannot be combined
+// with credentials, as that would let any site make requests with the
+// user's cookies.
+func ValidateOrigins(origins []string, allowCredentials bool) error {
+	var errs []error
+	for _, origin := range origins {
+		if origin == "*" {
+			if allowCredentials {
+				errs = append(errs, errors.New(`ALLOWED_ORIGINS "*" cannot be combined with CORS_ALLOW_CREDENTIALS, list the origins instead`))
+			}
+			continue
+		}
+		u, err := url.Parse(strings.Replace(origin, "://*.", "://", 1))
+		if err != nil || u.Scheme == "" || u.Host == "" || (u.Path != "" && u.Path != "/") {
+			errs = append(errs, fmt.Errorf("ALLOWED_ORIGINS entry %q is not a valid origin", origin))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+type originPattern struct {
+	exact  string
+	scheme string
+	suffix string
+}
+
+func (p originPattern) matches(origin string) bool {
+	if p.exact != "" {
+		return origin == p.exact
+	}
+	host, ok := strings.CutPrefix(origin, p.scheme+"://")
+	return ok && len(host) > len(p.suffix) && strings.HasSuffix(host, p.suffix)
+}
+
+type OriginMatcher struct {
+	patterns []originPattern
+	allowAny bool
+}
+
+// NewOriginMatcher parses an allow-list of exact origins, wildcard
+// subdomain patterns and "*".
+func NewOriginMatcher(origins []string) OriginMatcher {
+	var m OriginMatcher
+	for _, origin := range origins {
+		origin = strings.ToLower(strings.TrimRight(strings.TrimSpace(origin), "/"))
+		switch {
+		case origin == "":
+			continue
+		case origin == "*":
+			m.allowAny = true
+		case strings.Contains(origin, "://*."):
+			scheme, host, _ := strings.Cut(origin, "://*")
+			m.patterns = append(m.patterns, originPattern{scheme: scheme, suffix: host})
+		default:
+			m.patterns = append(m.patterns, originPattern{exact: origin})
+		}
+	}
+	return m
+}
+
+func (m OriginMatcher) Allows(origin string) bool {
+	return m.allowAny || m.listed(origin)
+}
+
+// listed reports whether origin matches an entry other than "*".
+func (m OriginMatcher) listed(origin string) bool {
+	origin = strings.ToLower(origin)
+	for _, p := range m.patterns {
+		if p.matches(origin) {
+			return true
+		}
+	}
+	return false
+}
+
+func Middleware(opts Options) gin.HandlerFunc {
+	origins := NewOriginMatcher(opts.AllowedOrigins)
+	allowedMethods := strings.Join(opts.AllowedMethods, ", ")
+	allowedHeaders := strings.Join(opts.AllowedHeaders, ", ")
+	exposedHeaders := strings.Join(opts.ExposedHeaders, ", ")
+	maxAge := strconv.Itoa(int(opts.MaxAge.Seconds()))
+
+	return func(c *gin.Context) {
+		origin := c.GetHeader("Origin")
+		// Every OPTIONS request is answered here with 204, as no route
+		// handles them; only preflights from allowed origins get the
+		// CORS headers.
+		options := c.Request.Method == http.MethodOptions
+		preflight := options && c.GetHeader("Access-Control-Request-Method") != ""
+
+		c.Writer.Header().Add("Vary", "Origin")
+
+		if origin == "" || !origins.Allows(origin) {
+			if options {
+				c.AbortWithStatus(http.StatusNoContent)
+				return
+			}
+			c.Next()
+			return
+		}
+
+		// An origin let in by "*" alone gets a literal "*", which browsers
+		// never send cookies to, so credentials only reach listed origins
+		// even if ValidateOrigins was skipped.
+		if origins.listed(origin) {
+			c.Header("Access-Control-Allow-Origin", origin)
+			if opts.AllowCredentials {
+				c.Header("Access-Control-Allow-Credentials", "true")
+			}
+		} else {
+			c.Header("Access-Control-Allow-Origin", "*")
+		}
+
+		if preflight {
+			c.Writer.Header().Add("Vary", "Access-Control-Request-Method")
+			c.Writer.Header().Add("Vary", "Access-Control-Request-Headers")
+			c.Header("Access-Control-Allow-Methods", allowedMethods)
+			c.Header("Access-Control-Allow-Headers", allowedHeaders)
+			if opts.MaxAge > 0 {
+				c.Header("Access-Control-Max-Age", maxAge)
+			}
+			c.AbortWithStatus(http.StatusNoContent)
+			return
+		}
+
+		if exposedHeaders != "" {
+			c.Header("Access-Control-Expose-Headers", exposedHeaders)
+		}
+
+		if options {
+			c.AbortWithStatus(http.StatusNoContent)
+			return
+		}
+		c.Next()
+	}
+}
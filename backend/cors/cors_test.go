@@ -0,0 +1,192 @@
+package cors
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestOriginMatcher(t *testing.T) {
+	tests := []struct {
+		name    string
+		allowed []string
+		origin  string
+		want    bool
+	}{
+		{"exact", []string{"https://app.example.com"}, "https://app.example.com", true},
+		{"exact ignores case and trailing slash", []string{"https://App.example.com/"}, "https://app.EXAMPLE.com", true},
+		{"exact checks the scheme", []string{"https://app.example.com"}, "http://app.example.com", false},
+		{"exact checks the port", []string{"https://app.example.com"}, "https://app.example.com:8443", false},
+		{"wildcard subdomain", []string{"https://*.example.com"}, "https://a.b.example.com", true},
+		{"wildcard needs a subdomain", []string{"https://*.example.com"}, "https://example.com", false},
+		{"wildcard checks the dot", []string{"https://*.example.com"}, "https://badexample.com", false},
+		{"wildcard checks the scheme", []string{"https://*.example.com"}, "http://a.example.com", false},
+		{"any", []string{"*"}, "https://anything.test", true},
+		{"not listed", []string{"https://app.example.com"}, "https://evil.test", false},
+		{"empty list", nil, "https://app.example.com", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := NewOriginMatcher(tt.allowed).Allows(tt.origin); got != tt.want {
+				t.Errorf("Allows(%q) with %q = %v, want %v", tt.origin, tt.allowed, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestValidateOrigins(t *testing.T) {
+	tests := []struct {
+		name        string
+		origins     []string
+		credentials bool
+		wantErr     string
+	}{
+		{"exact and wildcard", []string{"https://a.example", "https://*.b.example"}, true, ""},
+		{"any without credentials", []string{"*"}, false, ""},
+		{"any with credentials", []string{"https://a.example", "*"}, true, "cannot be combined"},
+		{"no scheme", []string{"a.example"}, false, `"a.example" is not a valid origin`},
+		{"path", []string{"https://a.example/app"}, false, "is not a valid origin"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := ValidateOrigins(tt.origins, tt.credentials)
+			if tt.wantErr == "" {
+				if err != nil {
+					t.Errorf("ValidateOrigins = %v, want nil", err)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("ValidateOrigins = %v, want an error containing %q", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestMiddleware(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	tests := []struct {
+		name        string
+		allowed     []string
+		credentials bool
+		method      string
+		origin      string
+		preflight   bool
+		wantStatus  int
+		wantOrigin  string
+		wantCreds   bool
+		wantVary    []string
+	}{
+		{
+			name: "exact origin", allowed: []string{"https://app.example.com"},
+			method: http.MethodGet, origin: "https://app.example.com",
+			wantStatus: http.StatusOK, wantOrigin: "https://app.example.com", wantVary: []string{"Origin"},
+		},
+		{
+			name: "wildcard origin with credentials", allowed: []string{"https://*.example.com"}, credentials: true,
+			method: http.MethodGet, origin: "https://a.example.com",
+			wantStatus: http.StatusOK, wantOrigin: "https://a.example.com", wantCreds: true, wantVary: []string{"Origin"},
+		},
+		{
+			name: "any origin", allowed: []string{"*"},
+			method: http.MethodGet, origin: "https://anything.test",
+			wantStatus: http.StatusOK, wantOrigin: "*", wantVary: []string{"Origin"},
+		},
+		{
+			// Config validation rejects this, but the middleware must not
+			// hand out credentials to any site either way.
+			name: "any origin never gets credentials", allowed: []string{"*"}, credentials: true,
+			method: http.MethodGet, origin: "https://evil.test",
+			wantStatus: http.StatusOK, wantOrigin: "*", wantVary: []string{"Origin"},
+		},
+		{
+			name: "listed origin keeps credentials next to any", allowed: []string{"*", "https://app.example.com"}, credentials: true,
+			method: http.MethodGet, origin: "https://app.example.com",
+			wantStatus: http.StatusOK, wantOrigin: "https://app.example.com", wantCreds: true, wantVary: []string{"Origin"},
+		},
+		{
+			name: "rejected origin", allowed: []string{"https://app.example.com"}, credentials: true,
+			method: http.MethodGet, origin: "https://evil.test",
+			wantStatus: http.StatusOK, wantVary: []string{"Origin"},
+		},
+		{
+			name: "no origin", allowed: []string{"*"},
+			method: http.MethodGet, wantStatus: http.StatusOK, wantVary: []string{"Origin"},
+		},
+		{
+			name: "preflight", allowed: []string{"https://app.example.com"},
+			method: http.MethodOptions, origin: "https://app.example.com", preflight: true,
+			wantStatus: http.StatusNoContent, wantOrigin: "https://app.example.com",
+			wantVary: []string{"Origin", "Access-Control-Request-Method", "Access-Control-Request-Headers"},
+		},
+		{
+			name: "rejected preflight", allowed: []string{"https://app.example.com"},
+			method: http.MethodOptions, origin: "https://evil.test", preflight: true,
+			wantStatus: http.StatusNoContent, wantVary: []string{"Origin"},
+		},
+		{
+			name: "options without preflight", allowed: []string{"https://app.example.com"},
+			method: http.MethodOptions, origin: "https://app.example.com",
+			wantStatus: http.StatusNoContent, wantOrigin: "https://app.example.com", wantVary: []string{"Origin"},
+		},
+		{
+			name: "options without origin", allowed: []string{"https://app.example.com"},
+			method: http.MethodOptions, wantStatus: http.StatusNoContent, wantVary: []string{"Origin"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			router := gin.New()
+			router.Use(Middleware(Options{
+				AllowedOrigins:   tt.allowed,
+				AllowedMethods:   []string{"GET", "POST"},
+				AllowedHeaders:   []string{"Content-Type"},
+				ExposedHeaders:   []string{"ETag"},
+				AllowCredentials: tt.credentials,
+				MaxAge:           10 * time.Minute,
+			}))
+			router.GET("/", func(c *gin.Context) { c.Status(http.StatusOK) })
+
+			req := httptest.NewRequest(tt.method, "/", nil)
+			if tt.origin != "" {
+				req.Header.Set("Origin", tt.origin)
+			}
+			if tt.preflight {
+				req.Header.Set("Access-Control-Request-Method", "POST")
+			}
+			w := httptest.NewRecorder()
+			router.ServeHTTP(w, req)
+
+			h := w.Header()
+			if w.Code != tt.wantStatus {
+				t.Errorf("status = %d, want %d", w.Code, tt.wantStatus)
+			}
+			if got := h.Get("Access-Control-Allow-Origin"); got != tt.wantOrigin {
+				t.Errorf("Access-Control-Allow-Origin = %q, want %q", got, tt.wantOrigin)
+			}
+			if got := h.Get("Access-Control-Allow-Credentials") == "true"; got != tt.wantCreds {
+				t.Errorf("Access-Control-Allow-Credentials set = %v, want %v", got, tt.wantCreds)
+			}
+			if got := h.Values("Vary"); !slices.Equal(got, tt.wantVary) {
+				t.Errorf("Vary = %q, want %q", got, tt.wantVary)
+			}
+
+			allowed := tt.wantOrigin != ""
+			if got := h.Get("Access-Control-Allow-Methods") != ""; got != (allowed && tt.preflight) {
+				t.Errorf("Access-Control-Allow-Methods set = %v", got)
+			}
+			if tt.preflight && allowed && h.Get("Access-Control-Max-Age") != "600" {
+				t.Errorf("Access-Control-Max-Age = %q, want 600", h.Get("Access-Control-Max-Age"))
+			}
+			if got := h.Get("Access-Control-Expose-Headers") != ""; got != (allowed && !tt.preflight) {
+				t.Errorf("Access-Control-Expose-Headers set = %v", got)
+			}
+		})
+	}
+}
@@ -0,0 +1,179 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"cors"
+)
+
+type Config struct {
+	Port                 string     `json:"port"`
+	ImageDir             string     `json:"image_dir"`
+	ImageURL             string     `json:"image_url"`
+	CachedImageName      string     `json:"cached_image_name"`
+	CacheDurationMinutes int        `json:"cache_duration_minutes"`
+	Cors                 CorsConfig `json:"cors"`
+}
+
+type CorsConfig struct {
+	AllowedOrigins   []string `json:"allowed_origins"`
+	AllowCredentials bool     `json:"allow_credentials"`
+	MaxAgeSeconds    int      `json:"max_age_seconds"`
+}
+
+func defaultConfig() Config {
+	return Config{
+		ImageURL:             "https://picsum.photos/300",
+		CachedImageName:      "current_image.jpg",
+		CacheDurationMinutes: 10,
+		Cors: CorsConfig{
+			AllowedOrigins: []string{"*"},
+			MaxAgeSeconds:  600,
+		},
+	}
+}
+
+// LoadConfig builds the service configuration from defaults, the optional
+// JSON config file at path and finally the environment, which always wins.
+func LoadConfig(path string) (*Config, error) {
+	cfg := defaultConfig()
+
+	if path != "" {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read config file: %w", err)
+		}
+		if err := json.Unmarshal(data, &cfg); err != nil {
+			return nil, fmt.Errorf("failed to parse config file %s: %w", path, err)
+		}
+	}
+
+	var errs []error
+	setString(&cfg.Port, "PORT")
+	setList(&cfg.Cors.AllowedOrigins, "ALLOWED_ORIGINS")
+	errs = append(errs, setBool(&cfg.Cors.AllowCredentials, "CORS_ALLOW_CREDENTIALS"))
+	errs = append(errs, setInt(&cfg.Cors.MaxAgeSeconds, "CORS_MAX_AGE_SECONDS"))
+	setString(&cfg.ImageDir, "IMAGE_DIR")
+	setString(&cfg.ImageURL, "IMAGE_URL")
+	setString(&cfg.CachedImageName, "CACHED_IMAGE_NAME")
+	errs = append(errs, setInt(&cfg.CacheDurationMinutes, "CACHE_DURATION_MINUTES"))
+
+	if cfg.ImageDir == "" {
+		cwd, _ := os.Getwd()
+		cfg.ImageDir = filepath.Join(filepath.Dir(cwd), "image")
+	}
+
+	errs = append(errs, cfg.validate())
+	if err := errors.Join(errs...); err != nil {
+		return nil, fmt.Errorf("invalid configuration:\n%w", err)
+	}
+	return &cfg, nil
+}
+
+func (c Config) validate() error {
+	var errs []error
+
+	if c.Port == "" {
+		errs = append(errs, errors.New("PORT must be set"))
+	} else if p, err := strconv.Atoi(c.Port); err != nil || p <= 0 || p > 65535 {
+		errs = append(errs, fmt.Errorf("PORT must be a valid port number, got %q", c.Port))
+	}
+	if _, err := url.ParseRequestURI(c.ImageURL); err != nil {
+		errs = append(errs, fmt.Errorf("IMAGE_URL is not a valid URL: %q", c.ImageURL))
+	}
+	if c.CachedImageName == "" || filepath.Base(c.CachedImageName) != c.CachedImageName {
+		errs = append(errs, fmt.Errorf("CACHED_IMAGE_NAME must be a plain file name, got %q", c.CachedImageName))
+	}
+	if c.CacheDurationMinutes <= 0 {
+		errs = append(errs, fmt.Errorf("CACHE_DURATION_MINUTES must be positive, got %d", c.CacheDurationMinutes))
+	}
+	errs = append(errs, c.Cors.validate())
+	return errors.Join(errs...)
+}
+
+func (c CorsConfig) validate() error {
+	errs := []error{cors.ValidateOrigins(c.AllowedOrigins, c.AllowCredentials)}
+	if c.MaxAgeSeconds < 0 {
+		errs = append(errs, fmt.Errorf("CORS_MAX_AGE_SECONDS must not be negative, got %d", c.MaxAgeSeconds))
+	}
+	return errors.Join(errs...)
+}
+
+func (c CorsConfig) Options() cors.Options {
+	return cors.Options{
+		AllowedOrigins:   c.AllowedOrigins,
+		AllowedMethods:   []string{"GET", "POST", "PUT", "PATCH", "DELETE", "OPTIONS"},
+		AllowedHeaders:   []string{"Content-Type", "Authorization"},
+		AllowCredentials: c.AllowCredentials,
+		MaxAge:           time.Duration(c.MaxAgeSeconds) * time.Second,
+	}
+}
+
+func (c Config) CacheDuration() time.Duration {
+	return time.Duration(c.CacheDurationMinutes) * time.Minute
+}
+
+func printConfig(cfg *Config) error {
+	// image-service has no secrets to redact.
+	out, err := json.MarshalIndent(cfg, "", "  ")
+	if err != nil {
+		return err
+	}
+	_, err = fmt.Println(string(out))
+	return err
+}
+
+func setString(dst *string, key string) {
+	if value, ok := os.LookupEnv(key); ok && value != "" {
+		*dst = value
+	}
+}
+
+// setList reads a comma-separated environment variable into dst.
+func setList(dst *[]string, key string) {
+	value, ok := os.LookupEnv(key)
+	if !ok || strings.TrimSpace(value) == "" {
+		return
+	}
+	var items []string
+	for _, item := range strings.Split(value, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	*dst = items
+}
+
+func setBool(dst *bool, key string) error {
+	value, ok := os.LookupEnv(key)
+	if !ok || value == "" {
+		return nil
+	}
+	b, err := strconv.ParseBool(value)
+	if err != nil {
+		return fmt.Errorf("%s must be a boolean, got %q", key, value)
+	}
+	*dst = b
+	return nil
+}
+
+func setInt(dst *int, key string) error {
+	value, ok := os.LookupEnv(key)
+	if !ok || value == "" {
+		return nil
+	}
+	n, err := strconv.Atoi(value)
+	if err != nil {
+		return fmt.Errorf("%s must be an integer, got %q", key, value)
+	}
+	*dst = n
+	return nil
+}
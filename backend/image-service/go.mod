@@ -3,6 +3,7 @@ module image-service
 go 1.24.3
 
 require (
+	cors v0.0.0
 	github.com/gin-contrib/logger v1.2.6
 	github.com/gin-gonic/gin v1.10.1
 	github.com/rs/zerolog v1.34.0
@@ -37,3 +38,5 @@ require (
 	google.golang.org/protobuf v1.36.6 // indirect
 	gopkg.in/yaml.v3 v3.0.1 // indirect
 )
+
+replace cors => ../cors
@@ -29,6 +29,8 @@ func (c *ImageController) welcome(ctx *gin.Context) {
 		"Endpoints": []string{
 			"GET api/image/current - Get cached image info",
 			"POST api/image/shutdown - Shutdown the server",
+			"GET /openapi.json - OpenAPI specification",
+			"GET /docs - Interactive API documentation",
 		},
 	})
 }
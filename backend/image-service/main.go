@@ -1,54 +1,63 @@
 package main
 
 import (
+	"flag"
 	"os"
-	"path/filepath"
 
+	"cors"
 	"github.com/gin-gonic/gin"
 	"github.com/rs/zerolog/log"
 )
 
 func main() {
-	port := os.Getenv("PORT")
-	if port == "" {
-		log.Fatal().Msg("$PORT must be set")
-	}
+	configPath := flag.String("config", os.Getenv("CONFIG_FILE"), "path to an optional JSON config file")
+	showConfig := flag.Bool("print-config", false, "print the effective configuration and exit")
+	flag.Parse()
 
-	imageDirectory := os.Getenv("IMAGE_DIR")
-	imageUrl := getEnvWithDefault("IMAGE_URL", "https://picsum.photos/300")
-	cachedImageName := getEnvWithDefault("CACHED_IMAGE_NAME", "current_image.jpg")
-	imageCacheDuration := getCacheDuration()
+	cfg, err := LoadConfig(*configPath)
+	if err != nil {
+		log.Fatal().Err(err).Msg("Failed to load configuration")
+	}
 
-	if imageDirectory == "" {
-		cwd, _ := os.Getwd()
-		parentDir := filepath.Dir(cwd)
-		imageDirectory = filepath.Join(parentDir, "image")
+	if *showConfig {
+		if err := printConfig(cfg); err != nil {
+			log.Fatal().Err(err).Msg("Failed to print configuration")
+		}
+		return
 	}
 
-	err := os.MkdirAll(imageDirectory, 0755)
+	err = os.MkdirAll(cfg.ImageDir, 0755)
 	if err != nil {
 		log.Fatal().Err(err).Msg("Failed to create image directory")
 	}
 
-	repo := NewLocalImageRepository(imageDirectory, cachedImageName, imageUrl, imageCacheDuration)
+	repo := NewLocalImageRepository(cfg.ImageDir, cfg.CachedImageName, cfg.ImageURL, cfg.CacheDuration())
 	controller := NewImageController(repo)
 
+	router := newRouter(cfg, controller)
+
+	log.Info().Str("port", cfg.Port).Msg("Server started")
+
+	err = router.Run(":" + cfg.Port)
+	if err != nil {
+		log.Fatal().Err(err).Msg("Failed to start server:")
+	}
+}
+
+// newRouter registers every route of the service.
+func newRouter(cfg *Config, controller *ImageController) *gin.Engine {
 	router := gin.Default()
 
-	router.Use(CorsMiddleware)
+	router.Use(cors.Middleware(cfg.Cors.Options()))
 
-	router.Static("/api/image/files", imageDirectory)
+	router.Static("/api/image/files", cfg.ImageDir)
 
 	router.GET("/", controller.welcome)
+	router.GET("/openapi.json", serveOpenAPISpec)
+	router.GET("/docs", serveDocs)
 
 	router.GET("/api/image/current", controller.getImageInfo)
 
 	router.POST("/api/image/shutdown", controller.shutdownServer)
-
-	log.Info().Str("port", port).Msg("Server started")
-
-	err = router.Run(":" + port)
-	if err != nil {
-		log.Fatal().Err(err).Msg("Failed to start server:")
-	}
+	return router
 }
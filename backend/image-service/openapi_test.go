@@ -0,0 +1,73 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+)
+
+var ginParamPattern = regexp.MustCompile(`[:*]([A-Za-z0-9_]+)`)
+
+// checkOpenAPICoverage returns an error naming every registered route that
+// has no matching operation in the OpenAPI document.
+func checkOpenAPICoverage(routes gin.RoutesInfo, spec []byte) error {
+	var doc struct {
+		Paths      map[string]map[string]json.RawMessage `json:"paths"`
+		Components struct {
+			PathItems map[string]map[string]json.RawMessage `json:"pathItems"`
+		} `json:"components"`
+	}
+	if err := json.Unmarshal(spec, &doc); err != nil {
+		return fmt.Errorf("failed to parse OpenAPI spec: %w", err)
+	}
+
+	var missing []string
+	for _, route := range routes {
+		path := ginParamPattern.ReplaceAllString(route.Path, "{$1}")
+		item, ok := doc.Paths[path]
+		if ref, isRef := item["$ref"]; isRef {
+			var target string
+			_ = json.Unmarshal(ref, &target)
+			item, ok = doc.Components.PathItems[strings.TrimPrefix(target, "#/components/pathItems/")]
+		}
+		if _, hasOp := item[strings.ToLower(route.Method)]; !ok || !hasOp {
+			missing = append(missing, route.Method+" "+route.Path)
+		}
+	}
+
+	if len(missing) > 0 {
+		return fmt.Errorf("routes missing from openapi.json: %s", strings.Join(missing, ", "))
+	}
+	return nil
+}
+
+func testRouter(cfg *Config) *gin.Engine {
+	gin.SetMode(gin.TestMode)
+	return newRouter(cfg, NewImageController(nil))
+}
+
+// TestOpenAPICoverage fails when a route is added without documenting it
+// in openapi.json.
+func TestOpenAPICoverage(t *testing.T) {
+	cfg := defaultConfig()
+	cfg.ImageDir = t.TempDir()
+	router := testRouter(&cfg)
+	if err := checkOpenAPICoverage(router.Routes(), openAPISpec); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestOpenAPICoverageReportsMissingRoutes(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+	router.GET("/undocumented/:id", func(*gin.Context) {})
+
+	err := checkOpenAPICoverage(router.Routes(), openAPISpec)
+	if err == nil || !strings.Contains(err.Error(), "GET /undocumented/:id") {
+		t.Fatalf("checkOpenAPICoverage = %v, want the undocumented route named", err)
+	}
+}
@@ -0,0 +1,121 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/rs/zerolog/log"
+)
+
+const (
+	defaultAuditLimit = 100
+	maxAuditLimit     = 1000
+)
+
+// repoFor returns the repository to write through for this request, so the
+// audit log knows who made each change.
+func (c *TodosController) repoFor(ctx *gin.Context) TodoRepository {
+	return c.repo.WithAudit(AuditMeta{
+		Actor:     callerID(ctx),
+		RequestID: ctx.GetString(requestIDKey),
+		Source:    ctx.Request.Method + " " + ctx.FullPath(),
+	})
+}
+
+func (c *TodosController) getHistory(ctx *gin.Context) {
+	id, ok := todoIDParam(ctx)
+	if !ok {
+		return
+	}
+
+	events, err := c.repo.GetHistory(id)
+	if err != nil {
+		c.respondTodoError(ctx, id, err, "get history")
+		return
+	}
+
+	log.Info().
+		Str("path", ctx.FullPath()).
+		Int("id", id).
+		Int("count", len(events)).
+		Msg("History received")
+	respondWithMeta(ctx, http.StatusOK, events, gin.H{"count": len(events)}, nil)
+}
+
+func (c *TodosController) getAuditLog(ctx *gin.Context) {
+	filter, apiErr := parseAuditFilter(ctx)
+	if apiErr != nil {
+		respondError(ctx, apiErr)
+		return
+	}
+
+	events, err := c.repo.GetAuditLog(filter)
+	if err != nil {
+		log.Error().Err(err).Msg("Failed to get audit log")
+		respondError(ctx, errInternal(err))
+		return
+	}
+
+	meta := gin.H{"count": len(events)}
+	if len(events) == filter.Limit {
+		meta["next_before"] = events[len(events)-1].ID
+	}
+
+	log.Info().
+		Str("path", ctx.FullPath()).
+		Int("count", len(events)).
+		Msg("Audit log received")
+	respondWithMeta(ctx, http.StatusOK, events, meta, gin.H{"events": events, "meta": meta})
+}
+
+func parseAuditFilter(ctx *gin.Context) (AuditFilter, *APIError) {
+	filter := AuditFilter{
+		Actor:  ctx.Query("actor"),
+		Action: ctx.Query("action"),
+		Source: ctx.Query("source"),
+		Limit:  defaultAuditLimit,
+	}
+
+	var err error
+	if raw := ctx.Query("todo_id"); raw != "" {
+		if filter.TodoID, err = strconv.Atoi(raw); err != nil || filter.TodoID <= 0 {
+			return filter, errInvalidQuery("todo_id", "todo_id must be a positive integer")
+		}
+	}
+	if raw := ctx.Query("before"); raw != "" {
+		if filter.BeforeID, err = strconv.ParseInt(raw, 10, 64); err != nil || filter.BeforeID <= 0 {
+			return filter, errInvalidQuery("before", "before must be a positive event id")
+		}
+	}
+	if raw := ctx.Query("limit"); raw != "" {
+		if filter.Limit, err = strconv.Atoi(raw); err != nil || filter.Limit <= 0 || filter.Limit > maxAuditLimit {
+			return filter, errInvalidQuery("limit", fmt.Sprintf("limit must be between 1 and %d", maxAuditLimit))
+		}
+	}
+	var apiErr *APIError
+	if filter.Since, apiErr = timeQuery(ctx, "since"); apiErr != nil {
+		return filter, apiErr
+	}
+	if filter.Until, apiErr = timeQuery(ctx, "until"); apiErr != nil {
+		return filter, apiErr
+	}
+	if filter.Since != nil && filter.Until != nil && !filter.Until.After(*filter.Since) {
+		return filter, errInvalidQuery("until", "until must be after since")
+	}
+	return filter, nil
+}
+
+func timeQuery(ctx *gin.Context, param string) (*time.Time, *APIError) {
+	raw := ctx.Query(param)
+	if raw == "" {
+		return nil, nil
+	}
+	t, err := time.Parse(time.RFC3339, raw)
+	if err != nil {
+		return nil, errInvalidQuery(param, param+" must be an RFC 3339 timestamp")
+	}
+	return &t, nil
+}
@@ -0,0 +1,231 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"maps"
+	"slices"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/jmoiron/sqlx/types"
+)
+
+const (
+	ActionCreated   = "created"
+	ActionUpdated   = "updated"
+	ActionCompleted = "completed"
+	ActionTrashed   = "trashed"
+	ActionRestored  = "restored"
+	ActionPurged    = "purged"
+)
+
+// AuditMeta says who made a write and through which request.
+type AuditMeta struct {
+	Actor     string
+	RequestID string
+	Source    string
+}
+
+// FieldChange is the old and new value of one field. From is null for
+// fields of a newly created todo.
+type FieldChange struct {
+	From any `json:"from"`
+	To   any `json:"to"`
+}
+
+type TodoEvent struct {
+	ID        int64          `json:"id" db:"id"`
+	TodoID    int            `json:"todo_id" db:"todo_id"`
+	Action    string         `json:"action" db:"action"`
+	Changes   types.JSONText `json:"changes" db:"changes"`
+	Version   int64          `json:"version,omitempty" db:"version"`
+	Actor     string         `json:"actor" db:"actor"`
+	RequestID string         `json:"request_id" db:"request_id"`
+	Source    string         `json:"source" db:"source"`
+	CreatedAt time.Time      `json:"created_at" db:"created_at"`
+}
+
+// AuditFilter narrows GetAuditLog. Zero-valued fields are not applied;
+// BeforeID pages backwards from an event ID.
+type AuditFilter struct {
+	TodoID   int
+	Actor    string
+	Action   string
+	Source   string
+	Since    *time.Time
+	Until    *time.Time
+	BeforeID int64
+	Limit    int
+}
+
+const todoEventColumns = "id, todo_id, action, changes, version, actor, request_id, source, created_at"
+
+// audited runs write in a transaction together with the todo_events row
+// that records it. The todo is locked first so the recorded diff is exact.
+func (t todoRepository) audited(id int, action string, write func(repo todoRepository) (Todo, error)) (Todo, error) {
+	return t.auditedChanges(id, action, func(repo todoRepository) (Todo, map[string]FieldChange, error) {
+		todo, err := write(repo)
+		return todo, nil, err
+	})
+}
+
+// auditedChanges is audited for writes that change more than the todos
+// row. write returns the changes outside the row, which are recorded along
+// with the diff. A write that changed nothing at all records no event.
+func (t todoRepository) auditedChanges(id int, action string, write func(repo todoRepository) (Todo, map[string]FieldChange, error)) (Todo, error) {
+	var after Todo
+	err := t.transaction(func(repo todoRepository) error {
+		var before *Todo
+		if id != 0 {
+			var current Todo
+			err := sqlx.Get(repo.q, &current, "SELECT "+todoColumns+" FROM todos WHERE id = $1 FOR UPDATE", id)
+			switch {
+			case err == nil:
+				before = &current
+			case !errors.Is(err, sql.ErrNoRows):
+				return err
+			}
+		}
+
+		var extra map[string]FieldChange
+		var err error
+		if after, extra, err = write(repo); err != nil {
+			return err
+		}
+		changes := diffTodos(before, after)
+		maps.Copy(changes, extra)
+		if before != nil && before.Version == after.Version && len(changes) == 0 {
+			return nil
+		}
+		return repo.recordEvent(after.ID, action, after.Version, changes)
+	})
+	return after, err
+}
+
+// recordEvent appends to the audit log. version is the todo's version after
+// the change, or zero once it is gone.
+func (t todoRepository) recordEvent(todoID int, action string, version int64, changes map[string]FieldChange) error {
+	if changes == nil {
+		changes = map[string]FieldChange{}
+	}
+	encoded, err := json.Marshal(changes)
+	if err != nil {
+		return err
+	}
+	_, err = t.q.Exec(`
+		INSERT INTO todo_events (todo_id, action, changes, version, actor, request_id, source)
+		VALUES ($1, $2, $3, $4, $5, $6, $7)`,
+		todoID, action, encoded, version, t.audit.Actor, t.audit.RequestID, t.audit.Source)
+	return err
+}
+
+// diffTodos lists the user-visible fields that differ between before and
+// after. A nil before diffs against nothing, as for a new todo.
+func diffTodos(before *Todo, after Todo) map[string]FieldChange {
+	changes := make(map[string]FieldChange)
+	if before == nil {
+		changes["task"] = FieldChange{To: after.Task}
+		changes["done"] = FieldChange{To: after.Done}
+		if after.ParentID != nil {
+			changes["parent_id"] = FieldChange{To: after.ParentID}
+		}
+		if after.AutoComplete {
+			changes["auto_complete"] = FieldChange{To: true}
+		}
+		if after.DueAt != nil {
+			changes["due_at"] = FieldChange{To: after.DueAt}
+		}
+		if after.Recurrence != "" {
+			changes["recurrence"] = FieldChange{To: after.Recurrence}
+		}
+		changes["priority"] = FieldChange{To: after.Priority}
+		changes["list_id"] = FieldChange{To: after.ListID}
+		changes["status"] = FieldChange{To: after.Status}
+		if len(after.Tags) > 0 {
+			changes["tags"] = FieldChange{To: after.Tags}
+		}
+		return changes
+	}
+	if before.Task != after.Task {
+		changes["task"] = FieldChange{From: before.Task, To: after.Task}
+	}
+	if before.Done != after.Done {
+		changes["done"] = FieldChange{From: before.Done, To: after.Done}
+	}
+	if before.Blocked != after.Blocked {
+		changes["blocked"] = FieldChange{From: before.Blocked, To: after.Blocked}
+	}
+	if !sameID(before.ParentID, after.ParentID) {
+		changes["parent_id"] = FieldChange{From: before.ParentID, To: after.ParentID}
+	}
+	if before.AutoComplete != after.AutoComplete {
+		changes["auto_complete"] = FieldChange{From: before.AutoComplete, To: after.AutoComplete}
+	}
+	if !sameTime(before.DueAt, after.DueAt) {
+		changes["due_at"] = FieldChange{From: before.DueAt, To: after.DueAt}
+	}
+	if before.Recurrence != after.Recurrence {
+		changes["recurrence"] = FieldChange{From: before.Recurrence, To: after.Recurrence}
+	}
+	if before.Priority != after.Priority {
+		changes["priority"] = FieldChange{From: before.Priority, To: after.Priority}
+	}
+	if before.Position != after.Position {
+		changes["position"] = FieldChange{From: before.Position, To: after.Position}
+	}
+	if before.Status != after.Status {
+		changes["status"] = FieldChange{From: before.Status, To: after.Status}
+	}
+	if !slices.Equal(before.Tags, after.Tags) {
+		changes["tags"] = FieldChange{From: before.Tags, To: after.Tags}
+	}
+	if (before.DeletedAt == nil) != (after.DeletedAt == nil) {
+		changes["deleted_at"] = FieldChange{From: before.DeletedAt, To: after.DeletedAt}
+	}
+	return changes
+}
+
+// GetHistory returns every recorded event of a todo, oldest first. It keeps
+// working after the todo has been purged.
+func (t todoRepository) GetHistory(id int) ([]TodoEvent, error) {
+	events := make([]TodoEvent, 0)
+	err := sqlx.Select(t.q, &events, "SELECT "+todoEventColumns+" FROM todo_events WHERE todo_id = $1 ORDER BY id", id)
+	if err == nil && len(events) == 0 {
+		return events, ErrTodoNotFound
+	}
+	return events, err
+}
+
+// GetAuditLog returns events matching filter, newest first.
+func (t todoRepository) GetAuditLog(filter AuditFilter) ([]TodoEvent, error) {
+	events := make([]TodoEvent, 0)
+	err := sqlx.Select(t.q, &events, `
+		SELECT `+todoEventColumns+` FROM todo_events
+		WHERE ($1::integer = 0 OR todo_id = $1)
+			AND ($2 = '' OR actor = $2)
+			AND ($3 = '' OR action = $3)
+			AND ($4 = '' OR source = $4)
+			AND ($5::timestamptz IS NULL OR created_at >= $5)
+			AND ($6::timestamptz IS NULL OR created_at < $6)
+			AND ($7::bigint = 0 OR id < $7)
+		ORDER BY id DESC
+		LIMIT $8`,
+		filter.TodoID, filter.Actor, filter.Action, filter.Source, filter.Since, filter.Until, filter.BeforeID, filter.Limit)
+	return events, err
+}
+
+func sameID(a, b *int) bool {
+	if a == nil || b == nil {
+		return a == b
+	}
+	return *a == *b
+}
+
+func sameTime(a, b *time.Time) bool {
+	if a == nil || b == nil {
+		return a == b
+	}
+	return a.Equal(*b)
+}
@@ -0,0 +1,48 @@
+package main
+
+import (
+	"slices"
+	"testing"
+	"time"
+)
+
+func TestDiffTodos(t *testing.T) {
+	now := time.Now()
+	before := Todo{ID: 1, Task: "Buy milk", Priority: 2, Status: "backlog", ListID: 1}
+	with := func(change func(*Todo)) Todo {
+		after := before
+		change(&after)
+		return after
+	}
+
+	tests := []struct {
+		name  string
+		after Todo
+		want  []string
+	}{
+		{"unchanged", before, nil},
+		{"task", with(func(t *Todo) { t.Task = "Buy oat milk" }), []string{"task"}},
+		{"blocked", with(func(t *Todo) { t.Blocked = true }), []string{"blocked"}},
+		{"completed", with(func(t *Todo) { t.Done, t.Status = true, "done" }), []string{"done", "status"}},
+		{"trashed", with(func(t *Todo) { t.DeletedAt = &now }), []string{"deleted_at"}},
+		// Revision and version change with every write and are not diffed.
+		{"revision", with(func(t *Todo) { t.Revision, t.Version = 9, 9 }), nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var got []string
+			for field := range diffTodos(&before, tt.after) {
+				got = append(got, field)
+			}
+			slices.Sort(got)
+			if !slices.Equal(got, tt.want) {
+				t.Errorf("changed fields = %v, want %v", got, tt.want)
+			}
+		})
+	}
+
+	created := diffTodos(nil, before)
+	if created["task"].From != nil || created["task"].To != "Buy milk" {
+		t.Errorf("created task change = %+v, want from null", created["task"])
+	}
+}
@@ -0,0 +1,283 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/rs/zerolog/log"
+)
+
+const maxBulkOperations = 500
+
+const (
+	bulkAtomic     = "atomic"
+	bulkBestEffort = "best_effort"
+)
+
+const (
+	bulkApplied    = "applied"
+	bulkFailed     = "failed"
+	bulkRolledBack = "rolled_back"
+	bulkSkipped    = "skipped"
+)
+
+type bulkOperation struct {
+	Op           string  `json:"op"`
+	ID           int     `json:"id"`
+	BaseRevision int64   `json:"base_revision"`
+	Task         *string `json:"task"`
+	Done         *bool   `json:"done"`
+}
+
+type bulkRequest struct {
+	Mode       string          `json:"mode"`
+	Operations []bulkOperation `json:"operations" binding:"required"`
+}
+
+type bulkResult struct {
+	Index  int        `json:"index"`
+	Op     string     `json:"op"`
+	Status string     `json:"status"`
+	ID     int        `json:"id,omitempty"`
+	Todo   *Todo      `json:"todo,omitempty"`
+	Error  *syncError `json:"error,omitempty"`
+}
+
+type bulkResponse struct {
+	Mode      string       `json:"mode"`
+	Committed bool         `json:"committed"`
+	Results   []bulkResult `json:"results"`
+}
+
+// bulkEvents collects the events of a bulk request so that each affected
+// todo produces one event carrying its final state, sent after commit. It
+// also collects the changes the request's undo token reverts.
+type bulkEvents struct {
+	order    []int
+	subjects map[int]string
+	todos    map[int]Todo
+	undo     []UndoTarget
+}
+
+func newBulkEvents() *bulkEvents {
+	return &bulkEvents{subjects: make(map[int]string), todos: make(map[int]Todo)}
+}
+
+func (e *bulkEvents) add(subject string, todo Todo) {
+	previous, seen := e.subjects[todo.ID]
+	if !seen {
+		e.order = append(e.order, todo.ID)
+	}
+	switch {
+	case previous == "todo.created" && subject == "todo.deleted":
+		// Created and deleted in the same request: nobody saw it.
+		delete(e.subjects, todo.ID)
+		delete(e.todos, todo.ID)
+		return
+	case previous == "todo.created":
+		subject = previous
+	}
+	e.subjects[todo.ID] = subject
+	e.todos[todo.ID] = todo
+}
+
+func (e *bulkEvents) merge(other *bulkEvents) {
+	for _, id := range other.order {
+		if subject, ok := other.subjects[id]; ok {
+			e.add(subject, other.todos[id])
+		}
+	}
+	e.undo = append(e.undo, other.undo...)
+}
+
+func (c *TodosController) bulkTodos(ctx *gin.Context) {
+	var request bulkRequest
+	if err := ctx.ShouldBindJSON(&request); err != nil {
+		log.Error().Err(err).Msg("Failed to parse bulk request body")
+		respondError(ctx, errInvalidBody(err))
+		return
+	}
+	if request.Mode == "" {
+		request.Mode = bulkAtomic
+	}
+	if request.Mode != bulkAtomic && request.Mode != bulkBestEffort {
+		respondError(ctx, errInvalidField("mode", fmt.Sprintf("mode must be %q or %q", bulkAtomic, bulkBestEffort)))
+		return
+	}
+	if len(request.Operations) > maxBulkOperations {
+		respondError(ctx, errTooManyItems("operations", maxBulkOperations))
+		return
+	}
+
+	resp := bulkResponse{Mode: request.Mode, Results: make([]bulkResult, len(request.Operations))}
+	for i, op := range request.Operations {
+		resp.Results[i] = bulkResult{Index: i, Op: op.Op, ID: op.ID, Status: bulkSkipped}
+	}
+	events := newBulkEvents()
+
+	repo := c.repoFor(ctx)
+	var err error
+	if request.Mode == bulkAtomic {
+		err = repo.Transaction(func(repo TodoRepository) error {
+			for i, op := range request.Operations {
+				if err := c.applyBulkOperation(repo, op, &resp.Results[i], events); err != nil {
+					for j := range i {
+						resp.Results[j].Status, resp.Results[j].Todo = bulkRolledBack, nil
+						resp.Results[j].ID = request.Operations[j].ID
+					}
+					return err
+				}
+			}
+			return nil
+		})
+	} else {
+		err = repo.Transaction(func(repo TodoRepository) error {
+			for i, op := range request.Operations {
+				// A savepoint per operation keeps one failure from
+				// aborting the rest of the transaction.
+				opEvents := newBulkEvents()
+				if repo.Transaction(func(repo TodoRepository) error {
+					return c.applyBulkOperation(repo, op, &resp.Results[i], opEvents)
+				}) == nil {
+					events.merge(opEvents)
+				}
+			}
+			return nil
+		})
+	}
+
+	var apiErr *APIError
+	switch {
+	case err == nil:
+		resp.Committed = true
+	case errors.As(err, &apiErr) && request.Mode == bulkAtomic:
+		// The failing operation is already reported in its result.
+	default:
+		log.Error().Err(err).Msg("Bulk operation failed")
+		respondError(ctx, errInternal(err))
+		return
+	}
+
+	if resp.Committed {
+		for _, id := range events.order {
+			if subject, ok := events.subjects[id]; ok {
+				c.sendNatsMessage(subject, events.todos[id])
+				c.publishStatusChange(events.todos[id])
+				c.publishCompletion(events.todos[id])
+			}
+		}
+	}
+
+	log.Info().
+		Str("path", ctx.FullPath()).
+		Str("mode", request.Mode).
+		Int("operations", len(request.Operations)).
+		Bool("committed", resp.Committed).
+		Msg("Bulk request processed")
+	if !resp.Committed {
+		respond(ctx, http.StatusOK, resp, nil)
+		return
+	}
+	c.respondWithBatchUndo(ctx, http.StatusOK, resp, nil, events.undo, nil)
+}
+
+// applyBulkOperation runs a single operation and records its outcome in
+// result. The returned error is an *APIError for failures of the operation
+// itself; any other error means the transaction is no longer usable.
+func (c *TodosController) applyBulkOperation(repo TodoRepository, op bulkOperation, result *bulkResult, events *bulkEvents) error {
+	fail := func(apiErr *APIError) error {
+		result.Status = bulkFailed
+		result.Error = &syncError{Code: apiErr.Code, Detail: apiErr.Detail}
+		return apiErr
+	}
+
+	switch op.Op {
+	case "create", "update", "complete", "delete":
+	default:
+		return fail(errInvalidField("op", fmt.Sprintf("Unknown op %q", op.Op)))
+	}
+	if op.Task != nil {
+		task, apiErr := c.tasks.Validate(*op.Task)
+		if apiErr != nil {
+			return fail(apiErr)
+		}
+		op.Task = &task
+	}
+	if op.Op != "create" {
+		if op.ID <= 0 {
+			return fail(errInvalidField("id", "id must be a positive integer"))
+		}
+		if op.BaseRevision == 0 && c.config.RequireIfMatch {
+			return fail(errPreconditionRequired())
+		}
+	}
+	pre := Precondition{Revision: op.BaseRevision}
+
+	var (
+		todo       Todo
+		completion Completion
+		err        error
+		subject    string
+	)
+	switch op.Op {
+	case "create":
+		if op.Task == nil {
+			return fail(errTaskEmpty())
+		}
+		todo, err = repo.AddTodo(NewTodo{Task: *op.Task})
+		if err == nil && op.Done != nil && *op.Done {
+			todo, _, err = repo.UpdateTodo(todo.ID, Precondition{}, TodoUpdate{Done: op.Done})
+		}
+		subject = "todo.created"
+	case "update":
+		if op.Task == nil && op.Done == nil {
+			return fail(errEmptyUpdate())
+		}
+		todo, completion, err = repo.UpdateTodo(op.ID, pre, TodoUpdate{Task: op.Task, Done: op.Done})
+		subject = "todo.updated"
+	case "complete":
+		todo, completion, err = repo.markTodoDone(op.ID, pre)
+		subject = "todo.updated"
+	case "delete":
+		var subtasks []Todo
+		todo, subtasks, completion, err = repo.DeleteTodo(op.ID, pre)
+		for _, subtask := range subtasks {
+			events.add("todo.deleted", Todo{ID: subtask.ID})
+		}
+		subject = "todo.deleted"
+	}
+
+	switch {
+	case errors.Is(err, ErrTodoNotFound):
+		return fail(errTodoNotFound(op.ID))
+	case errors.Is(err, ErrPreconditionFailed):
+		return fail(errPreconditionFailed(op.ID))
+	case errors.Is(err, ErrTodoBlocked):
+		return fail(errTodoBlocked(op.ID))
+	case errors.Is(err, ErrWIPLimitReached):
+		return fail(errWIPLimitReached())
+	case err != nil:
+		log.Error().Err(err).Str("op", op.Op).Int("id", op.ID).Msg("bulk operation failed")
+		result.Status = bulkFailed
+		result.Error = &syncError{Code: CodeInternal, Detail: "Unexpected server error"}
+		return err
+	}
+
+	// Undo restores a deleted todo from the trash, so its target is the
+	// trashed todo while its event only carries the ID.
+	events.undo = append(events.undo, UndoTarget{Todo: todo, Created: op.Op == "create"})
+	result.Status, result.ID = bulkApplied, todo.ID
+	if op.Op == "delete" {
+		todo = Todo{ID: todo.ID}
+	} else {
+		result.Todo = &todo
+	}
+	events.add(subject, todo)
+	// Publishing a completed ancestor publishes its occurrence as well.
+	for _, ancestor := range completion.Ancestors {
+		events.add("todo.updated", ancestor)
+	}
+	return nil
+}
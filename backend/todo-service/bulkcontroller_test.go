@@ -0,0 +1,129 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"slices"
+	"strconv"
+	"testing"
+)
+
+// postBulk runs a bulk request and returns its response and statuses.
+func postBulk(t *testing.T, c *TodosController, body string) (bulkResponse, []string) {
+	t.Helper()
+	ctx, w := postContext("/api/v2/todos/bulk", body)
+	c.bulkTodos(ctx)
+	if w.Code != http.StatusOK {
+		t.Fatalf("status = %d: %s", w.Code, w.Body)
+	}
+	var env struct {
+		Data bulkResponse `json:"data"`
+	}
+	if err := json.Unmarshal(w.Body.Bytes(), &env); err != nil {
+		t.Fatal(err)
+	}
+	var statuses []string
+	for _, result := range env.Data.Results {
+		statuses = append(statuses, result.Status)
+	}
+	return env.Data, statuses
+}
+
+func TestBulkAtomicRollsBack(t *testing.T) {
+	repo := newMemoryRepository()
+	addSubtree(t, repo)
+	c, events := newTestController(t, repo)
+
+	resp, statuses := postBulk(t, c, `{"operations": [
+		{"op": "create", "task": "Pack boxes"},
+		{"op": "update", "id": 4, "task": "Water the plants"},
+		{"op": "delete", "id": 9}
+	]}`)
+
+	want := []string{bulkRolledBack, bulkRolledBack, bulkFailed}
+	if resp.Committed || !slices.Equal(statuses, want) {
+		t.Errorf("committed = %v, statuses = %v, want %v", resp.Committed, statuses, want)
+	}
+	if len(repo.todos) != 4 || repo.todos[4].Task != "Water plants" {
+		t.Errorf("todos = %d, todo 4 = %q; want the request rolled back", len(repo.todos), repo.todos[4].Task)
+	}
+	if got := publishedEvents(events); len(got) != 0 {
+		t.Errorf("events = %v, want none for a rolled back request", got)
+	}
+}
+
+func TestBulkBestEffortRollsBackFailedOperations(t *testing.T) {
+	repo := newMemoryRepository()
+	addSubtree(t, repo)
+	repo.full["done"] = true
+	c, events := newTestController(t, repo)
+
+	// The second create is added before its done status is refused, so only
+	// its savepoint keeps it out of the list.
+	resp, statuses := postBulk(t, c, `{"mode": "best_effort", "operations": [
+		{"op": "create", "task": "Pack boxes"},
+		{"op": "create", "task": "Return keys", "done": true},
+		{"op": "delete", "id": 9},
+		{"op": "update", "id": 4, "task": "Water the plants"}
+	]}`)
+
+	want := []string{bulkApplied, bulkFailed, bulkFailed, bulkApplied}
+	if !resp.Committed || !slices.Equal(statuses, want) {
+		t.Errorf("committed = %v, statuses = %v, want %v", resp.Committed, statuses, want)
+	}
+	if len(repo.todos) != 5 || repo.todos[5].Task != "Pack boxes" || repo.todos[4].Task != "Water the plants" {
+		t.Errorf("todos = %d, want the applied operations kept and the failed ones undone", len(repo.todos))
+	}
+	wantEvents := []string{"todo.created 5", "todo.updated 4"}
+	if got := publishedEvents(events); !slices.Equal(got, wantEvents) {
+		t.Errorf("events = %v, want %v", got, wantEvents)
+	}
+}
+
+func TestBulkPublishesOneEventPerTodo(t *testing.T) {
+	repo := newMemoryRepository()
+	addSubtree(t, repo)
+	c, events := newTestController(t, repo)
+
+	_, statuses := postBulk(t, c, `{"operations": [
+		{"op": "create", "task": "Pack boxes"},
+		{"op": "update", "id": 5, "task": "Pack all boxes"},
+		{"op": "update", "id": 4, "task": "Water the plants"},
+		{"op": "complete", "id": 4},
+		{"op": "create", "task": "Typo"},
+		{"op": "delete", "id": 6},
+		{"op": "delete", "id": 1}
+	]}`)
+	for i, status := range statuses {
+		if status != bulkApplied {
+			t.Fatalf("operation %d: %s", i, status)
+		}
+	}
+
+	// A todo created and deleted in the request is never announced, and
+	// the subtasks trashed with 1 come first as they are collected first.
+	want := []string{"todo.created 5", "todo.updated 4", "todo.deleted 2", "todo.deleted 3", "todo.deleted 1"}
+	var got []string
+	var created, updated Todo
+	for len(events.events) > 0 {
+		event := <-events.events
+		var todo Todo
+		if err := json.Unmarshal(event.Data, &todo); err != nil {
+			t.Fatal(err)
+		}
+		got = append(got, event.Type+" "+strconv.Itoa(todo.ID))
+		switch event.Type {
+		case "todo.created":
+			created = todo
+		case "todo.updated":
+			updated = todo
+		}
+	}
+	if !slices.Equal(got, want) {
+		t.Errorf("events = %v, want %v", got, want)
+	}
+	// Each event carries the final state of its todo.
+	if created.Task != "Pack all boxes" || updated.Task != "Water the plants" || !updated.Done {
+		t.Errorf("created = %+v, updated = %+v, want their final state", created, updated)
+	}
+}
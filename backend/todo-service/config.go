@@ -0,0 +1,474 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/url"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"cors"
+
+	// The runtime image has no zoneinfo, so TIMEZONE relies on the copy
+	// embedded in the binary.
+	_ "time/tzdata"
+)
+
+const redacted = "xxxxx"
+
+type Config struct {
+	Port                    string            `json:"port"`
+	RandomArticleURL        string            `json:"random_article_url"`
+	NatsURL                 string            `json:"nats_url"`
+	RequireIfMatch          bool              `json:"require_if_match"`
+	RefuseBlockedCompletion bool              `json:"refuse_blocked_completion"`
+	Timezone                string            `json:"timezone"`
+	APIKeys                 []string          `json:"api_keys"`
+	TrustedProxies          []string          `json:"trusted_proxies"`
+	Tasks                   TaskConfig        `json:"tasks"`
+	Idempotency             IdempotencyConfig `json:"idempotency"`
+	Undo                    UndoConfig        `json:"undo"`
+	Cors                    CorsConfig        `json:"cors"`
+	RateLimit               RateLimitConfig   `json:"rate_limit"`
+	Stream                  StreamConfig      `json:"stream"`
+	Trash                   TrashConfig       `json:"trash"`
+	Postgres                PostgresConfig    `json:"postgres"`
+}
+
+// TaskConfig holds the rules for task text, see TaskValidator.
+// TASK_BANNED_PATTERNS is split on commas like other lists, so patterns
+// containing a comma have to be set in the config file.
+type TaskConfig struct {
+	MaxLength      int      `json:"max_length"`
+	BannedWords    []string `json:"banned_words"`
+	BannedPatterns []string `json:"banned_patterns"`
+}
+
+type TrashConfig struct {
+	RetentionDays        int `json:"retention_days"`
+	PurgeIntervalMinutes int `json:"purge_interval_minutes"`
+}
+
+type StreamConfig struct {
+	BufferSize       int `json:"buffer_size"`
+	ClientBuffer     int `json:"client_buffer"`
+	HeartbeatSeconds int `json:"heartbeat_seconds"`
+}
+
+// RateLimitConfig holds per-route limits in requests per minute. A limit
+// of zero disables throttling for that route.
+type RateLimitConfig struct {
+	Enabled         bool `json:"enabled"`
+	WritesPerMinute int  `json:"writes_per_minute"`
+	RandomPerMinute int  `json:"random_per_minute"`
+	Burst           int  `json:"burst"`
+}
+
+type IdempotencyConfig struct {
+	TTLHours int `json:"ttl_hours"`
+}
+
+type UndoConfig struct {
+	WindowSeconds int `json:"window_seconds"`
+}
+
+type CorsConfig struct {
+	AllowedOrigins   []string `json:"allowed_origins"`
+	AllowCredentials bool     `json:"allow_credentials"`
+	MaxAgeSeconds    int      `json:"max_age_seconds"`
+}
+
+type PostgresConfig struct {
+	Host     string `json:"host"`
+	Port     int    `json:"port"`
+	User     string `json:"user"`
+	Password string `json:"password"`
+	DB       string `json:"db"`
+	SSLMode  string `json:"sslmode"`
+	DSN      string `json:"dsn"`
+}
+
+func defaultConfig() Config {
+	return Config{
+		Timezone:    "UTC",
+		Tasks:       TaskConfig{MaxLength: defaultMaxTaskLength},
+		Idempotency: IdempotencyConfig{TTLHours: 24},
+		Undo:        UndoConfig{WindowSeconds: 300},
+		Cors: CorsConfig{
+			MaxAgeSeconds: 600,
+		},
+		RateLimit: RateLimitConfig{
+			Enabled:         true,
+			WritesPerMinute: 30,
+			RandomPerMinute: 5,
+		},
+		Trash: TrashConfig{
+			RetentionDays:        30,
+			PurgeIntervalMinutes: 60,
+		},
+		Stream: StreamConfig{
+			BufferSize:       1000,
+			ClientBuffer:     64,
+			HeartbeatSeconds: 15,
+		},
+		Postgres: PostgresConfig{
+			Port:    5432,
+			SSLMode: "disable",
+		},
+	}
+}
+
+// LoadConfig builds the service configuration from defaults, the optional
+// JSON config file at path and finally the environment, which always wins.
+func LoadConfig(path string) (*Config, error) {
+	cfg := defaultConfig()
+
+	if path != "" {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read config file: %w", err)
+		}
+		if err := json.Unmarshal(data, &cfg); err != nil {
+			return nil, fmt.Errorf("failed to parse config file %s: %w", path, err)
+		}
+	}
+
+	var errs []error
+	setString(&cfg.Port, "PORT")
+	setList(&cfg.Cors.AllowedOrigins, "ALLOWED_ORIGINS")
+	errs = append(errs, setBool(&cfg.Cors.AllowCredentials, "CORS_ALLOW_CREDENTIALS"))
+	errs = append(errs, setInt(&cfg.Cors.MaxAgeSeconds, "CORS_MAX_AGE_SECONDS"))
+	setString(&cfg.RandomArticleURL, "RANDOM_ARTICLE_URL")
+	setString(&cfg.NatsURL, "NATS_URL")
+	errs = append(errs, setBool(&cfg.RequireIfMatch, "REQUIRE_IF_MATCH"))
+	errs = append(errs, setBool(&cfg.RefuseBlockedCompletion, "REFUSE_BLOCKED_COMPLETION"))
+	setString(&cfg.Timezone, "TIMEZONE")
+	setList(&cfg.APIKeys, "API_KEYS")
+	setList(&cfg.TrustedProxies, "TRUSTED_PROXIES")
+	errs = append(errs, setInt(&cfg.Tasks.MaxLength, "TASK_MAX_LENGTH"))
+	setList(&cfg.Tasks.BannedWords, "TASK_BANNED_WORDS")
+	setList(&cfg.Tasks.BannedPatterns, "TASK_BANNED_PATTERNS")
+	errs = append(errs, setInt(&cfg.Idempotency.TTLHours, "IDEMPOTENCY_TTL_HOURS"))
+	errs = append(errs, setInt(&cfg.Undo.WindowSeconds, "UNDO_WINDOW_SECONDS"))
+	errs = append(errs, setBool(&cfg.RateLimit.Enabled, "RATE_LIMIT_ENABLED"))
+	errs = append(errs, setInt(&cfg.RateLimit.WritesPerMinute, "RATE_LIMIT_WRITES_PER_MINUTE"))
+	errs = append(errs, setInt(&cfg.RateLimit.RandomPerMinute, "RATE_LIMIT_RANDOM_PER_MINUTE"))
+	errs = append(errs, setInt(&cfg.RateLimit.Burst, "RATE_LIMIT_BURST"))
+	errs = append(errs, setInt(&cfg.Stream.BufferSize, "STREAM_BUFFER_SIZE"))
+	errs = append(errs, setInt(&cfg.Stream.ClientBuffer, "STREAM_CLIENT_BUFFER"))
+	errs = append(errs, setInt(&cfg.Stream.HeartbeatSeconds, "STREAM_HEARTBEAT_SECONDS"))
+	errs = append(errs, setInt(&cfg.Trash.RetentionDays, "TRASH_RETENTION_DAYS"))
+	errs = append(errs, setInt(&cfg.Trash.PurgeIntervalMinutes, "TRASH_PURGE_INTERVAL_MINUTES"))
+	setString(&cfg.Postgres.Host, "POSTGRES_HOST")
+	setString(&cfg.Postgres.User, "POSTGRES_USER")
+	setString(&cfg.Postgres.Password, "POSTGRES_PASSWORD")
+	setString(&cfg.Postgres.DB, "POSTGRES_DB")
+	setString(&cfg.Postgres.SSLMode, "POSTGRES_SSLMODE")
+	setString(&cfg.Postgres.DSN, "POSTGRES_DSN")
+	errs = append(errs, setInt(&cfg.Postgres.Port, "POSTGRES_PORT"))
+
+	errs = append(errs, cfg.validate())
+	if err := errors.Join(errs...); err != nil {
+		return nil, fmt.Errorf("invalid configuration:\n%w", err)
+	}
+	return &cfg, nil
+}
+
+func (c Config) validate() error {
+	var errs []error
+
+	if c.Port == "" {
+		errs = append(errs, errors.New("PORT must be set"))
+	} else if p, err := strconv.Atoi(c.Port); err != nil || p <= 0 || p > 65535 {
+		errs = append(errs, fmt.Errorf("PORT must be a valid port number, got %q", c.Port))
+	}
+
+	if c.RandomArticleURL != "" {
+		if _, err := url.ParseRequestURI(c.RandomArticleURL); err != nil {
+			errs = append(errs, fmt.Errorf("RANDOM_ARTICLE_URL is not a valid URL: %q", c.RandomArticleURL))
+		}
+	}
+
+	if _, err := time.LoadLocation(c.Timezone); err != nil {
+		errs = append(errs, fmt.Errorf("TIMEZONE %q is not a known time zone", c.Timezone))
+	}
+
+	for _, proxy := range c.TrustedProxies {
+		if net.ParseIP(proxy) == nil {
+			if _, _, err := net.ParseCIDR(proxy); err != nil {
+				errs = append(errs, fmt.Errorf("TRUSTED_PROXIES entry %q is not an IP address or CIDR range", proxy))
+			}
+		}
+	}
+
+	if c.Idempotency.TTLHours <= 0 {
+		errs = append(errs, fmt.Errorf("IDEMPOTENCY_TTL_HOURS must be positive, got %d", c.Idempotency.TTLHours))
+	}
+	if c.Undo.WindowSeconds <= 0 {
+		errs = append(errs, fmt.Errorf("UNDO_WINDOW_SECONDS must be positive, got %d", c.Undo.WindowSeconds))
+	}
+
+	errs = append(errs, c.Tasks.validate())
+	errs = append(errs, c.Cors.validate())
+	errs = append(errs, c.RateLimit.validate())
+	errs = append(errs, c.Stream.validate())
+	errs = append(errs, c.Trash.validate())
+	errs = append(errs, c.Postgres.validate())
+	return errors.Join(errs...)
+}
+
+// Location is the time zone dates in task text are read in and recurrence
+// rules are evaluated in. Timezone is
+// checked by validate, so a failed lookup only happens for a Config built
+// without LoadConfig.
+func (c Config) Location() *time.Location {
+	loc, err := time.LoadLocation(c.Timezone)
+	if err != nil {
+		return time.UTC
+	}
+	return loc
+}
+
+func (c TaskConfig) validate() error {
+	var errs []error
+	if c.MaxLength <= 0 {
+		errs = append(errs, fmt.Errorf("TASK_MAX_LENGTH must be positive, got %d", c.MaxLength))
+	}
+	if _, err := NewTaskValidator(c); err != nil {
+		errs = append(errs, err)
+	}
+	return errors.Join(errs...)
+}
+
+// Validator builds the TaskValidator for c. TaskConfig is checked by
+// validate, so the default rules are only used for a Config built without
+// LoadConfig.
+func (c TaskConfig) Validator() *TaskValidator {
+	v, err := NewTaskValidator(c)
+	if err != nil {
+		v, _ = NewTaskValidator(TaskConfig{})
+	}
+	return v
+}
+
+func (c IdempotencyConfig) TTL() time.Duration {
+	return time.Duration(c.TTLHours) * time.Hour
+}
+
+func (c UndoConfig) Window() time.Duration {
+	return time.Duration(c.WindowSeconds) * time.Second
+}
+
+func (c CorsConfig) validate() error {
+	errs := []error{cors.ValidateOrigins(c.AllowedOrigins, c.AllowCredentials)}
+	if c.MaxAgeSeconds < 0 {
+		errs = append(errs, fmt.Errorf("CORS_MAX_AGE_SECONDS must not be negative, got %d", c.MaxAgeSeconds))
+	}
+	return errors.Join(errs...)
+}
+
+func (c CorsConfig) Options() cors.Options {
+	return cors.Options{
+		AllowedOrigins:   c.AllowedOrigins,
+		AllowedMethods:   []string{"GET", "POST", "PUT", "PATCH", "DELETE", "OPTIONS"},
+		AllowedHeaders:   []string{"Content-Type", "Authorization", apiKeyHeader, "Last-Event-ID", "If-Match", "If-None-Match", idempotencyKeyHeader, requestIDHeader},
+		ExposedHeaders:   []string{"ETag", "Idempotent-Replayed", requestIDHeader, undoTokenHeader, "RateLimit-Limit", "RateLimit-Remaining", "RateLimit-Reset", "Retry-After"},
+		AllowCredentials: c.AllowCredentials,
+		MaxAge:           time.Duration(c.MaxAgeSeconds) * time.Second,
+	}
+}
+
+func (c RateLimitConfig) validate() error {
+	var errs []error
+	if c.WritesPerMinute < 0 {
+		errs = append(errs, fmt.Errorf("RATE_LIMIT_WRITES_PER_MINUTE must not be negative, got %d", c.WritesPerMinute))
+	}
+	if c.RandomPerMinute < 0 {
+		errs = append(errs, fmt.Errorf("RATE_LIMIT_RANDOM_PER_MINUTE must not be negative, got %d", c.RandomPerMinute))
+	}
+	if c.Burst < 0 {
+		errs = append(errs, fmt.Errorf("RATE_LIMIT_BURST must not be negative, got %d", c.Burst))
+	}
+	return errors.Join(errs...)
+}
+
+func (c StreamConfig) validate() error {
+	var errs []error
+	if c.BufferSize <= 0 {
+		errs = append(errs, fmt.Errorf("STREAM_BUFFER_SIZE must be positive, got %d", c.BufferSize))
+	}
+	if c.ClientBuffer <= 0 {
+		errs = append(errs, fmt.Errorf("STREAM_CLIENT_BUFFER must be positive, got %d", c.ClientBuffer))
+	}
+	if c.HeartbeatSeconds <= 0 {
+		errs = append(errs, fmt.Errorf("STREAM_HEARTBEAT_SECONDS must be positive, got %d", c.HeartbeatSeconds))
+	}
+	return errors.Join(errs...)
+}
+
+func (c TrashConfig) validate() error {
+	var errs []error
+	if c.RetentionDays <= 0 {
+		errs = append(errs, fmt.Errorf("TRASH_RETENTION_DAYS must be positive, got %d", c.RetentionDays))
+	}
+	if c.PurgeIntervalMinutes <= 0 {
+		errs = append(errs, fmt.Errorf("TRASH_PURGE_INTERVAL_MINUTES must be positive, got %d", c.PurgeIntervalMinutes))
+	}
+	return errors.Join(errs...)
+}
+
+func (c TrashConfig) Retention() time.Duration {
+	return time.Duration(c.RetentionDays) * 24 * time.Hour
+}
+
+func (c TrashConfig) PurgeInterval() time.Duration {
+	return time.Duration(c.PurgeIntervalMinutes) * time.Minute
+}
+
+func (c StreamConfig) Heartbeat() time.Duration {
+	return time.Duration(c.HeartbeatSeconds) * time.Second
+}
+
+func (c RateLimitConfig) Writes() RateLimit {
+	return RateLimit{Requests: c.WritesPerMinute, Per: time.Minute, Burst: c.Burst}
+}
+
+func (c RateLimitConfig) Random() RateLimit {
+	return RateLimit{Requests: c.RandomPerMinute, Per: time.Minute, Burst: c.Burst}
+}
+
+func (p PostgresConfig) validate() error {
+	if p.DSN != "" {
+		return nil
+	}
+
+	var errs []error
+	if p.Host == "" {
+		errs = append(errs, errors.New("POSTGRES_HOST must be set"))
+	}
+	if p.User == "" {
+		errs = append(errs, errors.New("POSTGRES_USER must be set"))
+	}
+	if p.Password == "" {
+		errs = append(errs, errors.New("POSTGRES_PASSWORD must be set"))
+	}
+	if p.DB == "" {
+		errs = append(errs, errors.New("POSTGRES_DB must be set"))
+	}
+	if p.Port <= 0 || p.Port > 65535 {
+		errs = append(errs, fmt.Errorf("POSTGRES_PORT must be a valid port number, got %d", p.Port))
+	}
+	switch p.SSLMode {
+	case "disable", "allow", "prefer", "require", "verify-ca", "verify-full":
+	default:
+		errs = append(errs, fmt.Errorf("POSTGRES_SSLMODE %q is not a valid sslmode", p.SSLMode))
+	}
+	return errors.Join(errs...)
+}
+
+// ConnString returns the DSN if one was configured, otherwise a key/value
+// connection string assembled from the individual settings.
+func (p PostgresConfig) ConnString() string {
+	if p.DSN != "" {
+		return p.DSN
+	}
+	return fmt.Sprintf("host=%s port=%d user=%s password=%s dbname=%s sslmode=%s",
+		p.Host, p.Port, p.User, p.Password, p.DB, p.SSLMode)
+}
+
+// Redacted returns a copy of the config that is safe to print or log.
+func (c Config) Redacted() Config {
+	if c.Postgres.Password != "" {
+		c.Postgres.Password = redacted
+	}
+	if c.Postgres.DSN != "" {
+		c.Postgres.DSN = redactDSN(c.Postgres.DSN)
+	}
+	c.NatsURL = redactURL(c.NatsURL)
+	if len(c.APIKeys) > 0 {
+		c.APIKeys = slices.Repeat([]string{redacted}, len(c.APIKeys))
+	}
+	return c
+}
+
+func redactDSN(dsn string) string {
+	if strings.Contains(dsn, "://") {
+		return redactURL(dsn)
+	}
+	fields := strings.Fields(dsn)
+	for i, field := range fields {
+		if strings.HasPrefix(field, "password=") {
+			fields[i] = "password=" + redacted
+		}
+	}
+	return strings.Join(fields, " ")
+}
+
+func redactURL(raw string) string {
+	u, err := url.Parse(raw)
+	if err != nil {
+		return raw
+	}
+	return u.Redacted()
+}
+
+func printConfig(cfg *Config) error {
+	out, err := json.MarshalIndent(cfg.Redacted(), "", "  ")
+	if err != nil {
+		return err
+	}
+	_, err = fmt.Println(string(out))
+	return err
+}
+
+func setString(dst *string, key string) {
+	if value, ok := os.LookupEnv(key); ok && value != "" {
+		*dst = value
+	}
+}
+
+// setList reads a comma-separated environment variable into dst.
+func setList(dst *[]string, key string) {
+	value, ok := os.LookupEnv(key)
+	if !ok || strings.TrimSpace(value) == "" {
+		return
+	}
+	var items []string
+	for _, item := range strings.Split(value, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	*dst = items
+}
+
+func setBool(dst *bool, key string) error {
+	value, ok := os.LookupEnv(key)
+	if !ok || value == "" {
+		return nil
+	}
+	b, err := strconv.ParseBool(value)
+	if err != nil {
+		return fmt.Errorf("%s must be a boolean, got %q", key, value)
+	}
+	*dst = b
+	return nil
+}
+
+func setInt(dst *int, key string) error {
+	value, ok := os.LookupEnv(key)
+	if !ok || value == "" {
+		return nil
+	}
+	n, err := strconv.Atoi(value)
+	if err != nil {
+		return fmt.Errorf("%s must be an integer, got %q", key, value)
+	}
+	*dst = n
+	return nil
+}
@@ -0,0 +1,142 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+	"github.com/rs/zerolog/log"
+)
+
+func (c *TodosController) getBlockers(ctx *gin.Context) {
+	id, ok := todoIDParam(ctx)
+	if !ok {
+		return
+	}
+
+	blockers, err := c.repo.GetBlockers(id)
+	if err != nil {
+		c.respondTodoError(ctx, id, err, "get blockers")
+		return
+	}
+
+	log.Info().
+		Str("path", ctx.FullPath()).
+		Int("id", id).
+		Int("count", len(blockers)).
+		Msg("Blockers received")
+	respondWithMeta(ctx, http.StatusOK, blockers, gin.H{"count": len(blockers)}, nil)
+}
+
+func (c *TodosController) addBlocker(ctx *gin.Context) {
+	id, blockerID, ok := dependencyParams(ctx)
+	if !ok {
+		return
+	}
+
+	todo, added, err := c.repoFor(ctx).AddBlocker(id, blockerID)
+	if err != nil {
+		c.respondDependencyError(ctx, id, blockerID, err, "add blocker")
+		return
+	}
+
+	log.Info().
+		Str("path", ctx.FullPath()).
+		Int("id", id).
+		Int("blocker_id", blockerID).
+		Bool("added", added).
+		Msg("Blocker added")
+
+	// Adding a blocker twice changes nothing, so there is nothing to
+	// announce or undo.
+	if !added {
+		setTodoETag(ctx, todo)
+		respond(ctx, http.StatusOK, todo, nil)
+		return
+	}
+	c.sendNatsMessage("todo.updated", todo)
+	c.respondWithUndo(ctx, http.StatusOK, todo, nil)
+}
+
+func (c *TodosController) removeBlocker(ctx *gin.Context) {
+	id, blockerID, ok := dependencyParams(ctx)
+	if !ok {
+		return
+	}
+
+	todo, err := c.repoFor(ctx).RemoveBlocker(id, blockerID)
+	if err != nil {
+		c.respondDependencyError(ctx, id, blockerID, err, "remove blocker")
+		return
+	}
+
+	log.Info().
+		Str("path", ctx.FullPath()).
+		Int("id", id).
+		Int("blocker_id", blockerID).
+		Msg("Blocker removed")
+
+	c.sendNatsMessage("todo.updated", todo)
+	c.respondWithUndo(ctx, http.StatusOK, todo, nil)
+}
+
+// dependencyParams parses the :id and :blocker_id path parameters and
+// writes a 400 if either is not an integer.
+func dependencyParams(ctx *gin.Context) (int, int, bool) {
+	id, ok := todoIDParam(ctx)
+	if !ok {
+		return 0, 0, false
+	}
+	raw := ctx.Param("blocker_id")
+	blockerID, err := strconv.Atoi(raw)
+	if err != nil {
+		log.Warn().
+			Str("path", ctx.FullPath()).
+			Str("blocker_id", raw).
+			Msg("request rejected: invalid blocker_id parameter")
+		respondError(ctx, errInvalidField("blocker_id", fmt.Sprintf("%q is not an integer id", raw)))
+		return 0, 0, false
+	}
+	return id, blockerID, true
+}
+
+func (c *TodosController) respondDependencyError(ctx *gin.Context, id, blockerID int, err error, action string) {
+	var apiErr *APIError
+	switch {
+	case errors.Is(err, ErrBlockerNotFound):
+		apiErr = errBlockerNotFound(blockerID)
+	case errors.Is(err, ErrDependencyCycle):
+		apiErr = errDependencyCycle(id, blockerID)
+	case errors.Is(err, ErrDependencyNotFound):
+		apiErr = errDependencyNotFound(id, blockerID)
+	default:
+		c.respondTodoError(ctx, id, err, action)
+		return
+	}
+	log.Warn().
+		Str("path", ctx.FullPath()).
+		Int("id", id).
+		Int("blocker_id", blockerID).
+		Str("code", apiErr.Code).
+		Msg(action + " rejected")
+	respondError(ctx, apiErr)
+}
+
+// publishUnblocked sends todo.unblocked for every todo that completing
+// blocker left without open blockers. Like the other events it is best
+// effort, so a failure is only logged.
+func (c *TodosController) publishUnblocked(blocker Todo) {
+	if !blocker.Done {
+		return
+	}
+	todos, err := c.repo.GetUnblocked(blocker)
+	if err != nil {
+		log.Error().Err(err).Int("id", blocker.ID).Msg("Failed to get unblocked todos")
+		return
+	}
+	for _, todo := range todos {
+		c.sendNatsMessage("todo.unblocked", todo)
+	}
+}
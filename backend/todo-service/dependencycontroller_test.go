@@ -0,0 +1,151 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strconv"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+)
+
+// addTodos creates n todos, numbered from 1.
+func addTodos(t *testing.T, repo TodoRepository, n int) {
+	t.Helper()
+	for i := range n {
+		if _, err := repo.AddTodo(NewTodo{Task: "Step " + strconv.Itoa(i+1)}); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+// blockerContext returns a context for a v2 request on the dependency of
+// id on blockerID.
+func blockerContext(method string, id, blockerID int) (*gin.Context, *httptest.ResponseRecorder) {
+	path := "/api/v2/todos/" + strconv.Itoa(id) + "/blockers/" + strconv.Itoa(blockerID)
+	ctx, w := versionedContext(2, path)
+	ctx.Request.Method = method
+	ctx.Params = gin.Params{{Key: "id", Value: strconv.Itoa(id)}, {Key: "blocker_id", Value: strconv.Itoa(blockerID)}}
+	return ctx, w
+}
+
+// problemCode returns the code of the problem w holds, or "" if it holds
+// none.
+func problemCode(t *testing.T, w *httptest.ResponseRecorder) string {
+	t.Helper()
+	var p problem
+	if err := json.Unmarshal(w.Body.Bytes(), &p); err != nil {
+		t.Fatal(err)
+	}
+	return p.Code
+}
+
+func TestAddBlockerRefusesCycles(t *testing.T) {
+	tests := []struct {
+		name     string
+		existing [][2]int
+		add      [2]int
+		wantCode string
+	}{
+		{"self", nil, [2]int{1, 1}, CodeDependencyCycle},
+		{"two todos", [][2]int{{1, 2}}, [2]int{2, 1}, CodeDependencyCycle},
+		{"three todos", [][2]int{{1, 2}, {2, 3}}, [2]int{3, 1}, CodeDependencyCycle},
+		{"shortcut", [][2]int{{1, 2}, {2, 3}}, [2]int{1, 3}, ""},
+		{"shared blocker", [][2]int{{1, 3}}, [2]int{2, 3}, ""},
+		{"missing blocker", nil, [2]int{1, 9}, CodeBlockerNotFound},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			repo := newMemoryRepository()
+			addTodos(t, repo, 3)
+			for _, pair := range tt.existing {
+				if _, _, err := repo.AddBlocker(pair[0], pair[1]); err != nil {
+					t.Fatal(err)
+				}
+			}
+			c, events := newTestController(t, repo)
+
+			ctx, w := blockerContext(http.MethodPost, tt.add[0], tt.add[1])
+			c.addBlocker(ctx)
+			if tt.wantCode == "" {
+				if w.Code != http.StatusOK || !repo.todos[tt.add[0]].Blocked {
+					t.Errorf("status = %d, blocked = %v; want the blocker added: %s", w.Code, repo.todos[tt.add[0]].Blocked, w.Body)
+				}
+				return
+			}
+			if code := problemCode(t, w); code != tt.wantCode {
+				t.Errorf("code = %q, want %q", code, tt.wantCode)
+			}
+			if got := publishedEvents(events); len(got) != 0 {
+				t.Errorf("events = %v, want none for a refused blocker", got)
+			}
+		})
+	}
+}
+
+func TestCompletingLastBlockerUnblocks(t *testing.T) {
+	repo := newMemoryRepository()
+	repo.refuseBlocked = true
+	addTodos(t, repo, 3)
+	for _, blockerID := range []int{2, 3} {
+		if _, _, err := repo.AddBlocker(1, blockerID); err != nil {
+			t.Fatal(err)
+		}
+	}
+	c, events := newTestController(t, repo)
+
+	ctx, w := versionedContext(2, "/api/v2/todos/1")
+	ctx.Params = gin.Params{{Key: "id", Value: "1"}}
+	c.markTodoDone(ctx)
+	if code := problemCode(t, w); code != CodeTodoBlocked {
+		t.Fatalf("completing a blocked todo: code = %q, want %q", code, CodeTodoBlocked)
+	}
+
+	tests := []struct {
+		id   int
+		want []string
+	}{
+		// 3 still blocks 1.
+		{2, []string{"todo.updated 2"}},
+		{3, []string{"todo.updated 3", "todo.unblocked 1"}},
+		{1, []string{"todo.updated 1"}},
+	}
+	for _, tt := range tests {
+		c.markTodoDone(todoContext(http.MethodPut, tt.id))
+		if got := publishedEvents(events); !slices.Equal(got, tt.want) {
+			t.Errorf("completing %d: events = %v, want %v", tt.id, got, tt.want)
+		}
+	}
+	if !repo.todos[1].Done {
+		t.Error("1 is still open after its blockers were completed")
+	}
+}
+
+func TestTrashedBlockerUnblocks(t *testing.T) {
+	repo := newMemoryRepository()
+	repo.refuseBlocked = true
+	addTodos(t, repo, 2)
+	if _, _, err := repo.AddBlocker(1, 2); err != nil {
+		t.Fatal(err)
+	}
+	c, _ := newTestController(t, repo)
+
+	c.deleteTodo(todoContext(http.MethodDelete, 2))
+	if repo.todos[1].Blocked {
+		t.Fatal("1 is still blocked by a trashed todo")
+	}
+	c.restoreTodo(todoContext(http.MethodPost, 2))
+	if !repo.todos[1].Blocked {
+		t.Fatal("1 is not blocked again once its blocker is restored")
+	}
+
+	c.deleteTodo(todoContext(http.MethodDelete, 2))
+	ctx, w := versionedContext(2, "/api/v2/todos/1")
+	ctx.Params = gin.Params{{Key: "id", Value: "1"}}
+	c.markTodoDone(ctx)
+	if w.Code != http.StatusOK || !repo.todos[1].Done {
+		t.Errorf("status = %d, want 1 completed once its blocker is trashed: %s", w.Code, w.Body)
+	}
+}
@@ -0,0 +1,231 @@
+package main
+
+import (
+	"database/sql"
+	"errors"
+	"slices"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/lib/pq"
+)
+
+var (
+	ErrTodoBlocked        = errors.New("todo has open blockers")
+	ErrBlockerNotFound    = errors.New("blocker todo does not exist or is in the trash")
+	ErrDependencyCycle    = errors.New("dependency would create a cycle")
+	ErrDependencyNotFound = errors.New("todo does not depend on the blocker")
+)
+
+func (t todoRepository) GetBlockers(id int) ([]Todo, error) {
+	if _, err := t.GetTodo(id); err != nil {
+		return nil, err
+	}
+	blockers := make([]Todo, 0)
+	err := sqlx.Select(t.q, &blockers, `
+		SELECT `+todoColumns+` FROM todos
+		WHERE id IN (SELECT blocker_id FROM todo_dependencies WHERE todo_id = $1)
+		ORDER BY id`, id)
+	return blockers, err
+}
+
+func (t todoRepository) AddBlocker(id, blockerID int) (Todo, bool, error) {
+	var (
+		todo  Todo
+		added bool
+	)
+	err := t.lockDependencies(func(repo todoRepository) error {
+		var err error
+		todo, err = repo.auditedChanges(id, ActionUpdated, func(repo todoRepository) (Todo, map[string]FieldChange, error) {
+			before, err := repo.GetTodo(id)
+			if err != nil {
+				return before, nil, err
+			}
+			if _, err := repo.GetTodo(blockerID); errors.Is(err, ErrTodoNotFound) {
+				return before, nil, ErrBlockerNotFound
+			} else if err != nil {
+				return before, nil, err
+			}
+			if err := repo.checkCycle(id, blockerID); err != nil {
+				return before, nil, err
+			}
+
+			blockers, err := repo.blockerIDs(id)
+			if err != nil {
+				return before, nil, err
+			}
+			if slices.Contains(blockers, blockerID) {
+				return before, nil, nil
+			}
+			if _, err := repo.q.Exec("INSERT INTO todo_dependencies (todo_id, blocker_id) VALUES ($1, $2)", id, blockerID); err != nil {
+				return before, nil, err
+			}
+			added = true
+			return repo.blockersChanged(before, blockers)
+		})
+		return err
+	})
+	return todo, added, err
+}
+
+// lockDependencies runs fn in a transaction that holds the lock serializing
+// dependency changes, so two concurrent additions cannot close a cycle
+// that neither would on its own. The lock is taken before auditedChanges
+// locks the todo, which a concurrent addition may need to check its
+// foreign key.
+func (t todoRepository) lockDependencies(fn func(repo todoRepository) error) error {
+	return t.transaction(func(repo todoRepository) error {
+		if _, err := repo.q.Exec("SELECT pg_advisory_xact_lock(hashtext('todo_dependencies'))"); err != nil {
+			return err
+		}
+		return fn(repo)
+	})
+}
+
+// checkCycle fails with ErrDependencyCycle if blockerID depends on id.
+func (t todoRepository) checkCycle(id, blockerID int) error {
+	var cycle bool
+	err := sqlx.Get(t.q, &cycle, `
+		WITH RECURSIVE upstream AS (
+			SELECT $2::integer AS id
+			UNION
+			SELECT d.blocker_id FROM todo_dependencies d
+			JOIN upstream u ON d.todo_id = u.id
+		)
+		SELECT EXISTS (SELECT 1 FROM upstream WHERE id = $1)`, id, blockerID)
+	if err != nil {
+		return err
+	}
+	if cycle {
+		return ErrDependencyCycle
+	}
+	return nil
+}
+
+func (t todoRepository) RemoveBlocker(id, blockerID int) (Todo, error) {
+	return t.auditedChanges(id, ActionUpdated, func(repo todoRepository) (Todo, map[string]FieldChange, error) {
+		before, err := repo.GetTodo(id)
+		if err != nil {
+			return before, nil, err
+		}
+		blockers, err := repo.blockerIDs(id)
+		if err != nil {
+			return before, nil, err
+		}
+		if !slices.Contains(blockers, blockerID) {
+			return before, nil, ErrDependencyNotFound
+		}
+		if _, err := repo.q.Exec("DELETE FROM todo_dependencies WHERE todo_id = $1 AND blocker_id = $2", id, blockerID); err != nil {
+			return before, nil, err
+		}
+		return repo.blockersChanged(before, blockers)
+	})
+}
+
+// revertBlockers gives id the blockers it had before a change, guarded by
+// pre. A blocker that has since been trashed or would now close a cycle
+// fails the revert.
+func (t todoRepository) revertBlockers(id int, pre Precondition, blockers []int) (Todo, error) {
+	var todo Todo
+	err := t.lockDependencies(func(repo todoRepository) error {
+		var err error
+		todo, err = repo.auditedChanges(id, ActionUpdated, func(repo todoRepository) (Todo, map[string]FieldChange, error) {
+			var before Todo
+			err := sqlx.Get(repo.q, &before, `
+				SELECT `+todoColumns+` FROM todos
+				WHERE id = $1 AND deleted_at IS NULL AND `+preconditionSQL, id, pre.Revision, pq.Array(pre.Versions))
+			if errors.Is(err, sql.ErrNoRows) {
+				return before, nil, repo.missOrConflict(id)
+			}
+			if err != nil {
+				return before, nil, err
+			}
+			current, err := repo.blockerIDs(id)
+			if err != nil {
+				return before, nil, err
+			}
+			if _, err := repo.q.Exec("DELETE FROM todo_dependencies WHERE todo_id = $1 AND NOT blocker_id = ANY($2)", id, pq.Array(blockers)); err != nil {
+				return before, nil, err
+			}
+			for _, blockerID := range blockers {
+				if slices.Contains(current, blockerID) {
+					continue
+				}
+				if _, err := repo.GetTodo(blockerID); errors.Is(err, ErrTodoNotFound) {
+					return before, nil, ErrBlockerNotFound
+				} else if err != nil {
+					return before, nil, err
+				}
+				if err := repo.checkCycle(id, blockerID); err != nil {
+					return before, nil, err
+				}
+				if _, err := repo.q.Exec("INSERT INTO todo_dependencies (todo_id, blocker_id) VALUES ($1, $2)", id, blockerID); err != nil {
+					return before, nil, err
+				}
+			}
+			return repo.blockersChanged(before, current)
+		})
+		return err
+	})
+	return todo, err
+}
+
+func (t todoRepository) blockerIDs(id int) ([]int, error) {
+	ids := make([]int, 0)
+	err := sqlx.Select(t.q, &ids, "SELECT blocker_id FROM todo_dependencies WHERE todo_id = $1 ORDER BY blocker_id", id)
+	return ids, err
+}
+
+// blockersChanged finishes a change to the blockers of before, which had
+// blockers until then. Changing blockers only updates the todo's row when
+// it changes blocked, so the version is bumped here otherwise: every
+// change gets its own version for ETags and undo to refer to.
+func (t todoRepository) blockersChanged(before Todo, blockers []int) (Todo, map[string]FieldChange, error) {
+	if _, err := t.q.Exec("UPDATE todos SET version = version WHERE id = $1 AND version = $2", before.ID, before.Version); err != nil {
+		return before, nil, err
+	}
+	after, err := t.GetTodo(before.ID)
+	if err != nil {
+		return after, nil, err
+	}
+	now, err := t.blockerIDs(before.ID)
+	if err != nil {
+		return after, nil, err
+	}
+	return after, map[string]FieldChange{"blockers": {From: blockers, To: now}}, nil
+}
+
+// checkUnblocked fails with ErrTodoBlocked if completing blocked todos is
+// refused and id is an open todo with open blockers.
+func (t todoRepository) checkUnblocked(id int) error {
+	if !t.refuseBlocked {
+		return nil
+	}
+	var blocked bool
+	err := sqlx.Get(t.q, &blocked, "SELECT blocked AND NOT done FROM todos WHERE id = $1", id)
+	switch {
+	case errors.Is(err, sql.ErrNoRows):
+		// The write itself reports the missing todo.
+		return nil
+	case err != nil:
+		return err
+	case blocked:
+		return ErrTodoBlocked
+	}
+	return nil
+}
+
+// GetUnblocked relies on the blocked triggers running in the transaction
+// that completed blocker: the todos they unblocked were written after it,
+// so their revision is higher.
+func (t todoRepository) GetUnblocked(blocker Todo) ([]Todo, error) {
+	todos := make([]Todo, 0)
+	if !blocker.Done {
+		return todos, nil
+	}
+	err := sqlx.Select(t.q, &todos, `
+		SELECT `+todoColumns+` FROM todos
+		WHERE id IN (SELECT todo_id FROM todo_dependencies WHERE blocker_id = $1)
+			AND NOT blocked AND NOT done AND deleted_at IS NULL AND revision > $2
+		ORDER BY id`, blocker.ID, blocker.Revision)
+	return todos, err
+}
@@ -0,0 +1,82 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+// todoETag is a strong validator for one todo: the version changes on
+// every write to it.
+func todoETag(todo Todo) string {
+	return fmt.Sprintf(`"v%d"`, todo.Version)
+}
+
+// listETag identifies the whole todo list. The API version is part of the
+// tag because v1 and v2 serialise the same list differently, and lists with
+// and without trashed todos differ as well.
+func listETag(ctx *gin.Context, revision int64, includeDeleted bool) string {
+	if includeDeleted {
+		return fmt.Sprintf(`"todos-v%d-r%d-all"`, ctx.GetInt(apiVersionKey), revision)
+	}
+	return fmt.Sprintf(`"todos-v%d-r%d"`, ctx.GetInt(apiVersionKey), revision)
+}
+
+func setTodoETag(ctx *gin.Context, todo Todo) {
+	ctx.Header("ETag", todoETag(todo))
+}
+
+// ifMatchPrecondition turns the If-Match header into a Precondition. "*"
+// only requires the todo to exist. Tags this API did not issue can never
+// match, so a header made only of those fails outright.
+func (c *TodosController) ifMatchPrecondition(ctx *gin.Context, id int) (Precondition, *APIError) {
+	header := strings.TrimSpace(ctx.GetHeader("If-Match"))
+	if header == "" {
+		if c.config.RequireIfMatch {
+			return Precondition{}, errPreconditionRequired()
+		}
+		return Precondition{}, nil
+	}
+	if header == "*" {
+		return Precondition{}, nil
+	}
+
+	var pre Precondition
+	for _, tag := range strings.Split(header, ",") {
+		// If-Match uses the strong comparison, so weak tags never match,
+		// and neither do tags without their quotes.
+		tag = strings.TrimSpace(tag)
+		if len(tag) < 2 || tag[0] != '"' || tag[len(tag)-1] != '"' {
+			continue
+		}
+		raw, ok := strings.CutPrefix(tag[1:len(tag)-1], "v")
+		if !ok {
+			continue
+		}
+		if version, err := strconv.ParseInt(raw, 10, 64); err == nil && version > 0 {
+			pre.Versions = append(pre.Versions, version)
+		}
+	}
+	if len(pre.Versions) == 0 {
+		return Precondition{}, errPreconditionFailed(id)
+	}
+	return pre, nil
+}
+
+// ifNoneMatch reports whether the If-None-Match header matches etag, using
+// the weak comparison as RFC 9110 requires.
+func ifNoneMatch(ctx *gin.Context, etag string) bool {
+	header := ctx.GetHeader("If-None-Match")
+	if header == "" {
+		return false
+	}
+	for _, tag := range strings.Split(header, ",") {
+		tag = strings.TrimPrefix(strings.TrimSpace(tag), "W/")
+		if tag == "*" || tag == etag {
+			return true
+		}
+	}
+	return false
+}
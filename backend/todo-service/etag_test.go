@@ -0,0 +1,96 @@
+package main
+
+import (
+	"net/http"
+	"slices"
+	"testing"
+)
+
+func TestIfMatchPrecondition(t *testing.T) {
+	tests := []struct {
+		name     string
+		header   string
+		require  bool
+		want     []int64
+		wantCode string
+	}{
+		{name: "absent", header: ""},
+		{name: "absent but required", header: "", require: true, wantCode: CodePreconditionNeeded},
+		{name: "any", header: "*", require: true},
+		{name: "one", header: `"v3"`, want: []int64{3}},
+		{name: "list", header: `"v3", "v5"`, want: []int64{3, 5}},
+		{name: "list with spacing", header: ` "v3" ,"v5",`, want: []int64{3, 5}},
+		{name: "weak only", header: `W/"v3"`, wantCode: CodePreconditionFailed},
+		{name: "weak next to strong", header: `W/"v3", "v4"`, want: []int64{4}},
+		{name: "list tag", header: `"todos-v2-r5"`, wantCode: CodePreconditionFailed},
+		{name: "unquoted", header: `v3`, wantCode: CodePreconditionFailed},
+		{name: "unterminated", header: `"v3`, wantCode: CodePreconditionFailed},
+		{name: "not a number", header: `"vx"`, wantCode: CodePreconditionFailed},
+		{name: "zero", header: `"v0"`, wantCode: CodePreconditionFailed},
+		{name: "garbage next to a tag", header: `"v3", garbage`, want: []int64{3}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c, _ := newTestController(t, newMemoryRepository())
+			c.config.RequireIfMatch = tt.require
+			ctx := todoContext(http.MethodPatch, 1)
+			if tt.header != "" {
+				ctx.Request.Header.Set("If-Match", tt.header)
+			}
+
+			pre, apiErr := c.ifMatchPrecondition(ctx, 1)
+			switch {
+			case apiErr != nil && apiErr.Code != tt.wantCode:
+				t.Errorf("error = %s, want %q", apiErr.Code, tt.wantCode)
+			case apiErr == nil && tt.wantCode != "":
+				t.Errorf("precondition = %+v, want %s", pre, tt.wantCode)
+			case !slices.Equal(pre.Versions, tt.want):
+				t.Errorf("versions = %v, want %v", pre.Versions, tt.want)
+			}
+		})
+	}
+}
+
+func TestIfNoneMatch(t *testing.T) {
+	tests := []struct {
+		header string
+		want   bool
+	}{
+		{"", false},
+		{"*", true},
+		{`"v3"`, true},
+		{`W/"v3"`, true},
+		{`"v2", "v3"`, true},
+		{`"v2",W/"v3"`, true},
+		{`"v2"`, false},
+		{`v3`, false},
+		{`"v3`, false},
+		{`"V3"`, false},
+	}
+	for _, tt := range tests {
+		ctx, _ := versionedContext(2, "/api/v2/todos/1")
+		if tt.header != "" {
+			ctx.Request.Header.Set("If-None-Match", tt.header)
+		}
+		if got := ifNoneMatch(ctx, `"v3"`); got != tt.want {
+			t.Errorf("ifNoneMatch(%q) = %v, want %v", tt.header, got, tt.want)
+		}
+	}
+}
+
+func TestListETag(t *testing.T) {
+	v1, _ := versionedContext(1, "/api/todos")
+	v2, _ := versionedContext(2, "/api/v2/todos")
+
+	tags := []string{listETag(v1, 7, false), listETag(v2, 7, false), listETag(v2, 7, true), listETag(v2, 8, false)}
+	want := []string{`"todos-v1-r7"`, `"todos-v2-r7"`, `"todos-v2-r7-all"`, `"todos-v2-r8"`}
+	if !slices.Equal(tags, want) {
+		t.Errorf("tags = %v, want %v", tags, want)
+	}
+
+	// A v1 client revalidating against v2 must get the v2 body, not a 304.
+	v2.Request.Header.Set("If-None-Match", tags[0])
+	if ifNoneMatch(v2, tags[1]) {
+		t.Error("the v1 tag matched the v2 list")
+	}
+}
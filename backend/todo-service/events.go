@@ -0,0 +1,76 @@
+package main
+
+import (
+	"encoding/json"
+
+	"github.com/nats-io/nats.go"
+	"github.com/rs/zerolog/log"
+)
+
+const todoSubjects = "todo.*"
+
+// EventBus publishes todo events to NATS and feeds every todo.* message,
+// including those published by other replicas, into the stream hub. Without
+// NATS the events are handed to the hub directly.
+type EventBus struct {
+	nc  *nats.Conn
+	hub *StreamHub
+}
+
+func NewEventBus(natsURL string, hub *StreamHub) *EventBus {
+	bus := &EventBus{hub: hub}
+	if natsURL == "" {
+		log.Warn().Msg("NATS_URL is not set, events are only delivered to local stream clients")
+		return bus
+	}
+
+	nc, err := nats.Connect(natsURL,
+		nats.RetryOnFailedConnect(true),
+		nats.MaxReconnects(-1),
+		nats.DisconnectErrHandler(func(_ *nats.Conn, err error) {
+			log.Warn().Err(err).Msg("Disconnected from NATS")
+		}),
+		nats.ReconnectHandler(func(_ *nats.Conn) {
+			log.Info().Msg("Reconnected to NATS")
+		}),
+	)
+	if err != nil {
+		log.Error().Err(err).Msg("Failed to connect to NATS")
+		return bus
+	}
+	bus.nc = nc
+
+	if _, err := nc.Subscribe(todoSubjects, func(m *nats.Msg) {
+		hub.Broadcast(m.Subject, m.Data)
+	}); err != nil {
+		log.Error().Err(err).Msg("Failed to subscribe to todo events")
+	}
+
+	return bus
+}
+
+func (b *EventBus) Publish(subject string, payload any) {
+	data, err := json.Marshal(payload)
+	if err != nil {
+		log.Error().Err(err).Str("subject", subject).Msg("Failed to marshal NATS message")
+		return
+	}
+
+	if b.nc == nil {
+		b.hub.Broadcast(subject, data)
+		return
+	}
+
+	if err := b.nc.Publish(subject, data); err != nil {
+		log.Error().Err(err).Str("subject", subject).Msg("Failed to publish NATS message")
+	}
+}
+
+func (b *EventBus) Close() {
+	if b.nc == nil {
+		return
+	}
+	if err := b.nc.Drain(); err != nil {
+		log.Error().Err(err).Msg("Failed to drain NATS connection")
+	}
+}
@@ -0,0 +1,77 @@
+package main
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/rs/zerolog/log"
+)
+
+// exportTodos streams the live todos matching the filters of search as a
+// file, each subtask right after its parent. A subtask whose parent is
+// filtered out is written as a top-level todo, so the file imports again.
+func (c *TodosController) exportTodos(ctx *gin.Context) {
+	format := ctx.DefaultQuery("format", formatJSON)
+	file, ok := exportFormats[format]
+	if !ok {
+		respondError(ctx, errInvalidQuery("format", "format must be csv, json, todotxt, md or ics"))
+		return
+	}
+	filter, apiErr := parseTodoFilter(ctx)
+	if apiErr != nil {
+		respondError(ctx, apiErr)
+		return
+	}
+
+	var exporter todoExporter
+	start := func() (err error) {
+		ctx.Header("Content-Type", file.contentType)
+		ctx.Header("Content-Disposition", `attachment; filename="`+file.filename+`"`)
+		ctx.Status(http.StatusOK)
+		exporter, err = newExporter(format, ctx.Writer, c.config.Location(), time.Now())
+		return err
+	}
+	// depths holds the todos written so far and how deep each is nested.
+	depths := make(map[int]int)
+	err := c.repo.ExportTodos(filter, func(todo Todo) error {
+		if exporter == nil {
+			if err := start(); err != nil {
+				return err
+			}
+		}
+		var parentID *int
+		depth := 0
+		if todo.ParentID != nil {
+			if parentDepth, ok := depths[*todo.ParentID]; ok {
+				parentID, depth = todo.ParentID, parentDepth+1
+			}
+		}
+		depths[todo.ID] = depth
+		return exporter.write(todo, parentID, depth)
+	})
+	if err == nil && exporter == nil {
+		err = start()
+	}
+	if err == nil {
+		err = exporter.close()
+	}
+	if err != nil {
+		if ctx.Writer.Written() {
+			// The status is sent, so the client only sees a cut-off file.
+			log.Error().Err(err).Str("format", format).Int("count", len(depths)).Msg("Export failed while streaming")
+			return
+		}
+		ctx.Writer.Header().Del("Content-Disposition")
+		ctx.Writer.Header().Del("Content-Type")
+		log.Error().Err(err).Msg("Failed to export todos")
+		respondError(ctx, errInternal(err))
+		return
+	}
+
+	log.Info().
+		Str("path", ctx.FullPath()).
+		Str("format", format).
+		Int("count", len(depths)).
+		Msg("Todos exported")
+}
@@ -0,0 +1,353 @@
+package main
+
+import (
+	"bufio"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+const formatICS = "ics"
+
+// exportFormats gives the Content-Type and file name of each export format.
+var exportFormats = map[string]struct{ contentType, filename string }{
+	formatCSV:      {"text/csv; charset=utf-8", "todos.csv"},
+	formatJSON:     {"application/json; charset=utf-8", "todos.json"},
+	formatTodoTxt:  {"text/plain; charset=utf-8", "todo.txt"},
+	formatMarkdown: {"text/markdown; charset=utf-8", "todos.md"},
+	formatICS:      {"text/calendar; charset=utf-8", "todos.ics"},
+}
+
+// todoExporter writes todos in one export format. The files it writes can
+// be imported again, except iCalendar ones.
+type todoExporter interface {
+	// write adds a todo. parentID is nil when the todo is written without
+	// its parent, and depth counts the ancestors written before it.
+	write(todo Todo, parentID *int, depth int) error
+	// close ends the file.
+	close() error
+}
+
+// newExporter returns the exporter of format, writing dates without a time
+// of day in loc and stamping iCalendar items with now.
+func newExporter(format string, w io.Writer, loc *time.Location, now time.Time) (todoExporter, error) {
+	switch format {
+	case formatCSV:
+		return newCSVExporter(w)
+	case formatJSON:
+		return &jsonExporter{w: bufio.NewWriter(w)}, nil
+	case formatTodoTxt:
+		return &lineExporter{w: bufio.NewWriter(w), line: todoTxtLine, loc: loc}, nil
+	case formatMarkdown:
+		return &lineExporter{w: bufio.NewWriter(w), line: markdownLine, loc: loc}, nil
+	case formatICS:
+		return newICSExporter(w, icsCalendar{Todos: true}, loc, now)
+	default:
+		return nil, fmt.Errorf("unknown format %q", format)
+	}
+}
+
+type csvExporter struct {
+	w *csv.Writer
+}
+
+func newCSVExporter(w io.Writer) (*csvExporter, error) {
+	e := &csvExporter{w: csv.NewWriter(w)}
+	return e, e.w.Write([]string{"task", "done", "priority", "due_at", "tags", "id", "parent_id"})
+}
+
+func (e *csvExporter) write(todo Todo, parentID *int, _ int) error {
+	record := []string{todo.Task, strconv.FormatBool(todo.Done), strconv.Itoa(todo.Priority), "",
+		strings.Join(todo.Tags, " "), strconv.Itoa(todo.ID), ""}
+	if todo.DueAt != nil {
+		record[3] = todo.DueAt.Format(time.RFC3339)
+	}
+	if parentID != nil {
+		record[6] = strconv.Itoa(*parentID)
+	}
+	return e.w.Write(record)
+}
+
+func (e *csvExporter) close() error {
+	e.w.Flush()
+	return e.w.Error()
+}
+
+// exportTodo is a todo in a JSON export, with the fields an import reads.
+type exportTodo struct {
+	ID       int        `json:"id"`
+	ParentID *int       `json:"parent_id"`
+	Task     string     `json:"task"`
+	Done     bool       `json:"done"`
+	Priority int        `json:"priority"`
+	DueAt    *time.Time `json:"due_at"`
+	Tags     []string   `json:"tags"`
+}
+
+// jsonExporter writes an array, one todo per line.
+type jsonExporter struct {
+	w *bufio.Writer
+	n int
+}
+
+func (e *jsonExporter) write(todo Todo, parentID *int, _ int) error {
+	data, err := json.Marshal(exportTodo{
+		ID:       todo.ID,
+		ParentID: parentID,
+		Task:     todo.Task,
+		Done:     todo.Done,
+		Priority: todo.Priority,
+		DueAt:    todo.DueAt,
+		Tags:     append([]string{}, todo.Tags...),
+	})
+	if err != nil {
+		return err
+	}
+	separator := ",\n"
+	if e.n == 0 {
+		separator = "[\n"
+	}
+	e.n++
+	e.w.WriteString(separator)
+	_, err = e.w.Write(data)
+	return err
+}
+
+func (e *jsonExporter) close() error {
+	if e.n == 0 {
+		e.w.WriteString("[")
+	}
+	e.w.WriteString("\n]\n")
+	return e.w.Flush()
+}
+
+// lineExporter writes one line per todo, for todo.txt and Markdown.
+type lineExporter struct {
+	w    *bufio.Writer
+	line func(todo Todo, depth int, loc *time.Location) string
+	loc  *time.Location
+}
+
+func (e *lineExporter) write(todo Todo, _ *int, depth int) error {
+	_, err := e.w.WriteString(e.line(todo, depth, e.loc) + "\n")
+	return err
+}
+
+func (e *lineExporter) close() error {
+	return e.w.Flush()
+}
+
+// todoTxtLine writes a todo as todo.txt. The default priority P2 is left
+// out, tags become +projects, and subtasks are listed like other todos.
+// Done todos keep their priority as pri:, as todo.txt tools do.
+func todoTxtLine(todo Todo, _ int, loc *time.Location) string {
+	var words []string
+	letter := string(rune('A' + todo.Priority))
+	switch {
+	case todo.Done:
+		words = append(words, "x")
+	case todo.Priority != defaultPriority:
+		words = append(words, "("+letter+")")
+	}
+	words = append(words, strings.Fields(todo.Task)...)
+	for _, tag := range todo.Tags {
+		words = append(words, "+"+tag)
+	}
+	if todo.DueAt != nil {
+		words = append(words, "due:"+exportDue(*todo.DueAt, loc))
+	}
+	if todo.Done && todo.Priority != defaultPriority {
+		words = append(words, "pri:"+letter)
+	}
+	return strings.Join(words, " ")
+}
+
+// markdownLine writes a todo as a checklist item, indented under its
+// parent, with the priority, tags and due date as an import reads them.
+func markdownLine(todo Todo, depth int, loc *time.Location) string {
+	check := "[ ]"
+	if todo.Done {
+		check = "[x]"
+	}
+	words := append([]string{strings.Repeat("  ", depth) + "-", check}, strings.Fields(todo.Task)...)
+	if todo.Priority != defaultPriority {
+		words = append(words, "!p"+strconv.Itoa(todo.Priority))
+	}
+	for _, tag := range todo.Tags {
+		words = append(words, "#"+tag)
+	}
+	if todo.DueAt != nil {
+		words = append(words, "due:"+exportDue(*todo.DueAt, loc))
+	}
+	return strings.Join(words, " ")
+}
+
+// exportDue writes a due date as a plain date when it is at the end of the
+// day in loc, as imported dates are, and as an RFC 3339 time otherwise.
+func exportDue(due time.Time, loc *time.Location) string {
+	local := due.In(loc)
+	if local.Hour() == 23 && local.Minute() == 59 && local.Second() == 0 {
+		return local.Format(time.DateOnly)
+	}
+	return due.Format(time.RFC3339)
+}
+
+// icsCalendar says what an iCalendar file holds.
+type icsCalendar struct {
+	// Name and Refresh are shown to and followed by subscribed clients.
+	Name    string
+	Refresh time.Duration
+	// Todos adds a VTODO per todo, and Events a VEVENT per todo with a due
+	// date, for calendars that do not show todos.
+	Todos  bool
+	Events bool
+}
+
+// icsExporter writes an iCalendar file. Items are stamped with now, so an
+// unchanged set of todos is written the same way every time.
+type icsExporter struct {
+	w   *bufio.Writer
+	cal icsCalendar
+	loc *time.Location
+	now time.Time
+}
+
+func newICSExporter(w io.Writer, cal icsCalendar, loc *time.Location, now time.Time) (*icsExporter, error) {
+	e := &icsExporter{w: bufio.NewWriter(w), cal: cal, loc: loc, now: now}
+	lines := []string{"BEGIN:VCALENDAR", "VERSION:2.0", "PRODID:-//todo-app//todo-service//EN", "CALSCALE:GREGORIAN"}
+	if cal.Name != "" {
+		lines = append(lines, "X-WR-CALNAME:"+icsText(cal.Name))
+	}
+	if cal.Refresh > 0 {
+		refresh := fmt.Sprintf("PT%dM", int(cal.Refresh.Minutes()))
+		lines = append(lines, "REFRESH-INTERVAL;VALUE=DURATION:"+refresh, "X-PUBLISHED-TTL:"+refresh)
+	}
+	return e, e.writeLines(lines...)
+}
+
+func (e *icsExporter) write(todo Todo, parentID *int, _ int) error {
+	if e.cal.Todos {
+		if err := e.writeLines(e.vtodo(todo, parentID)...); err != nil {
+			return err
+		}
+	}
+	if e.cal.Events && todo.DueAt != nil {
+		return e.writeLines(e.vevent(todo)...)
+	}
+	return nil
+}
+
+func (e *icsExporter) vtodo(todo Todo, parentID *int) []string {
+	lines := []string{
+		"BEGIN:VTODO",
+		"UID:" + icsUID(todo.ID),
+		"DTSTAMP:" + icsTime(e.now),
+		"SUMMARY:" + icsText(todo.Task),
+		"PRIORITY:" + strconv.Itoa(icsPriorities[todo.Priority]),
+	}
+	if todo.DueAt != nil {
+		lines = append(lines, "DUE:"+icsTime(*todo.DueAt))
+	}
+	if todo.Done {
+		lines = append(lines, "STATUS:COMPLETED", "PERCENT-COMPLETE:100")
+	} else {
+		lines = append(lines, "STATUS:NEEDS-ACTION")
+	}
+	lines = append(lines, icsCategories(todo)...)
+	if parentID != nil {
+		lines = append(lines, "RELATED-TO;RELTYPE=PARENT:"+icsUID(*parentID))
+	}
+	return append(lines, "END:VTODO")
+}
+
+// vevent shows a due date as an all-day event when it is at the end of the
+// day, and as an event at the due time otherwise. Events do not take up
+// time in free/busy views.
+func (e *icsExporter) vevent(todo Todo) []string {
+	lines := []string{
+		"BEGIN:VEVENT",
+		"UID:" + icsEventUID(todo.ID),
+		"DTSTAMP:" + icsTime(e.now),
+		"SUMMARY:" + icsText(todo.Task),
+	}
+	due := todo.DueAt.In(e.loc)
+	if due.Hour() == 23 && due.Minute() == 59 && due.Second() == 0 {
+		lines = append(lines, "DTSTART;VALUE=DATE:"+due.Format("20060102"), "DTEND;VALUE=DATE:"+due.AddDate(0, 0, 1).Format("20060102"))
+	} else {
+		lines = append(lines, "DTSTART:"+icsTime(due))
+	}
+	lines = append(lines, "TRANSP:TRANSPARENT")
+	lines = append(lines, icsCategories(todo)...)
+	if e.cal.Todos {
+		lines = append(lines, "RELATED-TO:"+icsUID(todo.ID))
+	}
+	return append(lines, "END:VEVENT")
+}
+
+func icsCategories(todo Todo) []string {
+	if len(todo.Tags) == 0 {
+		return nil
+	}
+	categories := make([]string, len(todo.Tags))
+	for i, tag := range todo.Tags {
+		categories[i] = icsText(tag)
+	}
+	return []string{"CATEGORIES:" + strings.Join(categories, ",")}
+}
+
+func (e *icsExporter) close() error {
+	if err := e.writeLines("END:VCALENDAR"); err != nil {
+		return err
+	}
+	return e.w.Flush()
+}
+
+// writeLines writes content lines ending in CRLF, folded after 75 octets
+// without splitting a character, as RFC 5545 requires.
+func (e *icsExporter) writeLines(lines ...string) error {
+	for _, line := range lines {
+		// The space that starts a continuation line is one of its octets.
+		for limit := 75; len(line) > limit; limit = 74 {
+			cut := limit
+			for !utf8.RuneStart(line[cut]) {
+				cut--
+			}
+			e.w.WriteString(line[:cut] + "\r\n ")
+			line = line[cut:]
+		}
+		if _, err := e.w.WriteString(line + "\r\n"); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// icsPriorities maps P0 to P3 onto the 1 (highest) to 9 (lowest) scale of
+// iCalendar.
+var icsPriorities = [...]int{1, 3, 5, 9}
+
+// icsUID is the UID of a todo in calendars. It only depends on the ID, so
+// calendar clients recognize the todo in every export and feed.
+func icsUID(id int) string {
+	return fmt.Sprintf("todo-%d@todo-app", id)
+}
+
+// icsEventUID is the UID of the event showing a todo's due date.
+func icsEventUID(id int) string {
+	return fmt.Sprintf("todo-%d-due@todo-app", id)
+}
+
+func icsTime(t time.Time) string {
+	return t.UTC().Format("20060102T150405Z")
+}
+
+var icsTextEscaper = strings.NewReplacer(`\`, `\\`, ";", `\;`, ",", `\,`, "\r\n", `\n`, "\n", `\n`, "\r", `\n`)
+
+func icsText(text string) string {
+	return icsTextEscaper.Replace(text)
+}
@@ -0,0 +1,185 @@
+package main
+
+import (
+	"bytes"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+)
+
+// exportItem is a todo as the export controller passes it to a writer.
+type exportItem struct {
+	todo     Todo
+	parentID *int
+	depth    int
+}
+
+// exportTree is 1 > 2 > 3 and 4, in the order of an export.
+func exportTree(loc *time.Location) []exportItem {
+	endOfDay := time.Date(2026, 10, 20, 23, 59, 0, 0, loc)
+	morning := time.Date(2026, 10, 19, 9, 0, 0, 0, time.UTC)
+	return []exportItem{
+		{Todo{ID: 1, Task: "Move house", Priority: 1, DueAt: &endOfDay, Tags: []string{"home"}}, nil, 0},
+		{Todo{ID: 2, Task: "Pack boxes", Done: true, Priority: defaultPriority, DueAt: &morning, Tags: []string{"home", "boxes"}}, ptr(1), 1},
+		{Todo{ID: 3, Task: "Buy tape", Priority: 0}, ptr(2), 2},
+		{Todo{ID: 4, Task: "Return keys, then leave", Done: true, Priority: 3}, nil, 0},
+	}
+}
+
+func TestExportRoundTrip(t *testing.T) {
+	helsinki, err := time.LoadLocation("Europe/Helsinki")
+	if err != nil {
+		t.Skip("no time zone data:", err)
+	}
+	tree := exportTree(helsinki)
+
+	for _, format := range []string{formatCSV, formatJSON, formatTodoTxt, formatMarkdown} {
+		t.Run(format, func(t *testing.T) {
+			var buf bytes.Buffer
+			exporter, err := newExporter(format, &buf, helsinki, time.Now())
+			if err != nil {
+				t.Fatal(err)
+			}
+			for _, item := range tree {
+				if err := exporter.write(item.todo, item.parentID, item.depth); err != nil {
+					t.Fatal(err)
+				}
+			}
+			if err := exporter.close(); err != nil {
+				t.Fatal(err)
+			}
+
+			rows, err := parseImport(format, buf.Bytes(), helsinki)
+			if err != nil {
+				t.Fatalf("import: %v\n%s", err, buf.String())
+			}
+			if len(rows) != len(tree) {
+				t.Fatalf("rows = %d, want %d\n%s", len(rows), len(tree), buf.String())
+			}
+			for i, row := range rows {
+				want := tree[i].todo
+				// todo.txt has no subtasks, so they come back as todos.
+				wantParent := -1
+				if tree[i].parentID != nil && format != formatTodoTxt {
+					wantParent = *tree[i].parentID - 1
+				}
+				priority := defaultPriority
+				if row.Priority != nil {
+					priority = *row.Priority
+				}
+				if len(row.Errors) > 0 || row.Task != want.Task || row.Done != want.Done || priority != want.Priority ||
+					!equalDue(row.DueAt, want.DueAt) || !slices.Equal(row.Tags, want.Tags) || row.Parent != wantParent {
+					t.Errorf("row %d = %s, want %+v under %d\n%s", i, summarizeImportRow(row), want, wantParent, buf.String())
+				}
+			}
+		})
+	}
+}
+
+func equalDue(a, b *time.Time) bool {
+	if a == nil || b == nil {
+		return a == b
+	}
+	return a.Equal(*b)
+}
+
+func TestExportEmpty(t *testing.T) {
+	for _, format := range []string{formatCSV, formatJSON, formatTodoTxt, formatMarkdown} {
+		var buf bytes.Buffer
+		exporter, err := newExporter(format, &buf, time.UTC, time.Now())
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := exporter.close(); err != nil {
+			t.Fatal(err)
+		}
+		rows, err := parseImport(format, buf.Bytes(), time.UTC)
+		if err != nil || len(rows) != 0 {
+			t.Errorf("%s: import of %q = %d rows, %v; want none", format, buf.String(), len(rows), err)
+		}
+	}
+}
+
+func TestExportICS(t *testing.T) {
+	now := time.Date(2026, 10, 18, 12, 0, 0, 0, time.UTC)
+	var buf bytes.Buffer
+	exporter, err := newExporter(formatICS, &buf, time.UTC, now)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, item := range exportTree(time.UTC) {
+		if err := exporter.write(item.todo, item.parentID, item.depth); err != nil {
+			t.Fatal(err)
+		}
+	}
+	long := Todo{ID: 5, Task: strings.Repeat("Päivitä ", 20), Priority: defaultPriority}
+	if err := exporter.write(long, nil, 0); err != nil {
+		t.Fatal(err)
+	}
+	if err := exporter.close(); err != nil {
+		t.Fatal(err)
+	}
+
+	out := buf.String()
+	for _, want := range []string{
+		"BEGIN:VCALENDAR\r\n",
+		"UID:todo-1@todo-app\r\nDTSTAMP:20261018T120000Z\r\nSUMMARY:Move house\r\nPRIORITY:3\r\nDUE:20261020T235900Z\r\n",
+		"RELATED-TO;RELTYPE=PARENT:todo-1@todo-app\r\n",
+		"SUMMARY:Return keys\\, then leave\r\nPRIORITY:9\r\nSTATUS:COMPLETED\r\n",
+		"CATEGORIES:home,boxes\r\n",
+		"END:VCALENDAR\r\n",
+	} {
+		if !strings.Contains(out, want) {
+			t.Errorf("export lacks %q:\n%s", want, out)
+		}
+	}
+	if n := strings.Count(out, "BEGIN:VTODO"); n != 5 {
+		t.Errorf("VTODOs = %d, want 5", n)
+	}
+
+	// Unfolding the long summary gives back the task.
+	var summary strings.Builder
+	folded := false
+	for i, line := range strings.Split(strings.TrimSuffix(out, "\r\n"), "\r\n") {
+		if len(line) > 75 {
+			t.Errorf("line %d is %d octets, want at most 75", i+1, len(line))
+		}
+		switch {
+		case strings.HasPrefix(line, "SUMMARY:Päivitä"):
+			summary.WriteString(line)
+			folded = true
+		case folded && strings.HasPrefix(line, " "):
+			summary.WriteString(line[1:])
+		default:
+			folded = false
+		}
+	}
+	if got, want := summary.String(), "SUMMARY:"+long.Task; got != want {
+		t.Errorf("unfolded = %q, want %q", got, want)
+	}
+	if _, err := parseImport(formatICS, buf.Bytes(), time.UTC); err == nil {
+		t.Error("iCalendar import succeeded, want an error")
+	}
+}
+
+func TestExportDue(t *testing.T) {
+	helsinki, err := time.LoadLocation("Europe/Helsinki")
+	if err != nil {
+		t.Skip("no time zone data:", err)
+	}
+	tests := []struct {
+		due  time.Time
+		want string
+	}{
+		{time.Date(2026, 10, 20, 23, 59, 0, 0, helsinki), "2026-10-20"},
+		{time.Date(2026, 10, 20, 20, 59, 0, 0, time.UTC), "2026-10-20"},
+		{time.Date(2026, 10, 20, 23, 59, 0, 0, time.UTC), "2026-10-20T23:59:00Z"},
+		{time.Date(2026, 10, 20, 23, 59, 30, 0, helsinki), "2026-10-20T23:59:30+03:00"},
+	}
+	for _, tt := range tests {
+		if got := exportDue(tt.due, helsinki); got != tt.want {
+			t.Errorf("exportDue(%v) = %s, want %s", tt.due, got, tt.want)
+		}
+	}
+}
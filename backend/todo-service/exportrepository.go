@@ -0,0 +1,33 @@
+package main
+
+func (t todoRepository) ExportTodos(filter TodoFilter, fn func(Todo) error) error {
+	// Walking the tree from the top-level todos, and from subtasks whose
+	// parent is in the trash, orders every todo right after its parent.
+	rows, err := t.q.Queryx(`
+		WITH RECURSIVE tree AS (
+			SELECT t.id, ARRAY[t.id] AS path FROM todos t
+			WHERE t.deleted_at IS NULL AND NOT EXISTS (
+				SELECT 1 FROM todos p WHERE p.id = t.parent_id AND p.deleted_at IS NULL)
+			UNION ALL
+			SELECT t.id, tree.path || t.id FROM todos t
+			JOIN tree ON t.parent_id = tree.id
+			WHERE t.deleted_at IS NULL
+		)
+		SELECT `+prefixColumns("t")+` FROM tree JOIN todos t ON t.id = tree.id
+		WHERE `+filterConditions+`
+		ORDER BY tree.path`, filter.filterArgs()...)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var todo Todo
+		if err := rows.StructScan(&todo); err != nil {
+			return err
+		}
+		if err := fn(todo); err != nil {
+			return err
+		}
+	}
+	return rows.Err()
+}
@@ -0,0 +1,209 @@
+package main
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net/http"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/rs/zerolog/log"
+)
+
+// feedRefresh is how often subscribed calendars are asked to fetch a feed.
+const feedRefresh = 15 * time.Minute
+
+type feedRequest struct {
+	Name string `json:"name" binding:"required,max=80"`
+	// ListID limits the feed to one list; by default it holds every list.
+	ListID *int `json:"list_id"`
+	// Components defaults to both VTODO and VEVENT.
+	Components []string `json:"components" binding:"omitempty,dive,oneof=VTODO VEVENT"`
+}
+
+func (c *TodosController) getFeeds(ctx *gin.Context) {
+	feeds, err := c.repo.GetFeeds()
+	if err != nil {
+		log.Error().Err(err).Msg("Failed to get calendar feeds")
+		respondError(ctx, errInternal(err))
+		return
+	}
+
+	log.Info().
+		Str("path", ctx.FullPath()).
+		Int("count", len(feeds)).
+		Msg("Calendar feeds received")
+	respondWithMeta(ctx, http.StatusOK, feeds, gin.H{"count": len(feeds)}, nil)
+}
+
+// createFeed returns the feed with its token and webcal URL. They are not
+// shown again; a lost token means deleting the feed and creating another.
+func (c *TodosController) createFeed(ctx *gin.Context) {
+	var request feedRequest
+	if err := ctx.ShouldBindJSON(&request); err != nil {
+		log.Error().Err(err).Msg("Failed to parse request body")
+		respondError(ctx, errInvalidBody(err))
+		return
+	}
+	if request.ListID != nil {
+		if _, err := c.repo.GetList(*request.ListID); err != nil {
+			c.respondTodoError(ctx, 0, err, "create calendar feed")
+			return
+		}
+	}
+	components := []string{feedTodos, feedEvents}
+	if len(request.Components) > 0 {
+		components = slices.DeleteFunc(components, func(component string) bool {
+			return !slices.Contains(request.Components, component)
+		})
+	}
+
+	b := make([]byte, 24)
+	if _, err := rand.Read(b); err != nil {
+		log.Error().Err(err).Msg("Failed to generate calendar feed token")
+		respondError(ctx, errInternal(err))
+		return
+	}
+	token := base64.RawURLEncoding.EncodeToString(b)
+	feed, err := c.repo.AddFeed(CalendarFeed{
+		Name:       request.Name,
+		ListID:     request.ListID,
+		Owner:      callerID(ctx),
+		Components: components,
+	}, feedTokenHash(token))
+	if err != nil {
+		c.respondTodoError(ctx, 0, err, "create calendar feed")
+		return
+	}
+	feed.Token = token
+
+	log.Info().
+		Str("path", ctx.FullPath()).
+		Int("id", feed.ID).
+		Msg("Calendar feed created")
+	respondWithMeta(ctx, http.StatusCreated, feed, gin.H{"url": "webcal://" + ctx.Request.Host + "/feeds/" + token + ".ics"}, nil)
+}
+
+func (c *TodosController) deleteFeed(ctx *gin.Context) {
+	id, ok := todoIDParam(ctx)
+	if !ok {
+		return
+	}
+
+	if err := c.repo.DeleteFeed(id); err != nil {
+		if errors.Is(err, ErrFeedNotFound) {
+			log.Warn().Str("path", ctx.FullPath()).Int("id", id).Msg("delete calendar feed failed: feed not found")
+			respondError(ctx, errFeedNotFound())
+			return
+		}
+		log.Error().Err(err).Int("id", id).Msg("delete calendar feed failed")
+		respondError(ctx, errInternal(err))
+		return
+	}
+
+	log.Info().
+		Str("path", ctx.FullPath()).
+		Int("id", id).
+		Msg("Calendar feed deleted")
+	ctx.Status(http.StatusNoContent)
+}
+
+// serveFeed writes the iCalendar file a subscribed calendar fetches. The
+// token in the path is the only credential, as calendar clients cannot
+// send headers. Feeds are only written again after a todo has changed.
+func (c *TodosController) serveFeed(ctx *gin.Context) {
+	token := strings.TrimSuffix(ctx.Param("token"), ".ics")
+	feed, err := c.repo.GetFeedByToken(feedTokenHash(token))
+	if err != nil {
+		if errors.Is(err, ErrFeedNotFound) {
+			log.Warn().Str("path", ctx.FullPath()).Msg("calendar feed rejected: unknown token")
+			respondError(ctx, errFeedNotFound())
+			return
+		}
+		log.Error().Err(err).Msg("Failed to get calendar feed")
+		respondError(ctx, errInternal(err))
+		return
+	}
+
+	// As for the todo list, the revision is read before the todos.
+	revision, err := c.repo.LatestRevision()
+	var modified time.Time
+	if err == nil {
+		modified, err = c.repo.LastModified()
+	}
+	if err != nil {
+		log.Error().Err(err).Msg("Failed to get latest revision")
+		respondError(ctx, errInternal(err))
+		return
+	}
+	modified = modified.UTC().Truncate(time.Second)
+	etag := fmt.Sprintf(`"feed-%d-r%d"`, feed.ID, revision)
+	ctx.Header("ETag", etag)
+	ctx.Header("Last-Modified", modified.Format(http.TimeFormat))
+	ctx.Header("Cache-Control", "private, max-age=300")
+	if notModified(ctx, etag, modified) {
+		ctx.Status(http.StatusNotModified)
+		return
+	}
+
+	todos, err := c.repo.GetFeedTodos(feed.ListID)
+	if err != nil {
+		log.Error().Err(err).Int("feed", feed.ID).Msg("Failed to get calendar feed todos")
+		respondError(ctx, errInternal(err))
+		return
+	}
+	inFeed := make(map[int]bool, len(todos))
+	for _, todo := range todos {
+		inFeed[todo.ID] = true
+	}
+
+	ctx.Header("Content-Type", exportFormats[formatICS].contentType)
+	ctx.Status(http.StatusOK)
+	exporter, err := newICSExporter(ctx.Writer, icsCalendar{
+		Name:    feed.Name,
+		Refresh: feedRefresh,
+		Todos:   slices.Contains(feed.Components, feedTodos),
+		Events:  slices.Contains(feed.Components, feedEvents),
+	}, c.config.Location(), modified)
+	for i := 0; err == nil && i < len(todos); i++ {
+		var parentID *int
+		if todos[i].ParentID != nil && inFeed[*todos[i].ParentID] {
+			parentID = todos[i].ParentID
+		}
+		err = exporter.write(todos[i], parentID, 0)
+	}
+	if err == nil {
+		err = exporter.close()
+	}
+	if err != nil {
+		log.Error().Err(err).Int("feed", feed.ID).Msg("Calendar feed failed while streaming")
+		return
+	}
+
+	log.Info().
+		Str("path", ctx.FullPath()).
+		Int("feed", feed.ID).
+		Int("count", len(todos)).
+		Msg("Calendar feed served")
+}
+
+// notModified checks If-None-Match, or If-Modified-Since when there is no
+// If-None-Match, as RFC 9110 orders them.
+func notModified(ctx *gin.Context, etag string, modified time.Time) bool {
+	if ctx.GetHeader("If-None-Match") != "" {
+		return ifNoneMatch(ctx, etag)
+	}
+	since, err := http.ParseTime(ctx.GetHeader("If-Modified-Since"))
+	return err == nil && !modified.After(since)
+}
+
+func feedTokenHash(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
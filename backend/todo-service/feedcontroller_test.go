@@ -0,0 +1,177 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// feedRouter returns a router over repo with the feeds "secret", of every
+// list, and "work", of list 2.
+func feedRouter(t *testing.T, repo *memoryRepository) *gin.Engine {
+	t.Helper()
+	repo.feeds[feedTokenHash("secret")] = CalendarFeed{ID: 1, Name: "Todos", Components: []string{feedTodos, feedEvents}}
+	repo.feeds[feedTokenHash("work")] = CalendarFeed{ID: 2, Name: "Work", ListID: ptr(2), Components: []string{feedTodos}}
+	cfg := defaultConfig()
+	return testRouter(&cfg, repo)
+}
+
+// getFeed fetches path from router with the headers given as name, value
+// pairs.
+func getFeed(router *gin.Engine, path string, header ...string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(http.MethodGet, path, nil)
+	for i := 0; i+1 < len(header); i += 2 {
+		req.Header.Set(header[i], header[i+1])
+	}
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	return w
+}
+
+func TestServeFeedToken(t *testing.T) {
+	router := feedRouter(t, newMemoryRepository())
+
+	tests := []struct {
+		path string
+		want int
+	}{
+		{"/feeds/secret", http.StatusOK},
+		{"/feeds/secret.ics", http.StatusOK},
+		{"/feeds/work.ics", http.StatusOK},
+		{"/feeds/other.ics", http.StatusNotFound},
+		{"/feeds/secret.ics.ics", http.StatusNotFound},
+		{"/feeds/.ics", http.StatusNotFound},
+	}
+	for _, tt := range tests {
+		w := getFeed(router, tt.path)
+		if w.Code != tt.want {
+			t.Errorf("GET %s: status = %d, want %d: %s", tt.path, w.Code, tt.want, w.Body)
+			continue
+		}
+		if tt.want == http.StatusOK && !strings.HasPrefix(w.Header().Get("Content-Type"), "text/calendar") {
+			t.Errorf("GET %s: Content-Type = %q, want an iCalendar file", tt.path, w.Header().Get("Content-Type"))
+		}
+	}
+}
+
+// TestCreatedFeedURLIsServed follows the webcal URL a new feed is created
+// with, so it cannot drift from the route.
+func TestCreatedFeedURLIsServed(t *testing.T) {
+	router := feedRouter(t, newMemoryRepository())
+
+	req := httptest.NewRequest(http.MethodPost, "/api/v2/feeds", strings.NewReader(`{"name": "Errands"}`))
+	req.Header.Set("Content-Type", "application/json")
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	var env struct {
+		Meta struct {
+			URL string `json:"url"`
+		} `json:"meta"`
+	}
+	if err := json.Unmarshal(w.Body.Bytes(), &env); err != nil || w.Code != http.StatusCreated {
+		t.Fatalf("status = %d, err = %v: %s", w.Code, err, w.Body)
+	}
+
+	path, ok := strings.CutPrefix(env.Meta.URL, "webcal://"+req.Host)
+	if !ok || !strings.HasSuffix(path, ".ics") {
+		t.Fatalf("url = %q, want a webcal URL of an .ics file on this host", env.Meta.URL)
+	}
+	if w := getFeed(router, path); w.Code != http.StatusOK {
+		t.Errorf("GET %s: status = %d, want %d: %s", path, w.Code, http.StatusOK, w.Body)
+	}
+}
+
+func TestServeFeedNotModified(t *testing.T) {
+	repo := newMemoryRepository()
+	repo.revision = 7
+	repo.modified = time.Date(2026, time.March, 4, 10, 0, 0, 500, time.UTC)
+	router := feedRouter(t, repo)
+
+	w := getFeed(router, "/feeds/secret.ics")
+	etag, lastModified := w.Header().Get("ETag"), w.Header().Get("Last-Modified")
+	if etag != `"feed-1-r7"` || lastModified != "Wed, 04 Mar 2026 10:00:00 GMT" {
+		t.Fatalf("ETag = %s, Last-Modified = %s", etag, lastModified)
+	}
+
+	tests := []struct {
+		name   string
+		header []string
+		want   int
+	}{
+		{"no validators", nil, http.StatusOK},
+		{"matching etag", []string{"If-None-Match", etag}, http.StatusNotModified},
+		{"one of several etags", []string{"If-None-Match", `"feed-1-r6", W/` + etag}, http.StatusNotModified},
+		{"stale etag", []string{"If-None-Match", `"feed-1-r6"`}, http.StatusOK},
+		{"another feed's etag", []string{"If-None-Match", `"feed-2-r7"`}, http.StatusOK},
+		{"same time", []string{"If-Modified-Since", lastModified}, http.StatusNotModified},
+		{"later time", []string{"If-Modified-Since", "Wed, 04 Mar 2026 11:00:00 GMT"}, http.StatusNotModified},
+		{"earlier time", []string{"If-Modified-Since", "Wed, 04 Mar 2026 09:59:59 GMT"}, http.StatusOK},
+		{"invalid time", []string{"If-Modified-Since", "yesterday"}, http.StatusOK},
+		// If-None-Match wins over If-Modified-Since.
+		{"stale etag, same time", []string{"If-None-Match", `"feed-1-r6"`, "If-Modified-Since", lastModified}, http.StatusOK},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := getFeed(router, "/feeds/secret.ics", tt.header...)
+			if w.Code != tt.want {
+				t.Fatalf("status = %d, want %d", w.Code, tt.want)
+			}
+			if tt.want == http.StatusNotModified && w.Body.Len() > 0 {
+				t.Errorf("body = %q, want none for 304", w.Body)
+			}
+		})
+	}
+
+	// A change to any todo invalidates the feed.
+	addTodos(t, repo, 1)
+	if w := getFeed(router, "/feeds/secret.ics", "If-None-Match", etag); w.Code != http.StatusOK {
+		t.Errorf("status = %d after a change, want %d", w.Code, http.StatusOK)
+	}
+}
+
+func TestServeFeedScopesToList(t *testing.T) {
+	repo := newMemoryRepository()
+	due := time.Date(2026, time.March, 4, 10, 0, 0, 0, time.UTC)
+	for _, task := range []string{"Pay rent", "Review budget", "Plan sprint", "Ship release"} {
+		if _, err := repo.AddTodo(NewTodo{Task: task, DueAt: &due}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if _, err := repo.AddTodo(NewTodo{Task: "Someday"}); err != nil {
+		t.Fatal(err)
+	}
+	repo.todos[3].ListID, repo.todos[4].ListID = 2, 2
+	repo.todos[2].DeletedAt = &due
+	router := feedRouter(t, repo)
+
+	tests := []struct {
+		token string
+		want  []string
+	}{
+		{"secret", []string{"Pay rent", "Plan sprint", "Ship release"}},
+		{"work", []string{"Plan sprint", "Ship release"}},
+	}
+	for _, tt := range tests {
+		w := getFeed(router, "/feeds/"+tt.token+".ics")
+		if w.Code != http.StatusOK {
+			t.Fatalf("%s: status = %d: %s", tt.token, w.Code, w.Body)
+		}
+		var got []string
+		for line := range strings.SplitSeq(w.Body.String(), "\r\n") {
+			if task, ok := strings.CutPrefix(line, "SUMMARY:"); ok {
+				got = append(got, task)
+			}
+		}
+		// A feed of both components lists each todo as a to-do and as an
+		// event.
+		if got = slices.Compact(slices.Sorted(slices.Values(got))); !slices.Equal(got, tt.want) {
+			t.Errorf("%s: summaries = %q, want %q", tt.token, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,95 @@
+package main
+
+import (
+	"database/sql"
+	"errors"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/lib/pq"
+)
+
+var ErrFeedNotFound = errors.New("calendar feed not found")
+
+const (
+	feedTodos  = "VTODO"
+	feedEvents = "VEVENT"
+)
+
+// CalendarFeed is a read-only iCalendar subscription to the todos with a
+// due date, of one list or of all of them. Token is only set when the
+// feed is created.
+type CalendarFeed struct {
+	ID         int            `json:"id" db:"id"`
+	Name       string         `json:"name" db:"name"`
+	ListID     *int           `json:"list_id" db:"list_id"`
+	Owner      string         `json:"owner" db:"owner"`
+	Components pq.StringArray `json:"components" db:"components"`
+	CreatedAt  time.Time      `json:"created_at" db:"created_at"`
+	Token      string         `json:"token,omitempty" db:"-"`
+}
+
+const feedColumns = "id, name, list_id, owner, components, created_at"
+
+func (t todoRepository) GetFeeds() ([]CalendarFeed, error) {
+	feeds := make([]CalendarFeed, 0)
+	err := sqlx.Select(t.q, &feeds, "SELECT "+feedColumns+" FROM calendar_feeds ORDER BY id")
+	return feeds, err
+}
+
+func (t todoRepository) GetFeedByToken(tokenHash string) (CalendarFeed, error) {
+	var feed CalendarFeed
+	err := sqlx.Get(t.q, &feed, "SELECT "+feedColumns+" FROM calendar_feeds WHERE token_hash = $1", tokenHash)
+	if errors.Is(err, sql.ErrNoRows) {
+		return feed, ErrFeedNotFound
+	}
+	return feed, err
+}
+
+func (t todoRepository) AddFeed(feed CalendarFeed, tokenHash string) (CalendarFeed, error) {
+	var added CalendarFeed
+	err := sqlx.Get(t.q, &added, `
+		INSERT INTO calendar_feeds (name, token_hash, list_id, owner, components)
+		VALUES ($1, $2, $3, $4, $5)
+		RETURNING `+feedColumns, feed.Name, tokenHash, feed.ListID, feed.Owner, feed.Components)
+	return added, err
+}
+
+func (t todoRepository) DeleteFeed(id int) error {
+	res, err := t.q.Exec("DELETE FROM calendar_feeds WHERE id = $1", id)
+	if err != nil {
+		return err
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return ErrFeedNotFound
+	}
+	return nil
+}
+
+// GetFeedTodos returns the live todos with a due date in a list, or in any
+// list when listID is nil, by due date.
+func (t todoRepository) GetFeedTodos(listID *int) ([]Todo, error) {
+	todos := make([]Todo, 0)
+	err := sqlx.Select(t.q, &todos, `
+		SELECT `+todoColumns+` FROM todos
+		WHERE due_at IS NOT NULL AND deleted_at IS NULL
+			AND ($1::integer IS NULL OR list_id = $1)
+		ORDER BY due_at, id`, listID)
+	return todos, err
+}
+
+// LastModified returns when a todo was last created, changed or purged,
+// which backs Last-Modified like LatestRevision backs ETags.
+func (t todoRepository) LastModified() (time.Time, error) {
+	var modified time.Time
+	err := sqlx.Get(t.q, &modified, `
+		SELECT GREATEST(
+			(SELECT COALESCE(MAX(updated_at), 'epoch') FROM todos),
+			(SELECT COALESCE(MAX(deleted_at), 'epoch') FROM todo_tombstones)
+		)`)
+	return modified, err
+}
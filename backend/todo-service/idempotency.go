@@ -0,0 +1,275 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/jmoiron/sqlx"
+	"github.com/rs/zerolog/log"
+)
+
+const (
+	idempotencyKeyHeader = "Idempotency-Key"
+	maxIdempotencyKeyLen = 255
+	// A claim older than this without a stored response belongs to a
+	// request that died mid-flight and may be taken over.
+	idempotencyClaimTimeout = time.Minute
+	// maxIdempotentBodyBytes caps the body read for hashing, which happens
+	// before any handler applies its own limit. No POST takes more than an
+	// import.
+	maxIdempotentBodyBytes = maxImportBytes
+)
+
+// replayedHeaders are stored with the response and sent again on replay.
+var replayedHeaders = []string{"Content-Type", "ETag", "Location", undoTokenHeader}
+
+var ErrIdempotencyKeyInUse = errors.New("idempotency key is already claimed")
+
+// StoredResponse is the record kept for a key. StatusCode is zero while the
+// first request is still running.
+type StoredResponse struct {
+	RequestHash string
+	StatusCode  int
+	Headers     map[string]string
+	Body        []byte
+}
+
+type idempotencyRow struct {
+	RequestHash string        `db:"request_hash"`
+	StatusCode  sql.NullInt32 `db:"status_code"`
+	Headers     []byte        `db:"headers"`
+	Body        []byte        `db:"response_body"`
+}
+
+type IdempotencyStore interface {
+	// Claim reserves key for a new request. If the key is live it returns
+	// the stored record and ErrIdempotencyKeyInUse.
+	Claim(scope, key, requestHash string, ttl time.Duration) (*StoredResponse, error)
+	Complete(scope, key string, status int, headers map[string]string, body []byte) error
+	Release(scope, key string) error
+	PurgeExpired() (int64, error)
+}
+
+type postgresIdempotencyStore struct {
+	db *sqlx.DB
+}
+
+func NewPostgresIdempotencyStore(db *sqlx.DB) IdempotencyStore {
+	return &postgresIdempotencyStore{db}
+}
+
+func (s postgresIdempotencyStore) Claim(scope, key, requestHash string, ttl time.Duration) (*StoredResponse, error) {
+	var claimed bool
+	err := s.db.Get(&claimed, `
+		INSERT INTO idempotency_keys (scope, key, request_hash, expires_at)
+		VALUES ($1, $2, $3, CURRENT_TIMESTAMP + make_interval(secs => $4::double precision))
+		ON CONFLICT (scope, key) DO UPDATE
+			SET request_hash = EXCLUDED.request_hash, expires_at = EXCLUDED.expires_at,
+				status_code = NULL, headers = NULL, response_body = NULL, created_at = CURRENT_TIMESTAMP
+			WHERE idempotency_keys.expires_at < CURRENT_TIMESTAMP
+				OR (idempotency_keys.status_code IS NULL
+					AND idempotency_keys.created_at < CURRENT_TIMESTAMP - make_interval(secs => $5::double precision))
+		RETURNING TRUE`,
+		scope, key, requestHash, ttl.Seconds(), idempotencyClaimTimeout.Seconds())
+	if err == nil && claimed {
+		return nil, nil
+	}
+	if !errors.Is(err, sql.ErrNoRows) {
+		return nil, err
+	}
+
+	var row idempotencyRow
+	err = s.db.Get(&row, `
+		SELECT request_hash, status_code, headers, response_body
+		FROM idempotency_keys WHERE scope = $1 AND key = $2`, scope, key)
+	if err != nil {
+		return nil, err
+	}
+	stored := &StoredResponse{
+		RequestHash: row.RequestHash,
+		StatusCode:  int(row.StatusCode.Int32),
+		Body:        row.Body,
+	}
+	if len(row.Headers) > 0 {
+		if err := json.Unmarshal(row.Headers, &stored.Headers); err != nil {
+			return nil, err
+		}
+	}
+	return stored, ErrIdempotencyKeyInUse
+}
+
+func (s postgresIdempotencyStore) Complete(scope, key string, status int, headers map[string]string, body []byte) error {
+	encoded, err := json.Marshal(headers)
+	if err != nil {
+		return err
+	}
+	_, err = s.db.Exec(`
+		UPDATE idempotency_keys SET status_code = $3, headers = $4, response_body = $5
+		WHERE scope = $1 AND key = $2`, scope, key, status, encoded, body)
+	return err
+}
+
+func (s postgresIdempotencyStore) Release(scope, key string) error {
+	_, err := s.db.Exec("DELETE FROM idempotency_keys WHERE scope = $1 AND key = $2", scope, key)
+	return err
+}
+
+func (s postgresIdempotencyStore) PurgeExpired() (int64, error) {
+	res, err := s.db.Exec("DELETE FROM idempotency_keys WHERE expires_at < CURRENT_TIMESTAMP")
+	if err != nil {
+		return 0, err
+	}
+	return res.RowsAffected()
+}
+
+// recordingWriter keeps a copy of the response body so it can be stored.
+type recordingWriter struct {
+	gin.ResponseWriter
+	body bytes.Buffer
+}
+
+func (w *recordingWriter) Write(data []byte) (int, error) {
+	w.body.Write(data)
+	return w.ResponseWriter.Write(data)
+}
+
+func (w *recordingWriter) WriteString(s string) (int, error) {
+	w.body.WriteString(s)
+	return w.ResponseWriter.WriteString(s)
+}
+
+// IdempotencyMiddleware makes POST handlers safe to retry. The first request
+// with a given Idempotency-Key runs normally and its response is stored for
+// ttl; repeats with the same query and body get that response replayed,
+// repeats with a different one are rejected. Keys are per caller, so two
+// callers cannot see each other's responses by picking the same key. Server
+// errors are not stored so they can be retried.
+func IdempotencyMiddleware(store IdempotencyStore, ttl time.Duration) gin.HandlerFunc {
+	return func(ctx *gin.Context) {
+		key := ctx.GetHeader(idempotencyKeyHeader)
+		if key == "" {
+			ctx.Next()
+			return
+		}
+		if len(key) > maxIdempotencyKeyLen {
+			respondError(ctx, errInvalidIdempotencyKey())
+			return
+		}
+
+		body, err := io.ReadAll(http.MaxBytesReader(ctx.Writer, ctx.Request.Body, maxIdempotentBodyBytes))
+		var tooLarge *http.MaxBytesError
+		if errors.As(err, &tooLarge) {
+			respondError(ctx, errInvalidField("body", fmt.Sprintf("The request body may be at most %d MB", maxIdempotentBodyBytes>>20)))
+			return
+		}
+		if err != nil {
+			respondError(ctx, errInvalidBody(err))
+			return
+		}
+		ctx.Request.Body = io.NopCloser(bytes.NewReader(body))
+
+		// The path keeps v1 and v2 apart, since they store different bodies.
+		scope := callerID(ctx) + " " + ctx.Request.Method + " " + ctx.Request.URL.Path
+		requestHash := idempotencyRequestHash(ctx.Request.URL.RawQuery, body)
+
+		stored, err := store.Claim(scope, key, requestHash, ttl)
+		switch {
+		case errors.Is(err, ErrIdempotencyKeyInUse):
+			replayStoredResponse(ctx, key, requestHash, stored)
+			return
+		case err != nil:
+			log.Error().Err(err).Msg("Failed to claim idempotency key")
+			respondError(ctx, errInternal(err))
+			return
+		}
+
+		recorder := &recordingWriter{ResponseWriter: ctx.Writer}
+		ctx.Writer = recorder
+		ctx.Next()
+
+		status := recorder.Status()
+		if status >= http.StatusInternalServerError {
+			if err := store.Release(scope, key); err != nil {
+				log.Error().Err(err).Msg("Failed to release idempotency key")
+			}
+			return
+		}
+
+		headers := make(map[string]string)
+		for _, name := range replayedHeaders {
+			if value := recorder.Header().Get(name); value != "" {
+				headers[name] = value
+			}
+		}
+		if err := store.Complete(scope, key, status, headers, recorder.body.Bytes()); err != nil {
+			log.Error().Err(err).Msg("Failed to store idempotent response")
+		}
+	}
+}
+
+// idempotencyRequestHash fingerprints what a request asks for beyond its
+// scope: the query, which selects options such as an import's format, and
+// the body.
+func idempotencyRequestHash(rawQuery string, body []byte) string {
+	hash := sha256.New()
+	hash.Write([]byte(rawQuery))
+	hash.Write([]byte{0})
+	hash.Write(body)
+	return hex.EncodeToString(hash.Sum(nil))
+}
+
+func replayStoredResponse(ctx *gin.Context, key, requestHash string, stored *StoredResponse) {
+	if stored.RequestHash != requestHash {
+		log.Warn().
+			Str("path", ctx.FullPath()).
+			Str("idempotency_key", key).
+			Msg("request rejected: idempotency key reused with a different request")
+		respondError(ctx, errIdempotencyKeyReused())
+		return
+	}
+	if stored.StatusCode == 0 {
+		respondError(ctx, errIdempotencyKeyInProgress())
+		return
+	}
+
+	log.Info().
+		Str("path", ctx.FullPath()).
+		Str("idempotency_key", key).
+		Msg("Replaying stored response")
+
+	for name, value := range stored.Headers {
+		ctx.Header(name, value)
+	}
+	ctx.Header("Idempotent-Replayed", "true")
+	ctx.Status(stored.StatusCode)
+	if _, err := ctx.Writer.Write(stored.Body); err != nil {
+		log.Error().Err(err).Msg("Failed to write replayed response")
+	}
+	ctx.Abort()
+}
+
+// purgeIdempotencyKeys removes expired keys every interval until the
+// process exits.
+func purgeIdempotencyKeys(store IdempotencyStore, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for range ticker.C {
+		n, err := store.PurgeExpired()
+		if err != nil {
+			log.Error().Err(err).Msg("Failed to purge expired idempotency keys")
+			continue
+		}
+		if n > 0 {
+			log.Info().Int64("count", n).Msg("Purged expired idempotency keys")
+		}
+	}
+}
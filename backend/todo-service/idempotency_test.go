@@ -0,0 +1,164 @@
+package main
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// memoryIdempotencyStore keeps records until they are released; expiry is
+// not modelled.
+type memoryIdempotencyStore struct {
+	records map[string]*StoredResponse
+}
+
+func (s *memoryIdempotencyStore) Claim(scope, key, requestHash string, _ time.Duration) (*StoredResponse, error) {
+	if stored, ok := s.records[scope+"|"+key]; ok {
+		return stored, ErrIdempotencyKeyInUse
+	}
+	s.records[scope+"|"+key] = &StoredResponse{RequestHash: requestHash}
+	return nil, nil
+}
+
+func (s *memoryIdempotencyStore) Complete(scope, key string, status int, headers map[string]string, body []byte) error {
+	stored := s.records[scope+"|"+key]
+	stored.StatusCode, stored.Headers, stored.Body = status, headers, body
+	return nil
+}
+
+func (s *memoryIdempotencyStore) Release(scope, key string) error {
+	delete(s.records, scope+"|"+key)
+	return nil
+}
+
+func (s *memoryIdempotencyStore) PurgeExpired() (int64, error) {
+	return 0, nil
+}
+
+// idempotentRouter serves a POST that answers with its call count and the
+// body it read, and fails with status when status is set.
+func idempotentRouter(store IdempotencyStore, calls *int, status *int) *gin.Engine {
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+	router.Use(APIKeyMiddleware(nil))
+	router.POST("/api/v2/todos", apiVersion(2), IdempotencyMiddleware(store, time.Hour), func(ctx *gin.Context) {
+		*calls++
+		body, _ := io.ReadAll(ctx.Request.Body)
+		if *status != 0 {
+			ctx.Status(*status)
+			return
+		}
+		ctx.Header("Location", "/api/v2/todos/1")
+		ctx.Header(undoTokenHeader, "undo-1")
+		ctx.String(http.StatusCreated, "call %d: %s", *calls, body)
+	})
+	return router
+}
+
+func postIdempotent(router *gin.Engine, remoteAddr, query, key, body string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(http.MethodPost, "/api/v2/todos"+query, strings.NewReader(body))
+	req.RemoteAddr = remoteAddr
+	if key != "" {
+		req.Header.Set(idempotencyKeyHeader, key)
+	}
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	return w
+}
+
+func TestIdempotencyReplay(t *testing.T) {
+	store := &memoryIdempotencyStore{records: make(map[string]*StoredResponse)}
+	var calls, status int
+	router := idempotentRouter(store, &calls, &status)
+
+	first := postIdempotent(router, "192.0.2.1:1", "", "k1", `{"task":"a"}`)
+	replay := postIdempotent(router, "192.0.2.1:1", "", "k1", `{"task":"a"}`)
+	if calls != 1 {
+		t.Fatalf("handler ran %d times, want once", calls)
+	}
+	if replay.Code != http.StatusCreated || replay.Body.String() != first.Body.String() {
+		t.Errorf("replay = %d %q, want %d %q", replay.Code, replay.Body, first.Code, first.Body)
+	}
+	for _, name := range []string{"Location", undoTokenHeader, "Content-Type"} {
+		if replay.Header().Get(name) != first.Header().Get(name) {
+			t.Errorf("replayed %s = %q, want %q", name, replay.Header().Get(name), first.Header().Get(name))
+		}
+	}
+	if replay.Header().Get("Idempotent-Replayed") != "true" || first.Header().Get("Idempotent-Replayed") != "" {
+		t.Error("only the replay should be marked Idempotent-Replayed")
+	}
+}
+
+func TestIdempotencyKeyReuse(t *testing.T) {
+	tests := []struct {
+		name       string
+		remoteAddr string
+		query      string
+		body       string
+		wantStatus int
+		wantCalls  int
+	}{
+		{"different body", "192.0.2.1:1", "", `{"task":"b"}`, http.StatusUnprocessableEntity, 1},
+		{"different query", "192.0.2.1:1", "?parse=true", `{"task":"a"}`, http.StatusUnprocessableEntity, 1},
+		// Keys are per caller, so another caller runs its own request.
+		{"another caller", "192.0.2.2:1", "", `{"task":"a"}`, http.StatusCreated, 2},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			store := &memoryIdempotencyStore{records: make(map[string]*StoredResponse)}
+			var calls, status int
+			router := idempotentRouter(store, &calls, &status)
+
+			postIdempotent(router, "192.0.2.1:1", "", "k1", `{"task":"a"}`)
+			w := postIdempotent(router, tt.remoteAddr, tt.query, "k1", tt.body)
+			if w.Code != tt.wantStatus || calls != tt.wantCalls {
+				t.Errorf("status %d after %d calls, want %d after %d", w.Code, calls, tt.wantStatus, tt.wantCalls)
+			}
+		})
+	}
+}
+
+func TestIdempotencyServerErrorsAreRetried(t *testing.T) {
+	store := &memoryIdempotencyStore{records: make(map[string]*StoredResponse)}
+	calls, status := 0, http.StatusInternalServerError
+	router := idempotentRouter(store, &calls, &status)
+
+	postIdempotent(router, "192.0.2.1:1", "", "k1", `{"task":"a"}`)
+	status = 0
+	if w := postIdempotent(router, "192.0.2.1:1", "", "k1", `{"task":"a"}`); w.Code != http.StatusCreated || calls != 2 {
+		t.Errorf("retry = %d after %d calls, want the request run again", w.Code, calls)
+	}
+}
+
+func TestIdempotencyInProgress(t *testing.T) {
+	store := &memoryIdempotencyStore{records: make(map[string]*StoredResponse)}
+	var calls, status int
+	router := idempotentRouter(store, &calls, &status)
+
+	scope := "ip:192.0.2.1 POST /api/v2/todos"
+	if _, err := store.Claim(scope, "k1", idempotencyRequestHash("", []byte(`{"task":"a"}`)), time.Hour); err != nil {
+		t.Fatal(err)
+	}
+	if w := postIdempotent(router, "192.0.2.1:1", "", "k1", `{"task":"a"}`); w.Code != http.StatusConflict || calls != 0 {
+		t.Errorf("status %d after %d calls, want 409 without running the request", w.Code, calls)
+	}
+}
+
+func TestIdempotencyBodyLimit(t *testing.T) {
+	store := &memoryIdempotencyStore{records: make(map[string]*StoredResponse)}
+	var calls, status int
+	router := idempotentRouter(store, &calls, &status)
+
+	body := strings.Repeat("x", maxIdempotentBodyBytes+1)
+	if w := postIdempotent(router, "192.0.2.1:1", "", "k1", body); w.Code != http.StatusBadRequest || calls != 0 {
+		t.Errorf("status %d after %d calls, want 400 without running the request", w.Code, calls)
+	}
+	if len(store.records) != 0 {
+		t.Error("an oversized request claimed its key")
+	}
+}
@@ -0,0 +1,257 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/lib/pq"
+	"github.com/rs/zerolog/log"
+)
+
+const (
+	maxImportBytes = 5 << 20
+	maxImportRows  = 5000
+)
+
+const (
+	importValid     = "valid"
+	importImported  = "imported"
+	importInvalid   = "invalid"
+	importDuplicate = "duplicate"
+	importSkipped   = "skipped"
+)
+
+// importContentTypes picks the format of an import from its Content-Type
+// when no format parameter is given.
+var importContentTypes = map[string]string{
+	"text/csv":         formatCSV,
+	"application/json": formatJSON,
+	"text/markdown":    formatMarkdown,
+}
+
+// importResult is the outcome of one row. A duplicate names the todo it
+// repeats, or the earlier line of the file; the subtasks of a duplicate
+// are skipped.
+type importResult struct {
+	Line            int          `json:"line"`
+	Status          string       `json:"status"`
+	Task            string       `json:"task"`
+	ID              int          `json:"id,omitempty"`
+	DuplicateOf     int          `json:"duplicate_of,omitempty"`
+	DuplicateOfLine int          `json:"duplicate_of_line,omitempty"`
+	Errors          []FieldError `json:"errors,omitempty"`
+}
+
+type importSummary struct {
+	Total      int `json:"total"`
+	Valid      int `json:"valid"`
+	Invalid    int `json:"invalid"`
+	Duplicates int `json:"duplicates"`
+	Imported   int `json:"imported"`
+}
+
+type importResponse struct {
+	Format    string         `json:"format"`
+	DryRun    bool           `json:"dry_run"`
+	Committed bool           `json:"committed"`
+	Summary   importSummary  `json:"summary"`
+	Results   []importResult `json:"results"`
+}
+
+// importTodos creates the todos of a CSV, JSON, todo.txt or Markdown file
+// in one transaction. Nothing is imported if any row is invalid, and a dry
+// run reports what would happen without writing.
+func (c *TodosController) importTodos(ctx *gin.Context) {
+	format := ctx.Query("format")
+	if format == "" {
+		format = importContentTypes[ctx.ContentType()]
+	}
+	switch format {
+	case formatCSV, formatJSON, formatTodoTxt, formatMarkdown:
+	default:
+		respondError(ctx, errInvalidQuery("format", "format must be csv, json, todotxt or md, or follow from the Content-Type"))
+		return
+	}
+	dryRun := false
+	if raw := ctx.Query("dry_run"); raw != "" {
+		var err error
+		if dryRun, err = strconv.ParseBool(raw); err != nil {
+			respondError(ctx, errInvalidQuery("dry_run", "dry_run must be true or false"))
+			return
+		}
+	}
+	duplicates := ctx.DefaultQuery("duplicates", "skip")
+	if duplicates != "skip" && duplicates != "allow" {
+		respondError(ctx, errInvalidQuery("duplicates", `duplicates must be "skip" or "allow"`))
+		return
+	}
+	listID := defaultListID
+	if raw := ctx.Query("list_id"); raw != "" {
+		id, err := strconv.Atoi(raw)
+		if err != nil || id <= 0 {
+			respondError(ctx, errInvalidQuery("list_id", "list_id must be a positive integer"))
+			return
+		}
+		listID = id
+	}
+
+	data, err := io.ReadAll(http.MaxBytesReader(ctx.Writer, ctx.Request.Body, maxImportBytes))
+	if err != nil {
+		log.Warn().Err(err).Str("path", ctx.FullPath()).Msg("import rejected: body not read")
+		respondError(ctx, errInvalidField("body", fmt.Sprintf("The file could not be read; it may be at most %d MB", maxImportBytes>>20)))
+		return
+	}
+	rows, err := parseImport(format, data, c.config.Location())
+	if err != nil {
+		log.Warn().Err(err).Str("path", ctx.FullPath()).Str("format", format).Msg("import rejected: file not parsed")
+		respondError(ctx, errInvalidField("body", err.Error()))
+		return
+	}
+	if len(rows) > maxImportRows {
+		respondError(ctx, errTooManyItems("rows", maxImportRows))
+		return
+	}
+
+	_, err = c.repo.GetList(listID)
+	var existing []Todo
+	if err == nil {
+		existing, err = c.repo.GetListTodos(listID)
+	}
+	if err != nil {
+		c.respondTodoError(ctx, 0, err, "import todos")
+		return
+	}
+	resp := importResponse{Format: format, DryRun: dryRun, Results: checkImportRows(c.tasks, rows, existing, duplicates == "skip")}
+	for _, result := range resp.Results {
+		switch result.Status {
+		case importValid:
+			resp.Summary.Valid++
+		case importInvalid:
+			resp.Summary.Invalid++
+		case importDuplicate:
+			resp.Summary.Duplicates++
+		}
+	}
+	resp.Summary.Total = len(rows)
+
+	var created []Todo
+	if !dryRun && resp.Summary.Invalid == 0 {
+		created, err = c.commitImport(ctx, rows, resp.Results, listID)
+		if err != nil {
+			c.respondTodoError(ctx, 0, err, "import todos")
+			return
+		}
+		resp.Committed, resp.Summary.Imported = true, len(created)
+		c.publishBatchCreated(BatchCreated{Source: "import", Todos: created})
+	}
+
+	log.Info().
+		Str("path", ctx.FullPath()).
+		Str("format", format).
+		Int("rows", len(rows)).
+		Int("invalid", resp.Summary.Invalid).
+		Int("imported", resp.Summary.Imported).
+		Bool("dry_run", dryRun).
+		Msg("Import processed")
+	c.respondWithBatchUndo(ctx, http.StatusOK, resp, nil, createdTargets(created), nil)
+}
+
+// checkImportRows validates rows with the rules of the create endpoint and,
+// when skipDuplicates is set, marks top-level rows whose task matches a
+// live top-level todo of the list, or an earlier row, ignoring case and
+// spacing.
+func checkImportRows(tasks *TaskValidator, rows []importRow, existing []Todo, skipDuplicates bool) []importResult {
+	seen := make(map[string]int)
+	for _, todo := range existing {
+		if todo.ParentID == nil {
+			seen[importDuplicateKey(todo.Task)] = todo.ID
+		}
+	}
+	seenLines := make(map[string]int)
+
+	results := make([]importResult, len(rows))
+	for i := range rows {
+		row := &rows[i]
+		result := importResult{Line: row.Line, Task: row.Task, Status: importValid}
+		if len(row.Errors) == 0 {
+			if task, apiErr := tasks.Validate(row.Task); apiErr != nil {
+				row.Errors = append(row.Errors, apiErr.Fields...)
+			} else {
+				row.Task, result.Task = task, task
+			}
+			if tags, err := normalizeTags(row.Tags); err != nil {
+				row.fail("tags", err.Error())
+			} else {
+				row.Tags = tags
+			}
+		}
+
+		key := importDuplicateKey(row.Task)
+		switch {
+		case len(row.Errors) > 0:
+			result.Status, result.Errors = importInvalid, row.Errors
+		case row.Parent >= 0:
+			if status := results[row.Parent].Status; status == importDuplicate || status == importSkipped {
+				result.Status = importSkipped
+			}
+		case !skipDuplicates:
+		case seen[key] != 0:
+			result.Status, result.DuplicateOf = importDuplicate, seen[key]
+		case seenLines[key] != 0:
+			result.Status, result.DuplicateOfLine = importDuplicate, seenLines[key]
+		default:
+			seenLines[key] = row.Line
+		}
+		results[i] = result
+	}
+	return results
+}
+
+func importDuplicateKey(task string) string {
+	return strings.ToLower(strings.Join(strings.Fields(task), " "))
+}
+
+// commitImport creates the valid rows, subtasks under the todos created for
+// their parents, and records the new IDs in results.
+func (c *TodosController) commitImport(ctx *gin.Context, rows []importRow, results []importResult, listID int) ([]Todo, error) {
+	created := make([]Todo, 0, len(rows))
+	ids := make([]int, len(rows))
+	err := c.repoFor(ctx).Transaction(func(repo TodoRepository) error {
+		created = created[:0]
+		for i, row := range rows {
+			if results[i].Status != importValid {
+				continue
+			}
+			todo := NewTodo{Task: row.Task, Priority: row.Priority, DueAt: row.DueAt, Tags: pq.StringArray(row.Tags)}
+			if row.Parent >= 0 {
+				todo.ParentID = &ids[row.Parent]
+			} else {
+				todo.ListID = &listID
+			}
+			newTodo, err := repo.AddTodo(todo)
+			if err == nil && row.Done {
+				done := true
+				newTodo, _, err = repo.UpdateTodo(newTodo.ID, Precondition{}, TodoUpdate{Done: &done})
+			}
+			if err != nil {
+				return fmt.Errorf("line %d: %w", row.Line, err)
+			}
+			ids[i] = newTodo.ID
+			created = append(created, newTodo)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	for i := range results {
+		if results[i].Status == importValid {
+			results[i].Status, results[i].ID = importImported, ids[i]
+		}
+	}
+	return created, nil
+}
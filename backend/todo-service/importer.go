@@ -0,0 +1,335 @@
+package main
+
+import (
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	formatCSV      = "csv"
+	formatJSON     = "json"
+	formatTodoTxt  = "todotxt"
+	formatMarkdown = "md"
+)
+
+// importRow is a todo read from an import file. Line is the line in the
+// file, or the position in the array for JSON. Parent is the index of the
+// row this one is a subtask of, or -1.
+type importRow struct {
+	Line     int
+	Parent   int
+	Task     string
+	Done     bool
+	Priority *int
+	DueAt    *time.Time
+	Tags     []string
+	Errors   []FieldError
+}
+
+func (r *importRow) fail(field, message string) {
+	r.Errors = append(r.Errors, FieldError{Field: field, Code: "invalid", Message: message})
+}
+
+// parseImport reads the rows of an import file. An error means the file as
+// a whole cannot be read; problems with single rows are recorded in their
+// Errors. Dates without a time are due at the end of the day in loc.
+func parseImport(format string, data []byte, loc *time.Location) ([]importRow, error) {
+	switch format {
+	case formatCSV:
+		return parseCSVImport(data, loc)
+	case formatJSON:
+		return parseJSONImport(data, loc)
+	case formatTodoTxt:
+		return parseTodoTxtImport(data, loc), nil
+	case formatMarkdown:
+		return parseMarkdownImport(data, loc), nil
+	default:
+		return nil, fmt.Errorf("unknown format %q", format)
+	}
+}
+
+// importRef links a CSV or JSON row to its parent through the id and
+// parent_id the file gives them, as exports write them.
+type importRef struct {
+	ID       string
+	ParentID string
+}
+
+// linkImportParents sets Parent from refs. A parent has to come before its
+// subtasks in the file.
+func linkImportParents(rows []importRow, refs []importRef) {
+	seen := make(map[string]int, len(rows))
+	for i := range rows {
+		rows[i].Parent = -1
+		if parentID := refs[i].ParentID; parentID != "" {
+			if parent, ok := seen[parentID]; ok {
+				rows[i].Parent = parent
+			} else {
+				rows[i].fail("parent_id", fmt.Sprintf("parent_id %s is not the id of a row earlier in the file", parentID))
+			}
+		}
+		if id := refs[i].ID; id != "" {
+			seen[id] = i
+		}
+	}
+}
+
+func parseCSVImport(data []byte, loc *time.Location) ([]importRow, error) {
+	reader := csv.NewReader(bytes.NewReader(bytes.TrimPrefix(data, []byte("\ufeff"))))
+	reader.FieldsPerRecord = -1
+	reader.TrimLeadingSpace = true
+
+	header, err := reader.Read()
+	if errors.Is(err, io.EOF) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	columns := make(map[string]int, len(header))
+	for i, name := range header {
+		columns[strings.ToLower(strings.TrimSpace(name))] = i
+	}
+	if _, ok := columns["task"]; !ok {
+		return nil, errors.New("the CSV header has no task column")
+	}
+
+	var rows []importRow
+	var refs []importRef
+	for {
+		record, err := reader.Read()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		line, _ := reader.FieldPos(0)
+		value := func(column string) string {
+			if i, ok := columns[column]; ok && i < len(record) {
+				return strings.TrimSpace(record[i])
+			}
+			return ""
+		}
+
+		row := importRow{Line: line, Task: value("task")}
+		row.Done = parseImportDone(&row, value("done"))
+		row.Priority = parseImportPriority(&row, value("priority"))
+		row.DueAt = parseImportDue(&row, value("due_at"), loc)
+		row.Tags = strings.FieldsFunc(value("tags"), func(r rune) bool {
+			return r == ' ' || r == ',' || r == ';'
+		})
+		rows = append(rows, row)
+		refs = append(refs, importRef{ID: value("id"), ParentID: value("parent_id")})
+	}
+	linkImportParents(rows, refs)
+	return rows, nil
+}
+
+// jsonImportTodo is a JSON import row. Numbers are kept raw so that a row
+// with a wrong type fails alone instead of the whole file.
+type jsonImportTodo struct {
+	ID       json.RawMessage `json:"id"`
+	ParentID json.RawMessage `json:"parent_id"`
+	Task     string          `json:"task"`
+	Done     bool            `json:"done"`
+	Priority *int            `json:"priority"`
+	DueAt    string          `json:"due_at"`
+	Tags     []string        `json:"tags"`
+}
+
+func parseJSONImport(data []byte, loc *time.Location) ([]importRow, error) {
+	var items []json.RawMessage
+	if err := json.Unmarshal(data, &items); err != nil {
+		return nil, fmt.Errorf("the body must be a JSON array of todos: %w", err)
+	}
+
+	rows := make([]importRow, len(items))
+	refs := make([]importRef, len(items))
+	for i, item := range items {
+		rows[i].Line = i + 1
+		var todo jsonImportTodo
+		if err := json.Unmarshal(item, &todo); err != nil {
+			rows[i].fail("row", "The row is not a valid todo object: "+err.Error())
+			continue
+		}
+		rows[i].Task, rows[i].Done, rows[i].Tags = todo.Task, todo.Done, todo.Tags
+		if todo.Priority != nil {
+			rows[i].Priority = parseImportPriority(&rows[i], strconv.Itoa(*todo.Priority))
+		}
+		rows[i].DueAt = parseImportDue(&rows[i], todo.DueAt, loc)
+		refs[i] = importRef{ID: jsonImportRef(todo.ID), ParentID: jsonImportRef(todo.ParentID)}
+	}
+	linkImportParents(rows, refs)
+	return rows, nil
+}
+
+func jsonImportRef(raw json.RawMessage) string {
+	ref := strings.Trim(string(raw), `"`)
+	if ref == "null" {
+		return ""
+	}
+	return ref
+}
+
+var (
+	todoTxtPriority = regexp.MustCompile(`^\(([A-Z])\)\s+`)
+	todoTxtDate     = regexp.MustCompile(`^\d{4}-\d{2}-\d{2}\s+`)
+)
+
+// parseTodoTxtImport reads todo.txt lines:
+//
+//	x 2026-10-18 2026-10-01 (A) Pay rent +home @phone due:2026-10-20
+//
+// "x" marks a done todo, followed by its completion and creation dates.
+// Priorities A, B and C are P0, P1 and P2, and later letters P3. Projects
+// and contexts become tags.
+func parseTodoTxtImport(data []byte, loc *time.Location) []importRow {
+	var rows []importRow
+	for i, line := range strings.Split(string(data), "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" {
+			continue
+		}
+		row := importRow{Line: i + 1, Parent: -1}
+		if strings.HasPrefix(line, "x ") {
+			row.Done = true
+			line = todoTxtDate.ReplaceAllString(strings.TrimSpace(line[2:]), "")
+		}
+		priority := func() {
+			if m := todoTxtPriority.FindStringSubmatch(line); m != nil {
+				row.Priority = todoTxtLetterPriority(m[1])
+				line = line[len(m[0]):]
+			}
+		}
+		// The priority comes before the creation date, but done todos are
+		// often written with it after their dates.
+		priority()
+		line = todoTxtDate.ReplaceAllString(line, "")
+		priority()
+
+		var kept []string
+		for _, word := range strings.Fields(line) {
+			switch {
+			case len(word) > 1 && (word[0] == '+' || word[0] == '@'):
+				row.Tags = append(row.Tags, word[1:])
+			case strings.HasPrefix(word, "due:"):
+				row.DueAt = parseImportDue(&row, word[len("due:"):], loc)
+			case strings.HasPrefix(word, "pri:") && len(word) == len("pri:")+1:
+				row.Priority = todoTxtLetterPriority(strings.ToUpper(word[len("pri:"):]))
+			default:
+				kept = append(kept, word)
+			}
+		}
+		row.Task = strings.Join(kept, " ")
+		rows = append(rows, row)
+	}
+	return rows
+}
+
+func todoTxtLetterPriority(letter string) *int {
+	if letter < "A" || letter > "Z" {
+		return nil
+	}
+	priority := min(int(letter[0]-'A'), 3)
+	return &priority
+}
+
+var markdownItem = regexp.MustCompile(`^([ \t]*)(?:[-*+]|\d+[.)])\s+\[([ xX])\]\s+(.*)$`)
+
+// parseMarkdownImport reads "- [ ]" and "- [x]" checklist items. Items
+// indented under another are its subtasks, and other lines are skipped.
+// Within an item, #tags, a !priority as in quick-add and due:2026-10-20
+// are read like their fields.
+func parseMarkdownImport(data []byte, loc *time.Location) []importRow {
+	type open struct{ indent, row int }
+	var rows []importRow
+	var parents []open
+	for i, line := range strings.Split(string(data), "\n") {
+		m := markdownItem.FindStringSubmatch(strings.TrimRight(line, "\r"))
+		if m == nil {
+			continue
+		}
+		indent := len(strings.ReplaceAll(m[1], "\t", "    "))
+		for len(parents) > 0 && parents[len(parents)-1].indent >= indent {
+			parents = parents[:len(parents)-1]
+		}
+		row := importRow{Line: i + 1, Parent: -1, Done: m[2] != " "}
+		if len(parents) > 0 {
+			row.Parent = parents[len(parents)-1].row
+		}
+
+		var kept []string
+		for _, word := range strings.Fields(m[3]) {
+			switch {
+			case strings.HasPrefix(word, "#"):
+				if tag, ok := quickAddTag(word[1:]); ok {
+					row.Tags = append(row.Tags, tag)
+					continue
+				}
+			case strings.HasPrefix(word, "!") && row.Priority == nil:
+				if priority, ok := quickAddPriorities[strings.ToLower(word[1:])]; ok {
+					row.Priority = &priority
+					continue
+				}
+			case strings.HasPrefix(word, "due:"):
+				row.DueAt = parseImportDue(&row, word[len("due:"):], loc)
+				continue
+			}
+			kept = append(kept, word)
+		}
+		row.Task = strings.Join(kept, " ")
+		parents = append(parents, open{indent: indent, row: len(rows)})
+		rows = append(rows, row)
+	}
+	return rows
+}
+
+func parseImportDone(row *importRow, value string) bool {
+	switch strings.ToLower(value) {
+	case "", "false", "0", "no":
+		return false
+	case "true", "1", "yes", "x":
+		return true
+	}
+	row.fail("done", fmt.Sprintf("done %q must be true or false", value))
+	return false
+}
+
+func parseImportPriority(row *importRow, value string) *int {
+	if value == "" {
+		return nil
+	}
+	priority, err := strconv.Atoi(strings.TrimPrefix(strings.ToLower(value), "p"))
+	if err != nil || priority < 0 || priority > 3 {
+		row.fail("priority", fmt.Sprintf("priority %q must be between 0 and 3", value))
+		return nil
+	}
+	return &priority
+}
+
+// parseImportDue reads an RFC 3339 time or a date, which is due at the end
+// of the day in loc like quick-add dates.
+func parseImportDue(row *importRow, value string, loc *time.Location) *time.Time {
+	if value == "" {
+		return nil
+	}
+	if due, err := time.Parse(time.RFC3339, value); err == nil {
+		return &due
+	}
+	date, err := time.ParseInLocation(time.DateOnly, value, loc)
+	if err != nil {
+		row.fail("due_at", fmt.Sprintf("due date %q must be an RFC 3339 time or a YYYY-MM-DD date", value))
+		return nil
+	}
+	due := time.Date(date.Year(), date.Month(), date.Day(), 23, 59, 0, 0, loc)
+	return &due
+}
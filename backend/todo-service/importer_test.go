@@ -0,0 +1,211 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+)
+
+// summarizeImportRow writes a row as "line parent task [done] [pN]
+// [due=...] [#tag...] [!field...]" so that cases read like the files.
+func summarizeImportRow(row importRow) string {
+	parts := []string{fmt.Sprint(row.Line), fmt.Sprint(row.Parent), fmt.Sprintf("%q", row.Task)}
+	if row.Done {
+		parts = append(parts, "done")
+	}
+	if row.Priority != nil {
+		parts = append(parts, fmt.Sprintf("p%d", *row.Priority))
+	}
+	if row.DueAt != nil {
+		parts = append(parts, "due="+row.DueAt.Format(time.RFC3339))
+	}
+	for _, tag := range row.Tags {
+		parts = append(parts, "#"+tag)
+	}
+	for _, field := range row.Errors {
+		parts = append(parts, "!"+field.Field)
+	}
+	return strings.Join(parts, " ")
+}
+
+func TestParseImport(t *testing.T) {
+	helsinki, err := time.LoadLocation("Europe/Helsinki")
+	if err != nil {
+		t.Skip("no time zone data:", err)
+	}
+
+	tests := []struct {
+		name   string
+		format string
+		data   string
+		want   []string
+	}{
+		{
+			name:   "csv",
+			format: formatCSV,
+			data: "\ufeffTask,Done,Priority,Due_At,Tags,ID,Parent_ID\n" +
+				"Move house,false,1,2026-10-20,home,10,\n" +
+				`Pack boxes,true,,2026-10-19T09:00:00Z,"home, boxes",11,10` + "\n" +
+				"Bad,maybe,7,someday,,12,99\n",
+			want: []string{
+				`2 -1 "Move house" p1 due=2026-10-20T23:59:00+03:00 #home`,
+				`3 0 "Pack boxes" done due=2026-10-19T09:00:00Z #home #boxes`,
+				`4 -1 "Bad" !done !priority !due_at !parent_id`,
+			},
+		},
+		{
+			name:   "csv with only some columns",
+			format: formatCSV,
+			data:   "task\nWater plants\n",
+			want:   []string{`2 -1 "Water plants"`},
+		},
+		{
+			name:   "empty csv",
+			format: formatCSV,
+			data:   "",
+		},
+		{
+			name:   "json",
+			format: formatJSON,
+			data: `[
+				{"id": 1, "task": "Move house", "priority": 0, "tags": ["home"]},
+				{"id": "2", "parent_id": 1, "task": "Pack", "done": true, "due_at": "2026-10-20"},
+				{"task": 5},
+				{"task": "Orphan", "parent_id": 9}
+			]`,
+			want: []string{
+				`1 -1 "Move house" p0 #home`,
+				`2 0 "Pack" done due=2026-10-20T23:59:00+03:00`,
+				`3 -1 "" !row`,
+				`4 -1 "Orphan" !parent_id`,
+			},
+		},
+		{
+			name:   "todotxt",
+			format: formatTodoTxt,
+			data: "(A) Call mom +family @phone due:2026-10-20\n" +
+				"x 2026-10-18 2026-10-01 Pay rent pri:B\n" +
+				"\n" +
+				"x (C) 2026-10-18 Water plants\n" +
+				"(F) Plain task due:soon\n",
+			want: []string{
+				`1 -1 "Call mom" p0 due=2026-10-20T23:59:00+03:00 #family #phone`,
+				`2 -1 "Pay rent" done p1`,
+				`4 -1 "Water plants" done p2`,
+				`5 -1 "Plain task" p3 !due_at`,
+			},
+		},
+		{
+			name:   "markdown",
+			format: formatMarkdown,
+			data: "# Moving\n" +
+				"- [ ] Move house !high #home due:2026-10-20\n" +
+				"  - [x] Pack boxes\n" +
+				"    * [ ] Buy tape #12\n" +
+				"- [X] Return keys\n" +
+				"Some notes\n" +
+				"1. [ ] Numbered !nope\n",
+			want: []string{
+				`2 -1 "Move house" p1 due=2026-10-20T23:59:00+03:00 #home`,
+				`3 0 "Pack boxes" done`,
+				`4 1 "Buy tape #12"`,
+				`5 -1 "Return keys" done`,
+				`7 -1 "Numbered !nope"`,
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rows, err := parseImport(tt.format, []byte(tt.data), helsinki)
+			if err != nil {
+				t.Fatal(err)
+			}
+			var got []string
+			for _, row := range rows {
+				got = append(got, summarizeImportRow(row))
+			}
+			if !slices.Equal(got, tt.want) {
+				t.Errorf("rows =\n%s\nwant\n%s", strings.Join(got, "\n"), strings.Join(tt.want, "\n"))
+			}
+		})
+	}
+}
+
+func TestParseImportRejectsFile(t *testing.T) {
+	tests := []struct {
+		format string
+		data   string
+	}{
+		{formatCSV, "name,done\nMove house,false\n"},
+		{formatCSV, "task\n\"unterminated\n"},
+		{formatJSON, `{"task": "Move house"}`},
+		{"xlsx", "task\n"},
+	}
+	for _, tt := range tests {
+		if _, err := parseImport(tt.format, []byte(tt.data), time.UTC); err == nil {
+			t.Errorf("parseImport(%s, %q) succeeded, want an error", tt.format, tt.data)
+		}
+	}
+}
+
+func TestImportPublishesOneEvent(t *testing.T) {
+	repo := newMemoryRepository()
+	c, events := newTestController(t, repo)
+
+	body := "- [ ] Move house\n  - [x] Pack boxes\n- [ ] Return keys\n"
+	ctx, w := postContext("/api/v2/todos/import?format=md", body)
+	c.importTodos(ctx)
+
+	if w.Code != http.StatusOK {
+		t.Fatalf("status = %d: %s", w.Code, w.Body)
+	}
+	var event StreamEvent
+	select {
+	case event = <-events.events:
+	default:
+		t.Fatal("no event published")
+	}
+	var batch BatchCreated
+	if err := json.Unmarshal(event.Data, &batch); err != nil {
+		t.Fatal(err)
+	}
+	if event.Type != "todo.batch_created" || batch.Source != "import" || batch.Count != 3 || len(batch.Todos) != 3 {
+		t.Errorf("event = %s %+v, want one todo.batch_created for 3 todos", event.Type, batch)
+	}
+	if got := publishedEvents(events); len(got) != 0 {
+		t.Errorf("further events = %v, want none", got)
+	}
+	if !repo.todos[2].Done || *repo.todos[2].ParentID != 1 {
+		t.Errorf("subtask = %+v, want done under 1", *repo.todos[2])
+	}
+
+	want := []string{"1@1+", "3@1+"}
+	if got := undoTargets(repo.batches[w.Header().Get(undoTokenHeader)]); !slices.Equal(got, want) {
+		t.Errorf("undo targets = %v, want %v", got, want)
+	}
+}
+
+func TestImportDryRunPublishesNothing(t *testing.T) {
+	repo := newMemoryRepository()
+	c, events := newTestController(t, repo)
+
+	ctx, w := postContext("/api/v2/todos/import?format=md&dry_run=true", "- [ ] Move house\n")
+	c.importTodos(ctx)
+
+	if w.Code != http.StatusOK {
+		t.Fatalf("status = %d: %s", w.Code, w.Body)
+	}
+	if len(repo.todos) != 0 {
+		t.Errorf("todos = %d, want none created", len(repo.todos))
+	}
+	if got := publishedEvents(events); len(got) != 0 {
+		t.Errorf("events = %v, want none", got)
+	}
+	if token := w.Header().Get(undoTokenHeader); token != "" {
+		t.Errorf("undo token = %q, want none", token)
+	}
+}
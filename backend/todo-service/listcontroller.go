@@ -0,0 +1,263 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"regexp"
+	"slices"
+
+	"github.com/gin-gonic/gin"
+	"github.com/lib/pq"
+	"github.com/rs/zerolog/log"
+)
+
+// maxStatuses keeps boards to a width a client can lay out.
+const maxStatuses = 20
+
+var statusKeyPattern = regexp.MustCompile(`^[a-z][a-z0-9_]{0,39}$`)
+
+type listRequest struct {
+	Name     string   `json:"name" binding:"required,max=80"`
+	Statuses []Status `json:"statuses" binding:"dive"`
+}
+
+type workflowRequest struct {
+	Statuses []Status `json:"statuses" binding:"required,dive"`
+}
+
+// BoardColumn is a status of a list with the todos that have it.
+type BoardColumn struct {
+	Status
+	Count int    `json:"count"`
+	Todos []Todo `json:"todos"`
+}
+
+type Board struct {
+	ID      int           `json:"id"`
+	Name    string        `json:"name"`
+	Columns []BoardColumn `json:"columns"`
+}
+
+func (c *TodosController) getLists(ctx *gin.Context) {
+	lists, err := c.repo.GetLists()
+	if err != nil {
+		log.Error().Err(err).Msg("Failed to get lists")
+		respondError(ctx, errInternal(err))
+		return
+	}
+
+	log.Info().
+		Str("path", ctx.FullPath()).
+		Int("count", len(lists)).
+		Msg("Lists received")
+	respondWithMeta(ctx, http.StatusOK, lists, gin.H{"count": len(lists)}, nil)
+}
+
+func (c *TodosController) createList(ctx *gin.Context) {
+	var request listRequest
+	if err := ctx.ShouldBindJSON(&request); err != nil {
+		log.Error().Err(err).Msg("Failed to parse request body")
+		respondError(ctx, errInvalidBody(err))
+		return
+	}
+	statuses := defaultWorkflow()
+	if request.Statuses != nil {
+		var apiErr *APIError
+		if statuses, apiErr = validateWorkflow(request.Statuses); apiErr != nil {
+			respondError(ctx, apiErr)
+			return
+		}
+	}
+
+	list, err := c.repo.AddList(request.Name, statuses)
+	if err != nil {
+		log.Error().Err(err).Msg("Failed to create list")
+		respondError(ctx, errInternal(err))
+		return
+	}
+
+	log.Info().
+		Str("path", ctx.FullPath()).
+		Int("id", list.ID).
+		Msg("List created")
+	respond(ctx, http.StatusCreated, list, nil)
+}
+
+func (c *TodosController) getList(ctx *gin.Context) {
+	id, ok := todoIDParam(ctx)
+	if !ok {
+		return
+	}
+
+	list, err := c.repo.GetList(id)
+	if err != nil {
+		respondListError(ctx, id, err, "get list")
+		return
+	}
+	respond(ctx, http.StatusOK, list, nil)
+}
+
+// setWorkflow replaces a list's statuses. Statuses are matched by key, so
+// renaming a key removes the status, which fails while todos have it. The
+// todos whose done changes are published like any update, but no undo
+// token is issued: undo reverts todos, and the workflow is not one.
+func (c *TodosController) setWorkflow(ctx *gin.Context) {
+	id, ok := todoIDParam(ctx)
+	if !ok {
+		return
+	}
+
+	var request workflowRequest
+	if err := ctx.ShouldBindJSON(&request); err != nil {
+		log.Error().Err(err).Msg("Failed to parse request body")
+		respondError(ctx, errInvalidBody(err))
+		return
+	}
+	statuses, apiErr := validateWorkflow(request.Statuses)
+	if apiErr != nil {
+		respondError(ctx, apiErr)
+		return
+	}
+
+	list, changed, err := c.repoFor(ctx).SetWorkflow(id, statuses)
+	if err != nil {
+		respondListError(ctx, id, err, "set workflow")
+		return
+	}
+
+	log.Info().
+		Str("path", ctx.FullPath()).
+		Int("id", id).
+		Int("statuses", len(list.Statuses)).
+		Int("changed", len(changed)).
+		Msg("Workflow updated")
+
+	for _, todo := range changed {
+		c.sendNatsMessage("todo.updated", todo)
+		c.publishCompletion(todo)
+	}
+	respond(ctx, http.StatusOK, list, nil)
+}
+
+// getBoard returns the live todos of a list grouped by status, in the
+// order of the workflow.
+func (c *TodosController) getBoard(ctx *gin.Context) {
+	id, ok := todoIDParam(ctx)
+	if !ok {
+		return
+	}
+
+	list, err := c.repo.GetList(id)
+	if err != nil {
+		respondListError(ctx, id, err, "get board")
+		return
+	}
+	todos, err := c.repo.GetListTodos(id)
+	if err != nil {
+		respondListError(ctx, id, err, "get board")
+		return
+	}
+
+	log.Info().
+		Str("path", ctx.FullPath()).
+		Int("id", id).
+		Int("count", len(todos)).
+		Msg("Board received")
+	respond(ctx, http.StatusOK, buildBoard(list, todos), nil)
+}
+
+func buildBoard(list List, todos []Todo) Board {
+	board := Board{ID: list.ID, Name: list.Name, Columns: make([]BoardColumn, len(list.Statuses))}
+	columns := make(map[string]*BoardColumn, len(list.Statuses))
+	for i, status := range list.Statuses {
+		board.Columns[i] = BoardColumn{Status: status, Todos: make([]Todo, 0)}
+		columns[status.Key] = &board.Columns[i]
+	}
+	for _, todo := range todos {
+		if column, ok := columns[todo.Status]; ok {
+			column.Todos = append(column.Todos, todo)
+			column.Count++
+		}
+	}
+	return board
+}
+
+// validateWorkflow checks a list of statuses and returns it with duplicate
+// transitions removed. A workflow needs an open status, where new and
+// reopened todos go, and a done status, where completed todos go; the first
+// of each in the list is used.
+func validateWorkflow(statuses []Status) ([]Status, *APIError) {
+	if len(statuses) > maxStatuses {
+		return nil, errTooManyItems("statuses", maxStatuses)
+	}
+	keys := make([]string, 0, len(statuses))
+	var open, done bool
+	for _, s := range statuses {
+		if !statusKeyPattern.MatchString(s.Key) {
+			return nil, errInvalidField("statuses", fmt.Sprintf(
+				"Status key %q must be lowercase letters, digits and underscores, starting with a letter", s.Key))
+		}
+		if slices.Contains(keys, s.Key) {
+			return nil, errInvalidField("statuses", fmt.Sprintf("Status key %q is used more than once", s.Key))
+		}
+		keys = append(keys, s.Key)
+		open = open || !s.Done
+		done = done || s.Done
+	}
+	if !open || !done {
+		return nil, errInvalidField("statuses", "A workflow needs at least one open and one done status")
+	}
+
+	valid := make([]Status, len(statuses))
+	for i, s := range statuses {
+		transitions := pq.StringArray{}
+		for _, to := range s.Transitions {
+			if to == s.Key || !slices.Contains(keys, to) {
+				return nil, errInvalidField("statuses", fmt.Sprintf(
+					"Status %q cannot transition to %q, which is not another status of the workflow", s.Key, to))
+			}
+			if !slices.Contains(transitions, to) {
+				transitions = append(transitions, to)
+			}
+		}
+		s.Transitions = transitions
+		valid[i] = s
+	}
+	return valid, nil
+}
+
+func respondListError(ctx *gin.Context, id int, err error, action string) {
+	var apiErr *APIError
+	switch {
+	case errors.Is(err, ErrListNotFound):
+		apiErr = errListNotFound(id)
+	case errors.Is(err, ErrStatusInUse):
+		apiErr = errStatusInUse(id)
+	case errors.Is(err, ErrTodoBlocked):
+		apiErr = errWorkflowBlocked(id)
+	default:
+		log.Error().Err(err).Int("id", id).Msg(action + " failed")
+		respondError(ctx, errInternal(err))
+		return
+	}
+	log.Warn().
+		Str("path", ctx.FullPath()).
+		Int("id", id).
+		Str("code", apiErr.Code).
+		Msg(action + " rejected")
+	respondError(ctx, apiErr)
+}
+
+// publishStatusChange sends todo.status_changed, with the old and new
+// status, if the write that produced todo moved it to another status.
+func (c *TodosController) publishStatusChange(todo Todo) {
+	change, err := c.repo.GetStatusChange(todo)
+	if err != nil {
+		log.Error().Err(err).Int("id", todo.ID).Msg("Failed to get status change")
+		return
+	}
+	if change != nil {
+		c.events.Publish("todo.status_changed", change)
+	}
+}
@@ -0,0 +1,44 @@
+package main
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+func TestWIPLimitReportedPerMutation(t *testing.T) {
+	repo := newMemoryRepository()
+	if _, err := repo.AddTodo(NewTodo{Task: "Water plants"}); err != nil {
+		t.Fatal(err)
+	}
+	repo.full["backlog"], repo.full["done"] = true, true
+	c, events := newTestController(t, repo)
+
+	task, done := "Pack boxes", true
+	created := c.applySyncMutation(repo, syncMutation{Op: "create", Task: &task})
+	completed := c.applySyncMutation(repo, syncMutation{Op: "update", ID: 1, BaseRevision: 1, Done: &done})
+	for name, result := range map[string]syncResult{"create": created, "update": completed} {
+		if result.Status != syncRejected || result.Error == nil || result.Error.Code != CodeWIPLimitReached {
+			t.Errorf("sync %s = %+v, want it rejected with %s", name, result, CodeWIPLimitReached)
+		}
+	}
+
+	ctx, w := postContext("/api/v2/todos/bulk", `{"mode": "best_effort", "operations": [
+		{"op": "create", "task": "Pack boxes"},
+		{"op": "update", "id": 1, "done": true}
+	]}`)
+	c.bulkTodos(ctx)
+	var body struct {
+		Data bulkResponse `json:"data"`
+	}
+	if err := json.Unmarshal(w.Body.Bytes(), &body); err != nil {
+		t.Fatal(err)
+	}
+	for _, result := range body.Data.Results {
+		if result.Status != bulkFailed || result.Error == nil || result.Error.Code != CodeWIPLimitReached {
+			t.Errorf("bulk %s = %+v, want it failed with %s", result.Op, result, CodeWIPLimitReached)
+		}
+	}
+	if got := publishedEvents(events); len(got) != 0 {
+		t.Errorf("events = %v, want none", got)
+	}
+}
@@ -0,0 +1,381 @@
+package main
+
+import (
+	"database/sql"
+	"errors"
+	"maps"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/lib/pq"
+)
+
+var (
+	ErrListNotFound     = errors.New("list not found")
+	ErrStatusNotFound   = errors.New("status is not part of the list's workflow")
+	ErrTransitionDenied = errors.New("the workflow does not allow this status change")
+	ErrWIPLimitReached  = errors.New("the status has reached its WIP limit")
+	ErrStatusInUse      = errors.New("todos still have a status the workflow removes")
+)
+
+// defaultListID is the list todos go into when no list is given. It is
+// created by the migrations.
+const defaultListID = 1
+
+// List is a named set of todos with its own workflow.
+type List struct {
+	ID       int      `json:"id" db:"id"`
+	Name     string   `json:"name" db:"name"`
+	Statuses []Status `json:"statuses" db:"-"`
+}
+
+// Status is one column of a list's workflow. Todos in a done status are
+// done. Transitions names the statuses a todo may move to from this one.
+type Status struct {
+	Key         string         `json:"key" db:"key" binding:"required"`
+	Name        string         `json:"name" db:"name" binding:"required,max=80"`
+	Done        bool           `json:"done" db:"done"`
+	WIPLimit    *int           `json:"wip_limit" db:"wip_limit" binding:"omitempty,min=1"`
+	Transitions pq.StringArray `json:"transitions" db:"transitions"`
+}
+
+// StatusChange is the payload of todo.status_changed.
+type StatusChange struct {
+	Todo Todo   `json:"todo"`
+	From string `json:"from"`
+	To   string `json:"to"`
+}
+
+// defaultWorkflow is the workflow of lists created without one, the same
+// the default list starts with.
+func defaultWorkflow() []Status {
+	return []Status{
+		{Key: "backlog", Name: "Backlog", Transitions: pq.StringArray{"in_progress", "done"}},
+		{Key: "in_progress", Name: "In progress", Transitions: pq.StringArray{"backlog", "review", "done"}},
+		{Key: "review", Name: "Review", Transitions: pq.StringArray{"in_progress", "done"}},
+		{Key: "done", Name: "Done", Done: true, Transitions: pq.StringArray{"backlog", "in_progress"}},
+	}
+}
+
+func (t todoRepository) GetLists() ([]List, error) {
+	lists := make([]List, 0)
+	if err := sqlx.Select(t.q, &lists, "SELECT id, name FROM lists ORDER BY id"); err != nil {
+		return lists, err
+	}
+	var statuses []struct {
+		ListID int `db:"list_id"`
+		Status
+	}
+	err := sqlx.Select(t.q, &statuses, `
+		SELECT list_id, key, name, done, wip_limit, transitions
+		FROM list_statuses ORDER BY list_id, position`)
+	if err != nil {
+		return lists, err
+	}
+	byID := make(map[int]*List, len(lists))
+	for i := range lists {
+		lists[i].Statuses = make([]Status, 0)
+		byID[lists[i].ID] = &lists[i]
+	}
+	for _, s := range statuses {
+		if list, ok := byID[s.ListID]; ok {
+			list.Statuses = append(list.Statuses, s.Status)
+		}
+	}
+	return lists, nil
+}
+
+func (t todoRepository) GetList(id int) (List, error) {
+	var list List
+	err := sqlx.Get(t.q, &list, "SELECT id, name FROM lists WHERE id = $1", id)
+	if errors.Is(err, sql.ErrNoRows) {
+		return list, ErrListNotFound
+	}
+	if err != nil {
+		return list, err
+	}
+	list.Statuses = make([]Status, 0)
+	err = sqlx.Select(t.q, &list.Statuses, `
+		SELECT key, name, done, wip_limit, transitions
+		FROM list_statuses WHERE list_id = $1 ORDER BY position`, id)
+	return list, err
+}
+
+func (t todoRepository) AddList(name string, statuses []Status) (List, error) {
+	var list List
+	err := t.transaction(func(repo todoRepository) error {
+		var id int
+		if err := sqlx.Get(repo.q, &id, "INSERT INTO lists (name) VALUES ($1) RETURNING id", name); err != nil {
+			return err
+		}
+		if err := repo.writeStatuses(id, statuses); err != nil {
+			return err
+		}
+		var err error
+		list, err = repo.GetList(id)
+		return err
+	})
+	return list, err
+}
+
+func (t todoRepository) SetWorkflow(id int, statuses []Status) (List, []Todo, error) {
+	var list List
+	var changed []Todo
+	err := t.transaction(func(repo todoRepository) error {
+		// Locking the statuses keeps todos from moving into a status that
+		// is being removed, and WIP checks from reading an old limit.
+		var locked []string
+		err := sqlx.Select(repo.q, &locked, "SELECT key FROM list_statuses WHERE list_id = $1 FOR UPDATE", id)
+		if err != nil {
+			return err
+		}
+		if len(locked) == 0 {
+			return ErrListNotFound
+		}
+
+		keys := make([]string, len(statuses))
+		for i, s := range statuses {
+			keys[i] = s.Key
+		}
+		var inUse bool
+		err = sqlx.Get(repo.q, &inUse, `
+			SELECT EXISTS (SELECT 1 FROM todos WHERE list_id = $1 AND status <> ALL($2))`, id, pq.Array(keys))
+		if err != nil {
+			return err
+		}
+		if inUse {
+			return ErrStatusInUse
+		}
+
+		if _, err := repo.q.Exec("DELETE FROM list_statuses WHERE list_id = $1 AND key <> ALL($2)", id, pq.Array(keys)); err != nil {
+			return err
+		}
+		if err := repo.writeStatuses(id, statuses); err != nil {
+			return err
+		}
+		if changed, err = repo.syncDone(id); err != nil {
+			return err
+		}
+		list, err = repo.GetList(id)
+		return err
+	})
+	return list, changed, err
+}
+
+// writeStatuses inserts or updates the statuses of list id, in order.
+func (t todoRepository) writeStatuses(id int, statuses []Status) error {
+	for i, s := range statuses {
+		transitions := s.Transitions
+		if transitions == nil {
+			transitions = pq.StringArray{}
+		}
+		_, err := t.q.Exec(`
+			INSERT INTO list_statuses (list_id, key, name, position, done, wip_limit, transitions)
+			VALUES ($1, $2, $3, $4, $5, $6, $7)
+			ON CONFLICT (list_id, key) DO UPDATE SET name = EXCLUDED.name, position = EXCLUDED.position,
+				done = EXCLUDED.done, wip_limit = EXCLUDED.wip_limit, transitions = EXCLUDED.transitions`,
+			id, s.Key, s.Name, i, s.Done, s.WIPLimit, transitions)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// syncDone updates done on the todos of list id whose status was turned
+// into a done status or out of one. The live todos it completes are
+// completed like any other, so it fails with ErrTodoBlocked for a blocked
+// one when that is refused. It returns the live todos it changed followed
+// by the ancestors they completed.
+func (t todoRepository) syncDone(id int) ([]Todo, error) {
+	if t.refuseBlocked {
+		var blocked bool
+		err := sqlx.Get(t.q, &blocked, `
+			SELECT EXISTS (
+				SELECT 1 FROM todos t JOIN list_statuses s ON s.list_id = t.list_id AND s.key = t.status
+				WHERE t.list_id = $1 AND s.done AND NOT t.done AND t.blocked AND t.deleted_at IS NULL
+			)`, id)
+		if err != nil {
+			return nil, err
+		}
+		if blocked {
+			return nil, ErrTodoBlocked
+		}
+	}
+
+	var changed []Todo
+	err := sqlx.Select(t.q, &changed, `
+		UPDATE todos t SET done = s.done
+		FROM list_statuses s
+		WHERE t.list_id = $1 AND s.list_id = t.list_id AND s.key = t.status AND t.done <> s.done
+		RETURNING `+prefixColumns("t"), id)
+	if err != nil {
+		return nil, err
+	}
+	live := make([]Todo, 0, len(changed))
+	var ancestors []Todo
+	for _, todo := range changed {
+		changes := map[string]FieldChange{"done": {From: !todo.Done, To: todo.Done}}
+		if todo.DeletedAt == nil {
+			live = append(live, todo)
+			if todo.Done {
+				completion, err := t.completed(todo)
+				if err != nil {
+					return nil, err
+				}
+				maps.Copy(changes, completion.changes())
+				ancestors = append(ancestors, completion.Ancestors...)
+			}
+		}
+		if err := t.recordEvent(todo.ID, ActionUpdated, todo.Version, changes); err != nil {
+			return nil, err
+		}
+	}
+	return append(live, ancestors...), nil
+}
+
+// GetListTodos returns the todos of a list that are not in the trash, by
+// priority and then position.
+func (t todoRepository) GetListTodos(id int) ([]Todo, error) {
+	todos := make([]Todo, 0)
+	err := sqlx.Select(t.q, &todos, `
+		SELECT `+todoColumns+` FROM todos
+		WHERE list_id = $1 AND deleted_at IS NULL
+		ORDER BY priority, position, id`, id)
+	return todos, err
+}
+
+// newTodoList returns the list a new todo goes into: the one it names, its
+// parent's, or the default list.
+func (t todoRepository) newTodoList(todo NewTodo) (int, error) {
+	if todo.ListID != nil {
+		var exists bool
+		err := sqlx.Get(t.q, &exists, "SELECT EXISTS (SELECT 1 FROM lists WHERE id = $1)", *todo.ListID)
+		if err == nil && !exists {
+			err = ErrListNotFound
+		}
+		return *todo.ListID, err
+	}
+	if todo.ParentID != nil {
+		var id int
+		err := sqlx.Get(t.q, &id, "SELECT list_id FROM todos WHERE id = $1", *todo.ParentID)
+		return id, err
+	}
+	return defaultListID, nil
+}
+
+// checkStatusChange checks that todo id may move to status to and reports
+// whether that completes it. Staying in the same status is always allowed.
+func (t todoRepository) checkStatusChange(id int, to string, enforce bool) (bool, error) {
+	var current struct {
+		ListID int    `db:"list_id"`
+		Status string `db:"status"`
+	}
+	err := sqlx.Get(t.q, &current, "SELECT list_id, status FROM todos WHERE id = $1 AND deleted_at IS NULL", id)
+	if errors.Is(err, sql.ErrNoRows) {
+		// The update itself reports the missing todo.
+		return false, nil
+	}
+	if err != nil || current.Status == to {
+		return false, err
+	}
+	return t.checkStatus(current.ListID, current.Status, to, enforce)
+}
+
+// checkDoneChange checks the WIP limit of the status that setting done on
+// todo id moves it to, which is picked as the todos_sync_status trigger
+// does. Clients that only set done predate workflows, so the transitions
+// do not apply to them.
+func (t todoRepository) checkDoneChange(id int, done bool, enforce bool) error {
+	var current struct {
+		ListID int  `db:"list_id"`
+		Done   bool `db:"done"`
+	}
+	err := sqlx.Get(t.q, &current, "SELECT list_id, done FROM todos WHERE id = $1 AND deleted_at IS NULL", id)
+	if errors.Is(err, sql.ErrNoRows) {
+		// The update itself reports the missing todo.
+		return nil
+	}
+	if err != nil || current.Done == done || !enforce {
+		return err
+	}
+	status, err := t.defaultStatus(current.ListID, done)
+	if err != nil {
+		return err
+	}
+	_, err = t.checkStatus(current.ListID, "", status, true)
+	return err
+}
+
+// defaultStatus returns the first done or open status of list listID.
+func (t todoRepository) defaultStatus(listID int, done bool) (string, error) {
+	var key string
+	err := sqlx.Get(t.q, &key, `
+		SELECT key FROM list_statuses WHERE list_id = $1 AND done = $2
+		ORDER BY position LIMIT 1`, listID, done)
+	if errors.Is(err, sql.ErrNoRows) {
+		return "", ErrStatusNotFound
+	}
+	return key, err
+}
+
+// checkStatus checks that a todo of list listID may move from status from,
+// empty for a new todo, to status to, and reports whether to is a done
+// status. The transitions are not checked when from is empty. With enforce
+// unset only the status's existence is checked. The
+// target status stays locked until commit, so concurrent moves cannot both
+// take the last place under its WIP limit.
+func (t todoRepository) checkStatus(listID int, from, to string, enforce bool) (bool, error) {
+	var target struct {
+		Done     bool `db:"done"`
+		WIPLimit *int `db:"wip_limit"`
+		Allowed  bool `db:"allowed"`
+	}
+	err := sqlx.Get(t.q, &target, `
+		SELECT s.done, s.wip_limit, COALESCE(s.key = ANY(f.transitions), FALSE) AS allowed
+		FROM list_statuses s
+		LEFT JOIN list_statuses f ON f.list_id = s.list_id AND f.key = $3
+		WHERE s.list_id = $1 AND s.key = $2
+		FOR UPDATE OF s`, listID, to, from)
+	if errors.Is(err, sql.ErrNoRows) {
+		return false, ErrStatusNotFound
+	}
+	if err != nil || !enforce {
+		return target.Done, err
+	}
+	if from != "" && !target.Allowed {
+		return false, ErrTransitionDenied
+	}
+	if target.WIPLimit != nil {
+		var count int
+		err := sqlx.Get(t.q, &count, `
+			SELECT COUNT(*) FROM todos WHERE list_id = $1 AND status = $2 AND deleted_at IS NULL`, listID, to)
+		if err != nil {
+			return false, err
+		}
+		if count >= *target.WIPLimit {
+			return false, ErrWIPLimitReached
+		}
+	}
+	return target.Done, nil
+}
+
+// GetStatusChange returns the status change that brought todo to its
+// current version, or nil if that write left the status alone.
+func (t todoRepository) GetStatusChange(todo Todo) (*StatusChange, error) {
+	var change struct {
+		From string `db:"from"`
+		To   string `db:"to"`
+	}
+	err := sqlx.Get(t.q, &change, `
+		SELECT COALESCE(changes->'status'->>'from', '') AS "from", changes->'status'->>'to' AS "to"
+		FROM todo_events
+		WHERE todo_id = $1 AND version = $2 AND changes->'status' IS NOT NULL
+		ORDER BY id DESC LIMIT 1`, todo.ID, todo.Version)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &StatusChange{Todo: todo, From: change.From, To: change.To}, nil
+}
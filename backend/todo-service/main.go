@@ -1,20 +1,36 @@
 package main
 
 import (
-	"fmt"
+	"flag"
 	"os"
 	"time"
 
+	"cors"
 	"github.com/gin-gonic/gin"
 	"github.com/jmoiron/sqlx"
-	_ "github.com/lib/pq"
+	"github.com/lib/pq"
 	"github.com/rs/zerolog/log"
 )
 
 type Todo struct {
-	ID   int    `json:"id" db:"id"`
-	Task string `json:"task" db:"task"`
-	Done bool   `json:"done" db:"done"`
+	ID           int            `json:"id" db:"id"`
+	Task         string         `json:"task" db:"task"`
+	Done         bool           `json:"done" db:"done"`
+	Revision     int64          `json:"revision" db:"revision"`
+	Version      int64          `json:"version" db:"version"`
+	DeletedAt    *time.Time     `json:"deleted_at,omitempty" db:"deleted_at"`
+	ParentID     *int           `json:"parent_id" db:"parent_id"`
+	AutoComplete bool           `json:"auto_complete" db:"auto_complete"`
+	Blocked      bool           `json:"blocked" db:"blocked"`
+	DueAt        *time.Time     `json:"due_at,omitempty" db:"due_at"`
+	Recurrence   string         `json:"recurrence,omitempty" db:"recurrence"`
+	SeriesID     *int           `json:"series_id,omitempty" db:"series_id"`
+	PreviousID   *int           `json:"previous_id,omitempty" db:"previous_id"`
+	Priority     int            `json:"priority" db:"priority"`
+	Position     string         `json:"position" db:"position"`
+	ListID       int            `json:"list_id" db:"list_id"`
+	Status       string         `json:"status" db:"status"`
+	Tags         pq.StringArray `json:"tags" db:"tags"`
 }
 
 const (
@@ -23,13 +39,23 @@ const (
 )
 
 func main() {
-	var port = os.Getenv("PORT")
+	configPath := flag.String("config", os.Getenv("CONFIG_FILE"), "path to an optional JSON config file")
+	showConfig := flag.Bool("print-config", false, "print the effective configuration and exit")
+	flag.Parse()
 
-	if port == "" {
-		log.Fatal().Msg("$PORT must be set")
+	cfg, err := LoadConfig(*configPath)
+	if err != nil {
+		log.Fatal().Err(err).Msg("Failed to load configuration")
+	}
+
+	if *showConfig {
+		if err := printConfig(cfg); err != nil {
+			log.Fatal().Err(err).Msg("Failed to print configuration")
+		}
+		return
 	}
 
-	db := initDB()
+	db := initDB(cfg.Postgres)
 	defer func(db *sqlx.DB) {
 		err := db.Close()
 		if err != nil {
@@ -37,42 +63,147 @@ func main() {
 		}
 	}(db)
 
-	repo := NewTodoRepository(db)
-	controller := NewTodosController(repo)
+	hub := NewStreamHub(cfg.Stream.BufferSize, cfg.Stream.ClientBuffer)
+	events := NewEventBus(cfg.NatsURL, hub)
+	defer events.Close()
 
-	router := gin.Default()
+	repo := NewTodoRepository(db, cfg.RefuseBlockedCompletion, cfg.Location())
+	controller := NewTodosController(repo, cfg, events)
+	streams := NewStreamController(hub, cfg.Stream.Heartbeat(), cfg.Cors.AllowedOrigins)
 
-	router.Use(CorsMiddleware)
+	go purgeTrash(repo, events, cfg.Trash.Retention(), cfg.Trash.PurgeInterval())
+	go purgeUndoTokens(repo, time.Hour)
 
-	router.GET("/", controller.welcome)
-	router.GET("/api/todos", controller.getTodos)
-	router.POST("/api/todos", controller.createTodo)
-	router.PUT("/api/todos/:id", controller.markTodoDone)
-	router.POST("/api/todos/random", controller.createRandomTodo)
-	router.GET("/api/todos/db-health", controller.dbHealthCheck)
-	router.GET("/api/todos/healthz", controller.healthCheck)
-
-	log.Info().Str("port", port).Msg("Server starting")
-	err := router.Run(":" + port)
+	idempotencyKeys := NewPostgresIdempotencyStore(db)
+	go purgeIdempotencyKeys(idempotencyKeys, time.Hour)
+
+	// v1 keeps the original response shapes the frontend relies on; v2
+	// serves the same handlers with envelopes and problem+json errors.
+	router := newRouter(cfg, todoRoutes{
+		controller: controller,
+		streams:    streams,
+		limiter:    NewRateLimiter(NewMemoryRateLimitStore(5*time.Minute), cfg.RateLimit.Enabled),
+		limits:     cfg.RateLimit,
+		idempotent: IdempotencyMiddleware(idempotencyKeys, cfg.Idempotency.TTL()),
+	})
+
+	log.Info().Str("port", cfg.Port).Msg("Server starting")
+	err = router.Run(":" + cfg.Port)
 	if err != nil {
 		log.Fatal().Err(err).Msg("Failed to start server")
 	}
 }
 
-func initDB() *sqlx.DB {
-	var (
-		dbHost = os.Getenv("POSTGRES_HOST")
-		dbUser = os.Getenv("POSTGRES_USER")
-		dbPass = os.Getenv("POSTGRES_PASSWORD")
-		dbName = os.Getenv("POSTGRES_DB")
-	)
-
-	if dbHost == "" || dbName == "" || dbUser == "" || dbPass == "" {
-		log.Fatal().Msg("All database environment variables not set")
+// newRouter registers every route of the service.
+func newRouter(cfg *Config, routes todoRoutes) *gin.Engine {
+	router := gin.Default()
+	// gin trusts every proxy by default, which lets any client pick its IP
+	// with X-Forwarded-For. TrustedProxies is checked by validate.
+	if err := router.SetTrustedProxies(cfg.TrustedProxies); err != nil {
+		log.Error().Err(err).Msg("Failed to set trusted proxies, trusting none")
 	}
 
-	connStr := fmt.Sprintf("host=%s port=5432 user=%s password=%s dbname=%s sslmode=disable",
-		dbHost, dbUser, dbPass, dbName)
+	router.Use(RequestIDMiddleware())
+	router.Use(APIKeyMiddleware(cfg.APIKeys))
+	router.Use(cors.Middleware(cfg.Cors.Options()))
+
+	// The audit log and todo histories name the callers behind changes.
+	routes.audit = RequireAPIKey(cfg.APIKeys)
+
+	controller := routes.controller
+	router.GET("/", controller.welcome)
+	router.GET("/openapi.json", serveOpenAPISpec)
+	router.GET("/docs", serveDocs)
+
+	routes.register(router.Group("/api/todos", apiVersion(1)))
+	routes.register(router.Group("/api/v2/todos", apiVersion(2)))
+	routes.registerLists(router.Group("/api/lists", apiVersion(1)))
+	routes.registerLists(router.Group("/api/v2/lists", apiVersion(2)))
+	routes.registerTemplates(router.Group("/api/templates", apiVersion(1)))
+	routes.registerTemplates(router.Group("/api/v2/templates", apiVersion(2)))
+	routes.registerFeeds(router.Group("/api/feeds", apiVersion(1)))
+	routes.registerFeeds(router.Group("/api/v2/feeds", apiVersion(2)))
+	router.GET("/feeds/:token", controller.serveFeed)
+	writes := routes.limiter.Limit("writes", routes.limits.Writes())
+	router.GET("/api/audit", apiVersion(1), routes.audit, writes, controller.getAuditLog)
+	router.GET("/api/v2/audit", apiVersion(2), routes.audit, writes, controller.getAuditLog)
+	router.POST("/api/undo/:token", apiVersion(1), writes, controller.undo)
+	router.POST("/api/v2/undo/:token", apiVersion(2), writes, controller.undo)
+	return router
+}
+
+type todoRoutes struct {
+	controller *TodosController
+	streams    *StreamController
+	limiter    *RateLimiter
+	limits     RateLimitConfig
+	idempotent gin.HandlerFunc
+	audit      gin.HandlerFunc
+}
+
+func (r todoRoutes) register(todos *gin.RouterGroup) {
+	writes := r.limiter.Limit("writes", r.limits.Writes())
+	random := r.limiter.Limit("random", r.limits.Random())
+
+	todos.GET("", r.controller.getTodos)
+	todos.POST("", writes, r.idempotent, r.controller.createTodo)
+	todos.GET("/trash", r.controller.getTrash)
+	todos.GET("/search", r.controller.searchTodos)
+	todos.GET("/:id", r.controller.getTodo)
+	todos.GET("/:id/history", r.audit, r.controller.getHistory)
+	todos.PUT("/:id", writes, r.controller.markTodoDone)
+	todos.PATCH("/:id", writes, r.controller.updateTodo)
+	todos.DELETE("/:id", writes, r.controller.deleteTodo)
+	todos.POST("/:id/restore", writes, r.controller.restoreTodo)
+	todos.GET("/:id/blockers", r.controller.getBlockers)
+	todos.PUT("/:id/blockers/:blocker_id", writes, r.controller.addBlocker)
+	todos.DELETE("/:id/blockers/:blocker_id", writes, r.controller.removeBlocker)
+	todos.PUT("/:id/recurrence", writes, r.controller.setRecurrence)
+	todos.POST("/:id/move", writes, r.controller.moveTodo)
+	todos.DELETE("/:id/recurrence", writes, r.controller.stopRecurrence)
+	todos.POST("/random", random, r.idempotent, r.controller.createRandomTodo)
+	todos.GET("/db-health", r.controller.dbHealthCheck)
+	todos.GET("/healthz", r.controller.healthCheck)
+	todos.GET("/changes", r.controller.getChanges)
+	todos.POST("/sync", writes, r.idempotent, r.controller.syncTodos)
+	todos.POST("/bulk", writes, r.idempotent, r.controller.bulkTodos)
+	todos.POST("/import", writes, r.idempotent, r.controller.importTodos)
+	todos.GET("/export", r.controller.exportTodos)
+	todos.GET("/stream", r.streams.streamTodos)
+	todos.GET("/stream/ws", r.streams.streamTodosWebSocket)
+}
+
+func (r todoRoutes) registerLists(lists *gin.RouterGroup) {
+	writes := r.limiter.Limit("writes", r.limits.Writes())
+
+	lists.GET("", r.controller.getLists)
+	lists.POST("", writes, r.idempotent, r.controller.createList)
+	lists.GET("/:id", r.controller.getList)
+	lists.PUT("/:id/workflow", writes, r.controller.setWorkflow)
+	lists.GET("/:id/board", r.controller.getBoard)
+}
+
+func (r todoRoutes) registerTemplates(templates *gin.RouterGroup) {
+	writes := r.limiter.Limit("writes", r.limits.Writes())
+
+	templates.GET("", r.controller.getTemplates)
+	templates.POST("", writes, r.idempotent, r.controller.createTemplate)
+	templates.GET("/:id", r.controller.getTemplate)
+	templates.PUT("/:id", writes, r.controller.updateTemplate)
+	templates.DELETE("/:id", writes, r.controller.deleteTemplate)
+	templates.POST("/:id/instantiate", writes, r.idempotent, r.controller.instantiateTemplate)
+}
+
+func (r todoRoutes) registerFeeds(feeds *gin.RouterGroup) {
+	writes := r.limiter.Limit("writes", r.limits.Writes())
+
+	feeds.GET("", r.controller.getFeeds)
+	feeds.POST("", writes, r.idempotent, r.controller.createFeed)
+	feeds.DELETE("/:id", writes, r.controller.deleteFeed)
+}
+
+func initDB(pgConfig PostgresConfig) *sqlx.DB {
+	connStr := pgConfig.ConnString()
 
 	var err error
 	var db *sqlx.DB
@@ -88,17 +219,8 @@ func initDB() *sqlx.DB {
 		log.Fatal().Err(err).Msgf("Failed to connect to database after retries: %v", err)
 	}
 
-	_, err = db.Exec(`
-		CREATE TABLE IF NOT EXISTS todos (
-			id SERIAL PRIMARY KEY,
-			task TEXT NOT NULL,
-			done BOOLEAN NOT NULL DEFAULT FALSE,
-			created_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP,
-			updated_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP
-		)
-	`)
-	if err != nil {
-		log.Fatal().Err(err).Msg("Failed to create table:")
+	if err := migrate(db); err != nil {
+		log.Fatal().Err(err).Msg("Failed to migrate database")
 	}
 
 	return db
@@ -0,0 +1,407 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"maps"
+	"slices"
+	"testing"
+	"time"
+)
+
+// memoryRepository keeps todos in memory for controller tests. It only
+// implements what those tests use; the embedded interface is nil, so any
+// other method panics.
+type memoryRepository struct {
+	TodoRepository
+	todos     map[int]*Todo
+	clientID  map[string]int
+	nextID    int
+	revision  int64
+	templates map[int]Template
+	// full are the statuses at their WIP limit.
+	full map[string]bool
+	// batches are the targets of the batch undo tokens issued, by token.
+	batches map[string][]UndoTarget
+	// undone is what Undo returns for any token.
+	undone      []UndoResult
+	undoneBatch bool
+	// blockers are the todos each todo depends on, by ID.
+	blockers map[int][]int
+	// refuseBlocked refuses to complete blocked todos, as
+	// REFUSE_BLOCKED_COMPLETION does.
+	refuseBlocked bool
+	// feeds are the calendar feeds, by token hash.
+	feeds map[string]CalendarFeed
+	// modified is what LastModified returns.
+	modified time.Time
+}
+
+func newMemoryRepository() *memoryRepository {
+	return &memoryRepository{todos: make(map[int]*Todo), clientID: make(map[string]int), nextID: 1,
+		templates: make(map[int]Template), full: make(map[string]bool), batches: make(map[string][]UndoTarget),
+		blockers: make(map[int][]int), feeds: make(map[string]CalendarFeed)}
+}
+
+func (r *memoryRepository) bump(todo *Todo) {
+	r.revision++
+	todo.Revision = r.revision
+	todo.Version++
+}
+
+// Transaction puts the todos back as they were when fn fails, so nested
+// calls act as savepoints like they do on Postgres.
+func (r *memoryRepository) Transaction(fn func(repo TodoRepository) error) error {
+	todos := make(map[int]Todo, len(r.todos))
+	for id, todo := range r.todos {
+		todos[id] = *todo
+	}
+	clientID, nextID, revision := maps.Clone(r.clientID), r.nextID, r.revision
+	err := fn(r)
+	if err != nil {
+		r.todos = make(map[int]*Todo, len(todos))
+		for id, todo := range todos {
+			r.todos[id] = &todo
+		}
+		r.clientID, r.nextID, r.revision = clientID, nextID, revision
+	}
+	return err
+}
+
+func (r *memoryRepository) WithAudit(AuditMeta) TodoRepository {
+	return r
+}
+
+func (r *memoryRepository) GetTodo(id int) (Todo, error) {
+	todo, ok := r.todos[id]
+	if !ok || todo.DeletedAt != nil {
+		return Todo{}, ErrTodoNotFound
+	}
+	return *todo, nil
+}
+
+func (r *memoryRepository) AddTodo(n NewTodo) (Todo, error) {
+	if _, ok := r.clientID[n.ClientID]; ok && n.ClientID != "" {
+		return Todo{}, ErrDuplicateClientID
+	}
+	if r.full["backlog"] {
+		return Todo{}, ErrWIPLimitReached
+	}
+	todo := &Todo{ID: r.nextID, Task: n.Task, ParentID: n.ParentID, AutoComplete: n.AutoComplete, DueAt: n.DueAt,
+		Recurrence: n.Recurrence, Priority: defaultPriority, ListID: 1, Status: "backlog", Tags: n.Tags}
+	if n.Priority != nil {
+		todo.Priority = *n.Priority
+	}
+	r.nextID++
+	r.bump(todo)
+	r.todos[todo.ID] = todo
+	if n.ClientID != "" {
+		r.clientID[n.ClientID] = todo.ID
+	}
+	return *todo, nil
+}
+
+func (r *memoryRepository) GetTodoByClientID(clientID string) (Todo, error) {
+	id, ok := r.clientID[clientID]
+	if !ok {
+		return Todo{}, ErrTodoNotFound
+	}
+	return *r.todos[id], nil
+}
+
+func (r *memoryRepository) GetTemplate(id int) (Template, error) {
+	template, ok := r.templates[id]
+	if !ok {
+		return Template{}, ErrTemplateNotFound
+	}
+	return template, nil
+}
+
+// GetList knows only the default list, which every todo is in.
+func (r *memoryRepository) GetList(id int) (List, error) {
+	if id != defaultListID {
+		return List{}, ErrListNotFound
+	}
+	return List{ID: id, Name: "Todos"}, nil
+}
+
+func (r *memoryRepository) GetListTodos(id int) ([]Todo, error) {
+	if _, err := r.GetList(id); err != nil {
+		return nil, err
+	}
+	todos := make([]Todo, 0, len(r.todos))
+	for id := 1; id < r.nextID; id++ {
+		if todo, ok := r.todos[id]; ok && todo.DeletedAt == nil {
+			todos = append(todos, *todo)
+		}
+	}
+	return todos, nil
+}
+
+func (r *memoryRepository) check(id int, pre Precondition) (*Todo, error) {
+	todo, ok := r.todos[id]
+	if !ok || todo.DeletedAt != nil {
+		return nil, ErrTodoNotFound
+	}
+	if pre.Revision != 0 && pre.Revision != todo.Revision ||
+		pre.Versions != nil && !slices.Contains(pre.Versions, todo.Version) {
+		return nil, ErrPreconditionFailed
+	}
+	return todo, nil
+}
+
+func (r *memoryRepository) UpdateTodo(id int, pre Precondition, update TodoUpdate) (Todo, Completion, error) {
+	todo, err := r.check(id, pre)
+	if err != nil {
+		return Todo{}, Completion{}, err
+	}
+	wasDone := todo.Done
+	if update.Done != nil && *update.Done && r.refuseBlocked && todo.Blocked && !todo.Done {
+		return Todo{}, Completion{}, ErrTodoBlocked
+	}
+	if update.Task != nil {
+		todo.Task = *update.Task
+	}
+	if update.Done != nil {
+		status := "backlog"
+		if *update.Done {
+			status = "done"
+		}
+		if status != todo.Status && r.full[status] {
+			return Todo{}, Completion{}, ErrWIPLimitReached
+		}
+		todo.Done, todo.Status = *update.Done, status
+	}
+	r.bump(todo)
+	if todo.Done != wasDone {
+		r.refreshBlocked(todo.ID)
+	}
+	var completion Completion
+	if todo.Done && !wasDone {
+		completion = r.completeAncestors(todo.ParentID)
+	}
+	return *todo, completion, nil
+}
+
+func (r *memoryRepository) markTodoDone(id int, pre Precondition) (Todo, Completion, error) {
+	done := true
+	return r.UpdateTodo(id, pre, TodoUpdate{Done: &done})
+}
+
+// completeAncestors completes parentID and up while they auto-complete and
+// have no open subtasks left. It schedules no occurrences.
+func (r *memoryRepository) completeAncestors(parentID *int) Completion {
+	var completion Completion
+	for ; parentID != nil; parentID = r.todos[*parentID].ParentID {
+		parent := r.todos[*parentID]
+		if !parent.AutoComplete || parent.Done || parent.DeletedAt != nil {
+			break
+		}
+		for _, child := range r.todos {
+			if child.ParentID != nil && *child.ParentID == parent.ID && child.DeletedAt == nil && !child.Done {
+				return completion
+			}
+		}
+		parent.Done, parent.Status = true, "done"
+		r.bump(parent)
+		completion.Ancestors = append(completion.Ancestors, *parent)
+	}
+	return completion
+}
+
+// descendants returns the subtasks below id that match, searching only
+// below those that match, parents before children.
+func (r *memoryRepository) descendants(id int, match func(*Todo) bool) []*Todo {
+	var found []*Todo
+	for parents := []int{id}; len(parents) > 0; parents = parents[1:] {
+		for childID := 1; childID < r.nextID; childID++ {
+			child, ok := r.todos[childID]
+			if ok && child.ParentID != nil && *child.ParentID == parents[0] && match(child) {
+				found = append(found, child)
+				parents = append(parents, childID)
+			}
+		}
+	}
+	return found
+}
+
+func (r *memoryRepository) DeleteTodo(id int, pre Precondition) (Todo, []Todo, Completion, error) {
+	todo, err := r.check(id, pre)
+	if err != nil {
+		return Todo{}, nil, Completion{}, err
+	}
+	now := time.Now()
+	subtasks := make([]Todo, 0)
+	for _, subtask := range append([]*Todo{todo}, r.descendants(id, func(t *Todo) bool { return t.DeletedAt == nil })...) {
+		subtask.DeletedAt = &now
+		r.bump(subtask)
+		r.refreshBlocked(subtask.ID)
+		subtasks = append(subtasks, *subtask)
+	}
+	return subtasks[0], subtasks[1:], r.completeAncestors(todo.ParentID), nil
+}
+
+func (r *memoryRepository) RestoreTodo(id int, pre Precondition) (Todo, []Todo, error) {
+	todo, ok := r.todos[id]
+	switch {
+	case !ok:
+		return Todo{}, nil, ErrTodoNotFound
+	case todo.DeletedAt == nil:
+		return Todo{}, nil, ErrTodoNotTrashed
+	}
+	deletedAt := *todo.DeletedAt
+	subtasks := make([]Todo, 0)
+	for _, subtask := range append([]*Todo{todo}, r.descendants(id, func(t *Todo) bool { return t.DeletedAt != nil && t.DeletedAt.Equal(deletedAt) })...) {
+		subtask.DeletedAt = nil
+		r.bump(subtask)
+		r.refreshBlocked(subtask.ID)
+		subtasks = append(subtasks, *subtask)
+	}
+	return subtasks[0], subtasks[1:], nil
+}
+
+func (r *memoryRepository) IssueUndoToken(todo Todo, _ time.Duration) (string, error) {
+	return fmt.Sprintf("undo-%d-%d", todo.ID, todo.Version), nil
+}
+
+func (r *memoryRepository) IssueBatchUndoToken(targets []UndoTarget, _ time.Duration) (string, error) {
+	token := fmt.Sprintf("batch-%d", len(r.batches)+1)
+	r.batches[token] = targets
+	return token, nil
+}
+
+func (r *memoryRepository) Undo(string) ([]UndoResult, bool, error) {
+	return r.undone, r.undoneBatch, nil
+}
+
+// AddBlocker looks for a cycle like checkCycle, walking up from the
+// blocker, and keeps blocked up to date like the triggers.
+func (r *memoryRepository) AddBlocker(id, blockerID int) (Todo, bool, error) {
+	todo, err := r.check(id, Precondition{})
+	if err != nil {
+		return Todo{}, false, err
+	}
+	if _, err := r.check(blockerID, Precondition{}); err != nil {
+		return Todo{}, false, ErrBlockerNotFound
+	}
+	for upstream := []int{blockerID}; len(upstream) > 0; upstream = upstream[1:] {
+		if upstream[0] == id {
+			return Todo{}, false, ErrDependencyCycle
+		}
+		upstream = append(upstream, r.blockers[upstream[0]]...)
+	}
+	if slices.Contains(r.blockers[id], blockerID) {
+		return *todo, false, nil
+	}
+	r.blockers[id] = append(r.blockers[id], blockerID)
+	r.bump(todo)
+	todo.Blocked = r.blocked(id)
+	return *todo, true, nil
+}
+
+// blocked reports whether any blocker of id is neither done nor trashed.
+func (r *memoryRepository) blocked(id int) bool {
+	return slices.ContainsFunc(r.blockers[id], func(blockerID int) bool {
+		blocker := r.todos[blockerID]
+		return !blocker.Done && blocker.DeletedAt == nil
+	})
+}
+
+// refreshBlocked updates blocked on the todos that depend on blockerID,
+// after it was completed, reopened, trashed or restored.
+func (r *memoryRepository) refreshBlocked(blockerID int) {
+	for id := 1; id < r.nextID; id++ {
+		todo, ok := r.todos[id]
+		if ok && slices.Contains(r.blockers[id], blockerID) && todo.Blocked != r.blocked(id) {
+			todo.Blocked = !todo.Blocked
+			r.bump(todo)
+		}
+	}
+}
+
+// GetUnblocked finds the todos unblocked after blocker by their revision,
+// like the repository.
+func (r *memoryRepository) GetUnblocked(blocker Todo) ([]Todo, error) {
+	todos := make([]Todo, 0)
+	if !blocker.Done {
+		return todos, nil
+	}
+	for id := 1; id < r.nextID; id++ {
+		todo, ok := r.todos[id]
+		if ok && slices.Contains(r.blockers[id], blocker.ID) && !todo.Blocked && !todo.Done &&
+			todo.DeletedAt == nil && todo.Revision > blocker.Revision {
+			todos = append(todos, *todo)
+		}
+	}
+	return todos, nil
+}
+
+func (r *memoryRepository) AddFeed(feed CalendarFeed, tokenHash string) (CalendarFeed, error) {
+	feed.ID = len(r.feeds) + 1
+	r.feeds[tokenHash] = feed
+	return feed, nil
+}
+
+func (r *memoryRepository) GetFeedByToken(tokenHash string) (CalendarFeed, error) {
+	feed, ok := r.feeds[tokenHash]
+	if !ok {
+		return CalendarFeed{}, ErrFeedNotFound
+	}
+	return feed, nil
+}
+
+// GetFeedTodos returns the todos in ID order rather than by due date.
+func (r *memoryRepository) GetFeedTodos(listID *int) ([]Todo, error) {
+	todos := make([]Todo, 0)
+	for id := 1; id < r.nextID; id++ {
+		todo, ok := r.todos[id]
+		if ok && todo.DueAt != nil && todo.DeletedAt == nil && (listID == nil || todo.ListID == *listID) {
+			todos = append(todos, *todo)
+		}
+	}
+	return todos, nil
+}
+
+func (r *memoryRepository) LatestRevision() (int64, error) {
+	return r.revision, nil
+}
+
+func (r *memoryRepository) LastModified() (time.Time, error) {
+	return r.modified, nil
+}
+
+func (r *memoryRepository) GetStatusChange(Todo) (*StatusChange, error) {
+	return nil, nil
+}
+
+// newTestController returns a controller on repo with the default config,
+// and a stream client that receives the events it publishes.
+func newTestController(t *testing.T, repo TodoRepository) (*TodosController, *streamClient) {
+	t.Helper()
+	cfg := defaultConfig()
+	hub := NewStreamHub(10, 100)
+	client, _ := hub.Subscribe(0)
+	t.Cleanup(func() { hub.Unsubscribe(client) })
+	return NewTodosController(repo, &cfg, NewEventBus("", hub)), client
+}
+
+// publishedEvents returns the events client has received as "type id",
+// or only the type for events without a todo ID.
+func publishedEvents(client *streamClient) []string {
+	var events []string
+	for {
+		select {
+		case event := <-client.events:
+			var todo struct {
+				ID int `json:"id"`
+			}
+			if json.Unmarshal(event.Data, &todo) == nil && todo.ID != 0 {
+				events = append(events, fmt.Sprintf("%s %d", event.Type, todo.ID))
+			} else {
+				events = append(events, event.Type)
+			}
+		default:
+			return events
+		}
+	}
+}
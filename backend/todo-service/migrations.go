@@ -0,0 +1,337 @@
+package main
+
+import (
+	"fmt"
+
+	"github.com/jmoiron/sqlx"
+)
+
+// migrations run in order on every start, so each statement must be
+// idempotent. Append new statements; never edit ones that have shipped.
+var migrations = []string{
+	`CREATE TABLE IF NOT EXISTS todos (
+		id SERIAL PRIMARY KEY,
+		task TEXT NOT NULL,
+		done BOOLEAN NOT NULL DEFAULT FALSE,
+		created_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP,
+		updated_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP
+	)`,
+
+	// Every insert, update and delete takes a new value from one sequence,
+	// which gives sync clients a single monotonically increasing cursor.
+	`CREATE SEQUENCE IF NOT EXISTS todo_revision_seq`,
+	`ALTER TABLE todos ADD COLUMN IF NOT EXISTS revision BIGINT NOT NULL DEFAULT nextval('todo_revision_seq')`,
+	`CREATE INDEX IF NOT EXISTS todos_revision_idx ON todos (revision)`,
+	`CREATE TABLE IF NOT EXISTS todo_tombstones (
+		todo_id INTEGER PRIMARY KEY,
+		revision BIGINT NOT NULL,
+		deleted_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT CURRENT_TIMESTAMP
+	)`,
+	`CREATE INDEX IF NOT EXISTS todo_tombstones_revision_idx ON todo_tombstones (revision)`,
+
+	// The advisory lock is held until commit, so revisions become visible in
+	// the order they were taken and a reader can never skip past a revision
+	// that an open transaction is about to commit.
+	`CREATE OR REPLACE FUNCTION todo_next_revision() RETURNS BIGINT AS $$
+	BEGIN
+		PERFORM pg_advisory_xact_lock(hashtext('todo_revision_seq'));
+		RETURN nextval('todo_revision_seq');
+	END
+	$$ LANGUAGE plpgsql`,
+	`CREATE OR REPLACE FUNCTION todos_bump_revision() RETURNS TRIGGER AS $$
+	BEGIN
+		NEW.revision := todo_next_revision();
+		IF TG_OP = 'UPDATE' THEN
+			NEW.updated_at := CURRENT_TIMESTAMP;
+		END IF;
+		RETURN NEW;
+	END
+	$$ LANGUAGE plpgsql`,
+	`CREATE OR REPLACE TRIGGER todos_bump_revision BEFORE INSERT OR UPDATE ON todos
+		FOR EACH ROW EXECUTE FUNCTION todos_bump_revision()`,
+	`CREATE OR REPLACE FUNCTION todos_record_tombstone() RETURNS TRIGGER AS $$
+	BEGIN
+		INSERT INTO todo_tombstones (todo_id, revision) VALUES (OLD.id, todo_next_revision())
+		ON CONFLICT (todo_id) DO UPDATE SET revision = EXCLUDED.revision, deleted_at = CURRENT_TIMESTAMP;
+		RETURN OLD;
+	END
+	$$ LANGUAGE plpgsql`,
+	`CREATE OR REPLACE TRIGGER todos_record_tombstone AFTER DELETE ON todos
+		FOR EACH ROW EXECUTE FUNCTION todos_record_tombstone()`,
+
+	// version counts the edits of a single todo and backs its ETag.
+	`ALTER TABLE todos ADD COLUMN IF NOT EXISTS version BIGINT NOT NULL DEFAULT 1`,
+	`CREATE OR REPLACE FUNCTION todos_bump_revision() RETURNS TRIGGER AS $$
+	BEGIN
+		NEW.revision := todo_next_revision();
+		IF TG_OP = 'UPDATE' THEN
+			NEW.updated_at := CURRENT_TIMESTAMP;
+			NEW.version := OLD.version + 1;
+		END IF;
+		RETURN NEW;
+	END
+	$$ LANGUAGE plpgsql`,
+
+	// Idempotency-Key records for POST requests, see idempotency.go.
+	`CREATE TABLE IF NOT EXISTS idempotency_keys (
+		scope TEXT NOT NULL,
+		key TEXT NOT NULL,
+		request_hash TEXT NOT NULL,
+		status_code INTEGER,
+		headers JSONB,
+		response_body BYTEA,
+		created_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT CURRENT_TIMESTAMP,
+		expires_at TIMESTAMP WITH TIME ZONE NOT NULL,
+		PRIMARY KEY (scope, key)
+	)`,
+	`CREATE INDEX IF NOT EXISTS idempotency_keys_expires_at_idx ON idempotency_keys (expires_at)`,
+
+	// Deleting moves a todo to the trash; it is removed for good, leaving a
+	// tombstone, once it has been there longer than the retention period.
+	`ALTER TABLE todos ADD COLUMN IF NOT EXISTS deleted_at TIMESTAMP WITH TIME ZONE`,
+	`CREATE INDEX IF NOT EXISTS todos_deleted_at_idx ON todos (deleted_at) WHERE deleted_at IS NOT NULL`,
+
+	// todo_events is the audit log. It has no foreign key so that history
+	// outlives purged todos, and rejects updates and deletes.
+	`CREATE TABLE IF NOT EXISTS todo_events (
+		id BIGSERIAL PRIMARY KEY,
+		todo_id INTEGER NOT NULL,
+		action TEXT NOT NULL,
+		changes JSONB NOT NULL DEFAULT '{}',
+		actor TEXT NOT NULL DEFAULT '',
+		request_id TEXT NOT NULL DEFAULT '',
+		source TEXT NOT NULL DEFAULT '',
+		created_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT CURRENT_TIMESTAMP
+	)`,
+	`CREATE INDEX IF NOT EXISTS todo_events_todo_id_idx ON todo_events (todo_id, id)`,
+	`CREATE INDEX IF NOT EXISTS todo_events_created_at_idx ON todo_events (created_at)`,
+	`CREATE INDEX IF NOT EXISTS todo_events_actor_idx ON todo_events (actor, id)`,
+	`CREATE OR REPLACE FUNCTION todo_events_append_only() RETURNS TRIGGER AS $$
+	BEGIN
+		RAISE EXCEPTION 'todo_events is append-only';
+	END
+	$$ LANGUAGE plpgsql`,
+	`CREATE OR REPLACE TRIGGER todo_events_append_only BEFORE UPDATE OR DELETE ON todo_events
+		FOR EACH ROW EXECUTE FUNCTION todo_events_append_only()`,
+	`CREATE OR REPLACE TRIGGER todo_events_no_truncate BEFORE TRUNCATE ON todo_events
+		FOR EACH STATEMENT EXECUTE FUNCTION todo_events_append_only()`,
+
+	// version ties an event to the todo state it produced, which is what an
+	// undo token points at.
+	`ALTER TABLE todo_events ADD COLUMN IF NOT EXISTS version BIGINT NOT NULL DEFAULT 0`,
+	`CREATE INDEX IF NOT EXISTS todo_events_version_idx ON todo_events (todo_id, version)`,
+	`CREATE TABLE IF NOT EXISTS todo_undo_tokens (
+		token TEXT PRIMARY KEY,
+		todo_id INTEGER NOT NULL,
+		version BIGINT NOT NULL,
+		expires_at TIMESTAMP WITH TIME ZONE NOT NULL,
+		used_at TIMESTAMP WITH TIME ZONE
+	)`,
+	`CREATE INDEX IF NOT EXISTS todo_undo_tokens_expires_at_idx ON todo_undo_tokens (expires_at)`,
+
+	// Subtasks. Purging a parent purges its subtree through the cascade,
+	// which also leaves a tombstone for every removed row.
+	`ALTER TABLE todos ADD COLUMN IF NOT EXISTS parent_id INTEGER REFERENCES todos (id) ON DELETE CASCADE`,
+	`ALTER TABLE todos ADD COLUMN IF NOT EXISTS auto_complete BOOLEAN NOT NULL DEFAULT FALSE`,
+	`CREATE INDEX IF NOT EXISTS todos_parent_id_idx ON todos (parent_id)`,
+
+	// Dependencies. blocked is kept up to date by triggers, so it changes
+	// a todo's revision and version like any other field: a todo is
+	// blocked while any of its blockers is neither done nor in the trash.
+	`CREATE TABLE IF NOT EXISTS todo_dependencies (
+		todo_id INTEGER NOT NULL REFERENCES todos (id) ON DELETE CASCADE,
+		blocker_id INTEGER NOT NULL REFERENCES todos (id) ON DELETE CASCADE,
+		created_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT CURRENT_TIMESTAMP,
+		PRIMARY KEY (todo_id, blocker_id),
+		CHECK (todo_id <> blocker_id)
+	)`,
+	`CREATE INDEX IF NOT EXISTS todo_dependencies_blocker_id_idx ON todo_dependencies (blocker_id)`,
+	`ALTER TABLE todos ADD COLUMN IF NOT EXISTS blocked BOOLEAN NOT NULL DEFAULT FALSE`,
+	`CREATE OR REPLACE FUNCTION todos_refresh_blocked(ids INTEGER[]) RETURNS VOID AS $$
+		UPDATE todos t SET blocked = s.blocked
+		FROM (
+			SELECT x.id, EXISTS (
+				SELECT 1 FROM todo_dependencies d JOIN todos b ON b.id = d.blocker_id
+				WHERE d.todo_id = x.id AND NOT b.done AND b.deleted_at IS NULL
+			) AS blocked
+			FROM unnest(ids) AS x (id)
+		) s
+		WHERE t.id = s.id AND t.blocked <> s.blocked
+	$$ LANGUAGE sql`,
+	`CREATE OR REPLACE FUNCTION todos_blocker_changed() RETURNS TRIGGER AS $$
+	BEGIN
+		PERFORM todos_refresh_blocked(ARRAY(SELECT todo_id FROM todo_dependencies WHERE blocker_id = NEW.id));
+		RETURN NULL;
+	END
+	$$ LANGUAGE plpgsql`,
+	`CREATE OR REPLACE TRIGGER todos_blocker_changed AFTER UPDATE OF done, deleted_at ON todos
+		FOR EACH ROW WHEN (OLD.done IS DISTINCT FROM NEW.done OR OLD.deleted_at IS DISTINCT FROM NEW.deleted_at)
+		EXECUTE FUNCTION todos_blocker_changed()`,
+	`CREATE OR REPLACE FUNCTION todo_dependencies_changed() RETURNS TRIGGER AS $$
+	BEGIN
+		IF TG_OP = 'DELETE' THEN
+			PERFORM todos_refresh_blocked(ARRAY[OLD.todo_id]);
+		ELSE
+			PERFORM todos_refresh_blocked(ARRAY[NEW.todo_id]);
+		END IF;
+		RETURN NULL;
+	END
+	$$ LANGUAGE plpgsql`,
+	`CREATE OR REPLACE TRIGGER todo_dependencies_changed AFTER INSERT OR DELETE ON todo_dependencies
+		FOR EACH ROW EXECUTE FUNCTION todo_dependencies_changed()`,
+
+	// Recurring todos. Completing an occurrence inserts the next one with
+	// previous_id pointing back, and the unique index makes that happen at
+	// most once however often the occurrence is completed.
+	`ALTER TABLE todos ADD COLUMN IF NOT EXISTS due_at TIMESTAMP WITH TIME ZONE`,
+	`ALTER TABLE todos ADD COLUMN IF NOT EXISTS recurrence TEXT NOT NULL DEFAULT ''`,
+	`ALTER TABLE todos ADD COLUMN IF NOT EXISTS series_id INTEGER`,
+	`ALTER TABLE todos ADD COLUMN IF NOT EXISTS previous_id INTEGER REFERENCES todos (id) ON DELETE SET NULL`,
+	`CREATE INDEX IF NOT EXISTS todos_series_id_idx ON todos (series_id) WHERE series_id IS NOT NULL`,
+	`CREATE UNIQUE INDEX IF NOT EXISTS todos_previous_id_idx ON todos (previous_id)`,
+
+	// Ordering. priority runs from 0 (P0, most urgent) to 3. position is a
+	// fractional index key, see position.go; the C collation makes it sort
+	// byte by byte. Existing todos keep their ID order, as the integer with
+	// six digits equal to their ID.
+	`ALTER TABLE todos ADD COLUMN IF NOT EXISTS priority SMALLINT NOT NULL DEFAULT 2
+		CHECK (priority BETWEEN 0 AND 3)`,
+	`ALTER TABLE todos ADD COLUMN IF NOT EXISTS position TEXT COLLATE "C"`,
+	`UPDATE todos SET position = 'f' || (
+		SELECT string_agg(substr('0123456789ABCDEFGHIJKLMNOPQRSTUVWXYZabcdefghijklmnopqrstuvwxyz',
+			((id / (62 ^ (5 - i))::bigint) % 62)::integer + 1, 1), '' ORDER BY i)
+		FROM generate_series(0, 5) AS i
+	) WHERE position IS NULL`,
+	`ALTER TABLE todos ALTER COLUMN position SET NOT NULL`,
+	`CREATE INDEX IF NOT EXISTS todos_order_idx ON todos (priority, position, id) WHERE deleted_at IS NULL`,
+
+	// Lists and their workflows. A list's statuses are the columns of its
+	// board, in position order; transitions names the statuses a todo may
+	// move to from each one. Every todo starts in the default list, whose
+	// workflow is only seeded once so that edits to it survive restarts.
+	`CREATE TABLE IF NOT EXISTS lists (
+		id SERIAL PRIMARY KEY,
+		name TEXT NOT NULL,
+		created_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT CURRENT_TIMESTAMP
+	)`,
+	`CREATE TABLE IF NOT EXISTS list_statuses (
+		list_id INTEGER NOT NULL REFERENCES lists (id) ON DELETE CASCADE,
+		key TEXT NOT NULL,
+		name TEXT NOT NULL,
+		position INTEGER NOT NULL,
+		done BOOLEAN NOT NULL DEFAULT FALSE,
+		wip_limit INTEGER CHECK (wip_limit > 0),
+		transitions TEXT[] NOT NULL DEFAULT '{}',
+		PRIMARY KEY (list_id, key)
+	)`,
+	`INSERT INTO lists (name) SELECT 'Todos' WHERE NOT EXISTS (SELECT 1 FROM lists)`,
+	`INSERT INTO list_statuses (list_id, key, name, position, done, transitions)
+		SELECT 1, key, name, position, done, transitions FROM (VALUES
+			('backlog', 'Backlog', 0, FALSE, '{in_progress,done}'::text[]),
+			('in_progress', 'In progress', 1, FALSE, '{backlog,review,done}'::text[]),
+			('review', 'Review', 2, FALSE, '{in_progress,done}'::text[]),
+			('done', 'Done', 3, TRUE, '{backlog,in_progress}'::text[])
+		) AS defaults (key, name, position, done, transitions)
+		WHERE NOT EXISTS (SELECT 1 FROM list_statuses WHERE list_id = 1)`,
+	`ALTER TABLE todos ADD COLUMN IF NOT EXISTS list_id INTEGER NOT NULL DEFAULT 1 REFERENCES lists (id)`,
+	`ALTER TABLE todos ADD COLUMN IF NOT EXISTS status TEXT`,
+	`UPDATE todos SET status = CASE WHEN done THEN 'done' ELSE 'backlog' END WHERE status IS NULL`,
+	`ALTER TABLE todos ALTER COLUMN status SET NOT NULL`,
+	`CREATE INDEX IF NOT EXISTS todos_list_status_idx ON todos (list_id, status) WHERE deleted_at IS NULL`,
+
+	// status is the source of truth and done follows it. Writes that only
+	// set done, from clients that predate workflows, move the todo to the
+	// first done or first open status of its list.
+	`CREATE OR REPLACE FUNCTION todos_sync_status() RETURNS TRIGGER AS $$
+	BEGIN
+		IF NEW.status IS NOT NULL AND (TG_OP = 'INSERT' OR NEW.status IS DISTINCT FROM OLD.status) THEN
+			NEW.done := (SELECT done FROM list_statuses WHERE list_id = NEW.list_id AND key = NEW.status);
+		ELSIF NEW.status IS NULL
+			OR NEW.done IS DISTINCT FROM (SELECT done FROM list_statuses WHERE list_id = NEW.list_id AND key = NEW.status) THEN
+			NEW.status := (
+				SELECT key FROM list_statuses WHERE list_id = NEW.list_id AND done = NEW.done
+				ORDER BY position LIMIT 1
+			);
+		END IF;
+		RETURN NEW;
+	END
+	$$ LANGUAGE plpgsql`,
+	`CREATE OR REPLACE TRIGGER todos_sync_status BEFORE INSERT OR UPDATE ON todos
+		FOR EACH ROW EXECUTE FUNCTION todos_sync_status()`,
+
+	// Tags and full-text search. The search vector is generated from the
+	// task with the english configuration, which searchrepository.go must
+	// query with as well.
+	`ALTER TABLE todos ADD COLUMN IF NOT EXISTS tags TEXT[] NOT NULL DEFAULT '{}'`,
+	`CREATE INDEX IF NOT EXISTS todos_tags_idx ON todos USING GIN (tags)`,
+	`ALTER TABLE todos ADD COLUMN IF NOT EXISTS search_vector TSVECTOR
+		GENERATED ALWAYS AS (to_tsvector('english', task)) STORED`,
+	`CREATE INDEX IF NOT EXISTS todos_search_idx ON todos USING GIN (search_vector)`,
+
+	// Templates keep their todos as a JSON tree, see TemplateItem; they are
+	// only turned into rows when instantiated.
+	`CREATE TABLE IF NOT EXISTS templates (
+		id SERIAL PRIMARY KEY,
+		name TEXT NOT NULL,
+		items JSONB NOT NULL,
+		created_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT CURRENT_TIMESTAMP,
+		updated_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT CURRENT_TIMESTAMP
+	)`,
+
+	// Calendar feeds are looked up by the SHA-256 of their token, so the
+	// tokens themselves are only ever known to whoever created the feed.
+	`CREATE TABLE IF NOT EXISTS calendar_feeds (
+		id SERIAL PRIMARY KEY,
+		name TEXT NOT NULL,
+		token_hash TEXT NOT NULL UNIQUE,
+		list_id INTEGER REFERENCES lists (id) ON DELETE CASCADE,
+		owner TEXT NOT NULL DEFAULT '',
+		components TEXT[] NOT NULL,
+		created_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT CURRENT_TIMESTAMP
+	)`,
+
+	// client_id is the ID an offline client gave a todo it created, so a
+	// sync that is retried after the response was lost finds the todo
+	// instead of creating it twice.
+	`ALTER TABLE todos ADD COLUMN IF NOT EXISTS client_id TEXT`,
+	`CREATE UNIQUE INDEX IF NOT EXISTS todos_client_id_idx ON todos (client_id)`,
+
+	// An undo token reverts one or more todos, each at the version the
+	// request left it at; created marks todos the request created, which
+	// undo trashes whatever else the request did to them. Tokens issued
+	// before kept their single todo on the token row.
+	`CREATE TABLE IF NOT EXISTS todo_undo_targets (
+		token TEXT NOT NULL REFERENCES todo_undo_tokens (token) ON DELETE CASCADE,
+		position INTEGER NOT NULL,
+		todo_id INTEGER NOT NULL,
+		version BIGINT NOT NULL,
+		created BOOLEAN NOT NULL DEFAULT FALSE,
+		PRIMARY KEY (token, position)
+	)`,
+	`ALTER TABLE todo_undo_tokens ADD COLUMN IF NOT EXISTS batch BOOLEAN NOT NULL DEFAULT FALSE`,
+	`ALTER TABLE todo_undo_tokens ALTER COLUMN todo_id DROP NOT NULL`,
+	`ALTER TABLE todo_undo_tokens ALTER COLUMN version DROP NOT NULL`,
+	`INSERT INTO todo_undo_targets (token, position, todo_id, version)
+		SELECT token, 0, todo_id, version FROM todo_undo_tokens WHERE todo_id IS NOT NULL
+		ON CONFLICT DO NOTHING`,
+}
+
+// migrate applies all migrations in one transaction. The advisory lock keeps
+// replicas that start at the same time from running them concurrently.
+func migrate(db *sqlx.DB) error {
+	tx, err := db.Beginx()
+	if err != nil {
+		return err
+	}
+	defer func() { _ = tx.Rollback() }()
+
+	if _, err := tx.Exec(`SELECT pg_advisory_xact_lock(hashtext('todo_migrations'))`); err != nil {
+		return fmt.Errorf("failed to lock migrations: %w", err)
+	}
+	for i, statement := range migrations {
+		if _, err := tx.Exec(statement); err != nil {
+			return fmt.Errorf("migration %d failed: %w", i, err)
+		}
+	}
+	return tx.Commit()
+}
@@ -0,0 +1,67 @@
+package main
+
+import (
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/rs/zerolog/log"
+)
+
+type moveRequest struct {
+	Before *int `json:"before"`
+	After  *int `json:"after"`
+}
+
+// moveTodo places a todo directly before or after another. The moved todo
+// takes the target's priority, so dragging across priority groups changes
+// the priority too.
+func (c *TodosController) moveTodo(ctx *gin.Context) {
+	id, ok := todoIDParam(ctx)
+	if !ok {
+		return
+	}
+
+	var request moveRequest
+	if err := ctx.ShouldBindJSON(&request); err != nil {
+		log.Error().Err(err).Msg("Failed to parse request body")
+		respondError(ctx, errInvalidBody(err))
+		return
+	}
+	if (request.Before == nil) == (request.After == nil) {
+		respondError(ctx, errInvalidField("before", "Provide exactly one of before and after"))
+		return
+	}
+	field, targetID := "before", request.Before
+	if request.After != nil {
+		field, targetID = "after", request.After
+	}
+	if *targetID == id {
+		respondError(ctx, errInvalidField(field, "A todo cannot be moved next to itself"))
+		return
+	}
+
+	pre, apiErr := c.ifMatchPrecondition(ctx, id)
+	if apiErr != nil {
+		respondError(ctx, apiErr)
+		return
+	}
+
+	todo, err := c.repoFor(ctx).MoveTodo(id, pre, *targetID, request.After != nil)
+	if err != nil {
+		c.respondTodoError(ctx, id, err, "move todo")
+		return
+	}
+
+	log.Info().
+		Str("path", ctx.FullPath()).
+		Int("id", id).
+		Str("side", field).
+		Int("target_id", *targetID).
+		Int("priority", todo.Priority).
+		Str("position", todo.Position).
+		Msg("Todo moved")
+
+	c.sendNatsMessage("todo.updated", todo)
+
+	c.respondWithUndo(ctx, http.StatusOK, todo, nil)
+}
@@ -0,0 +1,22 @@
+package main
+
+import (
+	_ "embed"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+)
+
+//go:embed openapi.json
+var openAPISpec []byte
+
+//go:embed docs.html
+var docsPage []byte
+
+func serveOpenAPISpec(ctx *gin.Context) {
+	ctx.Data(http.StatusOK, "application/json", openAPISpec)
+}
+
+func serveDocs(ctx *gin.Context) {
+	ctx.Data(http.StatusOK, "text/html; charset=utf-8", docsPage)
+}
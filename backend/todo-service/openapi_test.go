@@ -0,0 +1,115 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+var ginParamPattern = regexp.MustCompile(`[:*]([A-Za-z0-9_]+)`)
+
+// checkOpenAPICoverage returns an error naming every registered route that
+// has no matching operation in the OpenAPI document.
+func checkOpenAPICoverage(routes gin.RoutesInfo, spec []byte) error {
+	var doc struct {
+		Paths      map[string]map[string]json.RawMessage `json:"paths"`
+		Components struct {
+			PathItems map[string]map[string]json.RawMessage `json:"pathItems"`
+		} `json:"components"`
+	}
+	if err := json.Unmarshal(spec, &doc); err != nil {
+		return fmt.Errorf("failed to parse OpenAPI spec: %w", err)
+	}
+
+	var missing []string
+	for _, route := range routes {
+		path := ginParamPattern.ReplaceAllString(route.Path, "{$1}")
+		item, ok := doc.Paths[path]
+		if ref, isRef := item["$ref"]; isRef {
+			var target string
+			_ = json.Unmarshal(ref, &target)
+			item, ok = doc.Components.PathItems[strings.TrimPrefix(target, "#/components/pathItems/")]
+		}
+		if _, hasOp := item[strings.ToLower(route.Method)]; !ok || !hasOp {
+			missing = append(missing, route.Method+" "+route.Path)
+		}
+	}
+
+	if len(missing) > 0 {
+		return fmt.Errorf("routes missing from openapi.json: %s", strings.Join(missing, ", "))
+	}
+	return nil
+}
+
+func testRouter(cfg *Config, repo TodoRepository) *gin.Engine {
+	gin.SetMode(gin.TestMode)
+	hub := NewStreamHub(10, 10)
+	controller := NewTodosController(repo, cfg, NewEventBus("", hub))
+	return newRouter(cfg, todoRoutes{
+		controller: controller,
+		streams:    NewStreamController(hub, time.Second, nil),
+		limiter:    NewRateLimiter(NewMemoryRateLimitStore(time.Minute), false),
+		limits:     cfg.RateLimit,
+		idempotent: func(*gin.Context) {},
+	})
+}
+
+// TestOpenAPICoverage fails when a route is added without documenting it
+// in openapi.json.
+func TestOpenAPICoverage(t *testing.T) {
+	cfg := defaultConfig()
+	cfg.Port = "8080"
+	router := testRouter(&cfg, nil)
+	if err := checkOpenAPICoverage(router.Routes(), openAPISpec); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestOpenAPICoverageReportsMissingRoutes(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+	router.GET("/undocumented/:id", func(*gin.Context) {})
+
+	err := checkOpenAPICoverage(router.Routes(), openAPISpec)
+	if err == nil || !strings.Contains(err.Error(), "GET /undocumented/:id") {
+		t.Fatalf("checkOpenAPICoverage = %v, want the undocumented route named", err)
+	}
+}
+
+// TestWelcomeListsRoutes fails when the welcome page advertises a path
+// that is not registered.
+func TestWelcomeListsRoutes(t *testing.T) {
+	cfg := defaultConfig()
+	router := testRouter(&cfg, nil)
+	registered := make(map[string]bool)
+	for _, route := range router.Routes() {
+		registered[route.Method+" "+route.Path] = true
+	}
+
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/", nil))
+	var body struct {
+		Endpoints []string
+	}
+	if err := json.Unmarshal(w.Body.Bytes(), &body); err != nil {
+		t.Fatal(err)
+	}
+	for _, endpoint := range body.Endpoints {
+		route, _, _ := strings.Cut(endpoint, " - ")
+		route, _, _ = strings.Cut(route, "?")
+		if strings.HasPrefix(route, "/") {
+			// A note on a group of routes rather than a route.
+			continue
+		}
+		if !registered[route] {
+			t.Errorf("welcome lists %q, which is not a route", route)
+		}
+	}
+}
@@ -0,0 +1,186 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Positions are fractional index keys compared byte by byte: an integer
+// part followed by a fraction, both in base-62 digits. The head of the
+// integer part gives its length, a to z for 1 to 26 digits after it and Z
+// down to A for the negative ones, so appending or prepending steps the
+// integer and keys grow with the logarithm of the list's length. There is
+// always a key between two others, found in the fraction, so moving a todo
+// rewrites only its own position. Fractions never end in the zero digit,
+// which keeps room below every key.
+const positionDigits = "0123456789ABCDEFGHIJKLMNOPQRSTUVWXYZabcdefghijklmnopqrstuvwxyz"
+
+// firstPosition is the key of a todo added to an empty list, integer zero.
+const firstPosition = "a0"
+
+// positionBetween returns a key that sorts after a and before b. An empty a
+// means the start of the list and an empty b its end.
+func positionBetween(a, b string) (string, error) {
+	for _, key := range []string{a, b} {
+		if key == "" {
+			continue
+		}
+		if err := checkPosition(key); err != nil {
+			return "", err
+		}
+	}
+	if a != "" && b != "" && a >= b {
+		return "", fmt.Errorf("position %q does not sort before %q", a, b)
+	}
+
+	switch {
+	case a == "" && b == "":
+		return firstPosition, nil
+	case a == "":
+		ib := integerPart(b)
+		if len(ib) < len(b) {
+			// b has a fraction, so its integer alone sorts before it.
+			return ib, nil
+		}
+		if prev, ok := stepInteger(ib, -1); ok && prev != smallestPosition {
+			return prev, nil
+		}
+		return "", fmt.Errorf("no position sorts before %q", b)
+	case b == "":
+		ia := integerPart(a)
+		if next, ok := stepInteger(ia, 1); ok {
+			return next, nil
+		}
+		return ia + midpoint(a[len(ia):], ""), nil
+	}
+
+	ia, ib := integerPart(a), integerPart(b)
+	if ia == ib {
+		return ia + midpoint(a[len(ia):], b[len(ib):]), nil
+	}
+	// The integer after a's is free unless it is b's own.
+	if next, ok := stepInteger(ia, 1); ok && next < b {
+		return next, nil
+	}
+	return ia + midpoint(a[len(ia):], ""), nil
+}
+
+// smallestPosition is the smallest integer, which nothing could sort
+// before.
+var smallestPosition = "A" + strings.Repeat(positionDigits[:1], 26)
+
+// checkPosition reports whether key is well formed: a head, as many
+// integer digits as it calls for and a fraction without a trailing zero.
+func checkPosition(key string) error {
+	if key == "" || integerLength(key[0]) == 0 || integerLength(key[0]) > len(key) || key == smallestPosition {
+		return fmt.Errorf("position %q has no valid integer part", key)
+	}
+	for i := 1; i < len(key); i++ {
+		if strings.IndexByte(positionDigits, key[i]) < 0 {
+			return fmt.Errorf("position %q has an invalid digit", key)
+		}
+	}
+	if len(key) > integerLength(key[0]) && strings.HasSuffix(key, "0") {
+		return fmt.Errorf("position %q ends in a zero digit", key)
+	}
+	return nil
+}
+
+// integerLength returns the length of an integer part with head, counting
+// the head, or zero if head is not one.
+func integerLength(head byte) int {
+	switch {
+	case head >= 'a' && head <= 'z':
+		return int(head-'a') + 2
+	case head >= 'A' && head <= 'Z':
+		return int('Z'-head) + 2
+	}
+	return 0
+}
+
+// integerPart returns the integer part of a well-formed key.
+func integerPart(key string) string {
+	return key[:integerLength(key[0])]
+}
+
+// stepInteger returns the integer part after, for step 1, or before, for
+// -1, the integer part x. Running out of digits moves to the next head,
+// which has one more digit the further it is from zero. It fails past the
+// largest or smallest integer.
+func stepInteger(x string, step int) (string, bool) {
+	digits := []byte(x[1:])
+	for i := len(digits) - 1; i >= 0; i-- {
+		d := strings.IndexByte(positionDigits, digits[i]) + step
+		if d >= 0 && d < len(positionDigits) {
+			digits[i] = positionDigits[d]
+			return x[:1] + string(digits), true
+		}
+		// Carry or borrow into the next digit.
+		digits[i] = positionDigits[(d+len(positionDigits))%len(positionDigits)]
+	}
+
+	head := x[0]
+	switch {
+	case step > 0 && head == 'Z':
+		return "a" + positionDigits[:1], true
+	case step < 0 && head == 'a':
+		return "Z" + positionDigits[len(positionDigits)-1:], true
+	case step > 0 && head == 'z', step < 0 && head == 'A':
+		return "", false
+	}
+	next := head + byte(step)
+	if integerLength(next) > integerLength(head) {
+		// Past the largest integer of its length the next one starts
+		// from the lowest digits; before the smallest, from the highest.
+		digits = append(digits, digits[0])
+	} else {
+		digits = digits[1:]
+	}
+	return string(next) + string(digits), true
+}
+
+// midpoint returns a fraction between the fractions a and b, where an
+// empty b is one past the end.
+func midpoint(a, b string) string {
+	if b != "" {
+		// Keep the prefix a and b share, reading a missing digit of a as
+		// zero, and find the midpoint of what follows.
+		n := 0
+		for n < len(b) && digitAt(a, n) == strings.IndexByte(positionDigits, b[n]) {
+			n++
+		}
+		if n > 0 {
+			return b[:n] + midpoint(tail(a, n), b[n:])
+		}
+	}
+
+	low := digitAt(a, 0)
+	high := len(positionDigits)
+	if b != "" {
+		high = strings.IndexByte(positionDigits, b[0])
+	}
+	if high-low > 1 {
+		return string(positionDigits[(low+high+1)/2])
+	}
+	// The first digits are adjacent. A longer b can be cut to its first
+	// digit, which still sorts after a; otherwise keep a's first digit and
+	// go one level deeper.
+	if len(b) > 1 {
+		return b[:1]
+	}
+	return string(positionDigits[low]) + midpoint(tail(a, 1), "")
+}
+
+func digitAt(key string, i int) int {
+	if i >= len(key) {
+		return 0
+	}
+	return strings.IndexByte(positionDigits, key[i])
+}
+
+func tail(key string, n int) string {
+	if n >= len(key) {
+		return ""
+	}
+	return key[n:]
+}
@@ -0,0 +1,146 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestPositionBetween(t *testing.T) {
+	tests := []struct {
+		a, b string
+		want string
+	}{
+		{"", "", "a0"},
+		{"a0", "", "a1"},
+		{"az", "", "b00"},
+		{"Zz", "", "a0"},
+		{"", "a0", "Zz"},
+		{"", "b00", "az"},
+		{"", "a0V", "a0"},
+		{"a0", "a1", "a0V"},
+		{"a0", "a0V", "a0G"},
+		{"a0V", "a1", "a0l"},
+		{"a0", "a2", "a1"},
+		{"az", "b00", "azV"},
+		{"Zz", "a0", "ZzV"},
+		{"a0z", "a1", "a0zV"},
+		{"a1", "a1V", "a1G"},
+	}
+	for _, tt := range tests {
+		got, err := positionBetween(tt.a, tt.b)
+		if err != nil || got != tt.want {
+			t.Errorf("positionBetween(%q, %q) = %q, %v; want %q", tt.a, tt.b, got, err, tt.want)
+			continue
+		}
+		if got <= tt.a || tt.b != "" && got >= tt.b || checkPosition(got) != nil {
+			t.Errorf("positionBetween(%q, %q) = %q, which does not sort between them", tt.a, tt.b, got)
+		}
+	}
+}
+
+func TestPositionBetweenRejects(t *testing.T) {
+	tests := []struct{ a, b string }{
+		{"a1", "a0"},
+		{"a0", "a0"},
+		{"a0V0", ""},
+		{"", "a"},
+		{"b0", ""},
+		{"a-", ""},
+		{"0V", ""},
+		{"", smallestPosition},
+	}
+	for _, tt := range tests {
+		if got, err := positionBetween(tt.a, tt.b); err == nil {
+			t.Errorf("positionBetween(%q, %q) = %q, want an error", tt.a, tt.b, got)
+		}
+	}
+}
+
+func TestStepInteger(t *testing.T) {
+	largest := "z" + strings.Repeat("z", 26)
+	tests := []struct {
+		x          string
+		next, prev string
+	}{
+		{"a0", "a1", "Zz"},
+		{"az", "b00", "ay"},
+		{"b00", "b01", "az"},
+		{"bzz", "c000", "bzy"},
+		{"Zz", "a0", "Zy"},
+		{"Z0", "Z1", "Yzz"},
+		{"Yzz", "Z0", "Yzy"},
+		{largest, "", largest[:26] + "y"},
+		{smallestPosition, smallestPosition[:26] + "1", ""},
+	}
+	for _, tt := range tests {
+		if next, ok := stepInteger(tt.x, 1); next != tt.next || ok != (tt.next != "") {
+			t.Errorf("stepInteger(%q, 1) = %q, %v; want %q", tt.x, next, ok, tt.next)
+		}
+		if prev, ok := stepInteger(tt.x, -1); prev != tt.prev || ok != (tt.prev != "") {
+			t.Errorf("stepInteger(%q, -1) = %q, %v; want %q", tt.x, prev, ok, tt.prev)
+		}
+	}
+}
+
+// TestPositionGrowth checks how keys grow: at either end of the list with
+// the logarithm of its length, between two todos with the inserts.
+func TestPositionGrowth(t *testing.T) {
+	const n = 100000
+	tests := []struct {
+		name   string
+		next   func(first, last string) (string, string)
+		maxLen int
+	}{
+		{"append", func(_, last string) (string, string) { return last, "" }, 4},
+		{"prepend", func(first, _ string) (string, string) { return "", first }, 4},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			first, last := "", ""
+			for i := range n {
+				a, b := tt.next(first, last)
+				key, err := positionBetween(a, b)
+				if err != nil {
+					t.Fatalf("insert %d: %v", i, err)
+				}
+				if a != "" && key <= a || b != "" && key >= b {
+					t.Fatalf("insert %d: %q does not sort between %q and %q", i, key, a, b)
+				}
+				if len(key) > tt.maxLen {
+					t.Fatalf("insert %d: %q is longer than %d", i, key, tt.maxLen)
+				}
+				if first == "" || key < first {
+					first = key
+				}
+				if last == "" || key > last {
+					last = key
+				}
+			}
+		})
+	}
+
+	// Always inserting next to the same todo is the worst case between
+	// two: a digit every five inserts or so.
+	for _, after := range []bool{false, true} {
+		t.Run("between", func(t *testing.T) {
+			low, high := "a0", "a1"
+			for i := range 1000 {
+				key, err := positionBetween(low, high)
+				if err != nil {
+					t.Fatalf("insert %d: %v", i, err)
+				}
+				if key <= low || key >= high {
+					t.Fatalf("insert %d: %q does not sort between %q and %q", i, key, low, high)
+				}
+				if after {
+					low = key
+				} else {
+					high = key
+				}
+			}
+			if n := max(len(low), len(high)); n > 2+1000/4 {
+				t.Errorf("after 1000 inserts the key is %d long", n)
+			}
+		})
+	}
+}
@@ -0,0 +1,403 @@
+package main
+
+import (
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+)
+
+// QuickAdd is what parseQuickAdd recognised in a task typed into a single
+// input box. Task is the text that is left once the recognised words are
+// taken out.
+type QuickAdd struct {
+	Task string `json:"task"`
+	// Due holds the words that were read as DueAt, so a client can show
+	// them next to the date.
+	Due      string     `json:"due,omitempty"`
+	DueAt    *time.Time `json:"due_at,omitempty"`
+	Tags     []string   `json:"tags,omitempty"`
+	Priority *int       `json:"priority,omitempty"`
+	List     string     `json:"list,omitempty"`
+	ListID   *int       `json:"list_id,omitempty"`
+}
+
+// quickAddPriorities maps the words after '!' to priorities, P0 being the
+// most urgent.
+var quickAddPriorities = map[string]int{
+	"p0": 0, "urgent": 0,
+	"p1": 1, "high": 1,
+	"p2": 2, "medium": 2, "normal": 2,
+	"p3": 3, "low": 3,
+}
+
+var (
+	isoDatePattern        = regexp.MustCompile(`^(\d{4})-(\d{2})-(\d{2})$`)
+	dottedDatePattern     = regexp.MustCompile(`^(\d{1,2})\.(\d{1,2})\.(\d{4})?$`)
+	clockPattern          = regexp.MustCompile(`^(\d{1,2})(?::(\d{2}))?(am|pm)?$`)
+	dayOfMonthPattern     = regexp.MustCompile(`^(\d{1,2})(?:st|nd|rd|th)?$`)
+	yearPattern           = regexp.MustCompile(`^\d{4}$`)
+	relativeAmountPattern = regexp.MustCompile(`^\d{1,3}$`)
+)
+
+var monthNames = map[string]time.Month{
+	"jan": time.January, "january": time.January,
+	"feb": time.February, "february": time.February,
+	"mar": time.March, "march": time.March,
+	"apr": time.April, "april": time.April,
+	"may": time.May,
+	"jun": time.June, "june": time.June,
+	"jul": time.July, "july": time.July,
+	"aug": time.August, "august": time.August,
+	"sep": time.September, "sept": time.September, "september": time.September,
+	"oct": time.October, "october": time.October,
+	"nov": time.November, "november": time.November,
+	"dec": time.December, "december": time.December,
+}
+
+// weekdayNames has no abbreviations, which would take "sun" and "sat" out
+// of ordinary sentences.
+var weekdayNames = map[string]time.Weekday{
+	"sunday":    time.Sunday,
+	"monday":    time.Monday,
+	"tuesday":   time.Tuesday,
+	"wednesday": time.Wednesday,
+	"thursday":  time.Thursday,
+	"friday":    time.Friday,
+	"saturday":  time.Saturday,
+}
+
+// dueConnectors are dropped along with a due date that follows them, so
+// "pay rent by friday" leaves "pay rent". Dates with a month name need one,
+// since "march" and "may" are words too.
+var dueConnectors = []string{"due", "by", "on"}
+
+// parseQuickAdd takes due dates, tags, a priority and a list out of text:
+//
+//	#home            a tag; it needs a letter, so "#12" stays in the task
+//	!high            a priority: !urgent, !high, !medium, !low or !p0 to !p3
+//	@work            a list, matched by name ignoring case, with '-' or '_'
+//	                 standing for spaces; unknown lists stay in the task
+//	tomorrow 9am     a due date, see parseDue
+//
+// Only the first priority, list and due date are taken; later ones stay in
+// the task. Dates are read in now's location.
+func parseQuickAdd(text string, now time.Time, lists []List) QuickAdd {
+	var q QuickAdd
+	words := strings.Fields(text)
+	kept := make([]string, 0, len(words))
+	for i := 0; i < len(words); {
+		word := trimQuickAddWord(words[i])
+		switch {
+		case strings.HasPrefix(word, "#"):
+			if tag, ok := quickAddTag(word[1:]); ok {
+				if !slices.Contains(q.Tags, tag) {
+					q.Tags = append(q.Tags, tag)
+				}
+				i++
+				continue
+			}
+		case strings.HasPrefix(word, "!") && q.Priority == nil:
+			if priority, ok := quickAddPriorities[strings.ToLower(word[1:])]; ok {
+				q.Priority = &priority
+				i++
+				continue
+			}
+		case strings.HasPrefix(word, "@") && q.ListID == nil:
+			if list, ok := findQuickAddList(lists, word[1:]); ok {
+				q.List, q.ListID = list.Name, &list.ID
+				i++
+				continue
+			}
+		}
+		if q.DueAt == nil {
+			if due, n := parseDue(words[i:], now); n > 0 {
+				q.DueAt, q.Due = &due, strings.Join(words[i:i+n], " ")
+				i += n
+				continue
+			}
+		}
+		kept = append(kept, words[i])
+		i++
+	}
+	q.Task = strings.Join(kept, " ")
+	return q
+}
+
+// trimQuickAddWord drops punctuation that ends a clause, so "tomorrow," is
+// read as a date. Dots are kept for dates like "24.12.".
+func trimQuickAddWord(word string) string {
+	return strings.TrimRight(word, ",;:?!")
+}
+
+func quickAddTag(word string) (string, bool) {
+	tag := strings.ToLower(strings.TrimSuffix(word, "."))
+	if !tagPattern.MatchString(tag) || !strings.ContainsFunc(tag, unicode.IsLetter) {
+		return "", false
+	}
+	return tag, true
+}
+
+func findQuickAddList(lists []List, word string) (List, bool) {
+	key := quickAddListKey(word)
+	for _, list := range lists {
+		if quickAddListKey(list.Name) == key {
+			return list, true
+		}
+	}
+	return List{}, false
+}
+
+func quickAddListKey(name string) string {
+	return strings.NewReplacer(" ", "-", "_", "-").Replace(strings.ToLower(strings.TrimSpace(name)))
+}
+
+// parseDue reads a due date from the start of words and returns it with
+// the number of words it used, or 0 if words does not start with one. It
+// understands a date, a time, or both in either order:
+//
+//	today, tomorrow, friday, next friday, next week, next month
+//	in 3 days, in 2 weeks, in 1 month, in 2 hours, in 30 minutes
+//	2026-12-24, 24.12.2026, 24.12.
+//	on dec 24, by 24th december 2026, due may 3: after a connector
+//	9am, 9:30pm, 14:30, noon, optionally after "at"
+//
+// A date without a time is due at the end of the day, and a time without a
+// date the next time the clock shows it. Dates without a year are the next
+// such date, and a weekday is the next such day after today.
+func parseDue(words []string, now time.Time) (time.Time, int) {
+	start := 0
+	if len(words) > 1 && slices.Contains(dueConnectors, strings.ToLower(words[0])) {
+		start = 1
+	}
+
+	marked := start == 1
+	date, dateN, exact := parseDueDate(words[start:], now, marked)
+	if exact {
+		return date, start + dateN
+	}
+	clockAt := start + dateN
+	hour, minute, clockN := parseDueClock(words[clockAt:])
+	if dateN == 0 && clockN > 0 {
+		if date, dateN, exact = parseDueDate(words[clockAt+clockN:], now, marked); exact {
+			dateN = 0
+		}
+	}
+
+	switch {
+	case dateN == 0 && clockN == 0:
+		return time.Time{}, 0
+	case dateN == 0:
+		due := time.Date(now.Year(), now.Month(), now.Day(), hour, minute, 0, 0, now.Location())
+		if !due.After(now) {
+			due = due.AddDate(0, 0, 1)
+		}
+		return due, clockAt + clockN
+	case clockN == 0:
+		return time.Date(date.Year(), date.Month(), date.Day(), 23, 59, 0, 0, now.Location()), start + dateN
+	default:
+		return time.Date(date.Year(), date.Month(), date.Day(), hour, minute, 0, 0, now.Location()), start + dateN + clockN
+	}
+}
+
+// parseDueDate reads a date from the start of words. exact is set for
+// "in 2 hours" and the like, which already carry a time of day. Dates with
+// a month name are only read when marked, after a due connector.
+func parseDueDate(words []string, now time.Time, marked bool) (date time.Time, n int, exact bool) {
+	if len(words) == 0 {
+		return time.Time{}, 0, false
+	}
+	today := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, now.Location())
+	word := strings.ToLower(trimQuickAddWord(words[0]))
+	next := ""
+	if len(words) > 1 {
+		next = strings.TrimSuffix(strings.ToLower(trimQuickAddWord(words[1])), ".")
+	}
+
+	switch strings.TrimSuffix(word, ".") {
+	case "today":
+		return today, 1, false
+	case "tomorrow", "tmrw":
+		return today.AddDate(0, 0, 1), 1, false
+	case "next":
+		switch next {
+		case "week":
+			return nextWeekday(today, time.Monday), 2, false
+		case "month":
+			return time.Date(today.Year(), today.Month()+1, 1, 0, 0, 0, 0, now.Location()), 2, false
+		}
+		if day, ok := weekdayNames[next]; ok {
+			return nextWeekday(today, day), 2, false
+		}
+		return time.Time{}, 0, false
+	case "in":
+		return parseRelativeDue(words[1:], now, today)
+	}
+
+	if day, ok := weekdayNames[strings.TrimSuffix(word, ".")]; ok {
+		return nextWeekday(today, day), 1, false
+	}
+	if m := isoDatePattern.FindStringSubmatch(word); m != nil {
+		year, _ := strconv.Atoi(m[1])
+		month, _ := strconv.Atoi(m[2])
+		day, _ := strconv.Atoi(m[3])
+		if date, ok := calendarDate(year, time.Month(month), day, now.Location()); ok {
+			return date, 1, false
+		}
+		return time.Time{}, 0, false
+	}
+	if m := dottedDatePattern.FindStringSubmatch(word); m != nil {
+		day, _ := strconv.Atoi(m[1])
+		month, _ := strconv.Atoi(m[2])
+		if m[3] != "" {
+			year, _ := strconv.Atoi(m[3])
+			if date, ok := calendarDate(year, time.Month(month), day, now.Location()); ok {
+				return date, 1, false
+			}
+			return time.Time{}, 0, false
+		}
+		if date, ok := upcomingDate(today, time.Month(month), day); ok {
+			return date, 1, false
+		}
+		return time.Time{}, 0, false
+	}
+
+	// "dec 24" and "24 dec", either with an optional year.
+	if !marked {
+		return time.Time{}, 0, false
+	}
+	var month time.Month
+	var dayWord string
+	if m, ok := monthNames[strings.TrimSuffix(word, ".")]; ok && next != "" {
+		month, dayWord = m, next
+	} else if m, ok := monthNames[next]; ok {
+		month, dayWord = m, word
+	} else {
+		return time.Time{}, 0, false
+	}
+	match := dayOfMonthPattern.FindStringSubmatch(dayWord)
+	if match == nil {
+		return time.Time{}, 0, false
+	}
+	day, _ := strconv.Atoi(match[1])
+	if len(words) > 2 && yearPattern.MatchString(trimQuickAddWord(words[2])) {
+		year, _ := strconv.Atoi(trimQuickAddWord(words[2]))
+		if date, ok := calendarDate(year, month, day, now.Location()); ok {
+			return date, 3, false
+		}
+		return time.Time{}, 0, false
+	}
+	if date, ok := upcomingDate(today, month, day); ok {
+		return date, 2, false
+	}
+	return time.Time{}, 0, false
+}
+
+// parseRelativeDue reads the "3 days" of "in 3 days".
+func parseRelativeDue(words []string, now, today time.Time) (time.Time, int, bool) {
+	if len(words) < 2 {
+		return time.Time{}, 0, false
+	}
+	amount := strings.ToLower(words[0])
+	if !relativeAmountPattern.MatchString(amount) {
+		return time.Time{}, 0, false
+	}
+	n, _ := strconv.Atoi(amount)
+	if n == 0 {
+		return time.Time{}, 0, false
+	}
+
+	switch strings.TrimSuffix(strings.ToLower(trimQuickAddWord(words[1])), ".") {
+	case "minute", "minutes", "min", "mins":
+		return now.Add(time.Duration(n) * time.Minute).Truncate(time.Minute), 3, true
+	case "hour", "hours", "hr", "hrs":
+		return now.Add(time.Duration(n) * time.Hour).Truncate(time.Minute), 3, true
+	case "day", "days":
+		return today.AddDate(0, 0, n), 3, false
+	case "week", "weeks":
+		return today.AddDate(0, 0, 7*n), 3, false
+	case "month", "months":
+		return today.AddDate(0, n, 0), 3, false
+	}
+	return time.Time{}, 0, false
+}
+
+// parseDueClock reads a time of day from the start of words. A bare number
+// is not a time, so "buy 2 apples" keeps its 2.
+func parseDueClock(words []string) (hour, minute, n int) {
+	if len(words) > 1 && strings.ToLower(words[0]) == "at" {
+		if hour, minute, n = parseDueClock(words[1:]); n > 0 {
+			return hour, minute, n + 1
+		}
+		return 0, 0, 0
+	}
+	if len(words) == 0 {
+		return 0, 0, 0
+	}
+
+	word := strings.TrimSuffix(strings.ToLower(trimQuickAddWord(words[0])), ".")
+	if word == "noon" {
+		return 12, 0, 1
+	}
+	n = 1
+	// "9 am" is read like "9am".
+	if len(words) > 1 && dayOfMonthPattern.MatchString(word) {
+		if suffix := strings.TrimSuffix(strings.ToLower(trimQuickAddWord(words[1])), "."); suffix == "am" || suffix == "pm" {
+			word, n = word+suffix, 2
+		}
+	}
+
+	m := clockPattern.FindStringSubmatch(word)
+	if m == nil || (m[2] == "" && m[3] == "") {
+		return 0, 0, 0
+	}
+	hour, _ = strconv.Atoi(m[1])
+	if m[2] != "" {
+		minute, _ = strconv.Atoi(m[2])
+	}
+	switch {
+	case minute > 59:
+		return 0, 0, 0
+	case m[3] == "":
+		if hour > 23 {
+			return 0, 0, 0
+		}
+	case hour < 1 || hour > 12:
+		return 0, 0, 0
+	case m[3] == "am":
+		hour %= 12
+	default:
+		hour = hour%12 + 12
+	}
+	return hour, minute, n
+}
+
+func nextWeekday(today time.Time, day time.Weekday) time.Time {
+	days := (int(day) - int(today.Weekday()) + 7) % 7
+	if days == 0 {
+		days = 7
+	}
+	return today.AddDate(0, 0, days)
+}
+
+// upcomingDate is the next month and day on or after today.
+func upcomingDate(today time.Time, month time.Month, day int) (time.Time, bool) {
+	for year := today.Year(); year <= today.Year()+4; year++ {
+		date, ok := calendarDate(year, month, day, today.Location())
+		if ok && !date.Before(today) {
+			return date, true
+		}
+	}
+	// 29 February was not found within a leap cycle, or the day never
+	// exists.
+	return time.Time{}, false
+}
+
+// calendarDate builds a date, refusing ones time.Date would normalize, such
+// as 31 April.
+func calendarDate(year int, month time.Month, day int, loc *time.Location) (time.Time, bool) {
+	date := time.Date(year, month, day, 0, 0, 0, 0, loc)
+	return date, date.Year() == year && date.Month() == month && date.Day() == day
+}
@@ -0,0 +1,76 @@
+package main
+
+import (
+	"slices"
+	"testing"
+	"time"
+)
+
+func TestParseQuickAdd(t *testing.T) {
+	// A Wednesday morning.
+	now := time.Date(2026, time.March, 4, 10, 0, 0, 0, time.UTC)
+	at := func(month time.Month, day, hour, minute int) *time.Time {
+		due := time.Date(2026, month, day, hour, minute, 0, 0, time.UTC)
+		return &due
+	}
+	lists := []List{{ID: 1, Name: "Todos"}, {ID: 2, Name: "Side projects"}}
+
+	tests := []struct {
+		text     string
+		task     string
+		due      string
+		dueAt    *time.Time
+		tags     []string
+		priority *int
+		listID   *int
+	}{
+		{"Pay rent tomorrow 9am #home !high", "Pay rent", "tomorrow 9am", at(time.March, 5, 9, 0), []string{"home"}, ptr(1), nil},
+		{"call mom today", "call mom", "today", at(time.March, 4, 23, 59), nil, nil, nil},
+		{"report by friday", "report", "by friday", at(time.March, 6, 23, 59), nil, nil, nil},
+		{"standup next monday at 9:30am", "standup", "next monday at 9:30am", at(time.March, 9, 9, 30), nil, nil, nil},
+		{"ship next week", "ship", "next week", at(time.March, 9, 23, 59), nil, nil, nil},
+		{"invoice next month", "invoice", "next month", at(time.April, 1, 23, 59), nil, nil, nil},
+		{"water plants in 3 days", "water plants", "in 3 days", at(time.March, 7, 23, 59), nil, nil, nil},
+		{"stretch in 30 minutes", "stretch", "in 30 minutes", at(time.March, 4, 10, 30), nil, nil, nil},
+		{"renew 2026-12-24", "renew", "2026-12-24", at(time.December, 24, 23, 59), nil, nil, nil},
+		{"party 24.12.", "party", "24.12.", at(time.December, 24, 23, 59), nil, nil, nil},
+		{"lunch at noon", "lunch", "at noon", at(time.March, 4, 12, 0), nil, nil, nil},
+		{"call 14:30 tomorrow", "call", "14:30 tomorrow", at(time.March, 5, 14, 30), nil, nil, nil},
+		{"gym 7 am", "gym", "7 am", at(time.March, 5, 7, 0), nil, nil, nil},
+		{"taxes by march 5", "taxes", "by march 5", at(time.March, 5, 23, 59), nil, nil, nil},
+		{"trip due 24th december", "trip", "due 24th december", at(time.December, 24, 23, 59), nil, nil, nil},
+		{"dentist on may 3 at 2pm", "dentist", "on may 3 at 2pm", at(time.May, 3, 14, 0), nil, nil, nil},
+		{"@side-projects blog !p0 #q1", "blog", "", nil, []string{"q1"}, ptr(0), ptr(2)},
+		{"only the first !low !high", "only the first !high", "", nil, nil, ptr(3), nil},
+
+		// Not dates, tags or lists.
+		{"march 5 km", "march 5 km", "", nil, nil, nil, nil},
+		{"may 3 people join", "may 3 people join", "", nil, nil, nil, nil},
+		{"read 3 may books", "read 3 may books", "", nil, nil, nil, nil},
+		{"save in a month", "save in a month", "", nil, nil, nil, nil},
+		{"buy 2 apples", "buy 2 apples", "", nil, nil, nil, nil},
+		{"sun hat", "sun hat", "", nil, nil, nil, nil},
+		{"taxes 31.4.2026", "taxes 31.4.2026", "", nil, nil, nil, nil},
+		{"count #12 @nowhere", "count #12 @nowhere", "", nil, nil, nil, nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.text, func(t *testing.T) {
+			q := parseQuickAdd(tt.text, now, lists)
+			if q.Task != tt.task || q.Due != tt.due {
+				t.Errorf("task, due = %q, %q, want %q, %q", q.Task, q.Due, tt.task, tt.due)
+			}
+			if (q.DueAt == nil) != (tt.dueAt == nil) || q.DueAt != nil && !q.DueAt.Equal(*tt.dueAt) {
+				t.Errorf("due_at = %v, want %v", q.DueAt, tt.dueAt)
+			}
+			if !slices.Equal(q.Tags, tt.tags) {
+				t.Errorf("tags = %v, want %v", q.Tags, tt.tags)
+			}
+			if (q.Priority == nil) != (tt.priority == nil) || q.Priority != nil && *q.Priority != *tt.priority {
+				t.Errorf("priority = %v, want %v", q.Priority, tt.priority)
+			}
+			if (q.ListID == nil) != (tt.listID == nil) || q.ListID != nil && *q.ListID != *tt.listID {
+				t.Errorf("list_id = %v, want %v", q.ListID, tt.listID)
+			}
+		})
+	}
+}
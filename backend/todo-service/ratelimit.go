@@ -0,0 +1,216 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"math"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/rs/zerolog/log"
+)
+
+const (
+	apiKeyHeader = "X-API-Key"
+	// apiKeyIDKey holds the ID of a valid API key sent with the request.
+	apiKeyIDKey = "api_key_id"
+)
+
+// RateLimit describes a token bucket: Requests tokens are refilled evenly
+// over Per, and at most Burst tokens can be saved up.
+type RateLimit struct {
+	Requests int
+	Per      time.Duration
+	Burst    int
+}
+
+func (r RateLimit) capacity() float64 {
+	if r.Burst > 0 {
+		return float64(r.Burst)
+	}
+	return float64(r.Requests)
+}
+
+func (r RateLimit) ratePerSecond() float64 {
+	return float64(r.Requests) / r.Per.Seconds()
+}
+
+type RateLimitResult struct {
+	Allowed    bool
+	Limit      int
+	Remaining  int
+	Reset      time.Duration
+	RetryAfter time.Duration
+}
+
+// RateLimitStore keeps the buckets. The in-memory store is enough for a
+// single replica; a shared implementation (e.g. Redis) can be plugged in
+// when the service is scaled out.
+type RateLimitStore interface {
+	Take(key string, limit RateLimit) (RateLimitResult, error)
+}
+
+type bucket struct {
+	tokens   float64
+	lastSeen time.Time
+}
+
+type memoryRateLimitStore struct {
+	mu      sync.Mutex
+	buckets map[string]*bucket
+	now     func() time.Time
+}
+
+func NewMemoryRateLimitStore(sweepInterval time.Duration) RateLimitStore {
+	store := &memoryRateLimitStore{
+		buckets: make(map[string]*bucket),
+		now:     time.Now,
+	}
+	go store.sweep(sweepInterval)
+	return store
+}
+
+func (s *memoryRateLimitStore) Take(key string, limit RateLimit) (RateLimitResult, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := s.now()
+	capacity := limit.capacity()
+	rate := limit.ratePerSecond()
+
+	b, ok := s.buckets[key]
+	if !ok {
+		b = &bucket{tokens: capacity, lastSeen: now}
+		s.buckets[key] = b
+	}
+	b.tokens = math.Min(capacity, b.tokens+now.Sub(b.lastSeen).Seconds()*rate)
+	b.lastSeen = now
+
+	result := RateLimitResult{Limit: int(capacity)}
+	if b.tokens >= 1 {
+		b.tokens--
+		result.Allowed = true
+	} else {
+		result.RetryAfter = secondsToDuration((1 - b.tokens) / rate)
+	}
+	result.Remaining = int(math.Floor(b.tokens))
+	result.Reset = secondsToDuration((capacity - b.tokens) / rate)
+	return result, nil
+}
+
+// sweep drops buckets that have been idle for a whole interval. With
+// per-minute limits they have refilled by then, so a fresh bucket is
+// indistinguishable from the dropped one.
+func (s *memoryRateLimitStore) sweep(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for range ticker.C {
+		s.mu.Lock()
+		for key, b := range s.buckets {
+			if s.now().Sub(b.lastSeen) > interval {
+				delete(s.buckets, key)
+			}
+		}
+		s.mu.Unlock()
+	}
+}
+
+func secondsToDuration(seconds float64) time.Duration {
+	return time.Duration(math.Ceil(seconds)) * time.Second
+}
+
+// APIKeyMiddleware accepts an X-API-Key header that is one of keys. Other
+// keys are ignored, so a client cannot get a fresh rate limit bucket, or a
+// new audit identity, by making up keys.
+func APIKeyMiddleware(keys []string) gin.HandlerFunc {
+	ids := make(map[string]string, len(keys))
+	for _, key := range keys {
+		ids[apiKeyHash(key)] = apiKeyHash(key)[:16]
+	}
+	return func(ctx *gin.Context) {
+		if apiKey := ctx.GetHeader(apiKeyHeader); apiKey != "" {
+			if id, ok := ids[apiKeyHash(apiKey)]; ok {
+				ctx.Set(apiKeyIDKey, id)
+			} else {
+				log.Debug().Str("path", ctx.FullPath()).Msg("unknown API key ignored")
+			}
+		}
+		ctx.Next()
+	}
+}
+
+// RequireAPIKey refuses requests without a valid API key once API_KEYS is
+// set, for routes that expose who did what. It relies on APIKeyMiddleware
+// having run first.
+func RequireAPIKey(keys []string) gin.HandlerFunc {
+	return func(ctx *gin.Context) {
+		if len(keys) > 0 && ctx.GetString(apiKeyIDKey) == "" {
+			log.Warn().Str("path", ctx.FullPath()).Msg("request rejected: no valid API key")
+			respondError(ctx, errAPIKeyRequired())
+			return
+		}
+		ctx.Next()
+	}
+}
+
+func apiKeyHash(key string) string {
+	sum := sha256.Sum256([]byte(key))
+	return hex.EncodeToString(sum[:])
+}
+
+// callerID identifies the caller for rate limiting and auditing: a valid API
+// key wins over the client IP. ClientIP only reads forwarding headers from
+// TRUSTED_PROXIES.
+func callerID(ctx *gin.Context) string {
+	if id := ctx.GetString(apiKeyIDKey); id != "" {
+		return "key:" + id
+	}
+	return "ip:" + ctx.ClientIP()
+}
+
+type RateLimiter struct {
+	store   RateLimitStore
+	enabled bool
+}
+
+func NewRateLimiter(store RateLimitStore, enabled bool) *RateLimiter {
+	return &RateLimiter{store: store, enabled: enabled}
+}
+
+// Limit returns a middleware enforcing limit. Routes sharing a name share
+// their buckets, so the v1 and v2 copies of a route are limited together.
+func (l *RateLimiter) Limit(name string, limit RateLimit) gin.HandlerFunc {
+	return func(ctx *gin.Context) {
+		if !l.enabled || limit.Requests <= 0 {
+			ctx.Next()
+			return
+		}
+
+		result, err := l.store.Take(name+"|"+callerID(ctx), limit)
+		if err != nil {
+			// Fail open: an unavailable store should not take the API down.
+			log.Error().Err(err).Str("limit", name).Msg("Rate limit store failed")
+			ctx.Next()
+			return
+		}
+
+		ctx.Header("RateLimit-Limit", strconv.Itoa(result.Limit))
+		ctx.Header("RateLimit-Remaining", strconv.Itoa(result.Remaining))
+		ctx.Header("RateLimit-Reset", strconv.Itoa(int(result.Reset.Seconds())))
+
+		if !result.Allowed {
+			ctx.Header("Retry-After", strconv.Itoa(int(result.RetryAfter.Seconds())))
+			log.Warn().
+				Str("path", ctx.FullPath()).
+				Str("limit", name).
+				Str("client_ip", ctx.ClientIP()).
+				Msg("request rejected: rate limit exceeded")
+			respondError(ctx, errRateLimited(result.RetryAfter))
+			return
+		}
+
+		ctx.Next()
+	}
+}
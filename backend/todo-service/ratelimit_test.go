@@ -0,0 +1,245 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// newTestRateLimitStore returns a store without the sweeper, on a clock the
+// test moves.
+func newTestRateLimitStore(now *time.Time) *memoryRateLimitStore {
+	return &memoryRateLimitStore{
+		buckets: make(map[string]*bucket),
+		now:     func() time.Time { return *now },
+	}
+}
+
+func TestMemoryRateLimitStoreBuckets(t *testing.T) {
+	now := time.Date(2026, 10, 18, 12, 0, 0, 0, time.UTC)
+	store := newTestRateLimitStore(&now)
+	// 6 per minute is one token every 10 seconds, with 3 saved up at most.
+	limit := RateLimit{Requests: 6, Per: time.Minute, Burst: 3}
+
+	type step struct {
+		advance       time.Duration
+		wantAllowed   bool
+		wantRemaining int
+		wantRetry     time.Duration
+		wantReset     time.Duration
+	}
+	steps := []step{
+		{0, true, 2, 0, 10 * time.Second},
+		{0, true, 1, 0, 20 * time.Second},
+		{0, true, 0, 0, 30 * time.Second},
+		{0, false, 0, 10 * time.Second, 30 * time.Second},
+		{4 * time.Second, false, 0, 6 * time.Second, 26 * time.Second},
+		{6 * time.Second, true, 0, 0, 30 * time.Second},
+		// A minute of rest refills up to the burst, not beyond.
+		{time.Minute, true, 2, 0, 10 * time.Second},
+	}
+	for i, s := range steps {
+		now = now.Add(s.advance)
+		got, err := store.Take("writes|ip:192.0.2.1", limit)
+		if err != nil {
+			t.Fatal(err)
+		}
+		want := RateLimitResult{Allowed: s.wantAllowed, Limit: 3, Remaining: s.wantRemaining, Reset: s.wantReset, RetryAfter: s.wantRetry}
+		if got != want {
+			t.Errorf("step %d: Take = %+v, want %+v", i, got, want)
+		}
+	}
+
+	// Other keys have their own bucket.
+	if got, _ := store.Take("writes|ip:192.0.2.2", limit); !got.Allowed || got.Remaining != 2 {
+		t.Errorf("Take for another caller = %+v, want a full bucket", got)
+	}
+}
+
+func TestRateLimitCapacityDefaultsToRequests(t *testing.T) {
+	if got := (RateLimit{Requests: 30, Per: time.Minute}).capacity(); got != 30 {
+		t.Errorf("capacity = %v, want 30", got)
+	}
+}
+
+// rateLimitedRouter serves a write route limited to one request per minute.
+func rateLimitedRouter(t *testing.T, keys, proxies []string) *gin.Engine {
+	t.Helper()
+	gin.SetMode(gin.TestMode)
+	now := time.Now()
+	router := gin.New()
+	if err := router.SetTrustedProxies(proxies); err != nil {
+		t.Fatal(err)
+	}
+	router.Use(APIKeyMiddleware(keys))
+	limiter := NewRateLimiter(newTestRateLimitStore(&now), true)
+	router.POST("/api/v2/todos", apiVersion(2), limiter.Limit("writes", RateLimit{Requests: 1, Per: time.Minute}), func(ctx *gin.Context) {
+		ctx.String(http.StatusCreated, callerID(ctx))
+	})
+	return router
+}
+
+func postTodo(router *gin.Engine, remoteAddr string, headers ...string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(http.MethodPost, "/api/v2/todos", nil)
+	req.RemoteAddr = remoteAddr
+	for i := 0; i+1 < len(headers); i += 2 {
+		req.Header.Set(headers[i], headers[i+1])
+	}
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	return w
+}
+
+func TestRateLimiterRejectsWithRetryAfter(t *testing.T) {
+	router := rateLimitedRouter(t, nil, nil)
+
+	w := postTodo(router, "192.0.2.1:1234")
+	if w.Code != http.StatusCreated {
+		t.Fatalf("first request: status %d", w.Code)
+	}
+	if w.Header().Get("RateLimit-Limit") != "1" || w.Header().Get("RateLimit-Remaining") != "0" || w.Header().Get("RateLimit-Reset") != "60" {
+		t.Errorf("first request headers = %v", w.Header())
+	}
+
+	w = postTodo(router, "192.0.2.1:1234")
+	if w.Code != http.StatusTooManyRequests {
+		t.Fatalf("second request: status %d, want 429", w.Code)
+	}
+	if got := w.Header().Get("Retry-After"); got != "60" {
+		t.Errorf("Retry-After = %q, want 60", got)
+	}
+	var body problem
+	if err := json.Unmarshal(w.Body.Bytes(), &body); err != nil || body.Code != CodeRateLimited {
+		t.Errorf("body = %s, want a rate_limited problem", w.Body)
+	}
+}
+
+func TestRateLimiterCallerIdentity(t *testing.T) {
+	tests := []struct {
+		name    string
+		keys    []string
+		proxies []string
+		remote  string
+		headers []string
+		// secondHeaders go with a second request from the same address,
+		// which gets wantSecond.
+		secondHeaders []string
+		wantCaller    string
+		wantSecond    int
+	}{
+		{
+			name: "made-up keys share the IP bucket", remote: "192.0.2.1:1",
+			headers: []string{apiKeyHeader, "random-1"}, secondHeaders: []string{apiKeyHeader, "random-2"},
+			wantCaller: "ip:192.0.2.1", wantSecond: http.StatusTooManyRequests,
+		},
+		{
+			name: "a valid key has its own bucket", keys: []string{"k1"}, remote: "192.0.2.1:1",
+			headers: []string{apiKeyHeader, "k1"}, secondHeaders: nil,
+			wantCaller: "key:" + apiKeyHash("k1")[:16], wantSecond: http.StatusCreated,
+		},
+		{
+			name: "forwarded IPs from clients are ignored", remote: "192.0.2.1:1",
+			headers: []string{"X-Forwarded-For", "198.51.100.1"}, secondHeaders: []string{"X-Forwarded-For", "198.51.100.2"},
+			wantCaller: "ip:192.0.2.1", wantSecond: http.StatusTooManyRequests,
+		},
+		{
+			name: "forwarded IPs from trusted proxies are used", proxies: []string{"10.0.0.0/8"}, remote: "10.0.0.5:1",
+			headers: []string{"X-Forwarded-For", "198.51.100.1"}, secondHeaders: []string{"X-Forwarded-For", "198.51.100.2"},
+			wantCaller: "ip:198.51.100.1", wantSecond: http.StatusCreated,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			router := rateLimitedRouter(t, tt.keys, tt.proxies)
+			w := postTodo(router, tt.remote, tt.headers...)
+			if w.Code != http.StatusCreated || w.Body.String() != tt.wantCaller {
+				t.Errorf("first request: %d %q, want 201 %q", w.Code, w.Body, tt.wantCaller)
+			}
+			if w := postTodo(router, tt.remote, tt.secondHeaders...); w.Code != tt.wantSecond {
+				t.Errorf("second request: status %d, want %d", w.Code, tt.wantSecond)
+			}
+		})
+	}
+}
+
+func TestAuditRoutesRequireAPIKey(t *testing.T) {
+	cfg := defaultConfig()
+	cfg.APIKeys = []string{"k1"}
+	router := testRouter(&cfg, nil)
+
+	tests := []struct {
+		path   string
+		key    string
+		wantV2 bool
+	}{
+		{path: "/api/v2/audit", wantV2: true},
+		{path: "/api/v2/audit", key: "made-up", wantV2: true},
+		{path: "/api/audit"},
+		{path: "/api/v2/todos/1/history", wantV2: true},
+	}
+	for _, tt := range tests {
+		req := httptest.NewRequest(http.MethodGet, tt.path, nil)
+		if tt.key != "" {
+			req.Header.Set(apiKeyHeader, tt.key)
+		}
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+
+		var body struct {
+			Code  string `json:"code"`
+			Error string `json:"error"`
+		}
+		if err := json.Unmarshal(w.Body.Bytes(), &body); err != nil {
+			t.Fatal(err)
+		}
+		if w.Code != http.StatusUnauthorized || (tt.wantV2 && body.Code != CodeAPIKeyRequired) || (!tt.wantV2 && body.Error == "") {
+			t.Errorf("GET %s with key %q: %d %s, want 401", tt.path, tt.key, w.Code, w.Body)
+		}
+	}
+}
+
+func TestRequireAPIKeyPasses(t *testing.T) {
+	tests := []struct {
+		name string
+		keys []string
+		sent string
+	}{
+		{name: "valid key", keys: []string{"k1"}, sent: "k1"},
+		{name: "no keys configured"},
+		{name: "no keys configured and a made-up one sent", sent: "made-up"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			gin.SetMode(gin.TestMode)
+			router := gin.New()
+			router.Use(APIKeyMiddleware(tt.keys))
+			router.GET("/api/v2/audit", apiVersion(2), RequireAPIKey(tt.keys), func(ctx *gin.Context) {
+				ctx.Status(http.StatusOK)
+			})
+			req := httptest.NewRequest(http.MethodGet, "/api/v2/audit", nil)
+			if tt.sent != "" {
+				req.Header.Set(apiKeyHeader, tt.sent)
+			}
+			w := httptest.NewRecorder()
+			router.ServeHTTP(w, req)
+			if w.Code != http.StatusOK {
+				t.Errorf("status = %d: %s", w.Code, w.Body)
+			}
+		})
+	}
+}
+
+func TestLoadConfigTrustedProxies(t *testing.T) {
+	setRequiredEnv(t)
+	t.Setenv("TRUSTED_PROXIES", "10.0.0.0/8,192.0.2.1,proxy.local")
+
+	_, err := LoadConfig("")
+	if err == nil || !strings.Contains(err.Error(), `"proxy.local"`) || strings.Contains(err.Error(), "10.0.0.0/8") {
+		t.Fatalf("LoadConfig = %v, want only proxy.local rejected", err)
+	}
+}
@@ -0,0 +1,216 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Recurrence is the subset of RFC 5545 RRULE that recurring todos support:
+//
+//	FREQ=DAILY[;INTERVAL=n]
+//	FREQ=WEEKLY[;INTERVAL=n][;BYDAY=MO,TH]
+//	FREQ=MONTHLY[;INTERVAL=n][;BYMONTHDAY=1,15,-1]
+//	FREQ=DAILY;INTERVAL=n;X-FROM=COMPLETION
+//
+// The last form, an extension, schedules the next occurrence n days after
+// the previous one was completed rather than after it was due. Weekdays and
+// days of the month are evaluated in the configured time zone.
+type Recurrence struct {
+	Freq           string
+	Interval       int
+	ByDay          []time.Weekday
+	ByMonthDay     []int
+	FromCompletion bool
+}
+
+const (
+	freqDaily   = "DAILY"
+	freqWeekly  = "WEEKLY"
+	freqMonthly = "MONTHLY"
+)
+
+var weekdayCodes = []string{"SU", "MO", "TU", "WE", "TH", "FR", "SA"}
+
+// maxRecurrenceInterval keeps Next's search bounded.
+const maxRecurrenceInterval = 366
+
+// ParseRecurrence parses a rule, with or without the "RRULE:" prefix.
+func ParseRecurrence(rule string) (Recurrence, error) {
+	r := Recurrence{Interval: 1}
+	rule = strings.TrimPrefix(strings.ToUpper(strings.TrimSpace(rule)), "RRULE:")
+	if rule == "" {
+		return r, errors.New("rule is empty")
+	}
+
+	seen := make(map[string]bool)
+	for _, part := range strings.Split(rule, ";") {
+		name, value, ok := strings.Cut(part, "=")
+		if !ok || value == "" {
+			return r, fmt.Errorf("%q is not a NAME=VALUE rule part", part)
+		}
+		if seen[name] {
+			return r, fmt.Errorf("%s is given more than once", name)
+		}
+		seen[name] = true
+
+		switch name {
+		case "FREQ":
+			if value != freqDaily && value != freqWeekly && value != freqMonthly {
+				return r, fmt.Errorf("FREQ must be DAILY, WEEKLY or MONTHLY, not %q", value)
+			}
+			r.Freq = value
+		case "INTERVAL":
+			n, err := strconv.Atoi(value)
+			if err != nil || n < 1 || n > maxRecurrenceInterval {
+				return r, fmt.Errorf("INTERVAL must be between 1 and %d", maxRecurrenceInterval)
+			}
+			r.Interval = n
+		case "BYDAY":
+			for _, code := range strings.Split(value, ",") {
+				day := slices.Index(weekdayCodes, code)
+				if day < 0 {
+					return r, fmt.Errorf("BYDAY has unknown weekday %q", code)
+				}
+				if !slices.Contains(r.ByDay, time.Weekday(day)) {
+					r.ByDay = append(r.ByDay, time.Weekday(day))
+				}
+			}
+		case "BYMONTHDAY":
+			for _, raw := range strings.Split(value, ",") {
+				day, err := strconv.Atoi(raw)
+				if err != nil || day == 0 || day < -31 || day > 31 {
+					return r, fmt.Errorf("BYMONTHDAY has invalid day %q", raw)
+				}
+				if !slices.Contains(r.ByMonthDay, day) {
+					r.ByMonthDay = append(r.ByMonthDay, day)
+				}
+			}
+		case "X-FROM":
+			if value != "COMPLETION" {
+				return r, fmt.Errorf("X-FROM must be COMPLETION, not %q", value)
+			}
+			r.FromCompletion = true
+		default:
+			return r, fmt.Errorf("rule part %s is not supported", name)
+		}
+	}
+
+	switch {
+	case r.Freq == "":
+		return r, errors.New("FREQ is required")
+	case len(r.ByDay) > 0 && r.Freq != freqWeekly:
+		return r, errors.New("BYDAY is only supported with FREQ=WEEKLY")
+	case len(r.ByMonthDay) > 0 && r.Freq != freqMonthly:
+		return r, errors.New("BYMONTHDAY is only supported with FREQ=MONTHLY")
+	case r.FromCompletion && r.Freq != freqDaily:
+		return r, errors.New("X-FROM=COMPLETION is only supported with FREQ=DAILY")
+	}
+	slices.Sort(r.ByDay)
+	slices.Sort(r.ByMonthDay)
+	return r, nil
+}
+
+// String returns the rule in canonical form, which is how it is stored.
+func (r Recurrence) String() string {
+	parts := []string{"FREQ=" + r.Freq}
+	if r.Interval > 1 {
+		parts = append(parts, "INTERVAL="+strconv.Itoa(r.Interval))
+	}
+	if len(r.ByDay) > 0 {
+		codes := make([]string, len(r.ByDay))
+		for i, day := range r.ByDay {
+			codes[i] = weekdayCodes[day]
+		}
+		parts = append(parts, "BYDAY="+strings.Join(codes, ","))
+	}
+	if len(r.ByMonthDay) > 0 {
+		days := make([]string, len(r.ByMonthDay))
+		for i, day := range r.ByMonthDay {
+			days[i] = strconv.Itoa(day)
+		}
+		parts = append(parts, "BYMONTHDAY="+strings.Join(days, ","))
+	}
+	if r.FromCompletion {
+		parts = append(parts, "X-FROM=COMPLETION")
+	}
+	return strings.Join(parts, ";")
+}
+
+// Next returns when the occurrence after one due at due and completed at
+// completedAt is due, evaluating the rule in loc. Occurrences follow on
+// from due, keeping its local time of day, and skip those already past at
+// completedAt, so completing an overdue todo schedules the next one still
+// ahead. Without a due date, or for X-FROM=COMPLETION, they follow on from
+// completedAt. It reports false if the rule never matches again, such as
+// the 30th of every February.
+func (r Recurrence) Next(due *time.Time, completedAt time.Time, loc *time.Location) (time.Time, bool) {
+	completedAt = completedAt.In(loc)
+	if due == nil || r.FromCompletion {
+		return r.after(completedAt)
+	}
+	next, ok := r.after(due.In(loc))
+	for ok && !next.After(completedAt) {
+		next, ok = r.after(next)
+	}
+	return next, ok
+}
+
+// after returns the first time after base that the rule matches.
+func (r Recurrence) after(base time.Time) (time.Time, bool) {
+	switch r.Freq {
+	case freqWeekly:
+		if len(r.ByDay) == 0 {
+			return base.AddDate(0, 0, 7*r.Interval), true
+		}
+		// Weeks start on Monday, as with the RRULE default WKST=MO.
+		week := startOfWeek(base)
+		for d := base.AddDate(0, 0, 1); ; d = d.AddDate(0, 0, 1) {
+			weeks := int(startOfWeek(d).Sub(week).Hours()/24) / 7
+			if weeks%r.Interval == 0 && slices.Contains(r.ByDay, d.Weekday()) {
+				return d, true
+			}
+		}
+	case freqMonthly:
+		days := r.ByMonthDay
+		if len(days) == 0 {
+			days = []int{base.Day()}
+		}
+		first := time.Date(base.Year(), base.Month(), 1, base.Hour(), base.Minute(), base.Second(), base.Nanosecond(), base.Location())
+		// Days missing from a month are skipped, as RFC 5545 requires, so
+		// a rule for the 31st may pass over several months. The months
+		// visited repeat within four years, leap years included.
+		for step := 0; step <= 48; step++ {
+			month := first.AddDate(0, step*r.Interval, 0)
+			length := month.AddDate(0, 1, -1).Day()
+			var candidates []time.Time
+			for _, day := range days {
+				if day < 0 {
+					day = length + day + 1
+				}
+				if day >= 1 && day <= length {
+					candidates = append(candidates, month.AddDate(0, 0, day-1))
+				}
+			}
+			slices.SortFunc(candidates, func(a, b time.Time) int { return a.Compare(b) })
+			for _, candidate := range candidates {
+				if candidate.After(base) {
+					return candidate, true
+				}
+			}
+		}
+		return time.Time{}, false
+	default:
+		return base.AddDate(0, 0, r.Interval), true
+	}
+}
+
+// startOfWeek returns the Monday of t's week in its time zone, as a UTC
+// date so that weeks are whole multiples of 24 hours apart.
+func startOfWeek(t time.Time) time.Time {
+	offset := (int(t.Weekday()) + 6) % 7
+	return time.Date(t.Year(), t.Month(), t.Day()-offset, 0, 0, 0, 0, time.UTC)
+}
@@ -0,0 +1,64 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestRecurrenceNext(t *testing.T) {
+	helsinki, err := time.LoadLocation("Europe/Helsinki")
+	if err != nil {
+		t.Skip(err)
+	}
+	at := func(loc *time.Location, day, hour, minute int) time.Time {
+		// March 2026; days past 31 run on into April.
+		return time.Date(2026, time.March, day, hour, minute, 0, 0, loc)
+	}
+
+	tests := []struct {
+		name      string
+		rule      string
+		loc       *time.Location
+		due       *time.Time
+		completed time.Time
+		want      time.Time
+		wantOK    bool
+	}{
+		{"daily", "FREQ=DAILY", time.UTC, ptr(at(time.UTC, 2, 9, 0)), at(time.UTC, 2, 8, 0), at(time.UTC, 3, 9, 0), true},
+		{"every other day", "FREQ=DAILY;INTERVAL=2", time.UTC, ptr(at(time.UTC, 2, 9, 0)), at(time.UTC, 2, 8, 0), at(time.UTC, 4, 9, 0), true},
+		{"completed early", "FREQ=DAILY", time.UTC, ptr(at(time.UTC, 10, 9, 0)), at(time.UTC, 2, 8, 0), at(time.UTC, 11, 9, 0), true},
+		{"overdue", "FREQ=DAILY", time.UTC, ptr(at(time.UTC, 1, 9, 0)), at(time.UTC, 5, 12, 0), at(time.UTC, 6, 9, 0), true},
+		{"overdue on the day", "FREQ=DAILY", time.UTC, ptr(at(time.UTC, 1, 9, 0)), at(time.UTC, 5, 8, 0), at(time.UTC, 5, 9, 0), true},
+		{"overdue weekly", "FREQ=WEEKLY;BYDAY=MO", time.UTC, ptr(at(time.UTC, 2, 9, 0)), at(time.UTC, 17, 9, 0), at(time.UTC, 23, 9, 0), true},
+		{"weekdays", "FREQ=WEEKLY;BYDAY=MO,TH", time.UTC, ptr(at(time.UTC, 2, 9, 0)), at(time.UTC, 2, 8, 0), at(time.UTC, 5, 9, 0), true},
+		{"every other week", "FREQ=WEEKLY;INTERVAL=2;BYDAY=MO", time.UTC, ptr(at(time.UTC, 2, 9, 0)), at(time.UTC, 2, 8, 0), at(time.UTC, 16, 9, 0), true},
+		{"weekly", "FREQ=WEEKLY", time.UTC, ptr(at(time.UTC, 2, 9, 0)), at(time.UTC, 2, 8, 0), at(time.UTC, 9, 9, 0), true},
+		{"last day of the month", "FREQ=MONTHLY;BYMONTHDAY=-1", time.UTC, ptr(at(time.UTC, 31, 9, 0)), at(time.UTC, 31, 8, 0), at(time.UTC, 30+31, 9, 0), true},
+		{"31st skips April", "FREQ=MONTHLY;BYMONTHDAY=31", time.UTC, ptr(at(time.UTC, 31, 9, 0)), at(time.UTC, 31, 8, 0), time.Date(2026, time.May, 31, 9, 0, 0, 0, time.UTC), true},
+		{"30th of every February", "FREQ=MONTHLY;INTERVAL=12;BYMONTHDAY=30", time.UTC, ptr(time.Date(2026, time.February, 1, 9, 0, 0, 0, time.UTC)), at(time.UTC, 1, 8, 0), time.Time{}, false},
+		{"from completion", "FREQ=DAILY;INTERVAL=3;X-FROM=COMPLETION", time.UTC, ptr(at(time.UTC, 1, 9, 0)), at(time.UTC, 5, 18, 0), at(time.UTC, 8, 18, 0), true},
+		{"without a due date", "FREQ=DAILY", time.UTC, nil, at(time.UTC, 5, 18, 0), at(time.UTC, 6, 18, 0), true},
+
+		// 00:30 on Tuesday in Helsinki is still Monday in UTC.
+		{"weekday in the time zone", "FREQ=WEEKLY;BYDAY=TU", helsinki, ptr(at(helsinki, 3, 0, 30)), at(helsinki, 3, 0, 0), at(helsinki, 10, 0, 30), true},
+		{"month day in the time zone", "FREQ=MONTHLY;BYMONTHDAY=1", helsinki, ptr(at(helsinki, 1, 0, 30)), at(helsinki, 1, 0, 0), at(helsinki, 32, 0, 30), true},
+		// Daylight saving time starts on March 29.
+		{"local time across DST", "FREQ=DAILY", helsinki, ptr(at(helsinki, 28, 9, 0)), at(helsinki, 28, 8, 0), at(helsinki, 29, 9, 0), true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rule, err := ParseRecurrence(tt.rule)
+			if err != nil {
+				t.Fatal(err)
+			}
+			got, ok := rule.Next(tt.due, tt.completed, tt.loc)
+			if ok != tt.wantOK || ok && !got.Equal(tt.want) {
+				t.Errorf("Next = %v, %v, want %v, %v", got, ok, tt.want, tt.wantOK)
+			}
+		})
+	}
+}
+
+func ptr[T any](v T) *T {
+	return &v
+}
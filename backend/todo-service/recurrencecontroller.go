@@ -0,0 +1,113 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/rs/zerolog/log"
+)
+
+type recurrenceRequest struct {
+	Rule  string     `json:"rule" binding:"required"`
+	DueAt *time.Time `json:"due_at"`
+}
+
+// setRecurrence starts a series from a todo or changes the rule of the
+// series it belongs to.
+func (c *TodosController) setRecurrence(ctx *gin.Context) {
+	id, ok := todoIDParam(ctx)
+	if !ok {
+		return
+	}
+
+	var request recurrenceRequest
+	if err := ctx.ShouldBindJSON(&request); err != nil {
+		log.Error().Err(err).Msg("Failed to parse request body")
+		respondError(ctx, errInvalidBody(err))
+		return
+	}
+	rule, ok := validateRecurrence(ctx, "rule", request.Rule)
+	if !ok {
+		return
+	}
+
+	update := TodoUpdate{Recurrence: &rule}
+	if request.DueAt != nil {
+		update.DueAt = NullableTime{Set: true, Time: request.DueAt}
+	}
+	c.writeRecurrence(ctx, id, update, "Recurrence set")
+}
+
+// stopRecurrence ends the series a todo belongs to: no occurrence is
+// created when its open occurrences are completed.
+func (c *TodosController) stopRecurrence(ctx *gin.Context) {
+	id, ok := todoIDParam(ctx)
+	if !ok {
+		return
+	}
+	stop := ""
+	c.writeRecurrence(ctx, id, TodoUpdate{Recurrence: &stop}, "Recurrence stopped")
+}
+
+func (c *TodosController) writeRecurrence(ctx *gin.Context, id int, update TodoUpdate, msg string) {
+	pre, apiErr := c.ifMatchPrecondition(ctx, id)
+	if apiErr != nil {
+		respondError(ctx, apiErr)
+		return
+	}
+
+	todo, _, err := c.repoFor(ctx).UpdateTodo(id, pre, update)
+	if err != nil {
+		c.respondTodoError(ctx, id, err, "update recurrence")
+		return
+	}
+
+	log.Info().
+		Str("path", ctx.FullPath()).
+		Int("id", id).
+		Str("recurrence", todo.Recurrence).
+		Msg(msg)
+
+	c.sendNatsMessage("todo.updated", todo)
+
+	c.respondWithUndo(ctx, http.StatusOK, todo, nil)
+}
+
+// validateRecurrence parses a non-empty rule and returns its canonical form,
+// writing a 400 if it is not a supported RRULE.
+func validateRecurrence(ctx *gin.Context, field, rule string) (string, bool) {
+	if rule == "" {
+		return "", true
+	}
+	parsed, err := ParseRecurrence(rule)
+	if err != nil {
+		log.Warn().
+			Str("path", ctx.FullPath()).
+			Str("rule", rule).
+			Err(err).
+			Msg("recurrence rejected")
+		respondError(ctx, errInvalidField(field, err.Error()))
+		return "", false
+	}
+	return parsed.String(), true
+}
+
+// publishCompletion sends the events that follow from todo being done: the
+// next occurrence of a recurring todo and the todos it unblocked.
+func (c *TodosController) publishCompletion(todo Todo) {
+	if !todo.Done {
+		return
+	}
+	if todo.Recurrence != "" {
+		next, err := c.repo.GetNextOccurrence(todo)
+		switch {
+		case err == nil:
+			c.sendNatsMessage("todo.created", next)
+		case !errors.Is(err, ErrTodoNotFound):
+			log.Error().Err(err).Int("id", todo.ID).Msg("Failed to get next occurrence")
+		}
+	}
+	c.publishUnblocked(todo)
+}
@@ -0,0 +1,118 @@
+package main
+
+import (
+	"database/sql"
+	"errors"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+)
+
+// scheduleNext inserts the occurrence that follows a completed recurring
+// todo, in the same place in the list. Completing the same occurrence again
+// schedules nothing. The occurrence is not held to the WIP limit of its
+// status, so a full column never keeps a todo from being completed. It
+// returns the occurrence, or nil when none was scheduled.
+func (t todoRepository) scheduleNext(todo Todo) (*Todo, error) {
+	if !todo.Done || todo.Recurrence == "" {
+		return nil, nil
+	}
+	rule, err := ParseRecurrence(todo.Recurrence)
+	if err != nil {
+		return nil, err
+	}
+	due, ok := rule.Next(todo.DueAt, time.Now(), t.location)
+	if !ok {
+		return nil, nil
+	}
+
+	var next Todo
+	err = sqlx.Get(t.q, &next, `
+		INSERT INTO todos (task, parent_id, auto_complete, due_at, recurrence, series_id, previous_id, priority, position, list_id, tags)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11)
+		ON CONFLICT (previous_id) DO NOTHING
+		RETURNING `+todoColumns,
+		todo.Task, todo.ParentID, todo.AutoComplete, due, todo.Recurrence, todo.SeriesID, todo.ID,
+		todo.Priority, todo.Position, todo.ListID, todo.Tags)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := t.recordEvent(next.ID, ActionCreated, next.Version, diffTodos(nil, next)); err != nil {
+		return nil, err
+	}
+	return &next, nil
+}
+
+// unscheduleNext deletes the occurrence that completing id scheduled, for
+// undoing that completion. It is deleted rather than trashed so completing
+// id again schedules a new one. An occurrence that has changed since, or
+// has subtasks, is left alone and the undo fails with
+// ErrPreconditionFailed.
+func (t todoRepository) unscheduleNext(id int) ([]int, error) {
+	var next struct {
+		ID       int   `db:"id"`
+		Version  int64 `db:"version"`
+		Subtasks bool  `db:"subtasks"`
+	}
+	err := sqlx.Get(t.q, &next, `
+		SELECT id, version, EXISTS (SELECT 1 FROM todos c WHERE c.parent_id = todos.id) AS subtasks
+		FROM todos WHERE previous_id = $1 FOR UPDATE`, id)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if next.Version != 1 || next.Subtasks {
+		return nil, ErrPreconditionFailed
+	}
+	if _, err := t.q.Exec("DELETE FROM todos WHERE id = $1", next.ID); err != nil {
+		return nil, err
+	}
+	return []int{next.ID}, t.recordEvent(next.ID, ActionPurged, 0, nil)
+}
+
+// updateSeries gives the other open occurrences of todo's series its
+// recurrence rule, so editing or stopping a series works from any of them.
+func (t todoRepository) updateSeries(todo Todo) error {
+	if todo.SeriesID == nil {
+		return nil
+	}
+	var updated []struct {
+		From string `db:"previous_recurrence"`
+		Todo
+	}
+	err := sqlx.Select(t.q, &updated, `
+		UPDATE todos t SET recurrence = $2
+		FROM todos prev
+		WHERE prev.id = t.id AND t.series_id = $1 AND t.id <> $3
+			AND NOT t.done AND t.deleted_at IS NULL AND t.recurrence <> $2
+		RETURNING prev.recurrence AS previous_recurrence, `+prefixColumns("t"),
+		*todo.SeriesID, todo.Recurrence, todo.ID)
+	if err != nil {
+		return err
+	}
+	for _, u := range updated {
+		changes := map[string]FieldChange{"recurrence": {From: u.From, To: u.Recurrence}}
+		if err := t.recordEvent(u.ID, ActionUpdated, u.Version, changes); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// GetNextOccurrence only finds an occurrence written after todo, so a todo
+// completed again does not report the occurrence its first completion made.
+func (t todoRepository) GetNextOccurrence(todo Todo) (Todo, error) {
+	var next Todo
+	err := sqlx.Get(t.q, &next, `
+		SELECT `+todoColumns+` FROM todos
+		WHERE previous_id = $1 AND revision > $2 AND deleted_at IS NULL`, todo.ID, todo.Revision)
+	if errors.Is(err, sql.ErrNoRows) {
+		return next, ErrTodoNotFound
+	}
+	return next, err
+}
@@ -0,0 +1,46 @@
+package main
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	requestIDHeader = "X-Request-ID"
+	requestIDKey    = "request_id"
+	maxRequestIDLen = 128
+)
+
+// RequestIDMiddleware tags every request with an ID, reusing the one sent
+// by a proxy or client when it looks sane, and echoes it in the response.
+func RequestIDMiddleware() gin.HandlerFunc {
+	return func(ctx *gin.Context) {
+		id := ctx.GetHeader(requestIDHeader)
+		if !validRequestID(id) {
+			id = newRequestID()
+		}
+		ctx.Set(requestIDKey, id)
+		ctx.Header(requestIDHeader, id)
+		ctx.Next()
+	}
+}
+
+func validRequestID(id string) bool {
+	if id == "" || len(id) > maxRequestIDLen {
+		return false
+	}
+	for _, r := range id {
+		if r < '!' || r > '~' {
+			return false
+		}
+	}
+	return true
+}
+
+func newRequestID() string {
+	b := make([]byte, 16)
+	_, _ = rand.Read(b)
+	return hex.EncodeToString(b)
+}
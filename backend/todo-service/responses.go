@@ -0,0 +1,614 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/go-playground/validator/v10"
+)
+
+const (
+	apiVersionKey      = "api_version"
+	problemContentType = "application/problem+json"
+	problemTypeBase    = "https://todo-app/problems/"
+)
+
+// Stable, machine-readable error codes returned in the "code" member of
+// problem responses. Clients may switch on these; never rename them.
+const (
+	CodeInvalidBody         = "invalid_body"
+	CodeInvalidID           = "invalid_id"
+	CodeValidationFailed    = "validation_failed"
+	CodeTaskEmpty           = "task_empty"
+	CodeTaskTooLong         = "task_too_long"
+	CodeTaskInvalid         = "task_invalid"
+	CodeTaskNotAllowed      = "task_not_allowed"
+	CodeTodoNotFound        = "todo_not_found"
+	CodeTodoNotTrashed      = "todo_not_trashed"
+	CodeParentNotFound      = "parent_not_found"
+	CodeParentCycle         = "parent_cycle"
+	CodeTodoBlocked         = "todo_blocked"
+	CodeBlockerNotFound     = "blocker_not_found"
+	CodeDependencyCycle     = "dependency_cycle"
+	CodeDependencyNotFound  = "dependency_not_found"
+	CodeMoveTargetNotFound  = "move_target_not_found"
+	CodeListNotFound        = "list_not_found"
+	CodeStatusNotFound      = "status_not_found"
+	CodeTransitionDenied    = "transition_not_allowed"
+	CodeWIPLimitReached     = "wip_limit_reached"
+	CodeStatusInUse         = "status_in_use"
+	CodeTemplateNotFound    = "template_not_found"
+	CodeFeedNotFound        = "feed_not_found"
+	CodeUndoTokenInvalid    = "undo_token_invalid"
+	CodeUndoConflict        = "undo_conflict"
+	CodeInvalidSyncToken    = "invalid_sync_token"
+	CodePreconditionFailed  = "precondition_failed"
+	CodePreconditionNeeded  = "precondition_required"
+	CodeIdempotencyInvalid  = "invalid_idempotency_key"
+	CodeIdempotencyReused   = "idempotency_key_reused"
+	CodeIdempotencyPending  = "idempotency_key_in_progress"
+	CodeArticleRepeated     = "article_repeated"
+	CodeUpstreamUnavailable = "upstream_unavailable"
+	CodeFeatureDisabled     = "feature_disabled"
+	CodeRateLimited         = "rate_limited"
+	CodeAPIKeyRequired      = "api_key_required"
+	CodeDatabaseUnavailable = "database_unavailable"
+	CodeInternal            = "internal_error"
+)
+
+type FieldError struct {
+	Field   string `json:"field"`
+	Code    string `json:"code"`
+	Message string `json:"message"`
+}
+
+// APIError is an error that knows how it should be presented to clients.
+// Cause is only ever logged, never serialised.
+type APIError struct {
+	Status int
+	// LegacyStatus is sent instead of Status on v1 routes, for errors v1
+	// answered with another status before v2 existed.
+	LegacyStatus int
+	Code         string
+	Title        string
+	Detail       string
+	Fields       []FieldError
+	Cause        error
+}
+
+func (e *APIError) Error() string {
+	if e.Cause != nil {
+		return fmt.Sprintf("%s: %v", e.Code, e.Cause)
+	}
+	return e.Code + ": " + e.Detail
+}
+
+func (e *APIError) Unwrap() error {
+	return e.Cause
+}
+
+type problem struct {
+	Type     string       `json:"type"`
+	Title    string       `json:"title"`
+	Status   int          `json:"status"`
+	Detail   string       `json:"detail,omitempty"`
+	Instance string       `json:"instance,omitempty"`
+	Code     string       `json:"code"`
+	Errors   []FieldError `json:"errors,omitempty"`
+}
+
+type envelope struct {
+	Data any `json:"data"`
+	Meta any `json:"meta,omitempty"`
+}
+
+// apiVersion marks every request in a route group with the API version
+// so the response helpers can pick the matching wire format.
+func apiVersion(version int) gin.HandlerFunc {
+	return func(ctx *gin.Context) {
+		ctx.Set(apiVersionKey, version)
+		ctx.Next()
+	}
+}
+
+func isV2(ctx *gin.Context) bool {
+	return ctx.GetInt(apiVersionKey) >= 2
+}
+
+// respond writes data inside the v2 success envelope, or legacy as-is for
+// the v1 API. A nil legacy body falls back to data.
+func respond(ctx *gin.Context, status int, data any, legacy any) {
+	if isV2(ctx) {
+		ctx.JSON(status, envelope{Data: data})
+		return
+	}
+	if legacy == nil {
+		legacy = data
+	}
+	ctx.JSON(status, legacy)
+}
+
+func respondWithMeta(ctx *gin.Context, status int, data any, meta any, legacy any) {
+	if isV2(ctx) {
+		ctx.JSON(status, envelope{Data: data, Meta: meta})
+		return
+	}
+	respond(ctx, status, data, legacy)
+}
+
+// respondError writes err as application/problem+json on v2 routes and as
+// the historical {"error": "..."} body on v1 routes.
+func respondError(ctx *gin.Context, err *APIError) {
+	if !isV2(ctx) {
+		message := err.Detail
+		if message == "" {
+			message = err.Title
+		}
+		status := err.Status
+		if err.LegacyStatus != 0 {
+			status = err.LegacyStatus
+		}
+		ctx.AbortWithStatusJSON(status, gin.H{"error": message})
+		return
+	}
+
+	body := problem{
+		Type:     problemTypeBase + err.Code,
+		Title:    err.Title,
+		Status:   err.Status,
+		Detail:   err.Detail,
+		Instance: ctx.Request.URL.Path,
+		Code:     err.Code,
+		Errors:   err.Fields,
+	}
+	// gin keeps a Content-Type that is already set, so the JSON render
+	// below is sent as problem+json.
+	ctx.Header("Content-Type", problemContentType)
+	ctx.AbortWithStatusJSON(err.Status, body)
+}
+
+func errInvalidBody(cause error) *APIError {
+	var validationErrs validator.ValidationErrors
+	if errors.As(cause, &validationErrs) {
+		fields := make([]FieldError, 0, len(validationErrs))
+		for _, fe := range validationErrs {
+			fields = append(fields, FieldError{
+				Field:   strings.ToLower(fe.Field()),
+				Code:    fe.Tag(),
+				Message: fmt.Sprintf("%s failed the %q rule", strings.ToLower(fe.Field()), fe.Tag()),
+			})
+		}
+		return &APIError{
+			Status: http.StatusBadRequest,
+			Code:   CodeValidationFailed,
+			Title:  "Validation failed",
+			Detail: "The request body has invalid fields",
+			Fields: fields,
+			Cause:  cause,
+		}
+	}
+	return &APIError{
+		Status: http.StatusBadRequest,
+		Code:   CodeInvalidBody,
+		Title:  "Invalid request body",
+		Detail: "The request body is not valid JSON",
+		Cause:  cause,
+	}
+}
+
+func errInvalidQuery(param, message string) *APIError {
+	return &APIError{
+		Status: http.StatusBadRequest,
+		Code:   CodeValidationFailed,
+		Title:  "Invalid query parameter",
+		Detail: message,
+		Fields: []FieldError{{Field: param, Code: "invalid", Message: message}},
+	}
+}
+
+func errInvalidField(field, message string) *APIError {
+	return &APIError{
+		Status: http.StatusBadRequest,
+		Code:   CodeValidationFailed,
+		Title:  "Validation failed",
+		Detail: message,
+		Fields: []FieldError{{Field: field, Code: "invalid", Message: message}},
+	}
+}
+
+func errTooManyItems(field string, max int) *APIError {
+	msg := fmt.Sprintf("At most %d %s are allowed per request", max, field)
+	return &APIError{
+		Status: http.StatusBadRequest,
+		Code:   CodeValidationFailed,
+		Title:  "Too many items",
+		Detail: msg,
+		Fields: []FieldError{{Field: field, Code: "max", Message: msg}},
+	}
+}
+
+func errInvalidID(raw string) *APIError {
+	return &APIError{
+		Status: http.StatusBadRequest,
+		Code:   CodeInvalidID,
+		Title:  "Invalid id parameter",
+		Detail: "Invalid id parameter",
+		Fields: []FieldError{{Field: "id", Code: "integer", Message: fmt.Sprintf("%q is not an integer id", raw)}},
+	}
+}
+
+func errTaskEmpty() *APIError {
+	return &APIError{
+		Status: http.StatusBadRequest,
+		Code:   CodeTaskEmpty,
+		Title:  "Task is empty",
+		Detail: "Task cannot be empty",
+		Fields: []FieldError{{Field: "task", Code: CodeTaskEmpty, Message: "Task cannot be empty"}},
+	}
+}
+
+func errTaskTooLong(maxLen int) *APIError {
+	msg := fmt.Sprintf("Task cannot exceed %d characters", maxLen)
+	return &APIError{
+		Status: http.StatusBadRequest,
+		Code:   CodeTaskTooLong,
+		Title:  "Task is too long",
+		Detail: msg,
+		Fields: []FieldError{{Field: "task", Code: CodeTaskTooLong, Message: msg}},
+	}
+}
+
+func errTaskInvalid(msg string) *APIError {
+	return &APIError{
+		Status: http.StatusBadRequest,
+		Code:   CodeTaskInvalid,
+		Title:  "Task is invalid",
+		Detail: msg,
+		Fields: []FieldError{{Field: "task", Code: CodeTaskInvalid, Message: msg}},
+	}
+}
+
+func errTaskNotAllowed() *APIError {
+	msg := "Task contains text that is not allowed"
+	return &APIError{
+		Status: http.StatusBadRequest,
+		Code:   CodeTaskNotAllowed,
+		Title:  "Task is not allowed",
+		Detail: msg,
+		Fields: []FieldError{{Field: "task", Code: CodeTaskNotAllowed, Message: msg}},
+	}
+}
+
+func errInvalidSyncToken() *APIError {
+	return &APIError{
+		Status: http.StatusBadRequest,
+		Code:   CodeInvalidSyncToken,
+		Title:  "Invalid sync token",
+		Detail: "The since token is not a token returned by this API",
+		Fields: []FieldError{{Field: "since", Code: CodeInvalidSyncToken, Message: "Unrecognised change token"}},
+	}
+}
+
+func errEmptyUpdate() *APIError {
+	return &APIError{
+		Status: http.StatusBadRequest,
+		Code:   CodeValidationFailed,
+		Title:  "Empty update",
+		Detail: "Provide at least one field to change",
+	}
+}
+
+func errMissingBaseRevision() *APIError {
+	return &APIError{
+		Status: http.StatusBadRequest,
+		Code:   CodeValidationFailed,
+		Title:  "Missing base revision",
+		Detail: "base_revision is required for update and delete",
+		Fields: []FieldError{{Field: "base_revision", Code: "required", Message: "base_revision is required"}},
+	}
+}
+
+func errPreconditionFailed(id int) *APIError {
+	return &APIError{
+		Status: http.StatusPreconditionFailed,
+		Code:   CodePreconditionFailed,
+		Title:  "Precondition failed",
+		Detail: fmt.Sprintf("Todo %d was changed by someone else; fetch it again and retry", id),
+	}
+}
+
+func errPreconditionRequired() *APIError {
+	return &APIError{
+		Status: http.StatusPreconditionRequired,
+		Code:   CodePreconditionNeeded,
+		Title:  "Precondition required",
+		Detail: "Send an If-Match header with the todo's ETag",
+	}
+}
+
+func errInvalidIdempotencyKey() *APIError {
+	return &APIError{
+		Status: http.StatusBadRequest,
+		Code:   CodeIdempotencyInvalid,
+		Title:  "Invalid idempotency key",
+		Detail: fmt.Sprintf("Idempotency-Key must be at most %d characters", maxIdempotencyKeyLen),
+	}
+}
+
+func errIdempotencyKeyReused() *APIError {
+	return &APIError{
+		Status: http.StatusUnprocessableEntity,
+		Code:   CodeIdempotencyReused,
+		Title:  "Idempotency key reused",
+		Detail: "This Idempotency-Key was already used with a different request body",
+	}
+}
+
+func errIdempotencyKeyInProgress() *APIError {
+	return &APIError{
+		Status: http.StatusConflict,
+		Code:   CodeIdempotencyPending,
+		Title:  "Request in progress",
+		Detail: "A request with this Idempotency-Key is still being processed",
+	}
+}
+
+func errTodoNotFound(id int) *APIError {
+	return &APIError{
+		Status: http.StatusNotFound,
+		Code:   CodeTodoNotFound,
+		Title:  "Todo not found",
+		Detail: fmt.Sprintf("Todo %d does not exist", id),
+	}
+}
+
+func errTodoNotTrashed(id int) *APIError {
+	return &APIError{
+		Status: http.StatusConflict,
+		Code:   CodeTodoNotTrashed,
+		Title:  "Todo not in trash",
+		Detail: fmt.Sprintf("Todo %d is not in the trash", id),
+	}
+}
+
+func errParentNotFound() *APIError {
+	return &APIError{
+		Status: http.StatusUnprocessableEntity,
+		Code:   CodeParentNotFound,
+		Title:  "Parent not found",
+		Detail: "The parent todo does not exist or is in the trash",
+		Fields: []FieldError{{Field: "parent_id", Code: CodeParentNotFound, Message: "Parent todo does not exist or is in the trash"}},
+	}
+}
+
+func errParentCycle() *APIError {
+	return &APIError{
+		Status: http.StatusUnprocessableEntity,
+		Code:   CodeParentCycle,
+		Title:  "Invalid parent",
+		Detail: "A todo cannot become a subtask of itself or of its own subtasks",
+		Fields: []FieldError{{Field: "parent_id", Code: CodeParentCycle, Message: "Parent is the todo itself or one of its subtasks"}},
+	}
+}
+
+func errTodoBlocked(id int) *APIError {
+	return &APIError{
+		Status: http.StatusConflict,
+		Code:   CodeTodoBlocked,
+		Title:  "Todo blocked",
+		Detail: fmt.Sprintf("Todo %d cannot be completed while it has open blockers", id),
+	}
+}
+
+func errMoveTargetNotFound() *APIError {
+	return &APIError{
+		Status: http.StatusUnprocessableEntity,
+		Code:   CodeMoveTargetNotFound,
+		Title:  "Move target not found",
+		Detail: "The todo to move next to does not exist or is in the trash",
+	}
+}
+
+func errBlockerNotFound(id int) *APIError {
+	return &APIError{
+		Status: http.StatusUnprocessableEntity,
+		Code:   CodeBlockerNotFound,
+		Title:  "Blocker not found",
+		Detail: fmt.Sprintf("Todo %d does not exist or is in the trash", id),
+		Fields: []FieldError{{Field: "blocker_id", Code: CodeBlockerNotFound, Message: "Blocker does not exist or is in the trash"}},
+	}
+}
+
+func errDependencyCycle(id, blockerID int) *APIError {
+	return &APIError{
+		Status: http.StatusUnprocessableEntity,
+		Code:   CodeDependencyCycle,
+		Title:  "Dependency cycle",
+		Detail: fmt.Sprintf("Blocking todo %d by todo %d would create a dependency cycle", id, blockerID),
+		Fields: []FieldError{{Field: "blocker_id", Code: CodeDependencyCycle, Message: "Blocker is this todo or depends on it"}},
+	}
+}
+
+func errDependencyNotFound(id, blockerID int) *APIError {
+	return &APIError{
+		Status: http.StatusNotFound,
+		Code:   CodeDependencyNotFound,
+		Title:  "Dependency not found",
+		Detail: fmt.Sprintf("Todo %d is not blocked by todo %d", id, blockerID),
+	}
+}
+
+func errListNotFound(id int) *APIError {
+	return &APIError{
+		Status: http.StatusNotFound,
+		Code:   CodeListNotFound,
+		Title:  "List not found",
+		Detail: fmt.Sprintf("List %d does not exist", id),
+	}
+}
+
+// errUnknownList is errListNotFound for a list named in a request body.
+func errUnknownList() *APIError {
+	return &APIError{
+		Status: http.StatusUnprocessableEntity,
+		Code:   CodeListNotFound,
+		Title:  "List not found",
+		Detail: "The list does not exist",
+		Fields: []FieldError{{Field: "list_id", Code: CodeListNotFound, Message: "List does not exist"}},
+	}
+}
+
+func errStatusNotFound() *APIError {
+	return &APIError{
+		Status: http.StatusUnprocessableEntity,
+		Code:   CodeStatusNotFound,
+		Title:  "Status not found",
+		Detail: "The status is not part of the list's workflow",
+		Fields: []FieldError{{Field: "status", Code: CodeStatusNotFound, Message: "Status is not part of the list's workflow"}},
+	}
+}
+
+func errTransitionDenied(id int) *APIError {
+	return &APIError{
+		Status: http.StatusConflict,
+		Code:   CodeTransitionDenied,
+		Title:  "Transition not allowed",
+		Detail: fmt.Sprintf("The workflow does not allow todo %d to move from its status to this one", id),
+	}
+}
+
+func errWIPLimitReached() *APIError {
+	return &APIError{
+		Status: http.StatusConflict,
+		Code:   CodeWIPLimitReached,
+		Title:  "WIP limit reached",
+		Detail: "The status already holds as many todos as its WIP limit allows",
+	}
+}
+
+func errStatusInUse(id int) *APIError {
+	return &APIError{
+		Status: http.StatusConflict,
+		Code:   CodeStatusInUse,
+		Title:  "Status in use",
+		Detail: fmt.Sprintf("Todos of list %d, including trashed ones, still have a status the workflow removes", id),
+	}
+}
+
+func errWorkflowBlocked(id int) *APIError {
+	return &APIError{
+		Status: http.StatusConflict,
+		Code:   CodeTodoBlocked,
+		Title:  "Todo blocked",
+		Detail: fmt.Sprintf("The workflow would complete todos of list %d that have open blockers", id),
+	}
+}
+
+func errTemplateNotFound(id int) *APIError {
+	return &APIError{
+		Status: http.StatusNotFound,
+		Code:   CodeTemplateNotFound,
+		Title:  "Template not found",
+		Detail: fmt.Sprintf("Template %d does not exist", id),
+	}
+}
+
+func errFeedNotFound() *APIError {
+	return &APIError{
+		Status: http.StatusNotFound,
+		Code:   CodeFeedNotFound,
+		Title:  "Calendar feed not found",
+		Detail: "The calendar feed does not exist or was revoked",
+	}
+}
+
+func errUndoTokenInvalid() *APIError {
+	return &APIError{
+		Status: http.StatusGone,
+		Code:   CodeUndoTokenInvalid,
+		Title:  "Undo token invalid",
+		Detail: "The undo token is unknown, already used or expired",
+	}
+}
+
+func errUndoConflict() *APIError {
+	return &APIError{
+		Status: http.StatusConflict,
+		Code:   CodeUndoConflict,
+		Title:  "Cannot undo",
+		Detail: "The todo has changed since this undo token was issued",
+	}
+}
+
+func errArticleRepeated() *APIError {
+	return &APIError{
+		Status: http.StatusBadRequest,
+		Code:   CodeArticleRepeated,
+		Title:  "Article repeated",
+		Detail: "Cannot read the same article twice",
+	}
+}
+
+func errUpstreamUnavailable(cause error) *APIError {
+	return &APIError{
+		Status:       http.StatusBadGateway,
+		LegacyStatus: http.StatusInternalServerError,
+		Code:         CodeUpstreamUnavailable,
+		Title:        "Upstream service unavailable",
+		Detail:       "Failed to fetch random article",
+		Cause:        cause,
+	}
+}
+
+func errFeatureDisabled(detail string) *APIError {
+	return &APIError{
+		Status:       http.StatusServiceUnavailable,
+		LegacyStatus: http.StatusInternalServerError,
+		Code:         CodeFeatureDisabled,
+		Title:        "Feature disabled",
+		Detail:       detail,
+	}
+}
+
+func errRateLimited(retryAfter time.Duration) *APIError {
+	return &APIError{
+		Status: http.StatusTooManyRequests,
+		Code:   CodeRateLimited,
+		Title:  "Too many requests",
+		Detail: fmt.Sprintf("Rate limit exceeded, retry in %d seconds", int(retryAfter.Seconds())),
+	}
+}
+
+func errAPIKeyRequired() *APIError {
+	return &APIError{
+		Status: http.StatusUnauthorized,
+		Code:   CodeAPIKeyRequired,
+		Title:  "API key required",
+		Detail: "Send a valid API key in the " + apiKeyHeader + " header",
+	}
+}
+
+func errDatabaseUnavailable(cause error) *APIError {
+	return &APIError{
+		Status:       http.StatusServiceUnavailable,
+		LegacyStatus: http.StatusInternalServerError,
+		Code:         CodeDatabaseUnavailable,
+		Title:        "Database unavailable",
+		Detail:       "Database is not reachable",
+		Cause:        cause,
+	}
+}
+
+func errInternal(cause error) *APIError {
+	return &APIError{
+		Status: http.StatusInternalServerError,
+		Code:   CodeInternal,
+		Title:  "Internal server error",
+		Detail: "An unexpected error occurred",
+		Cause:  cause,
+	}
+}
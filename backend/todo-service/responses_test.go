@@ -0,0 +1,117 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+)
+
+// versionedContext returns a context for a request to path on the given
+// API version.
+func versionedContext(version int, path string) (*gin.Context, *httptest.ResponseRecorder) {
+	gin.SetMode(gin.TestMode)
+	w := httptest.NewRecorder()
+	ctx, _ := gin.CreateTestContext(w)
+	ctx.Request = httptest.NewRequest(http.MethodGet, path, nil)
+	ctx.Set(apiVersionKey, version)
+	return ctx, w
+}
+
+func TestRespondErrorStatus(t *testing.T) {
+	tests := []struct {
+		name   string
+		err    *APIError
+		wantV1 int
+		wantV2 int
+	}{
+		{"database unavailable", errDatabaseUnavailable(errors.New("dial tcp: refused")), http.StatusInternalServerError, http.StatusServiceUnavailable},
+		{"upstream unavailable", errUpstreamUnavailable(errors.New("timeout")), http.StatusInternalServerError, http.StatusBadGateway},
+		{"feature disabled", errFeatureDisabled("Random todos are not configured"), http.StatusInternalServerError, http.StatusServiceUnavailable},
+		{"task empty", errTaskEmpty(), http.StatusBadRequest, http.StatusBadRequest},
+		{"internal", errInternal(errors.New("boom")), http.StatusInternalServerError, http.StatusInternalServerError},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx, w := versionedContext(1, "/api/todos/db-health")
+			respondError(ctx, tt.err)
+			if w.Code != tt.wantV1 {
+				t.Errorf("v1 status = %d, want %d", w.Code, tt.wantV1)
+			}
+
+			ctx, w = versionedContext(2, "/api/v2/todos/db-health")
+			respondError(ctx, tt.err)
+			if w.Code != tt.wantV2 {
+				t.Errorf("v2 status = %d, want %d", w.Code, tt.wantV2)
+			}
+		})
+	}
+}
+
+func TestRespondErrorBody(t *testing.T) {
+	ctx, w := versionedContext(1, "/api/todos")
+	respondError(ctx, errTaskTooLong(140))
+	var legacy map[string]string
+	if err := json.Unmarshal(w.Body.Bytes(), &legacy); err != nil {
+		t.Fatalf("v1 body %s: %v", w.Body, err)
+	}
+	if legacy["error"] != "Task cannot exceed 140 characters" || len(legacy) != 1 {
+		t.Errorf("v1 body = %v, want only the error message", legacy)
+	}
+
+	ctx, w = versionedContext(2, "/api/v2/todos")
+	respondError(ctx, errTaskTooLong(140))
+	if got := w.Header().Get("Content-Type"); got != problemContentType {
+		t.Errorf("v2 Content-Type = %q, want %q", got, problemContentType)
+	}
+	var body problem
+	if err := json.Unmarshal(w.Body.Bytes(), &body); err != nil {
+		t.Fatalf("v2 body %s: %v", w.Body, err)
+	}
+	if body.Type != problemTypeBase+CodeTaskTooLong || body.Code != CodeTaskTooLong || body.Status != http.StatusBadRequest {
+		t.Errorf("v2 problem = %+v", body)
+	}
+	if body.Instance != "/api/v2/todos" {
+		t.Errorf("instance = %q, want the request path", body.Instance)
+	}
+	if len(body.Errors) != 1 || body.Errors[0].Field != "task" {
+		t.Errorf("field errors = %+v, want one on task", body.Errors)
+	}
+}
+
+func TestRespondCause(t *testing.T) {
+	// The cause is logged, never sent.
+	ctx, w := versionedContext(2, "/api/v2/todos")
+	respondError(ctx, errInternal(errors.New("password authentication failed")))
+	var body map[string]any
+	if err := json.Unmarshal(w.Body.Bytes(), &body); err != nil {
+		t.Fatal(err)
+	}
+	if detail, _ := body["detail"].(string); detail == "" || detail == "password authentication failed" {
+		t.Errorf("detail = %q", detail)
+	}
+}
+
+func TestRespondEnvelope(t *testing.T) {
+	todo := Todo{ID: 1, Task: "Buy milk"}
+
+	ctx, w := versionedContext(1, "/api/todos/1")
+	respond(ctx, http.StatusOK, todo, gin.H{"Todo updated": todo})
+	var legacy map[string]Todo
+	if err := json.Unmarshal(w.Body.Bytes(), &legacy); err != nil || legacy["Todo updated"].ID != 1 {
+		t.Errorf("v1 body = %s, want the legacy shape", w.Body)
+	}
+
+	ctx, w = versionedContext(2, "/api/v2/todos/1")
+	respondWithMeta(ctx, http.StatusOK, todo, gin.H{"undo_token": "t"}, nil)
+	var body struct {
+		Data Todo              `json:"data"`
+		Meta map[string]string `json:"meta"`
+	}
+	if err := json.Unmarshal(w.Body.Bytes(), &body); err != nil || body.Data.ID != 1 || body.Meta["undo_token"] != "t" {
+		t.Errorf("v2 body = %s, want data and meta", w.Body)
+	}
+}
@@ -0,0 +1,73 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"unicode"
+)
+
+// maxSearchTerms bounds the tsquery a search builds.
+const maxSearchTerms = 20
+
+// searchTerm is one part of a search query. Every term has to match, or
+// for a negated term, must not.
+type searchTerm struct {
+	Text   string
+	Phrase bool
+	Prefix bool
+	Negate bool
+}
+
+// parseSearchQuery splits a search into terms:
+//
+//	rent            a word, matched after stemming
+//	"pay the rent"  a phrase, the words in this order
+//	inv*            words starting with inv
+//	-draft          todos without the word, phrase or prefix
+func parseSearchQuery(q string) ([]searchTerm, error) {
+	var terms []searchTerm
+	rest := strings.TrimSpace(q)
+	for rest != "" {
+		var term searchTerm
+		if strings.HasPrefix(rest, "-") {
+			term.Negate = true
+			rest = rest[1:]
+		}
+
+		if strings.HasPrefix(rest, `"`) {
+			// An unclosed quote runs to the end of the query.
+			phrase, after, _ := strings.Cut(rest[1:], `"`)
+			term.Text, term.Phrase, rest = strings.TrimSpace(phrase), true, after
+		} else {
+			word, after, _ := strings.Cut(rest, " ")
+			term.Text, rest = word, after
+			if strings.HasSuffix(word, "*") {
+				// to_tsquery gets a prefix term as query syntax, so only its
+				// letters and digits are kept.
+				term.Text = strings.Map(func(r rune) rune {
+					if unicode.IsLetter(r) || unicode.IsDigit(r) {
+						return r
+					}
+					return -1
+				}, word)
+				term.Prefix = true
+			}
+		}
+		rest = strings.TrimSpace(rest)
+
+		if term.Text != "" {
+			terms = append(terms, term)
+		}
+	}
+
+	if len(terms) > maxSearchTerms {
+		return nil, fmt.Errorf("at most %d search terms are allowed", maxSearchTerms)
+	}
+	for _, term := range terms {
+		if !term.Negate {
+			return terms, nil
+		}
+	}
+	return nil, errors.New("the search needs at least one word that is not excluded")
+}
@@ -0,0 +1,74 @@
+package main
+
+import (
+	"slices"
+	"strings"
+	"testing"
+)
+
+func TestParseSearchQuery(t *testing.T) {
+	tests := []struct {
+		query string
+		want  []searchTerm
+	}{
+		{"rent", []searchTerm{{Text: "rent"}}},
+		{"  pay   rent ", []searchTerm{{Text: "pay"}, {Text: "rent"}}},
+		{`"pay the rent" now`, []searchTerm{{Text: "pay the rent", Phrase: true}, {Text: "now"}}},
+		{`"pay the rent`, []searchTerm{{Text: "pay the rent", Phrase: true}}},
+		{"inv*", []searchTerm{{Text: "inv", Prefix: true}}},
+		{"rent -draft", []searchTerm{{Text: "rent"}, {Text: "draft", Negate: true}}},
+		{`rent -"first draft" -inv*`, []searchTerm{{Text: "rent"}, {Text: "first draft", Phrase: true, Negate: true}, {Text: "inv", Prefix: true, Negate: true}}},
+		{`rent "" - *`, []searchTerm{{Text: "rent"}}},
+		// Query syntax is plain text to a word or phrase and dropped from
+		// a prefix.
+		{`a&b|c !(x): 'y'`, []searchTerm{{Text: "a&b|c"}, {Text: "!(x):"}, {Text: "'y'"}}},
+		{`"it's (done) & gone"`, []searchTerm{{Text: "it's (done) & gone", Phrase: true}}},
+		{`in'v|o!(i):c&e*`, []searchTerm{{Text: "invoice", Prefix: true}}},
+	}
+	for _, tt := range tests {
+		got, err := parseSearchQuery(tt.query)
+		if err != nil {
+			t.Errorf("parseSearchQuery(%q): %v", tt.query, err)
+			continue
+		}
+		if !slices.Equal(got, tt.want) {
+			t.Errorf("parseSearchQuery(%q) = %+v, want %+v", tt.query, got, tt.want)
+		}
+	}
+}
+
+func TestParseSearchQueryRejects(t *testing.T) {
+	for _, query := range []string{
+		"",
+		"   ",
+		"-draft",
+		`-draft -"first draft" -inv*`,
+		"&|!():*'*",
+		strings.Repeat("word ", maxSearchTerms+1),
+	} {
+		if terms, err := parseSearchQuery(query); err == nil {
+			t.Errorf("parseSearchQuery(%q) = %+v, want an error", query, terms)
+		}
+	}
+}
+
+func TestSearchQuery(t *testing.T) {
+	terms, err := parseSearchQuery(`rent "it's & done" -a|b inv'o!ice*`)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	query, args := searchQuery(terms, 3)
+	wantQuery := "plainto_tsquery('english', $3)" +
+		" && phraseto_tsquery('english', $4)" +
+		" && !!plainto_tsquery('english', $5)" +
+		" && to_tsquery('english', $6 || ':*')"
+	if query != wantQuery {
+		t.Errorf("query = %s, want %s", query, wantQuery)
+	}
+	// The user's text only ever reaches the database as parameters.
+	wantArgs := []any{"rent", "it's & done", "a|b", "invoice"}
+	if !slices.Equal(args, wantArgs) {
+		t.Errorf("args = %q, want %q", args, wantArgs)
+	}
+}
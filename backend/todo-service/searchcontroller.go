@@ -0,0 +1,113 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+	"github.com/rs/zerolog/log"
+)
+
+const (
+	defaultSearchLimit = 50
+	maxSearchLimit     = 200
+	maxSearchLength    = 200
+)
+
+func (c *TodosController) searchTodos(ctx *gin.Context) {
+	search, apiErr := parseTodoSearch(ctx)
+	if apiErr != nil {
+		respondError(ctx, apiErr)
+		return
+	}
+
+	results, err := c.repo.SearchTodos(search)
+	if err != nil {
+		log.Error().Err(err).Msg("Failed to search todos")
+		respondError(ctx, errInternal(err))
+		return
+	}
+
+	meta := gin.H{"count": len(results)}
+	if len(results) == search.Limit {
+		meta["next_offset"] = search.Offset + len(results)
+	}
+
+	log.Info().
+		Str("path", ctx.FullPath()).
+		Str("q", ctx.Query("q")).
+		Int("count", len(results)).
+		Msg("Todos searched")
+	respondWithMeta(ctx, http.StatusOK, results, meta, gin.H{"results": results, "meta": meta})
+}
+
+func parseTodoSearch(ctx *gin.Context) (TodoSearch, *APIError) {
+	search := TodoSearch{Limit: defaultSearchLimit}
+
+	q := ctx.Query("q")
+	if len([]rune(q)) > maxSearchLength {
+		return search, errInvalidQuery("q", fmt.Sprintf("q must be at most %d characters", maxSearchLength))
+	}
+	terms, err := parseSearchQuery(q)
+	if err != nil {
+		return search, errInvalidQuery("q", err.Error())
+	}
+	search.Terms = terms
+
+	filter, apiErr := parseTodoFilter(ctx)
+	if apiErr != nil {
+		return search, apiErr
+	}
+	search.TodoFilter = filter
+
+	if raw := ctx.Query("limit"); raw != "" {
+		if search.Limit, err = strconv.Atoi(raw); err != nil || search.Limit <= 0 || search.Limit > maxSearchLimit {
+			return search, errInvalidQuery("limit", fmt.Sprintf("limit must be between 1 and %d", maxSearchLimit))
+		}
+	}
+	if raw := ctx.Query("offset"); raw != "" {
+		if search.Offset, err = strconv.Atoi(raw); err != nil || search.Offset < 0 {
+			return search, errInvalidQuery("offset", "offset must be a non-negative integer")
+		}
+	}
+	return search, nil
+}
+
+// parseTodoFilter reads the done, tag, list_id, due_from and due_to
+// parameters shared by search and export.
+func parseTodoFilter(ctx *gin.Context) (TodoFilter, *APIError) {
+	var filter TodoFilter
+	if raw := ctx.Query("done"); raw != "" {
+		done, err := strconv.ParseBool(raw)
+		if err != nil {
+			return filter, errInvalidQuery("done", "done must be true or false")
+		}
+		filter.Done = &done
+	}
+	if raw := ctx.QueryArray("tag"); len(raw) > 0 {
+		tags, err := normalizeTags(raw)
+		if err != nil {
+			return filter, errInvalidQuery("tag", err.Error())
+		}
+		filter.Tags = tags
+	}
+	if raw := ctx.Query("list_id"); raw != "" {
+		id, err := strconv.Atoi(raw)
+		if err != nil || id <= 0 {
+			return filter, errInvalidQuery("list_id", "list_id must be a positive integer")
+		}
+		filter.ListID = &id
+	}
+	var apiErr *APIError
+	if filter.DueFrom, apiErr = timeQuery(ctx, "due_from"); apiErr != nil {
+		return filter, apiErr
+	}
+	if filter.DueTo, apiErr = timeQuery(ctx, "due_to"); apiErr != nil {
+		return filter, apiErr
+	}
+	if filter.DueFrom != nil && filter.DueTo != nil && !filter.DueTo.After(*filter.DueFrom) {
+		return filter, errInvalidQuery("due_to", "due_to must be after due_from")
+	}
+	return filter, nil
+}
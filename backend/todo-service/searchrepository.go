@@ -0,0 +1,98 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/lib/pq"
+)
+
+// TodoFilter narrows searches and exports. Nil fields do not filter, and
+// todos in the trash are never included.
+type TodoFilter struct {
+	Done   *bool
+	Tags   []string
+	ListID *int
+	// DueFrom and DueTo bound due_at, inclusive and exclusive. Either
+	// excludes todos without a due date.
+	DueFrom *time.Time
+	DueTo   *time.Time
+}
+
+// filterArgs returns the five parameters filterConditions reads, in order.
+func (f TodoFilter) filterArgs() []any {
+	var tags any
+	if len(f.Tags) > 0 {
+		tags = pq.Array(f.Tags)
+	}
+	return []any{f.Done, tags, f.ListID, f.DueFrom, f.DueTo}
+}
+
+// filterConditions applies a TodoFilter to the todos aliased t, reading
+// filterArgs as $1 to $5.
+const filterConditions = `($1::boolean IS NULL OR t.done = $1)
+			AND ($2::text[] IS NULL OR t.tags @> $2)
+			AND ($3::integer IS NULL OR t.list_id = $3)
+			AND ($4::timestamptz IS NULL OR t.due_at >= $4)
+			AND ($5::timestamptz IS NULL OR t.due_at < $5)`
+
+// TodoSearch is a full-text search with optional filters.
+type TodoSearch struct {
+	TodoFilter
+	Terms  []searchTerm
+	Limit  int
+	Offset int
+}
+
+// SearchResult is a todo found by a search. Snippet is the task, HTML
+// escaped, with the matching words in <mark> elements.
+type SearchResult struct {
+	Todo
+	Rank    float64 `json:"rank" db:"rank"`
+	Snippet string  `json:"snippet" db:"snippet"`
+}
+
+// searchQuery builds the tsquery for terms. The text of each term is passed
+// as a parameter, numbered from first.
+func searchQuery(terms []searchTerm, first int) (string, []any) {
+	parts := make([]string, len(terms))
+	args := make([]any, len(terms))
+	for i, term := range terms {
+		param := fmt.Sprintf("$%d", first+i)
+		switch {
+		case term.Phrase:
+			parts[i] = "phraseto_tsquery('english', " + param + ")"
+		case term.Prefix:
+			parts[i] = "to_tsquery('english', " + param + " || ':*')"
+		default:
+			parts[i] = "plainto_tsquery('english', " + param + ")"
+		}
+		if term.Negate {
+			parts[i] = "!!" + parts[i]
+		}
+		args[i] = term.Text
+	}
+	return strings.Join(parts, " && "), args
+}
+
+func (t todoRepository) SearchTodos(search TodoSearch) ([]SearchResult, error) {
+	query, terms := searchQuery(search.Terms, 8)
+	args := append(append(search.filterArgs(), search.Limit, search.Offset), terms...)
+
+	results := make([]SearchResult, 0)
+	err := sqlx.Select(t.q, &results, `
+		WITH search AS (SELECT `+query+` AS query)
+		SELECT `+prefixColumns("t")+`,
+			ts_rank_cd(t.search_vector, search.query) AS rank,
+			ts_headline('english',
+				replace(replace(replace(t.task, '&', '&amp;'), '<', '&lt;'), '>', '&gt;'),
+				search.query, 'HighlightAll=true, StartSel=<mark>, StopSel=</mark>') AS snippet
+		FROM todos t, search
+		WHERE t.search_vector @@ search.query AND t.deleted_at IS NULL
+			AND `+filterConditions+`
+		ORDER BY rank DESC, t.id
+		LIMIT $6 OFFSET $7`, args...)
+	return results, err
+}
@@ -0,0 +1,261 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"cors"
+	"github.com/gin-gonic/gin"
+	"github.com/rs/zerolog/log"
+	"golang.org/x/net/websocket"
+)
+
+// resetEvent tells a client that the events after its Last-Event-ID are no
+// longer buffered and it has to refetch the full list.
+const resetEvent = "reset"
+
+type StreamEvent struct {
+	ID   uint64          `json:"id"`
+	Type string          `json:"type"`
+	Data json.RawMessage `json:"data,omitempty"`
+}
+
+type streamClient struct {
+	events chan StreamEvent
+}
+
+// StreamHub fans todo events out to connected stream clients and keeps
+// the most recent ones so reconnecting clients can resume.
+type StreamHub struct {
+	mu     sync.Mutex
+	lastID uint64
+	// buffer is a ring of the last len(buffer) events, the event with ID n
+	// at index (n-1) % len(buffer).
+	buffer       []StreamEvent
+	clientBuffer int
+	clients      map[*streamClient]struct{}
+}
+
+func NewStreamHub(bufferSize, clientBuffer int) *StreamHub {
+	return &StreamHub{
+		buffer:       make([]StreamEvent, bufferSize),
+		clientBuffer: clientBuffer,
+		clients:      make(map[*streamClient]struct{}),
+	}
+}
+
+func (h *StreamHub) slot(id uint64) *StreamEvent {
+	return &h.buffer[(id-1)%uint64(len(h.buffer))]
+}
+
+func (h *StreamHub) Broadcast(eventType string, data []byte) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	h.lastID++
+	event := StreamEvent{ID: h.lastID, Type: eventType, Data: data}
+
+	*h.slot(event.ID) = event
+
+	for client := range h.clients {
+		select {
+		case client.events <- event:
+		default:
+			// The client is not keeping up. Dropping it lets it reconnect
+			// with Last-Event-ID instead of stalling every other client.
+			log.Warn().Uint64("event_id", event.ID).Msg("Stream client too slow, disconnecting")
+			h.removeLocked(client)
+		}
+	}
+}
+
+// Subscribe registers a client and returns the buffered events it missed
+// since lastEventID. If those events have already been evicted, or
+// lastEventID is ahead of the hub because it was seen before a restart or
+// on another replica, the backlog is a single reset event.
+func (h *StreamHub) Subscribe(lastEventID uint64) (*streamClient, []StreamEvent) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	client := &streamClient{events: make(chan StreamEvent, h.clientBuffer)}
+	h.clients[client] = struct{}{}
+
+	if lastEventID == 0 || lastEventID == h.lastID {
+		return client, nil
+	}
+	oldest := uint64(1)
+	if size := uint64(len(h.buffer)); h.lastID > size {
+		oldest = h.lastID - size + 1
+	}
+	if lastEventID > h.lastID || lastEventID+1 < oldest {
+		return client, []StreamEvent{{ID: h.lastID, Type: resetEvent}}
+	}
+
+	backlog := make([]StreamEvent, 0, h.lastID-lastEventID)
+	for id := lastEventID + 1; id <= h.lastID; id++ {
+		backlog = append(backlog, *h.slot(id))
+	}
+	return client, backlog
+}
+
+func (h *StreamHub) Unsubscribe(client *streamClient) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.removeLocked(client)
+}
+
+func (h *StreamHub) removeLocked(client *streamClient) {
+	if _, ok := h.clients[client]; ok {
+		delete(h.clients, client)
+		close(client.events)
+	}
+}
+
+type StreamController struct {
+	hub       *StreamHub
+	heartbeat time.Duration
+	origins   cors.OriginMatcher
+}
+
+func NewStreamController(hub *StreamHub, heartbeat time.Duration, allowedOrigins []string) *StreamController {
+	return &StreamController{
+		hub:       hub,
+		heartbeat: heartbeat,
+		origins:   cors.NewOriginMatcher(allowedOrigins),
+	}
+}
+
+// lastEventID reads the resume position from the Last-Event-ID header sent
+// by EventSource on reconnect, or from a query parameter for first connects
+// and WebSocket clients, which cannot set headers.
+func lastEventID(ctx *gin.Context) uint64 {
+	raw := ctx.GetHeader("Last-Event-ID")
+	if raw == "" {
+		raw = ctx.Query("last_event_id")
+	}
+	id, _ := strconv.ParseUint(raw, 10, 64)
+	return id
+}
+
+func (c *StreamController) streamTodos(ctx *gin.Context) {
+	client, backlog := c.hub.Subscribe(lastEventID(ctx))
+	defer c.hub.Unsubscribe(client)
+
+	ctx.Header("Content-Type", "text/event-stream")
+	ctx.Header("Cache-Control", "no-cache")
+	ctx.Header("Connection", "keep-alive")
+	ctx.Header("X-Accel-Buffering", "no")
+	ctx.Status(http.StatusOK)
+
+	log.Info().
+		Str("path", ctx.FullPath()).
+		Int("backlog", len(backlog)).
+		Msg("Stream client connected")
+
+	for _, event := range backlog {
+		if !writeSSE(ctx, event) {
+			return
+		}
+	}
+	ctx.Writer.Flush()
+
+	heartbeat := time.NewTicker(c.heartbeat)
+	defer heartbeat.Stop()
+
+	for {
+		select {
+		case <-ctx.Request.Context().Done():
+			return
+		case event, ok := <-client.events:
+			if !ok {
+				return
+			}
+			if !writeSSE(ctx, event) {
+				return
+			}
+		case <-heartbeat.C:
+			if _, err := fmt.Fprint(ctx.Writer, ": heartbeat\n\n"); err != nil {
+				return
+			}
+		}
+		ctx.Writer.Flush()
+	}
+}
+
+func writeSSE(ctx *gin.Context, event StreamEvent) bool {
+	data := event.Data
+	if data == nil {
+		data = json.RawMessage("{}")
+	}
+	_, err := fmt.Fprintf(ctx.Writer, "id: %d\nevent: %s\ndata: %s\n\n", event.ID, event.Type, data)
+	return err == nil
+}
+
+func (c *StreamController) streamTodosWebSocket(ctx *gin.Context) {
+	resumeFrom := lastEventID(ctx)
+
+	server := websocket.Server{
+		// Browsers do not apply CORS to WebSockets, so the allow-list is
+		// enforced here. Non-browser clients send no Origin at all.
+		Handshake: func(config *websocket.Config, req *http.Request) error {
+			origin := req.Header.Get("Origin")
+			if origin != "" && !c.origins.Allows(origin) {
+				return fmt.Errorf("origin %q not allowed", origin)
+			}
+			return nil
+		},
+		Handler: func(ws *websocket.Conn) {
+			c.serveWebSocket(ws, resumeFrom)
+		},
+	}
+	server.ServeHTTP(ctx.Writer, ctx.Request)
+}
+
+func (c *StreamController) serveWebSocket(ws *websocket.Conn, resumeFrom uint64) {
+	defer func() {
+		if err := ws.Close(); err != nil {
+			log.Debug().Err(err).Msg("Error closing WebSocket")
+		}
+	}()
+
+	client, backlog := c.hub.Subscribe(resumeFrom)
+	defer c.hub.Unsubscribe(client)
+
+	// The stream is server-to-client only; reading just detects when the
+	// client goes away.
+	closed := make(chan struct{})
+	go func() {
+		defer close(closed)
+		var discard string
+		for websocket.Message.Receive(ws, &discard) == nil {
+		}
+	}()
+
+	for _, event := range backlog {
+		if websocket.JSON.Send(ws, event) != nil {
+			return
+		}
+	}
+
+	heartbeat := time.NewTicker(c.heartbeat)
+	defer heartbeat.Stop()
+
+	for {
+		select {
+		case <-closed:
+			return
+		case event, ok := <-client.events:
+			if !ok || websocket.JSON.Send(ws, event) != nil {
+				return
+			}
+		case <-heartbeat.C:
+			if websocket.JSON.Send(ws, StreamEvent{Type: "heartbeat"}) != nil {
+				return
+			}
+		}
+	}
+}
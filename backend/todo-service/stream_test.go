@@ -0,0 +1,119 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// eventIDs lists the IDs of events, with "reset@n" for a reset at ID n.
+func eventIDs(events []StreamEvent) string {
+	ids := make([]string, len(events))
+	for i, event := range events {
+		ids[i] = fmt.Sprint(event.ID)
+		if event.Type == resetEvent {
+			ids[i] = fmt.Sprintf("reset@%d", event.ID)
+		}
+	}
+	return strings.Join(ids, " ")
+}
+
+func TestStreamHubBacklog(t *testing.T) {
+	hub := NewStreamHub(4, 10)
+	for i := 1; i <= 6; i++ {
+		hub.Broadcast("todo.updated", []byte(fmt.Sprintf(`{"id":%d}`, i)))
+	}
+
+	tests := []struct {
+		name        string
+		lastEventID uint64
+		want        string
+	}{
+		{"first connect", 0, ""},
+		{"up to date", 6, ""},
+		{"resume from the oldest buffered", 2, "3 4 5 6"},
+		{"resume in the middle", 4, "5 6"},
+		{"evicted", 1, "reset@6"},
+		// IDs from before a restart, or from another replica.
+		{"ahead of the hub", 9, "reset@6"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			client, backlog := hub.Subscribe(tt.lastEventID)
+			defer hub.Unsubscribe(client)
+			if got := eventIDs(backlog); got != tt.want {
+				t.Errorf("Subscribe(%d) backlog = %q, want %q", tt.lastEventID, got, tt.want)
+			}
+		})
+	}
+
+	client, backlog := hub.Subscribe(4)
+	defer hub.Unsubscribe(client)
+	if string(backlog[0].Data) != `{"id":5}` {
+		t.Errorf("backlog data = %s, want the event broadcast with ID 5", backlog[0].Data)
+	}
+}
+
+func TestStreamHubResetAfterRestart(t *testing.T) {
+	// A fresh hub has sent nothing, so any Last-Event-ID is from before.
+	hub := NewStreamHub(4, 10)
+	client, backlog := hub.Subscribe(42)
+	defer hub.Unsubscribe(client)
+	if got := eventIDs(backlog); got != "reset@0" {
+		t.Errorf("backlog = %q, want a reset", got)
+	}
+}
+
+func TestStreamHubBroadcast(t *testing.T) {
+	hub := NewStreamHub(4, 1)
+	fast, _ := hub.Subscribe(0)
+	defer hub.Unsubscribe(fast)
+	slow, _ := hub.Subscribe(0)
+
+	hub.Broadcast("todo.created", nil)
+	if event := <-fast.events; event.ID != 1 || event.Type != "todo.created" {
+		t.Errorf("event = %+v", event)
+	}
+	// slow has not read its event, so its buffer of one is full.
+	hub.Broadcast("todo.deleted", nil)
+	if event := <-fast.events; event.ID != 2 {
+		t.Errorf("event = %+v", event)
+	}
+
+	<-slow.events
+	if _, ok := <-slow.events; ok {
+		t.Error("slow client still subscribed, want it disconnected")
+	}
+}
+
+func TestStreamTodosSSE(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	hub := NewStreamHub(4, 10)
+	hub.Broadcast("todo.created", []byte(`{"id":1}`))
+	hub.Broadcast("todo.deleted", nil)
+	streams := NewStreamController(hub, time.Hour, nil)
+	router := gin.New()
+	router.GET("/api/todos/stream", streams.streamTodos)
+
+	// The request is cancelled before it starts, so only the backlog is
+	// written.
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	req := httptest.NewRequestWithContext(ctx, http.MethodGet, "/api/todos/stream?last_event_id=1", nil)
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+
+	if got := w.Header().Get("Content-Type"); got != "text/event-stream" {
+		t.Errorf("Content-Type = %q", got)
+	}
+	want := "id: 2\nevent: todo.deleted\ndata: {}\n\n"
+	if w.Body.String() != want {
+		t.Errorf("body = %q, want %q", w.Body, want)
+	}
+}
@@ -0,0 +1,90 @@
+package main
+
+import (
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/rs/zerolog/log"
+)
+
+// TodoTree is a todo with its subtasks nested below it. Progress is the
+// percentage of direct subtasks that are done and is omitted for leaves.
+type TodoTree struct {
+	Todo
+	Progress *int        `json:"progress,omitempty"`
+	Children []*TodoTree `json:"children"`
+}
+
+func (c *TodosController) getTodoTree(ctx *gin.Context, id int) {
+	todos, err := c.repo.GetSubtree(id)
+	if err != nil {
+		c.respondTodoError(ctx, id, err, "get todo tree")
+		return
+	}
+
+	log.Info().
+		Str("path", ctx.FullPath()).
+		Int("id", id).
+		Int("count", len(todos)).
+		Msg("Todo tree received")
+
+	respond(ctx, http.StatusOK, buildTodoTree(todos), nil)
+}
+
+// buildTodoTree nests todos under their parents. todos must start with the
+// root and list every parent before its subtasks, as GetSubtree does.
+func buildTodoTree(todos []Todo) *TodoTree {
+	nodes := make(map[int]*TodoTree, len(todos))
+	var root *TodoTree
+	for _, todo := range todos {
+		node := &TodoTree{Todo: todo, Children: make([]*TodoTree, 0)}
+		nodes[todo.ID] = node
+		if root == nil {
+			root = node
+			continue
+		}
+		if parent := nodes[*todo.ParentID]; parent != nil {
+			parent.Children = append(parent.Children, node)
+		}
+	}
+	for _, node := range nodes {
+		if len(node.Children) == 0 {
+			continue
+		}
+		done := 0
+		for _, child := range node.Children {
+			if child.Done {
+				done++
+			}
+		}
+		progress := done * 100 / len(node.Children)
+		node.Progress = &progress
+	}
+	return root
+}
+
+// publishSubtasks sends subject for each subtask that was trashed or
+// restored along with its parent. Like the parent's, deletes only carry the
+// ID.
+func (c *TodosController) publishSubtasks(subject string, subtasks []Todo) {
+	for _, subtask := range subtasks {
+		if subject == "todo.deleted" {
+			subtask = Todo{ID: subtask.ID}
+		}
+		c.sendNatsMessage(subject, subtask)
+	}
+}
+
+// publishAncestors sends the events of the ancestors that completed along
+// with a todo: each ancestor, the todos it unblocked and the occurrences
+// scheduled for them.
+func (c *TodosController) publishAncestors(completion Completion) {
+	for _, ancestor := range completion.Ancestors {
+		c.sendNatsMessage("todo.updated", ancestor)
+		c.publishStatusChange(ancestor)
+		c.publishUnblocked(ancestor)
+	}
+	for _, next := range completion.Occurrences {
+		c.sendNatsMessage("todo.created", next)
+	}
+}
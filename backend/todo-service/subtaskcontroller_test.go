@@ -0,0 +1,160 @@
+package main
+
+import (
+	"net/http"
+	"slices"
+	"strconv"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+)
+
+// addSubtree creates todo 1 with subtask 2, which has subtask 3, and a
+// separate todo 4.
+func addSubtree(t *testing.T, repo TodoRepository) {
+	t.Helper()
+	parents := []*int{nil, nil, new(int), new(int)}
+	*parents[2], *parents[3] = 1, 2
+	for _, parentID := range parents[1:] {
+		if _, err := repo.AddTodo(NewTodo{Task: "Move house", ParentID: parentID}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if _, err := repo.AddTodo(NewTodo{Task: "Water plants"}); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// todoContext returns a context for a v2 request to the todo with id.
+func todoContext(method string, id int) *gin.Context {
+	ctx, _ := versionedContext(2, "/api/v2/todos/"+strconv.Itoa(id))
+	ctx.Request.Method = method
+	ctx.Params = gin.Params{{Key: "id", Value: strconv.Itoa(id)}}
+	return ctx
+}
+
+func TestDeleteAndRestorePublishSubtaskEvents(t *testing.T) {
+	repo := newMemoryRepository()
+	addSubtree(t, repo)
+	c, events := newTestController(t, repo)
+
+	c.deleteTodo(todoContext(http.MethodDelete, 1))
+	want := []string{"todo.deleted 1", "todo.deleted 2", "todo.deleted 3"}
+	if got := publishedEvents(events); !slices.Equal(got, want) {
+		t.Errorf("delete events = %v, want %v", got, want)
+	}
+
+	c.restoreTodo(todoContext(http.MethodPost, 1))
+	want = []string{"todo.restored 1", "todo.restored 2", "todo.restored 3"}
+	if got := publishedEvents(events); !slices.Equal(got, want) {
+		t.Errorf("restore events = %v, want %v", got, want)
+	}
+}
+
+func TestSyncDeletePublishesSubtaskEvents(t *testing.T) {
+	repo := newMemoryRepository()
+	addSubtree(t, repo)
+	c, events := newTestController(t, repo)
+
+	c.applySyncMutation(repo, syncMutation{Op: "delete", ID: 1, BaseRevision: repo.todos[1].Revision})
+	want := []string{"todo.deleted 1", "todo.deleted 2", "todo.deleted 3"}
+	if got := publishedEvents(events); !slices.Equal(got, want) {
+		t.Errorf("events = %v, want %v", got, want)
+	}
+}
+
+func TestBulkDeleteCollectsSubtaskEvents(t *testing.T) {
+	repo := newMemoryRepository()
+	addSubtree(t, repo)
+	c, _ := newTestController(t, repo)
+
+	events := newBulkEvents()
+	var result bulkResult
+	if err := c.applyBulkOperation(repo, bulkOperation{Op: "delete", ID: 1}, &result, events); err != nil {
+		t.Fatal(err)
+	}
+	if !slices.Equal(events.order, []int{2, 3, 1}) {
+		t.Errorf("events for %v, want the subtasks and the todo", events.order)
+	}
+	for _, id := range events.order {
+		if events.subjects[id] != "todo.deleted" {
+			t.Errorf("event for %d = %q, want todo.deleted", id, events.subjects[id])
+		}
+	}
+}
+
+func TestCompletingLastSubtaskPublishesAncestors(t *testing.T) {
+	tests := []struct {
+		name  string
+		write func(c *TodosController, repo *memoryRepository)
+		first string
+	}{
+		{"complete", func(c *TodosController, _ *memoryRepository) {
+			c.markTodoDone(todoContext(http.MethodPut, 3))
+		}, "todo.updated 3"},
+		{"update", func(c *TodosController, _ *memoryRepository) {
+			ctx, _ := postContext("/api/v2/todos/3", `{"done": true}`)
+			ctx.Request.Method = http.MethodPatch
+			ctx.Params = gin.Params{{Key: "id", Value: "3"}}
+			c.updateTodo(ctx)
+		}, "todo.updated 3"},
+		{"delete", func(c *TodosController, _ *memoryRepository) {
+			c.deleteTodo(todoContext(http.MethodDelete, 3))
+		}, "todo.deleted 3"},
+		{"sync update", func(c *TodosController, repo *memoryRepository) {
+			c.applySyncMutation(repo, syncMutation{Op: "update", ID: 3, BaseRevision: repo.todos[3].Revision, Done: ptr(true)})
+		}, "todo.updated 3"},
+		{"sync delete", func(c *TodosController, repo *memoryRepository) {
+			c.applySyncMutation(repo, syncMutation{Op: "delete", ID: 3, BaseRevision: repo.todos[3].Revision})
+		}, "todo.deleted 3"},
+		{"bulk", func(c *TodosController, _ *memoryRepository) {
+			ctx, _ := postContext("/api/v2/todos/bulk", `{"operations": [{"op": "complete", "id": 3}]}`)
+			c.bulkTodos(ctx)
+		}, "todo.updated 3"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// 1 and 2 complete along with their last open subtask, 3.
+			repo := newMemoryRepository()
+			for _, n := range []NewTodo{
+				{Task: "Move house", AutoComplete: true},
+				{Task: "Pack boxes", ParentID: ptr(1), AutoComplete: true},
+				{Task: "Buy tape", ParentID: ptr(2)},
+			} {
+				if _, err := repo.AddTodo(n); err != nil {
+					t.Fatal(err)
+				}
+			}
+			c, events := newTestController(t, repo)
+
+			tt.write(c, repo)
+			want := []string{tt.first, "todo.updated 2", "todo.updated 1"}
+			if got := publishedEvents(events); !slices.Equal(got, want) {
+				t.Errorf("events = %v, want %v", got, want)
+			}
+			if !repo.todos[1].Done || !repo.todos[2].Done {
+				t.Error("the ancestors are still open")
+			}
+		})
+	}
+}
+
+func TestUndoPublishesReopenedAncestors(t *testing.T) {
+	repo := newMemoryRepository()
+	c, events := newTestController(t, repo)
+	// Undoing the completion of 3, which completed 2 and then 1.
+	repo.undone = []UndoResult{{Action: ActionUpdated, Todo: Todo{ID: 3, Version: 3},
+		Reopened: []Todo{{ID: 2, Version: 3}, {ID: 1, Version: 3}}}}
+
+	ctx, w := postContext("/api/v2/undo/undo-3-2", "")
+	ctx.Params = gin.Params{{Key: "token", Value: "undo-3-2"}}
+	c.undo(ctx)
+
+	if w.Code != http.StatusOK {
+		t.Fatalf("status = %d, want 200", w.Code)
+	}
+	want := []string{"todo.updated 3", "todo.updated 2", "todo.updated 1"}
+	if got := publishedEvents(events); !slices.Equal(got, want) {
+		t.Errorf("events = %v, want %v", got, want)
+	}
+}
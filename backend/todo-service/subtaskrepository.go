@@ -0,0 +1,164 @@
+package main
+
+import (
+	"database/sql"
+	"errors"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+)
+
+// Completion is what completing a todo did to other todos: the
+// auto-complete ancestors it completed, nearest first, and the occurrences
+// scheduled for them.
+type Completion struct {
+	Ancestors   []Todo
+	Occurrences []Todo
+}
+
+// completedAncestor is an ancestor as the event of the todo that completed
+// it records it: at the version completing gave it.
+type completedAncestor struct {
+	ID      int   `json:"id"`
+	Version int64 `json:"version"`
+}
+
+// changes records the ancestors on the event of the todo that completed
+// them, so undoing that change reopens them.
+func (c Completion) changes() map[string]FieldChange {
+	if len(c.Ancestors) == 0 {
+		return nil
+	}
+	ancestors := make([]completedAncestor, len(c.Ancestors))
+	for i, ancestor := range c.Ancestors {
+		ancestors[i] = completedAncestor{ID: ancestor.ID, Version: ancestor.Version}
+	}
+	return map[string]FieldChange{"completed_ancestors": {To: ancestors}}
+}
+
+// checkParent verifies that parentID is a live todo and that nesting id
+// under it would not create a cycle. id is zero for a todo not yet created.
+// Tree changes are serialized so two concurrent moves cannot form a cycle
+// that neither would on its own.
+func (t todoRepository) checkParent(id, parentID int) error {
+	if _, err := t.q.Exec("SELECT pg_advisory_xact_lock(hashtext('todo_tree'))"); err != nil {
+		return err
+	}
+	ancestors := make([]int, 0)
+	err := sqlx.Select(t.q, &ancestors, `
+		WITH RECURSIVE ancestors AS (
+			SELECT id, parent_id FROM todos WHERE id = $1 AND deleted_at IS NULL
+			UNION
+			SELECT t.id, t.parent_id FROM todos t
+			JOIN ancestors a ON t.id = a.parent_id
+		)
+		SELECT id FROM ancestors`, parentID)
+	if err != nil {
+		return err
+	}
+	if len(ancestors) == 0 {
+		return ErrParentNotFound
+	}
+	for _, ancestor := range ancestors {
+		if ancestor == id {
+			return ErrParentCycle
+		}
+	}
+	return nil
+}
+
+// completeAncestors marks parentID done when it opted into auto_complete and
+// all of its live subtasks are done, then repeats for its own parent. A
+// blocked parent is left open when completing blocked todos is refused.
+func (t todoRepository) completeAncestors(parentID *int) (Completion, error) {
+	var completion Completion
+	for parentID != nil {
+		var parent Todo
+		err := sqlx.Get(t.q, &parent, `
+			UPDATE todos p SET done = TRUE
+			WHERE id = $1 AND auto_complete AND NOT done AND deleted_at IS NULL AND NOT (blocked AND $2)
+				AND NOT EXISTS (
+					SELECT 1 FROM todos c
+					WHERE c.parent_id = p.id AND c.deleted_at IS NULL AND NOT c.done
+				)
+			RETURNING `+todoColumns, *parentID, t.refuseBlocked)
+		if errors.Is(err, sql.ErrNoRows) {
+			return completion, nil
+		}
+		if err != nil {
+			return completion, err
+		}
+		changes := map[string]FieldChange{"done": {From: false, To: true}}
+		if err := t.recordEvent(parent.ID, ActionCompleted, parent.Version, changes); err != nil {
+			return completion, err
+		}
+		completion.Ancestors = append(completion.Ancestors, parent)
+		next, err := t.scheduleNext(parent)
+		if err != nil {
+			return completion, err
+		}
+		if next != nil {
+			completion.Occurrences = append(completion.Occurrences, *next)
+		}
+		parentID = parent.ParentID
+	}
+	return completion, nil
+}
+
+// trashSubtasks moves the live descendants of a just-trashed todo to the
+// trash with the same deleted_at, so restoring the todo brings them back.
+func (t todoRepository) trashSubtasks(todo Todo) ([]Todo, error) {
+	trashed := make([]Todo, 0)
+	err := sqlx.Select(t.q, &trashed, `
+		WITH RECURSIVE descendants AS (
+			SELECT id, 1 AS depth FROM todos WHERE parent_id = $1 AND deleted_at IS NULL
+			UNION ALL
+			SELECT t.id, d.depth + 1 FROM todos t
+			JOIN descendants d ON t.parent_id = d.id
+			WHERE t.deleted_at IS NULL
+		), trashed AS (
+			UPDATE todos SET deleted_at = $2
+			WHERE id IN (SELECT id FROM descendants)
+			RETURNING `+todoColumns+`
+		)
+		SELECT trashed.* FROM trashed JOIN descendants USING (id)
+		ORDER BY depth, priority, position, id`, todo.ID, todo.DeletedAt)
+	if err != nil {
+		return nil, err
+	}
+	return trashed, t.recordSubtaskEvents(trashed, ActionTrashed, nil, todo.DeletedAt)
+}
+
+// restoreSubtasks restores the descendants of id that were trashed along
+// with it, leaving those trashed on their own beforehand in the trash.
+func (t todoRepository) restoreSubtasks(id int, deletedAt time.Time) ([]Todo, error) {
+	restored := make([]Todo, 0)
+	err := sqlx.Select(t.q, &restored, `
+		WITH RECURSIVE descendants AS (
+			SELECT id, 1 AS depth FROM todos WHERE parent_id = $1 AND deleted_at = $2
+			UNION ALL
+			SELECT t.id, d.depth + 1 FROM todos t
+			JOIN descendants d ON t.parent_id = d.id
+			WHERE t.deleted_at = $2
+		), restored AS (
+			UPDATE todos SET deleted_at = NULL
+			WHERE id IN (SELECT id FROM descendants)
+			RETURNING `+todoColumns+`
+		)
+		SELECT restored.* FROM restored JOIN descendants USING (id)
+		ORDER BY depth, priority, position, id`, id, deletedAt)
+	if err != nil {
+		return nil, err
+	}
+	return restored, t.recordSubtaskEvents(restored, ActionRestored, &deletedAt, nil)
+}
+
+func (t todoRepository) recordSubtaskEvents(todos []Todo, action string, from, to *time.Time) error {
+	for _, todo := range todos {
+		changes := map[string]FieldChange{"deleted_at": {From: from, To: to}}
+		if err := t.recordEvent(todo.ID, action, todo.Version, changes); err != nil {
+			return err
+		}
+	}
+	return nil
+}
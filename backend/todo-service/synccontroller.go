@@ -0,0 +1,319 @@
+package main
+
+import (
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/rs/zerolog/log"
+)
+
+const (
+	defaultChangesLimit = 500
+	maxChangesLimit     = 1000
+	maxSyncMutations    = 500
+	changeTokenPrefix   = "v1:"
+)
+
+type changeEntry struct {
+	Op       string `json:"op"`
+	Revision int64  `json:"revision"`
+	ID       int    `json:"id"`
+	Todo     *Todo  `json:"todo,omitempty"`
+}
+
+type changesResponse struct {
+	Changes   []changeEntry `json:"changes"`
+	NextToken string        `json:"next_token"`
+	HasMore   bool          `json:"has_more"`
+}
+
+type syncMutation struct {
+	ClientID     string  `json:"client_id"`
+	Op           string  `json:"op"`
+	ID           int     `json:"id"`
+	BaseRevision int64   `json:"base_revision"`
+	Task         *string `json:"task"`
+	Done         *bool   `json:"done"`
+}
+
+type syncRequest struct {
+	Mutations []syncMutation `json:"mutations" binding:"required"`
+}
+
+type syncResult struct {
+	ClientID string     `json:"client_id,omitempty"`
+	Op       string     `json:"op"`
+	Status   string     `json:"status"`
+	ID       int        `json:"id,omitempty"`
+	Todo     *Todo      `json:"todo,omitempty"`
+	Deleted  bool       `json:"deleted,omitempty"`
+	Error    *syncError `json:"error,omitempty"`
+
+	// undo is the change the sync's undo token reverts, if one was made.
+	undo *UndoTarget
+}
+
+type syncError struct {
+	Code   string `json:"code"`
+	Detail string `json:"detail"`
+}
+
+const (
+	syncApplied  = "applied"
+	syncConflict = "conflict"
+	syncRejected = "rejected"
+)
+
+// Change tokens are opaque to clients so the cursor format can change
+// without breaking them; the prefix versions the encoding.
+func encodeChangeToken(revision int64) string {
+	return base64.RawURLEncoding.EncodeToString([]byte(changeTokenPrefix + strconv.FormatInt(revision, 10)))
+}
+
+func decodeChangeToken(token string) (int64, error) {
+	if token == "" {
+		return 0, nil
+	}
+	raw, err := base64.RawURLEncoding.DecodeString(token)
+	if err != nil {
+		return 0, err
+	}
+	revision, ok := strings.CutPrefix(string(raw), changeTokenPrefix)
+	if !ok {
+		return 0, fmt.Errorf("unknown token version")
+	}
+	return strconv.ParseInt(revision, 10, 64)
+}
+
+func (c *TodosController) getChanges(ctx *gin.Context) {
+	since, err := decodeChangeToken(ctx.Query("since"))
+	if err != nil || since < 0 {
+		log.Warn().
+			Str("path", ctx.FullPath()).
+			Str("since", ctx.Query("since")).
+			Msg("changes rejected: invalid token")
+		respondError(ctx, errInvalidSyncToken())
+		return
+	}
+
+	limit := defaultChangesLimit
+	if raw := ctx.Query("limit"); raw != "" {
+		limit, err = strconv.Atoi(raw)
+		if err != nil || limit <= 0 || limit > maxChangesLimit {
+			respondError(ctx, errInvalidQuery("limit", fmt.Sprintf("limit must be between 1 and %d", maxChangesLimit)))
+			return
+		}
+	}
+
+	// Fetch one extra row to learn whether another page follows.
+	changes, err := c.repo.GetChanges(since, limit+1)
+	if err != nil {
+		log.Error().Err(err).Msg("Failed to get changes")
+		respondError(ctx, errInternal(err))
+		return
+	}
+
+	resp := changesResponse{Changes: make([]changeEntry, 0, len(changes)), NextToken: encodeChangeToken(since)}
+	if len(changes) > limit {
+		resp.HasMore = true
+		changes = changes[:limit]
+	}
+	for _, change := range changes {
+		entry := changeEntry{Op: change.Op, Revision: change.Revision, ID: change.ID}
+		if change.Op != "delete" {
+			todo := change.Todo
+			entry.Todo = &todo
+		}
+		resp.Changes = append(resp.Changes, entry)
+	}
+	if len(changes) > 0 {
+		resp.NextToken = encodeChangeToken(changes[len(changes)-1].Revision)
+	}
+
+	log.Info().
+		Str("path", ctx.FullPath()).
+		Int64("since", since).
+		Int("count", len(resp.Changes)).
+		Msg("Changes received")
+	respond(ctx, http.StatusOK, resp, nil)
+}
+
+func (c *TodosController) syncTodos(ctx *gin.Context) {
+	var request syncRequest
+	if err := ctx.ShouldBindJSON(&request); err != nil {
+		log.Error().Err(err).Msg("Failed to parse sync request body")
+		respondError(ctx, errInvalidBody(err))
+		return
+	}
+	if len(request.Mutations) > maxSyncMutations {
+		respondError(ctx, errTooManyItems("mutations", maxSyncMutations))
+		return
+	}
+
+	repo := c.repoFor(ctx)
+	results := make([]syncResult, 0, len(request.Mutations))
+	var targets []UndoTarget
+	for _, mutation := range request.Mutations {
+		result := c.applySyncMutation(repo, mutation)
+		if result.undo != nil {
+			targets = append(targets, *result.undo)
+		}
+		results = append(results, result)
+	}
+
+	log.Info().
+		Str("path", ctx.FullPath()).
+		Int("mutations", len(results)).
+		Msg("Sync applied")
+	c.respondWithBatchUndo(ctx, http.StatusOK, gin.H{"results": results}, nil, targets, nil)
+}
+
+// applySyncMutation applies a single offline mutation. Conflicts are
+// resolved in favour of the server: the result carries the server's current
+// state so the client can overwrite its copy.
+func (c *TodosController) applySyncMutation(repo TodoRepository, m syncMutation) syncResult {
+	result := syncResult{ClientID: m.ClientID, Op: m.Op, ID: m.ID}
+
+	reject := func(apiErr *APIError) syncResult {
+		result.Status = syncRejected
+		result.Error = &syncError{Code: apiErr.Code, Detail: apiErr.Detail}
+		return result
+	}
+
+	if m.Task != nil {
+		task, apiErr := c.tasks.Validate(*m.Task)
+		if apiErr != nil {
+			return reject(apiErr)
+		}
+		m.Task = &task
+	}
+
+	switch m.Op {
+	case "create":
+		if m.Task == nil {
+			return reject(errTaskEmpty())
+		}
+		if m.ClientID != "" {
+			if replayed, ok := c.replaySyncCreate(repo, result, reject); ok {
+				return replayed
+			}
+		}
+		var todo Todo
+		err := repo.Transaction(func(repo TodoRepository) error {
+			var err error
+			todo, err = repo.AddTodo(NewTodo{Task: *m.Task, ClientID: m.ClientID})
+			if err == nil && m.Done != nil && *m.Done {
+				todo, _, err = repo.UpdateTodo(todo.ID, Precondition{Revision: todo.Revision}, TodoUpdate{Done: m.Done})
+			}
+			return err
+		})
+		if errors.Is(err, ErrDuplicateClientID) {
+			// A concurrent retry of the same sync got there first.
+			if replayed, ok := c.replaySyncCreate(repo, result, reject); ok {
+				return replayed
+			}
+		}
+		if errors.Is(err, ErrWIPLimitReached) {
+			return reject(errWIPLimitReached())
+		}
+		if err != nil {
+			log.Error().Err(err).Msg("sync create failed")
+			return reject(errInternal(err))
+		}
+		c.sendNatsMessage("todo.created", todo)
+		result.Status, result.ID, result.Todo = syncApplied, todo.ID, &todo
+		result.undo = &UndoTarget{Todo: todo, Created: true}
+
+	case "update":
+		if m.BaseRevision <= 0 {
+			return reject(errMissingBaseRevision())
+		}
+		todo, completion, err := repo.UpdateTodo(m.ID, Precondition{Revision: m.BaseRevision}, TodoUpdate{Task: m.Task, Done: m.Done})
+		if err != nil {
+			return c.resolveSyncConflict(result, err, reject)
+		}
+		c.sendNatsMessage("todo.updated", todo)
+		c.publishStatusChange(todo)
+		c.publishCompletion(todo)
+		c.publishAncestors(completion)
+		result.Status, result.Todo = syncApplied, &todo
+		result.undo = &UndoTarget{Todo: todo}
+
+	case "delete":
+		if m.BaseRevision <= 0 {
+			return reject(errMissingBaseRevision())
+		}
+		todo, subtasks, completion, err := repo.DeleteTodo(m.ID, Precondition{Revision: m.BaseRevision})
+		if errors.Is(err, ErrTodoNotFound) {
+			// Already gone, which is what the client wanted.
+			result.Status, result.Deleted = syncApplied, true
+			return result
+		}
+		if err != nil {
+			return c.resolveSyncConflict(result, err, reject)
+		}
+		c.sendNatsMessage("todo.deleted", Todo{ID: m.ID})
+		c.publishSubtasks("todo.deleted", subtasks)
+		c.publishAncestors(completion)
+		result.Status, result.Deleted = syncApplied, true
+		result.undo = &UndoTarget{Todo: todo}
+
+	default:
+		return reject(&APIError{Code: CodeValidationFailed, Detail: fmt.Sprintf("Unknown op %q", m.Op)})
+	}
+
+	return result
+}
+
+// replaySyncCreate answers a create whose client ID was already used with
+// the todo it created the first time, as if it were applied again.
+func (c *TodosController) replaySyncCreate(repo TodoRepository, result syncResult, reject func(*APIError) syncResult) (syncResult, bool) {
+	todo, err := repo.GetTodoByClientID(result.ClientID)
+	switch {
+	case errors.Is(err, ErrTodoNotFound):
+		return result, false
+	case err != nil:
+		log.Error().Err(err).Str("client_id", result.ClientID).Msg("sync create failed")
+		return reject(errInternal(err)), true
+	}
+
+	log.Info().Str("client_id", result.ClientID).Int("id", todo.ID).Msg("sync create replayed")
+	result.Status, result.ID = syncApplied, todo.ID
+	if todo.DeletedAt != nil {
+		result.Deleted = true
+	} else {
+		result.Todo = &todo
+	}
+	return result, true
+}
+
+func (c *TodosController) resolveSyncConflict(result syncResult, err error, reject func(*APIError) syncResult) syncResult {
+	switch {
+	case errors.Is(err, ErrTodoNotFound):
+		result.Status, result.Deleted = syncConflict, true
+		return result
+	case errors.Is(err, ErrPreconditionFailed):
+		current, getErr := c.repo.GetTodo(result.ID)
+		if errors.Is(getErr, ErrTodoNotFound) {
+			result.Status, result.Deleted = syncConflict, true
+			return result
+		}
+		if getErr != nil {
+			return reject(errInternal(getErr))
+		}
+		result.Status, result.Todo = syncConflict, &current
+		return result
+	case errors.Is(err, ErrTodoBlocked):
+		return reject(errTodoBlocked(result.ID))
+	case errors.Is(err, ErrWIPLimitReached):
+		return reject(errWIPLimitReached())
+	default:
+		log.Error().Err(err).Int("id", result.ID).Msg("sync mutation failed")
+		return reject(errInternal(err))
+	}
+}
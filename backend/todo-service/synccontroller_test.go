@@ -0,0 +1,57 @@
+package main
+
+import (
+	"slices"
+	"testing"
+)
+
+func TestSyncCreateDeduplicatesClientID(t *testing.T) {
+	repo := newMemoryRepository()
+	c, events := newTestController(t, repo)
+	task, done := "Buy milk", true
+	create := syncMutation{ClientID: "6f1c", Op: "create", Task: &task, Done: &done}
+
+	first := c.applySyncMutation(repo, create)
+	if first.Status != syncApplied || first.Todo == nil || !first.Todo.Done {
+		t.Fatalf("first create = %+v, want a done todo", first)
+	}
+
+	// The client never saw the response and sends the mutation again.
+	replayed := c.applySyncMutation(repo, create)
+	if replayed.Status != syncApplied || replayed.ID != first.ID || replayed.Todo == nil || replayed.Todo.Revision != first.Todo.Revision {
+		t.Errorf("replayed create = %+v, want the todo from the first create", replayed)
+	}
+	if len(repo.todos) != 1 {
+		t.Errorf("%d todos, want 1", len(repo.todos))
+	}
+	if got := publishedEvents(events); !slices.Equal(got, []string{"todo.created 1"}) {
+		t.Errorf("events = %v, want a single todo.created", got)
+	}
+
+	// Creates without a client ID are never deduplicated.
+	c.applySyncMutation(repo, syncMutation{Op: "create", Task: &task})
+	c.applySyncMutation(repo, syncMutation{Op: "create", Task: &task})
+	if len(repo.todos) != 3 {
+		t.Errorf("%d todos, want 3", len(repo.todos))
+	}
+}
+
+func TestSyncCreateReplayAfterDelete(t *testing.T) {
+	repo := newMemoryRepository()
+	c, _ := newTestController(t, repo)
+	task := "Buy milk"
+	create := syncMutation{ClientID: "6f1c", Op: "create", Task: &task}
+
+	first := c.applySyncMutation(repo, create)
+	if _, _, _, err := repo.DeleteTodo(first.ID, Precondition{}); err != nil {
+		t.Fatal(err)
+	}
+
+	replayed := c.applySyncMutation(repo, create)
+	if replayed.Status != syncApplied || replayed.ID != first.ID || !replayed.Deleted || replayed.Todo != nil {
+		t.Errorf("replayed create = %+v, want the todo reported deleted", replayed)
+	}
+	if len(repo.todos) != 1 {
+		t.Errorf("%d todos, want 1", len(repo.todos))
+	}
+}
@@ -0,0 +1,51 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"slices"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/lib/pq"
+	"github.com/rs/zerolog/log"
+)
+
+// maxTags keeps a todo's tags short enough to show on a board card.
+const maxTags = 20
+
+var tagPattern = regexp.MustCompile(`^[\p{L}\p{N}_-]{1,40}$`)
+
+// normalizeTags lowercases tags and drops a leading '#' and duplicates,
+// keeping the first occurrence of each.
+func normalizeTags(tags []string) (pq.StringArray, error) {
+	if len(tags) > maxTags {
+		return nil, fmt.Errorf("at most %d tags are allowed", maxTags)
+	}
+	normalized := make(pq.StringArray, 0, len(tags))
+	for _, tag := range tags {
+		tag = strings.ToLower(strings.TrimPrefix(strings.TrimSpace(tag), "#"))
+		if !tagPattern.MatchString(tag) {
+			return nil, fmt.Errorf("tag %q must be 1 to 40 letters, digits, '-' or '_'", tag)
+		}
+		if !slices.Contains(normalized, tag) {
+			normalized = append(normalized, tag)
+		}
+	}
+	return normalized, nil
+}
+
+// validateTags normalizes tags, writing a 400 if any is invalid.
+func validateTags(ctx *gin.Context, field string, tags []string) (pq.StringArray, bool) {
+	normalized, err := normalizeTags(tags)
+	if err != nil {
+		log.Warn().
+			Str("path", ctx.FullPath()).
+			Strs("tags", tags).
+			Err(err).
+			Msg("tags rejected")
+		respondError(ctx, errInvalidField(field, err.Error()))
+		return nil, false
+	}
+	return normalized, true
+}
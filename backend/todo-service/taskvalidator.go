@@ -0,0 +1,119 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	"golang.org/x/text/unicode/norm"
+)
+
+const defaultMaxTaskLength = 140
+
+// TaskValidator holds the rules for task text. Every endpoint that writes a
+// task runs it through Validate and stores the text Validate returns.
+type TaskValidator struct {
+	maxLength int
+	banned    []*regexp.Regexp
+}
+
+// NewTaskValidator compiles the banned words and patterns of cfg. Banned
+// words match whole words, ignoring case; patterns match anywhere in the
+// normalized task. A MaxLength of zero means defaultMaxTaskLength.
+func NewTaskValidator(cfg TaskConfig) (*TaskValidator, error) {
+	v := &TaskValidator{maxLength: cfg.MaxLength}
+	if v.maxLength <= 0 {
+		v.maxLength = defaultMaxTaskLength
+	}
+	if len(cfg.BannedWords) > 0 {
+		words := make([]string, len(cfg.BannedWords))
+		for i, word := range cfg.BannedWords {
+			words[i] = regexp.QuoteMeta(norm.NFC.String(word))
+		}
+		// \b only knows ASCII letters, so the word boundaries are spelled
+		// out to work for words like "höpö".
+		v.banned = append(v.banned, regexp.MustCompile(
+			`(?i)(?:^|[^\p{L}\p{N}_])(?:`+strings.Join(words, "|")+`)(?:$|[^\p{L}\p{N}_])`))
+	}
+	for _, pattern := range cfg.BannedPatterns {
+		re, err := regexp.Compile(pattern)
+		if err != nil {
+			return nil, fmt.Errorf("TASK_BANNED_PATTERNS entry %q is not a valid regular expression: %w", pattern, err)
+		}
+		v.banned = append(v.banned, re)
+	}
+	return v, nil
+}
+
+// Validate returns task normalized to NFC and trimmed, or the rule it
+// breaks. The length limit counts user-perceived characters, see
+// graphemeCount, so "é" and a flag emoji count once each.
+func (v *TaskValidator) Validate(task string) (string, *APIError) {
+	if !utf8.ValidString(task) {
+		return "", errTaskInvalid("Task is not valid UTF-8")
+	}
+	task = strings.TrimFunc(norm.NFC.String(task), func(r rune) bool {
+		return unicode.IsSpace(r) || unicode.Is(unicode.Cf, r)
+	})
+	if task == "" {
+		return "", errTaskEmpty()
+	}
+	if strings.ContainsFunc(task, func(r rune) bool {
+		return unicode.IsControl(r) || unicode.In(r, unicode.Zl, unicode.Zp)
+	}) {
+		return "", errTaskInvalid("Task cannot contain control characters or line breaks")
+	}
+	if graphemeCount(task) > v.maxLength {
+		return "", errTaskTooLong(v.maxLength)
+	}
+	for _, re := range v.banned {
+		if re.MatchString(task) {
+			return "", errTaskNotAllowed()
+		}
+	}
+	return task, nil
+}
+
+// MaxLength is the longest task allowed, in graphemes.
+func (v *TaskValidator) MaxLength() int {
+	return v.maxLength
+}
+
+// graphemeCount counts the characters a reader sees in s. It follows the
+// cases of Unicode text segmentation that matter for task text: combining
+// marks, variation selectors and emoji modifiers extend the character
+// before them, a zero width joiner glues emoji together and regional
+// indicators pair up into flags.
+func graphemeCount(s string) int {
+	n := 0
+	joined, flag := false, false
+	for _, r := range s {
+		switch {
+		case joined:
+			joined = false
+			continue
+		case r == '\u200d' && n > 0:
+			joined = true
+			continue
+		case graphemeExtend(r) && n > 0:
+			continue
+		case r >= 0x1f1e6 && r <= 0x1f1ff:
+			flag = !flag
+			if !flag {
+				continue
+			}
+		default:
+			flag = false
+		}
+		n++
+	}
+	return n
+}
+
+func graphemeExtend(r rune) bool {
+	return unicode.In(r, unicode.Mn, unicode.Me, unicode.Mc) ||
+		(r >= 0x1f3fb && r <= 0x1f3ff) || // emoji skin tones
+		(r >= 0xe0020 && r <= 0xe007f) // tags of subdivision flags
+}
@@ -0,0 +1,76 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestGraphemeCount(t *testing.T) {
+	tests := []struct {
+		name string
+		s    string
+		want int
+	}{
+		{"empty", "", 0},
+		{"ascii", "Buy milk", 8},
+		{"precomposed", "Päivitä", 7},
+		{"combining mark", "e\u0301", 1},
+		{"stacked combining marks", "a\u0308\u0301b", 2},
+		{"leading combining mark", "\u0301a", 2},
+		{"keycap", "1\ufe0f\u20e3", 1},
+		{"skin tone", "\U0001f44d\U0001f3fd", 1},
+		{"zwj family", "\U0001f468\u200d\U0001f469\u200d\U0001f467", 1},
+		{"zwj family with skin tones", "\U0001f468\U0001f3fb\u200d\U0001f469\U0001f3ff\u200d\U0001f467", 1},
+		{"zwj rainbow flag", "\U0001f3f3\ufe0f\u200d\U0001f308", 1},
+		{"zwj sequences side by side", "\U0001f469\u200d\U0001f4bb\U0001f468\u200d\U0001f373", 2},
+		{"flag", "\U0001f1eb\U0001f1ee", 1},
+		{"two flags", "\U0001f1eb\U0001f1ee\U0001f1f8\U0001f1ea", 2},
+		{"lone regional indicator", "\U0001f1eb", 1},
+		{"three regional indicators", "\U0001f1eb\U0001f1ee\U0001f1f8", 2},
+		{"flag after text", "Go \U0001f1eb\U0001f1ee", 4},
+		{"subdivision flag", "\U0001f3f4\U000e0067\U000e0062\U000e0073\U000e0063\U000e0074\U000e007f", 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := graphemeCount(tt.s); got != tt.want {
+				t.Errorf("graphemeCount(%+q) = %d, want %d", tt.s, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestValidateMaxLength(t *testing.T) {
+	v, err := NewTaskValidator(TaskConfig{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	family := "\U0001f468\u200d\U0001f469\u200d\U0001f467"
+	flag := "\U0001f1eb\U0001f1ee"
+
+	tests := []struct {
+		name    string
+		task    string
+		tooLong bool
+	}{
+		{"140 letters", strings.Repeat("a", 140), false},
+		{"141 letters", strings.Repeat("a", 141), true},
+		{"140 decomposed letters", strings.Repeat("e\u0301", 140), false},
+		{"141 decomposed letters", strings.Repeat("e\u0301", 141), true},
+		{"139 letters and a family", strings.Repeat("a", 139) + family, false},
+		{"140 letters and a family", strings.Repeat("a", 140) + family, true},
+		{"140 families", strings.Repeat(family, 140), false},
+		{"140 flags", strings.Repeat(flag, 140), false},
+		{"141 flags", strings.Repeat(flag, 141), true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, apiErr := v.Validate(tt.task)
+			switch {
+			case tt.tooLong && (apiErr == nil || apiErr.Detail != errTaskTooLong(defaultMaxTaskLength).Detail):
+				t.Errorf("Validate = %v, want too long", apiErr)
+			case !tt.tooLong && apiErr != nil:
+				t.Errorf("Validate = %v, want valid", apiErr)
+			}
+		})
+	}
+}
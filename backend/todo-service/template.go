@@ -0,0 +1,134 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+// maxTemplateItems bounds the todos, subtasks included, that one
+// instantiation creates in its transaction.
+const maxTemplateItems = 100
+
+var (
+	placeholderPattern = regexp.MustCompile(`\{\{\s*([A-Za-z_][A-Za-z0-9_]*)\s*\}\}`)
+	dueOffsetPattern   = regexp.MustCompile(`^(?:(\d{1,4})w)?(?:(\d{1,4})d)?(?:(\d{1,4})h)?(?:(\d{1,4})m)?$`)
+)
+
+// templateTodo is a TemplateItem with its placeholders and due offset
+// filled in, ready to be created.
+type templateTodo struct {
+	NewTodo
+	Subtasks []templateTodo
+}
+
+// addDueOffset adds an offset of weeks, days, hours and minutes, written
+// like "2w", "3d12h" or "30m", to start. Weeks and days are calendar days,
+// so a due time keeps its time of day across daylight saving changes.
+func addDueOffset(start time.Time, offset string) (time.Time, error) {
+	m := dueOffsetPattern.FindStringSubmatch(offset)
+	if offset == "" || m == nil {
+		return start, fmt.Errorf("due offset %q must be weeks, days, hours and minutes, such as 2w, 3d12h or 30m", offset)
+	}
+	parts := make([]int, 4)
+	for i, part := range m[1:] {
+		if part != "" {
+			parts[i], _ = strconv.Atoi(part)
+		}
+	}
+	return start.AddDate(0, 0, 7*parts[0]+parts[1]).
+		Add(time.Duration(parts[2])*time.Hour + time.Duration(parts[3])*time.Minute), nil
+}
+
+// countTemplateItems counts items and all their subtasks.
+func countTemplateItems(items []TemplateItem) int {
+	n := len(items)
+	for _, item := range items {
+		n += countTemplateItems(item.Subtasks)
+	}
+	return n
+}
+
+// templatePlaceholders lists the placeholder names used in the tasks of
+// items, in order of first use.
+func templatePlaceholders(items []TemplateItem) []string {
+	var names []string
+	var walk func(items []TemplateItem)
+	walk = func(items []TemplateItem) {
+		for _, item := range items {
+			for _, m := range placeholderPattern.FindAllStringSubmatch(item.Task, -1) {
+				if !slices.Contains(names, m[1]) {
+					names = append(names, m[1])
+				}
+			}
+			walk(item.Subtasks)
+		}
+	}
+	walk(items)
+	return names
+}
+
+// expandTemplate replaces the placeholders of items with values and turns
+// due offsets into due dates from start. Every placeholder needs a value;
+// values are inserted as they are, so a value cannot add placeholders.
+func expandTemplate(tasks *TaskValidator, items []TemplateItem, values map[string]string, start time.Time) ([]templateTodo, *APIError) {
+	var missing []string
+	for _, name := range templatePlaceholders(items) {
+		if _, ok := values[name]; !ok {
+			missing = append(missing, "{{"+name+"}}")
+		}
+	}
+	if len(missing) > 0 {
+		return nil, errInvalidField("values", "No value given for "+strings.Join(missing, ", "))
+	}
+	return expandTemplateItems(tasks, items, values, start, "items")
+}
+
+func expandTemplateItems(tasks *TaskValidator, items []TemplateItem, values map[string]string, start time.Time, field string) ([]templateTodo, *APIError) {
+	todos := make([]templateTodo, len(items))
+	for i, item := range items {
+		path := fmt.Sprintf("%s[%d]", field, i)
+		task := placeholderPattern.ReplaceAllStringFunc(item.Task, func(placeholder string) string {
+			return values[placeholderPattern.FindStringSubmatch(placeholder)[1]]
+		})
+		// Empty values can leave doubled or trailing spaces, which the
+		// validator trims like any task.
+		task, apiErr := tasks.Validate(task)
+		if apiErr != nil {
+			return nil, atField(apiErr, path+".task")
+		}
+
+		todo := templateTodo{NewTodo: NewTodo{
+			Task:         task,
+			Priority:     item.Priority,
+			AutoComplete: item.AutoComplete,
+			Tags:         pq.StringArray(item.Tags),
+		}}
+		if item.DueOffset != "" {
+			due, err := addDueOffset(start, item.DueOffset)
+			if err != nil {
+				return nil, errInvalidField(path+".due_offset", err.Error())
+			}
+			todo.DueAt = &due
+		}
+		if todo.Subtasks, apiErr = expandTemplateItems(tasks, item.Subtasks, values, start, path+".subtasks"); apiErr != nil {
+			return nil, apiErr
+		}
+		todos[i] = todo
+	}
+	return todos, nil
+}
+
+// atField points the field errors of apiErr at field, for rules checked on
+// nested items.
+func atField(apiErr *APIError, field string) *APIError {
+	for i := range apiErr.Fields {
+		apiErr.Fields[i].Field = field
+	}
+	return apiErr
+}
@@ -0,0 +1,261 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/rs/zerolog/log"
+)
+
+type templateRequest struct {
+	Name  string         `json:"name" binding:"required,max=80"`
+	Items []TemplateItem `json:"items" binding:"required,dive"`
+}
+
+type instantiateRequest struct {
+	// ListID defaults to the default list. Subtasks go into the list of
+	// their parent.
+	ListID *int              `json:"list_id"`
+	Values map[string]string `json:"values"`
+	// Start is what due offsets count from, by default the time of the
+	// request.
+	Start *time.Time `json:"start"`
+}
+
+func (c *TodosController) getTemplates(ctx *gin.Context) {
+	templates, err := c.repo.GetTemplates()
+	if err != nil {
+		log.Error().Err(err).Msg("Failed to get templates")
+		respondError(ctx, errInternal(err))
+		return
+	}
+
+	log.Info().
+		Str("path", ctx.FullPath()).
+		Int("count", len(templates)).
+		Msg("Templates received")
+	respondWithMeta(ctx, http.StatusOK, templates, gin.H{"count": len(templates)}, nil)
+}
+
+func (c *TodosController) createTemplate(ctx *gin.Context) {
+	request, ok := c.bindTemplateRequest(ctx)
+	if !ok {
+		return
+	}
+
+	template, err := c.repo.AddTemplate(request.Name, request.Items)
+	if err != nil {
+		log.Error().Err(err).Msg("Failed to create template")
+		respondError(ctx, errInternal(err))
+		return
+	}
+
+	log.Info().
+		Str("path", ctx.FullPath()).
+		Int("id", template.ID).
+		Msg("Template created")
+	respond(ctx, http.StatusCreated, template, nil)
+}
+
+func (c *TodosController) getTemplate(ctx *gin.Context) {
+	id, ok := todoIDParam(ctx)
+	if !ok {
+		return
+	}
+
+	template, err := c.repo.GetTemplate(id)
+	if err != nil {
+		respondTemplateError(ctx, id, err, "get template")
+		return
+	}
+	respond(ctx, http.StatusOK, template, nil)
+}
+
+// updateTemplate replaces a template's name and todos. Todos created from
+// it earlier are not changed.
+func (c *TodosController) updateTemplate(ctx *gin.Context) {
+	id, ok := todoIDParam(ctx)
+	if !ok {
+		return
+	}
+	request, ok := c.bindTemplateRequest(ctx)
+	if !ok {
+		return
+	}
+
+	template, err := c.repo.UpdateTemplate(id, request.Name, request.Items)
+	if err != nil {
+		respondTemplateError(ctx, id, err, "update template")
+		return
+	}
+
+	log.Info().
+		Str("path", ctx.FullPath()).
+		Int("id", id).
+		Msg("Template updated")
+	respond(ctx, http.StatusOK, template, nil)
+}
+
+func (c *TodosController) deleteTemplate(ctx *gin.Context) {
+	id, ok := todoIDParam(ctx)
+	if !ok {
+		return
+	}
+
+	if err := c.repo.DeleteTemplate(id); err != nil {
+		respondTemplateError(ctx, id, err, "delete template")
+		return
+	}
+
+	log.Info().
+		Str("path", ctx.FullPath()).
+		Int("id", id).
+		Msg("Template deleted")
+	ctx.Status(http.StatusNoContent)
+}
+
+// instantiateTemplate creates the todos of a template, subtasks under their
+// parents, in one transaction, and returns them parents first.
+func (c *TodosController) instantiateTemplate(ctx *gin.Context) {
+	id, ok := todoIDParam(ctx)
+	if !ok {
+		return
+	}
+	var request instantiateRequest
+	if err := ctx.ShouldBindJSON(&request); err != nil {
+		log.Error().Err(err).Msg("Failed to parse request body")
+		respondError(ctx, errInvalidBody(err))
+		return
+	}
+
+	template, err := c.repo.GetTemplate(id)
+	if err != nil {
+		respondTemplateError(ctx, id, err, "instantiate template")
+		return
+	}
+	start := time.Now().In(c.config.Location()).Truncate(time.Minute)
+	if request.Start != nil {
+		start = *request.Start
+	}
+	todos, apiErr := expandTemplate(c.tasks, template.Items, request.Values, start)
+	if apiErr != nil {
+		log.Warn().
+			Str("path", ctx.FullPath()).
+			Int("id", id).
+			Str("code", apiErr.Code).
+			Msg("instantiate template rejected")
+		respondError(ctx, apiErr)
+		return
+	}
+
+	created := make([]Todo, 0, countTemplateItems(template.Items))
+	err = c.repoFor(ctx).Transaction(func(repo TodoRepository) error {
+		var add func(todos []templateTodo, parentID *int) error
+		add = func(todos []templateTodo, parentID *int) error {
+			for _, todo := range todos {
+				todo.ParentID = parentID
+				if parentID == nil {
+					todo.ListID = request.ListID
+				}
+				newTodo, err := repo.AddTodo(todo.NewTodo)
+				if err != nil {
+					return err
+				}
+				created = append(created, newTodo)
+				if err := add(todo.Subtasks, &newTodo.ID); err != nil {
+					return err
+				}
+			}
+			return nil
+		}
+		return add(todos, nil)
+	})
+	if err != nil {
+		c.respondTodoError(ctx, 0, err, "instantiate template")
+		return
+	}
+
+	c.publishBatchCreated(BatchCreated{Source: "template", TemplateID: id, Todos: created})
+
+	log.Info().
+		Str("path", ctx.FullPath()).
+		Int("id", id).
+		Int("count", len(created)).
+		Msg("Template instantiated")
+	c.respondWithBatchUndo(ctx, http.StatusCreated, created, gin.H{"count": len(created)}, createdTargets(created), nil)
+}
+
+// bindTemplateRequest reads a template and normalizes its tags, writing a
+// 400 if any todo in it is invalid.
+func (c *TodosController) bindTemplateRequest(ctx *gin.Context) (templateRequest, bool) {
+	var request templateRequest
+	if err := ctx.ShouldBindJSON(&request); err != nil {
+		log.Error().Err(err).Msg("Failed to parse request body")
+		respondError(ctx, errInvalidBody(err))
+		return request, false
+	}
+
+	var apiErr *APIError
+	switch n := countTemplateItems(request.Items); {
+	case n == 0:
+		apiErr = errInvalidField("items", "A template needs at least one todo")
+	case n > maxTemplateItems:
+		apiErr = errTooManyItems("items", maxTemplateItems)
+	default:
+		request.Items, apiErr = validateTemplateItems(c.tasks, request.Items, "items")
+	}
+	if apiErr != nil {
+		log.Warn().
+			Str("path", ctx.FullPath()).
+			Str("code", apiErr.Code).
+			Msg("template rejected")
+		respondError(ctx, apiErr)
+		return request, false
+	}
+	return request, true
+}
+
+func validateTemplateItems(tasks *TaskValidator, items []TemplateItem, field string) ([]TemplateItem, *APIError) {
+	valid := make([]TemplateItem, len(items))
+	for i, item := range items {
+		path := fmt.Sprintf("%s[%d]", field, i)
+		task, apiErr := tasks.Validate(item.Task)
+		if apiErr != nil {
+			return nil, atField(apiErr, path+".task")
+		}
+		item.Task = task
+		if len(item.Tags) > 0 {
+			tags, err := normalizeTags(item.Tags)
+			if err != nil {
+				return nil, errInvalidField(path+".tags", err.Error())
+			}
+			item.Tags = tags
+		}
+		if item.DueOffset != "" {
+			if _, err := addDueOffset(time.Time{}, item.DueOffset); err != nil {
+				return nil, errInvalidField(path+".due_offset", err.Error())
+			}
+		}
+		if item.Subtasks, apiErr = validateTemplateItems(tasks, item.Subtasks, path+".subtasks"); apiErr != nil {
+			return nil, apiErr
+		}
+		valid[i] = item
+	}
+	return valid, nil
+}
+
+func respondTemplateError(ctx *gin.Context, id int, err error, action string) {
+	if !errors.Is(err, ErrTemplateNotFound) {
+		log.Error().Err(err).Int("id", id).Msg(action + " failed")
+		respondError(ctx, errInternal(err))
+		return
+	}
+	log.Warn().
+		Str("path", ctx.FullPath()).
+		Int("id", id).
+		Msg(action + " failed: template not found")
+	respondError(ctx, errTemplateNotFound(id))
+}
@@ -0,0 +1,49 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"slices"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestInstantiateTemplatePublishesOneEvent(t *testing.T) {
+	repo := newMemoryRepository()
+	repo.templates[7] = Template{ID: 7, Name: "Moving", Items: TemplateItems{
+		{Task: "Pack {{room}}", Subtasks: []TemplateItem{{Task: "Buy tape"}}},
+		{Task: "Return keys"},
+	}}
+	c, events := newTestController(t, repo)
+
+	ctx, w := postContext("/api/v2/templates/7/instantiate", `{"values": {"room": "kitchen"}}`)
+	ctx.Params = gin.Params{{Key: "id", Value: "7"}}
+	c.instantiateTemplate(ctx)
+
+	if w.Code != http.StatusCreated {
+		t.Fatalf("status = %d: %s", w.Code, w.Body)
+	}
+	var event StreamEvent
+	select {
+	case event = <-events.events:
+	default:
+		t.Fatal("no event published")
+	}
+	var batch BatchCreated
+	if err := json.Unmarshal(event.Data, &batch); err != nil {
+		t.Fatal(err)
+	}
+	if event.Type != "todo.batch_created" || batch.Source != "template" || batch.TemplateID != 7 || batch.Count != 3 || len(batch.Todos) != 3 {
+		t.Errorf("event = %s %+v, want one todo.batch_created for 3 todos", event.Type, batch)
+	}
+	if got := publishedEvents(events); len(got) != 0 {
+		t.Errorf("further events = %v, want none", got)
+	}
+
+	// Trashing the top-level todos undoes the lot.
+	want := []string{"1@1+", "3@1+"}
+	if got := undoTargets(repo.batches[w.Header().Get(undoTokenHeader)]); !slices.Equal(got, want) {
+		t.Errorf("undo targets = %v, want %v", got, want)
+	}
+}
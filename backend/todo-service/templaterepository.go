@@ -0,0 +1,106 @@
+package main
+
+import (
+	"database/sql"
+	"database/sql/driver"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+)
+
+var ErrTemplateNotFound = errors.New("template not found")
+
+// Template is a named set of todos that can be created again and again,
+// see TodosController.instantiateTemplate.
+type Template struct {
+	ID        int           `json:"id" db:"id"`
+	Name      string        `json:"name" db:"name"`
+	Items     TemplateItems `json:"items" db:"items"`
+	CreatedAt time.Time     `json:"created_at" db:"created_at"`
+	UpdatedAt time.Time     `json:"updated_at" db:"updated_at"`
+}
+
+// TemplateItem is a todo of a template. Task may hold placeholders such as
+// {{name}}, and DueOffset is added to the time the template is
+// instantiated, see addDueOffset.
+type TemplateItem struct {
+	Task         string         `json:"task" binding:"required"`
+	Priority     *int           `json:"priority,omitempty" binding:"omitempty,min=0,max=3"`
+	Tags         []string       `json:"tags,omitempty"`
+	DueOffset    string         `json:"due_offset,omitempty"`
+	AutoComplete bool           `json:"auto_complete,omitempty"`
+	Subtasks     []TemplateItem `json:"subtasks,omitempty" binding:"dive"`
+}
+
+// TemplateItems is stored as JSONB.
+type TemplateItems []TemplateItem
+
+func (items TemplateItems) Value() (driver.Value, error) {
+	return json.Marshal(items)
+}
+
+func (items *TemplateItems) Scan(src any) error {
+	switch data := src.(type) {
+	case []byte:
+		return json.Unmarshal(data, items)
+	case string:
+		return json.Unmarshal([]byte(data), items)
+	default:
+		return fmt.Errorf("cannot scan %T into template items", src)
+	}
+}
+
+const templateColumns = "id, name, items, created_at, updated_at"
+
+func (t todoRepository) GetTemplates() ([]Template, error) {
+	templates := make([]Template, 0)
+	err := sqlx.Select(t.q, &templates, "SELECT "+templateColumns+" FROM templates ORDER BY id")
+	return templates, err
+}
+
+func (t todoRepository) GetTemplate(id int) (Template, error) {
+	var template Template
+	err := sqlx.Get(t.q, &template, "SELECT "+templateColumns+" FROM templates WHERE id = $1", id)
+	if errors.Is(err, sql.ErrNoRows) {
+		return template, ErrTemplateNotFound
+	}
+	return template, err
+}
+
+func (t todoRepository) AddTemplate(name string, items TemplateItems) (Template, error) {
+	var template Template
+	err := sqlx.Get(t.q, &template, `
+		INSERT INTO templates (name, items) VALUES ($1, $2)
+		RETURNING `+templateColumns, name, items)
+	return template, err
+}
+
+func (t todoRepository) UpdateTemplate(id int, name string, items TemplateItems) (Template, error) {
+	var template Template
+	err := sqlx.Get(t.q, &template, `
+		UPDATE templates SET name = $2, items = $3, updated_at = CURRENT_TIMESTAMP
+		WHERE id = $1
+		RETURNING `+templateColumns, id, name, items)
+	if errors.Is(err, sql.ErrNoRows) {
+		return template, ErrTemplateNotFound
+	}
+	return template, err
+}
+
+func (t todoRepository) DeleteTemplate(id int) error {
+	res, err := t.q.Exec("DELETE FROM templates WHERE id = $1", id)
+	if err != nil {
+		return err
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return ErrTemplateNotFound
+	}
+	return nil
+}
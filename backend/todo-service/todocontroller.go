@@ -1,88 +1,234 @@
 package main
 
 import (
-	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"net/http"
-	"os"
 	"strconv"
+	"strings"
 	"time"
 
 	"github.com/gin-gonic/gin"
-	"github.com/nats-io/nats.go"
 	"github.com/rs/zerolog/log"
 )
 
 type TodosController struct {
-	repo TodoRepository
+	repo   TodoRepository
+	config *Config
+	events *EventBus
+	tasks  *TaskValidator
 }
 
-func NewTodosController(repo TodoRepository) *TodosController {
-	return &TodosController{repo: repo}
+func NewTodosController(repo TodoRepository, config *Config, events *EventBus) *TodosController {
+	return &TodosController{repo: repo, config: config, events: events, tasks: config.Tasks.Validator()}
 }
 
 func (c *TodosController) getTodos(ctx *gin.Context) {
-	todos, err := c.repo.GetTodos()
+	// The revision is read before the list, so a concurrent write can only
+	// make the list newer than its ETag, never older.
+	revision, err := c.repo.LatestRevision()
+	if err != nil {
+		log.Error().Err(err).Msg("Failed to get latest revision")
+		respondError(ctx, errInternal(err))
+		return
+	}
+	includeDeleted := false
+	if raw := ctx.Query("include_deleted"); raw != "" {
+		includeDeleted, err = strconv.ParseBool(raw)
+		if err != nil {
+			respondError(ctx, errInvalidQuery("include_deleted", "include_deleted must be true or false"))
+			return
+		}
+	}
+
+	etag := listETag(ctx, revision, includeDeleted)
+	ctx.Header("ETag", etag)
+	if ifNoneMatch(ctx, etag) {
+		ctx.Status(http.StatusNotModified)
+		return
+	}
+
+	todos, err := c.repo.GetTodos(includeDeleted)
 	if err != nil {
 		log.Error().Err(err).Msg("Failed to get todos")
-		ctx.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		respondError(ctx, errInternal(err))
 		return
 	}
 	log.Info().
 		Str("path", ctx.FullPath()).
 		Int("count", len(todos)).
 		Msg("Todos received")
-	ctx.JSON(http.StatusOK, todos)
+	respondWithMeta(ctx, http.StatusOK, todos, gin.H{"count": len(todos)}, nil)
 }
 
 func (c *TodosController) createTodo(ctx *gin.Context) {
-	var requestTodo struct {
-		Task string `json:"task" binding:"required"`
-	}
+	var requestTodo NewTodo
 
-	if err := ctx.BindJSON(&requestTodo); err != nil {
+	if err := ctx.ShouldBindJSON(&requestTodo); err != nil {
 		log.Error().Err(err).Msg("Failed to parse request body")
-		ctx.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		respondError(ctx, errInvalidBody(err))
 		return
 	}
 
-	task, ok := validateAndLogTask(ctx, requestTodo.Task)
+	var parsed *QuickAdd
+	if raw := ctx.Query("parse"); raw != "" {
+		parse, err := strconv.ParseBool(raw)
+		if err != nil {
+			respondError(ctx, errInvalidQuery("parse", "parse must be true or false"))
+			return
+		}
+		if parse {
+			var ok bool
+			if parsed, ok = c.quickAdd(ctx, &requestTodo); !ok {
+				return
+			}
+		}
+	}
+
+	task, ok := c.validateAndLogTask(ctx, requestTodo.Task)
 	if !ok {
 		return
 	}
+	requestTodo.Task = task
+	if requestTodo.Recurrence, ok = validateRecurrence(ctx, "recurrence", requestTodo.Recurrence); !ok {
+		return
+	}
+	if requestTodo.Tags, ok = validateTags(ctx, "tags", requestTodo.Tags); !ok {
+		return
+	}
 
-	newTodo, err := c.repo.AddTodo(task)
+	newTodo, err := c.repoFor(ctx).AddTodo(requestTodo)
 	if err != nil {
-		log.Error().Err(err).Msg("todo insert failed")
-		ctx.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		c.respondTodoError(ctx, 0, err, "todo insert")
 		return
 	}
 
 	c.sendNatsMessage("todo.created", newTodo)
 
-	ctx.JSON(http.StatusCreated, newTodo)
+	if parsed == nil {
+		c.respondWithUndo(ctx, http.StatusCreated, newTodo, nil)
+		return
+	}
+	// What the parser recognised goes in meta on v2 and next to the todo's
+	// fields on v1.
+	parsed.Task = newTodo.Task
+	setTodoETag(ctx, newTodo)
+	meta := gin.H{"parsed": parsed}
+	if token := c.issueUndoToken(ctx, newTodo); token != "" {
+		meta["undo_token"] = token
+	}
+	respondWithMeta(ctx, http.StatusCreated, newTodo, meta, struct {
+		Todo
+		Parsed *QuickAdd `json:"parsed"`
+	}{newTodo, parsed})
+}
+
+// quickAdd replaces the task of request with what is left after
+// parseQuickAdd, in the configured time zone or the one in ?tz=. Fields
+// given in the body win over ones found in the text, and tags are merged.
+func (c *TodosController) quickAdd(ctx *gin.Context, request *NewTodo) (*QuickAdd, bool) {
+	loc := c.config.Location()
+	if tz := ctx.Query("tz"); tz != "" {
+		var err error
+		if loc, err = time.LoadLocation(tz); err != nil {
+			respondError(ctx, errInvalidQuery("tz", fmt.Sprintf("tz %q is not a known time zone", tz)))
+			return nil, false
+		}
+	}
+	// Lists are only looked up when the text could name one.
+	var lists []List
+	if strings.Contains(request.Task, "@") {
+		var err error
+		if lists, err = c.repo.GetLists(); err != nil {
+			log.Error().Err(err).Msg("Failed to get lists")
+			respondError(ctx, errInternal(err))
+			return nil, false
+		}
+	}
+
+	parsed := parseQuickAdd(request.Task, time.Now().In(loc), lists)
+	request.Task = parsed.Task
+	if request.DueAt == nil {
+		request.DueAt = parsed.DueAt
+	}
+	if request.Priority == nil {
+		request.Priority = parsed.Priority
+	}
+	if request.ListID == nil {
+		request.ListID = parsed.ListID
+	}
+	request.Tags = append(request.Tags, parsed.Tags...)
+
+	log.Info().
+		Str("path", ctx.FullPath()).
+		Str("due", parsed.Due).
+		Strs("tags", parsed.Tags).
+		Str("list", parsed.List).
+		Msg("Quick-add parsed")
+	return &parsed, true
 }
 
 func (c *TodosController) welcome(ctx *gin.Context) {
 	ctx.JSON(http.StatusOK, gin.H{
-		"message":     "Welcome to the Todo API! Use /api/todos to manage your tasks.",
+		"message":     "Welcome to the Todo API! Use /api/todos to manage your tasks. Full documentation is served at /docs.",
 		"status_code": http.StatusOK,
 		"Endpoints": []string{
-			"GET /api/todos - Retrieve all todos",
-			"POST /api/todos - Create a new todo",
+			"GET /api/todos - Retrieve all todos, by priority and then position",
+			"POST /api/todos - Create a new todo, with ?parse=true to read due date, #tags, !priority and @list from the task",
+			"GET /api/todos/:id - Retrieve a todo, with ?include=children for its subtasks and progress",
+			"GET /api/todos/:id/history - Change history of a todo (needs X-API-Key when API_KEYS is set)",
+			"GET /api/todos/:id/blockers - List the todos a todo is blocked by",
+			"PUT /api/todos/:id/blockers/:blocker_id - Mark a todo as blocked by another",
+			"DELETE /api/todos/:id/blockers/:blocker_id - Remove a blocker",
+			"PUT /api/todos/:id/recurrence - Make a todo recur, or change the rule of its series",
+			"DELETE /api/todos/:id/recurrence - Stop a recurring series",
+			"POST /api/todos/:id/move - Move a todo before or after another",
 			"PUT /api/todos/:id - Mark a todo as done",
+			"PATCH /api/todos/:id - Update a todo's task, done state, priority, parent, auto_complete, due_at, recurrence, status or tags",
+			"DELETE /api/todos/:id - Move a todo and its subtasks to the trash",
+			"GET /api/todos/trash - List trashed todos",
+			"GET /api/todos/search?q=<query> - Full-text search, filtered by done, tag, list_id, due_from and due_to",
+			"POST /api/todos/:id/restore - Restore a todo from the trash",
 			"POST /api/todos/random - Create a random todo",
+			"GET /api/lists - List the lists and their workflows",
+			"POST /api/lists - Create a list, with the default workflow unless statuses are given",
+			"GET /api/lists/:id - Retrieve a list and its workflow",
+			"PUT /api/lists/:id/workflow - Replace a list's statuses, transitions and WIP limits",
+			"GET /api/lists/:id/board - The todos of a list grouped by status",
+			"GET /api/templates - List todo templates",
+			"POST /api/templates - Save a template of todos with subtasks, tags, due offsets and {{placeholders}}",
+			"GET /api/templates/:id - Retrieve a template",
+			"PUT /api/templates/:id - Replace a template's name and todos",
+			"DELETE /api/templates/:id - Delete a template",
+			"POST /api/templates/:id/instantiate - Create a template's todos in a list, filling in placeholders",
+			"GET /api/feeds - List calendar feeds",
+			"POST /api/feeds - Create a token-protected webcal feed of the todos with due dates",
+			"DELETE /api/feeds/:id - Revoke a calendar feed",
+			"GET /feeds/:token - iCalendar feed for calendar subscriptions, also served with .ics after the token",
 			"GET /api/todos/db-health - Check database connectivity",
 			"GET /api/todos/healthz - Health check endpoint",
+			"GET /api/todos/changes?since=<token> - Todos changed since a sync token",
+			"POST /api/todos/sync - Apply queued offline mutations",
+			"POST /api/todos/bulk - Create, update, complete or delete many todos at once",
+			"POST /api/todos/import?format=csv|json|todotxt|md - Import todos from a file, with dry_run and duplicate detection",
+			"GET /api/todos/export?format=csv|json|todotxt|md|ics - Download the todos, filtered like search",
+			"GET /api/todos/stream - Server-Sent Events stream of todo changes",
+			"GET /api/todos/stream/ws - WebSocket stream of todo changes",
+			"GET /api/audit - Audit log of all todo changes, filterable by todo_id, actor, action, source, since and until (needs X-API-Key when API_KEYS is set)",
+			"POST /api/undo/:token - Revert the change that returned this undo token",
+			"/api/v2/todos/... - Same endpoints with {\"data\": ...} envelopes and problem+json errors",
+			"GET /openapi.json - OpenAPI specification",
+			"GET /docs - Interactive API documentation",
 		},
 	})
 }
 
 func (c *TodosController) createRandomTodo(ctx *gin.Context) {
-	randomArticleURL := os.Getenv("RANDOM_ARTICLE_URL")
+	randomArticleURL := c.config.RandomArticleURL
 	if randomArticleURL == "" {
-		ctx.JSON(http.StatusInternalServerError, gin.H{"error": "RANDOM_ARTICLE_URL is not set"})
+		log.Warn().Msg("RANDOM_ARTICLE_URL is not set, random todos are disabled")
+		respondError(ctx, errFeatureDisabled("Random todos are not configured"))
 		return
 	}
 
@@ -93,7 +239,7 @@ func (c *TodosController) createRandomTodo(ctx *gin.Context) {
 	req, err := http.NewRequest("GET", randomArticleURL, nil)
 	if err != nil {
 		log.Error().Err(err).Msg("Failed to create request")
-		ctx.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		respondError(ctx, errInternal(err))
 		return
 	}
 
@@ -102,7 +248,7 @@ func (c *TodosController) createRandomTodo(ctx *gin.Context) {
 	resp, err := client.Do(req)
 	if err != nil {
 		log.Error().Err(err).Msg("Failed to get random article")
-		ctx.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		respondError(ctx, errUpstreamUnavailable(err))
 		return
 	}
 	defer func(Body io.ReadCloser) {
@@ -117,7 +263,7 @@ func (c *TodosController) createRandomTodo(ctx *gin.Context) {
 			Int("status_code", resp.StatusCode).
 			Str("url", randomArticleURL).
 			Msg("Failed to fetch random article")
-		ctx.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch random article"})
+		respondError(ctx, errUpstreamUnavailable(fmt.Errorf("unexpected status %d", resp.StatusCode)))
 		return
 	}
 
@@ -128,108 +274,315 @@ func (c *TodosController) createRandomTodo(ctx *gin.Context) {
 			Str("path", ctx.FullPath()).
 			Str("url", redirectedURL).
 			Msg("random article rejected: same as RANDOM_ARTICLE_URL")
-		ctx.JSON(http.StatusBadRequest, gin.H{"error": "Cannot read the same article twice"})
+		respondError(ctx, errArticleRepeated())
 		return
 	}
 
 	task := fmt.Sprintf("Read: %s", redirectedURL)
-	task, ok := validateAndLogTask(ctx, task)
+	task, ok := c.validateAndLogTask(ctx, task)
 	if !ok {
 		return
 	}
 
-	createdTodo, err := c.repo.AddTodo(task)
+	createdTodo, err := c.repoFor(ctx).AddTodo(NewTodo{Task: task})
 	if err != nil {
 		log.Error().Err(err).Msg("random todo insert failed")
-		ctx.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		respondError(ctx, errInternal(err))
 		return
 	}
 
 	c.sendNatsMessage("todo.created", createdTodo)
 
-	ctx.JSON(http.StatusCreated, gin.H{
+	c.respondWithUndo(ctx, http.StatusCreated, createdTodo, gin.H{
 		"New todo created": createdTodo,
 	})
 }
 
-func (c *TodosController) markTodoDone(ctx *gin.Context) {
-	idParam := ctx.Param("id")
-	if idParam == "" {
-		log.Warn().
-			Str("path", ctx.FullPath()).
-			Msg("mark todo done failed: missing id parameter")
-		ctx.JSON(http.StatusBadRequest, gin.H{"error": "Missing id parameter"})
+func (c *TodosController) getTodo(ctx *gin.Context) {
+	id, ok := todoIDParam(ctx)
+	if !ok {
 		return
 	}
 
-	idParamInt, err := strconv.Atoi(idParam)
+	switch include := ctx.Query("include"); include {
+	case "":
+	case "children":
+		c.getTodoTree(ctx, id)
+		return
+	default:
+		respondError(ctx, errInvalidQuery("include", fmt.Sprintf("unsupported include %q, expected children", include)))
+		return
+	}
+
+	todo, err := c.repo.GetTodo(id)
 	if err != nil {
-		log.Warn().
-			Str("path", ctx.FullPath()).
-			Msg("mark todo done failed: invalid id parameter")
-		ctx.JSON(http.StatusBadRequest, gin.H{"error": "Invalid id parameter"})
+		c.respondTodoError(ctx, id, err, "get todo")
+		return
+	}
+
+	setTodoETag(ctx, todo)
+	if ifNoneMatch(ctx, todoETag(todo)) {
+		ctx.Status(http.StatusNotModified)
+		return
+	}
+	respond(ctx, http.StatusOK, todo, nil)
+}
+
+func (c *TodosController) markTodoDone(ctx *gin.Context) {
+	id, ok := todoIDParam(ctx)
+	if !ok {
 		return
 	}
 
-	var todo Todo
-	todo, err = c.repo.markTodoDone(idParamInt)
+	pre, apiErr := c.ifMatchPrecondition(ctx, id)
+	if apiErr != nil {
+		respondError(ctx, apiErr)
+		return
+	}
+
+	todo, completion, err := c.repoFor(ctx).markTodoDone(id, pre)
 	if err != nil {
-		log.Error().Err(err).Msg("mark todo done failed")
-		ctx.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		c.respondTodoError(ctx, id, err, "mark todo done")
 		return
 	}
 
 	log.Info().
 		Str("path", ctx.FullPath()).
-		Str("id", idParam).
+		Int("id", id).
 		Msg("Todo marked as done")
 
 	c.sendNatsMessage("todo.updated", todo)
+	c.publishStatusChange(todo)
+	c.publishCompletion(todo)
+	c.publishAncestors(completion)
 
-	ctx.JSON(http.StatusOK, gin.H{"Todo updated": todo})
+	c.respondWithUndo(ctx, http.StatusOK, todo, gin.H{"Todo updated": todo})
 }
 
-func (c *TodosController) dbHealthCheck(ctx *gin.Context) {
-	health, err := c.repo.dbHealthCheck()
-	if err != nil {
-		log.Error().Err(err).Msg("Database health check failed")
-		ctx.JSON(http.StatusInternalServerError, gin.H{"error": "Database is not reachable"})
+func (c *TodosController) updateTodo(ctx *gin.Context) {
+	id, ok := todoIDParam(ctx)
+	if !ok {
+		return
+	}
+
+	var update TodoUpdate
+	if err := ctx.ShouldBindJSON(&update); err != nil {
+		log.Error().Err(err).Msg("Failed to parse request body")
+		respondError(ctx, errInvalidBody(err))
 		return
 	}
-	if !health {
-		ctx.JSON(http.StatusInternalServerError, gin.H{"error": "Database is not reachable"})
+	if update.empty() {
+		respondError(ctx, errEmptyUpdate())
 		return
 	}
-	msg := "Database is reachable"
-	log.Info().Msg(msg)
-	ctx.JSON(http.StatusOK, gin.H{"message": msg})
+	if update.Status != nil && update.Done != nil {
+		respondError(ctx, errInvalidField("done", "Set either status or done, not both"))
+		return
+	}
+	if update.Task != nil {
+		task, ok := c.validateAndLogTask(ctx, *update.Task)
+		if !ok {
+			return
+		}
+		update.Task = &task
+	}
+	if update.Recurrence != nil {
+		rule, ok := validateRecurrence(ctx, "recurrence", *update.Recurrence)
+		if !ok {
+			return
+		}
+		update.Recurrence = &rule
+	}
+	if update.Tags != nil {
+		tags, ok := validateTags(ctx, "tags", *update.Tags)
+		if !ok {
+			return
+		}
+		update.Tags = &tags
+	}
+
+	pre, apiErr := c.ifMatchPrecondition(ctx, id)
+	if apiErr != nil {
+		respondError(ctx, apiErr)
+		return
+	}
+
+	todo, completion, err := c.repoFor(ctx).UpdateTodo(id, pre, update)
+	if err != nil {
+		c.respondTodoError(ctx, id, err, "update todo")
+		return
+	}
+
+	log.Info().
+		Str("path", ctx.FullPath()).
+		Int("id", id).
+		Msg("Todo updated")
+
+	c.sendNatsMessage("todo.updated", todo)
+	c.publishStatusChange(todo)
+	c.publishCompletion(todo)
+	c.publishAncestors(completion)
+
+	c.respondWithUndo(ctx, http.StatusOK, todo, nil)
 }
 
-func (c *TodosController) healthCheck(ctx *gin.Context) {
-	ctx.JSON(http.StatusOK, gin.H{"message": "Service is healthy"})
+func (c *TodosController) deleteTodo(ctx *gin.Context) {
+	id, ok := todoIDParam(ctx)
+	if !ok {
+		return
+	}
+
+	pre, apiErr := c.ifMatchPrecondition(ctx, id)
+	if apiErr != nil {
+		respondError(ctx, apiErr)
+		return
+	}
+
+	todo, subtasks, completion, err := c.repoFor(ctx).DeleteTodo(id, pre)
+	if err != nil {
+		c.respondTodoError(ctx, id, err, "delete todo")
+		return
+	}
+
+	log.Info().
+		Str("path", ctx.FullPath()).
+		Int("id", id).
+		Msg("Todo deleted")
+
+	c.sendNatsMessage("todo.deleted", Todo{ID: id})
+	c.publishSubtasks("todo.deleted", subtasks)
+	c.publishAncestors(completion)
+
+	c.issueUndoToken(ctx, todo)
+	ctx.Status(http.StatusNoContent)
 }
 
-func validateAndLogTask(ctx *gin.Context, task string) (string, bool) {
-	const maxLen = 140
-	length := len(task)
+// todoIDParam parses the :id path parameter and writes a 400 if it is not
+// an integer.
+func todoIDParam(ctx *gin.Context) (int, bool) {
+	idParam := ctx.Param("id")
+	id, err := strconv.Atoi(idParam)
+	if err != nil {
+		log.Warn().
+			Str("path", ctx.FullPath()).
+			Str("id", idParam).
+			Msg("request rejected: invalid id parameter")
+		respondError(ctx, errInvalidID(idParam))
+		return 0, false
+	}
+	return id, true
+}
 
-	if task == "" {
+// respondTodoError maps a repository error for todo id to a response. On a
+// failed precondition the current ETag is sent so the client can refetch.
+func (c *TodosController) respondTodoError(ctx *gin.Context, id int, err error, action string) {
+	switch {
+	case errors.Is(err, ErrTodoNotFound):
 		log.Warn().
 			Str("path", ctx.FullPath()).
-			Int("length", length).
-			Msg("todo rejected: empty task")
-		ctx.JSON(http.StatusBadRequest, gin.H{"error": "Task cannot be empty"})
-		return "", false
+			Int("id", id).
+			Msg(action + " failed: todo not found")
+		respondError(ctx, errTodoNotFound(id))
+	case errors.Is(err, ErrTodoNotTrashed):
+		log.Warn().
+			Str("path", ctx.FullPath()).
+			Int("id", id).
+			Msg(action + " failed: todo is not in the trash")
+		respondError(ctx, errTodoNotTrashed(id))
+	case errors.Is(err, ErrParentNotFound):
+		log.Warn().
+			Str("path", ctx.FullPath()).
+			Int("id", id).
+			Msg(action + " failed: parent not found")
+		respondError(ctx, errParentNotFound())
+	case errors.Is(err, ErrParentCycle):
+		log.Warn().
+			Str("path", ctx.FullPath()).
+			Int("id", id).
+			Msg(action + " failed: parent would create a cycle")
+		respondError(ctx, errParentCycle())
+	case errors.Is(err, ErrMoveTargetNotFound):
+		log.Warn().
+			Str("path", ctx.FullPath()).
+			Int("id", id).
+			Msg(action + " failed: target not found")
+		respondError(ctx, errMoveTargetNotFound())
+	case errors.Is(err, ErrListNotFound):
+		log.Warn().
+			Str("path", ctx.FullPath()).
+			Int("id", id).
+			Msg(action + " failed: list not found")
+		respondError(ctx, errUnknownList())
+	case errors.Is(err, ErrStatusNotFound):
+		log.Warn().
+			Str("path", ctx.FullPath()).
+			Int("id", id).
+			Msg(action + " failed: status not found")
+		respondError(ctx, errStatusNotFound())
+	case errors.Is(err, ErrTransitionDenied):
+		log.Warn().
+			Str("path", ctx.FullPath()).
+			Int("id", id).
+			Msg(action + " failed: transition not allowed")
+		respondError(ctx, errTransitionDenied(id))
+	case errors.Is(err, ErrWIPLimitReached):
+		log.Warn().
+			Str("path", ctx.FullPath()).
+			Int("id", id).
+			Msg(action + " failed: WIP limit reached")
+		respondError(ctx, errWIPLimitReached())
+	case errors.Is(err, ErrTodoBlocked):
+		log.Warn().
+			Str("path", ctx.FullPath()).
+			Int("id", id).
+			Msg(action + " failed: todo has open blockers")
+		respondError(ctx, errTodoBlocked(id))
+	case errors.Is(err, ErrPreconditionFailed):
+		log.Warn().
+			Str("path", ctx.FullPath()).
+			Int("id", id).
+			Str("if_match", ctx.GetHeader("If-Match")).
+			Msg(action + " failed: precondition failed")
+		if current, getErr := c.repo.GetTodo(id); getErr == nil {
+			setTodoETag(ctx, current)
+		}
+		respondError(ctx, errPreconditionFailed(id))
+	default:
+		log.Error().Err(err).Int("id", id).Msg(action + " failed")
+		respondError(ctx, errInternal(err))
+	}
+}
+
+func (c *TodosController) dbHealthCheck(ctx *gin.Context) {
+	health, err := c.repo.dbHealthCheck()
+	if err != nil || !health {
+		log.Error().Err(err).Msg("Database health check failed")
+		respondError(ctx, errDatabaseUnavailable(err))
+		return
 	}
+	msg := "Database is reachable"
+	log.Info().Msg(msg)
+	respond(ctx, http.StatusOK, gin.H{"message": msg}, nil)
+}
 
-	if length > maxLen {
+func (c *TodosController) healthCheck(ctx *gin.Context) {
+	respond(ctx, http.StatusOK, gin.H{"message": "Service is healthy"}, nil)
+}
+
+// validateAndLogTask returns the normalized task, or writes the error
+// response. Lengths are logged in graphemes, as the limit counts them.
+func (c *TodosController) validateAndLogTask(ctx *gin.Context, task string) (string, bool) {
+	length := graphemeCount(task)
+
+	task, apiErr := c.tasks.Validate(task)
+	if apiErr != nil {
 		log.Warn().
 			Str("path", ctx.FullPath()).
 			Int("length", length).
-			Int("max_length", maxLen).
-			Str("task", task).
-			Msg("todo rejected: too long")
-		ctx.JSON(http.StatusBadRequest, gin.H{"error": "Task cannot exceed 140 characters"})
+			Int("max_length", c.tasks.MaxLength()).
+			Str("code", apiErr.Code).
+			Msg("todo rejected")
+		respondError(ctx, apiErr)
 		return "", false
 	}
 
@@ -243,27 +596,24 @@ func validateAndLogTask(ctx *gin.Context, task string) (string, bool) {
 }
 
 func (c *TodosController) sendNatsMessage(subject string, todo Todo) {
-	natsURL := os.Getenv("NATS_URL")
-	if natsURL == "" {
-		log.Warn().Msg("NATS_URL is not set, skipping NATS message sending")
-		return
-	}
-
-	nc, err := nats.Connect(natsURL)
-	if err != nil {
-		log.Error().Err(err).Msg("Failed to connect to NATS")
-		return
-	}
-	defer nc.Close()
+	c.events.Publish(subject, todo)
+}
 
-	data, err := json.Marshal(todo)
-	if err != nil {
-		log.Error().Err(err).Msg("Failed to marshal todo for NATS message")
-		return
-	}
+// BatchCreated is the payload of todo.batch_created, which announces the
+// todos one request created from a template or an import. One event for
+// the lot keeps subscribers such as the Discord broadcaster from getting a
+// todo.created per row.
+type BatchCreated struct {
+	Source     string `json:"source"`
+	TemplateID int    `json:"template_id,omitempty"`
+	Count      int    `json:"count"`
+	Todos      []Todo `json:"todos"`
+}
 
-	if err := nc.Publish(subject, data); err != nil {
-		log.Error().Err(err).Msg("Failed to publish NATS message")
+func (c *TodosController) publishBatchCreated(batch BatchCreated) {
+	if len(batch.Todos) == 0 {
 		return
 	}
+	batch.Count = len(batch.Todos)
+	c.events.Publish("todo.batch_created", batch)
 }
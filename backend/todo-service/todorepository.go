@@ -1,16 +1,234 @@
 package main
 
-import "github.com/jmoiron/sqlx"
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/lib/pq"
+)
+
+var (
+	ErrTodoNotFound       = errors.New("todo not found")
+	ErrPreconditionFailed = errors.New("todo was changed by someone else")
+	ErrTodoNotTrashed     = errors.New("todo is not in the trash")
+	ErrParentNotFound     = errors.New("parent todo does not exist or is in the trash")
+	ErrParentCycle        = errors.New("a todo cannot become a subtask of itself or its subtasks")
+	ErrMoveTargetNotFound = errors.New("todo to move next to does not exist or is in the trash")
+	ErrDuplicateClientID  = errors.New("a todo with this client ID already exists")
+)
+
+// todoColumns is the column list every query returning a Todo selects.
+const todoColumns = "id, task, done, revision, version, deleted_at, parent_id, auto_complete, blocked, due_at, recurrence, series_id, previous_id, priority, position, list_id, status, tags"
+
+// prefixColumns qualifies todoColumns with a table alias.
+func prefixColumns(alias string) string {
+	return alias + "." + strings.ReplaceAll(todoColumns, ", ", ", "+alias+".")
+}
 
 type TodoRepository interface {
-	GetTodos() ([]Todo, error)
-	AddTodo(task string) (Todo, error)
+	// GetTodos lists the todos that are not in the trash, or all of them
+	// when includeDeleted is set, by priority and then position.
+	GetTodos(includeDeleted bool) ([]Todo, error)
+	// GetTodo returns a todo that is not in the trash.
+	GetTodo(id int) (Todo, error)
+	// GetSubtree returns a todo followed by all its subtasks that are not
+	// in the trash, parents before children.
+	GetSubtree(id int) ([]Todo, error)
+	// AddTodo creates a todo. It fails with ErrDuplicateClientID if
+	// todo.ClientID was used before.
+	AddTodo(todo NewTodo) (Todo, error)
+	// GetTodoByClientID returns the todo, trashed or not, that a sync
+	// client created under clientID.
+	GetTodoByClientID(clientID string) (Todo, error)
+	// UpdateTodo changes a todo and returns it along with what completing
+	// it did to other todos.
+	UpdateTodo(id int, pre Precondition, update TodoUpdate) (Todo, Completion, error)
+	// MoveTodo places a todo right before, or after, targetID in the
+	// list order and gives it the target's priority.
+	MoveTodo(id int, pre Precondition, targetID int, after bool) (Todo, error)
+	// DeleteTodo moves a todo and its subtasks to the trash and returns
+	// it along with the subtasks, parents before children, and the
+	// ancestors that completed as their last open subtask went.
+	DeleteTodo(id int, pre Precondition) (Todo, []Todo, Completion, error)
+	GetTrash() ([]Todo, error)
+	// RestoreTodo brings a todo back from the trash with the subtasks that
+	// were trashed along with it, and returns both like DeleteTodo.
+	RestoreTodo(id int, pre Precondition) (Todo, []Todo, error)
+	// PurgeTrash permanently removes todos that were trashed before
+	// cutoff and returns their IDs.
+	PurgeTrash(cutoff time.Time) ([]int, error)
+	GetChanges(sinceRevision int64, limit int) ([]TodoChange, error)
+	LatestRevision() (int64, error)
 	dbHealthCheck() (bool, error)
-	markTodoDone(id int) (Todo, error)
+	markTodoDone(id int, pre Precondition) (Todo, Completion, error)
+	// Transaction runs fn against a repository bound to a single database
+	// transaction, committing if fn returns nil and rolling back otherwise.
+	Transaction(fn func(repo TodoRepository) error) error
+	// WithAudit returns a repository that attributes the writes it makes
+	// to meta in the todo_events log.
+	WithAudit(meta AuditMeta) TodoRepository
+	GetHistory(id int) ([]TodoEvent, error)
+	GetAuditLog(filter AuditFilter) ([]TodoEvent, error)
+	// IssueUndoToken returns a token that reverts the change which brought
+	// todo to its current version, valid for ttl.
+	IssueUndoToken(todo Todo, ttl time.Duration) (string, error)
+	// IssueBatchUndoToken returns a token that reverts the targets of a
+	// request that changed several todos, all or none of them.
+	IssueBatchUndoToken(targets []UndoTarget, ttl time.Duration) (string, error)
+	// Undo redeems a token and returns a result per todo it reverted, in
+	// the order it reverted them; batch is set for batch tokens. It fails
+	// with ErrUndoConflict if a todo has changed since the token was
+	// issued.
+	Undo(token string) (results []UndoResult, batch bool, err error)
+	PurgeUndoTokens() (int64, error)
+	// GetBlockers lists the todos that id depends on.
+	GetBlockers(id int) ([]Todo, error)
+	// AddBlocker makes id depend on blockerID and returns the updated id,
+	// and whether the dependency is new. It fails with ErrDependencyCycle
+	// if blockerID already depends on id.
+	AddBlocker(id, blockerID int) (todo Todo, added bool, err error)
+	RemoveBlocker(id, blockerID int) (Todo, error)
+	// GetUnblocked returns the open todos that completing blocker left
+	// without open blockers.
+	GetUnblocked(blocker Todo) ([]Todo, error)
+	// GetNextOccurrence returns the occurrence that completing a recurring
+	// todo created.
+	GetNextOccurrence(todo Todo) (Todo, error)
+	GetLists() ([]List, error)
+	GetList(id int) (List, error)
+	AddList(name string, statuses []Status) (List, error)
+	// SetWorkflow replaces the statuses of a list and returns it along
+	// with the live todos whose done it changed and the ancestors they
+	// completed. It fails with ErrStatusInUse if a todo, trashed or not,
+	// has a status it removes, and with ErrTodoBlocked if it would complete
+	// a blocked todo when that is refused.
+	SetWorkflow(id int, statuses []Status) (List, []Todo, error)
+	GetListTodos(id int) ([]Todo, error)
+	// GetStatusChange returns the status change that brought todo to its
+	// current version, or nil if there was none.
+	GetStatusChange(todo Todo) (*StatusChange, error)
+	// SearchTodos returns the todos matching a full-text search, best
+	// match first.
+	SearchTodos(search TodoSearch) ([]SearchResult, error)
+	// ExportTodos calls fn with each todo that matches filter, subtasks
+	// right after their parent, without loading them all at once.
+	ExportTodos(filter TodoFilter, fn func(Todo) error) error
+	GetTemplates() ([]Template, error)
+	GetTemplate(id int) (Template, error)
+	AddTemplate(name string, items TemplateItems) (Template, error)
+	UpdateTemplate(id int, name string, items TemplateItems) (Template, error)
+	DeleteTemplate(id int) error
+	GetFeeds() ([]CalendarFeed, error)
+	// GetFeedByToken finds a feed by the SHA-256 of its token.
+	GetFeedByToken(tokenHash string) (CalendarFeed, error)
+	AddFeed(feed CalendarFeed, tokenHash string) (CalendarFeed, error)
+	DeleteFeed(id int) error
+	GetFeedTodos(listID *int) ([]Todo, error)
+	LastModified() (time.Time, error)
+}
+
+// Precondition restricts a write to a known state of the todo. Zero-valued
+// fields are not checked.
+type Precondition struct {
+	Revision int64
+	Versions []int64
+}
+
+// NewTodo holds the fields a todo is created with. Recurrence must be a
+// canonical rule, see Recurrence.
+type NewTodo struct {
+	Task         string     `json:"task" binding:"required"`
+	ParentID     *int       `json:"parent_id"`
+	AutoComplete bool       `json:"auto_complete"`
+	DueAt        *time.Time `json:"due_at"`
+	Recurrence   string     `json:"recurrence"`
+	Priority     *int       `json:"priority" binding:"omitempty,min=0,max=3"`
+	// ListID defaults to the parent's list, or the default list for a
+	// top-level todo. Status defaults to the list's first open status.
+	ListID *int           `json:"list_id"`
+	Status string         `json:"status"`
+	Tags   pq.StringArray `json:"tags"`
+	// ClientID is set by sync to the ID the client gave the todo.
+	ClientID string `json:"-"`
+}
+
+// TodoUpdate holds the fields to change; nil fields are left untouched.
+// Setting ParentID moves the todo, with its subtasks, under another todo
+// or, when null, to the top level. Setting Recurrence, to a canonical rule
+// or to "" to stop the series, applies to every open occurrence. Status
+// must be a transition the list's workflow allows into a column with room;
+// Done skips the transitions and moves to the first done or open status,
+// which needs room too. A todo stays in the list it was created in:
+// statuses belong to a list, so there is no ListID to move it by.
+type TodoUpdate struct {
+	Task         *string      `json:"task"`
+	Done         *bool        `json:"done"`
+	ParentID     NullableID   `json:"parent_id"`
+	AutoComplete *bool        `json:"auto_complete"`
+	DueAt        NullableTime `json:"due_at"`
+	Recurrence   *string      `json:"recurrence"`
+	Priority     *int         `json:"priority" binding:"omitempty,min=0,max=3"`
+	Status       *string      `json:"status"`
+	// Tags replaces the todo's tags.
+	Tags *pq.StringArray `json:"tags"`
+	// Position is set by MoveTodo and by undo, never by clients.
+	Position *string `json:"-"`
+	// SkipWorkflow lets undo restore a status that the transitions or WIP
+	// limits would not allow.
+	SkipWorkflow bool `json:"-"`
+}
+
+func (u TodoUpdate) empty() bool {
+	return u.Task == nil && u.Done == nil && !u.ParentID.Set && u.AutoComplete == nil &&
+		!u.DueAt.Set && u.Recurrence == nil && u.Priority == nil && u.Status == nil && u.Tags == nil && u.Position == nil
+}
+
+// NullableID tells a JSON field that is absent apart from one that is null.
+type NullableID struct {
+	Set bool
+	ID  *int
+}
+
+func (n *NullableID) UnmarshalJSON(data []byte) error {
+	n.Set = true
+	return json.Unmarshal(data, &n.ID)
+}
+
+// NullableTime is NullableID for timestamps.
+type NullableTime struct {
+	Set  bool
+	Time *time.Time
+}
+
+func (n *NullableTime) UnmarshalJSON(data []byte) error {
+	n.Set = true
+	return json.Unmarshal(data, &n.Time)
+}
+
+// TodoChange is a row of the change feed. For deletes only the ID and
+// Revision of the embedded Todo are set.
+type TodoChange struct {
+	Op string `db:"op"`
+	Todo
 }
 
 type todoRepository struct {
 	db *sqlx.DB
+	// q runs the queries: db itself, or tx inside a Transaction.
+	q     sqlx.Ext
+	tx    *sqlx.Tx
+	depth int
+	audit AuditMeta
+	// refuseBlocked makes completing a blocked todo fail with
+	// ErrTodoBlocked.
+	refuseBlocked bool
+	// location is the time zone recurrence rules are evaluated in.
+	location *time.Location
 }
 
 func (t todoRepository) dbHealthCheck() (bool, error) {
@@ -21,24 +239,434 @@ func (t todoRepository) dbHealthCheck() (bool, error) {
 	return true, nil
 }
 
-func NewTodoRepository(db *sqlx.DB) TodoRepository {
-	return &todoRepository{db}
+func NewTodoRepository(db *sqlx.DB, refuseBlockedCompletion bool, location *time.Location) TodoRepository {
+	return &todoRepository{db: db, q: db, refuseBlocked: refuseBlockedCompletion, location: location}
+}
+
+func (t todoRepository) WithAudit(meta AuditMeta) TodoRepository {
+	t.audit = meta
+	return t
 }
 
-func (t todoRepository) GetTodos() ([]Todo, error) {
+// Transaction implements TodoRepository. Inside an open transaction it sets
+// a savepoint instead, so a failing fn undoes only its own writes.
+func (t todoRepository) Transaction(fn func(repo TodoRepository) error) error {
+	return t.transaction(func(repo todoRepository) error {
+		return fn(repo)
+	})
+}
+
+func (t todoRepository) transaction(fn func(repo todoRepository) error) error {
+	if t.tx != nil {
+		return t.savepoint(fn)
+	}
+
+	tx, err := t.db.Beginx()
+	if err != nil {
+		return err
+	}
+	defer func() { _ = tx.Rollback() }()
+
+	if err := fn(todoRepository{db: t.db, q: tx, tx: tx, audit: t.audit, refuseBlocked: t.refuseBlocked, location: t.location}); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+func (t todoRepository) savepoint(fn func(repo todoRepository) error) error {
+	name := fmt.Sprintf("todo_savepoint_%d", t.depth)
+	if _, err := t.tx.Exec("SAVEPOINT " + name); err != nil {
+		return err
+	}
+
+	nested := t
+	nested.depth++
+	if err := fn(nested); err != nil {
+		if _, rollbackErr := t.tx.Exec("ROLLBACK TO SAVEPOINT " + name); rollbackErr != nil {
+			return errors.Join(err, rollbackErr)
+		}
+		return err
+	}
+	_, err := t.tx.Exec("RELEASE SAVEPOINT " + name)
+	return err
+}
+
+func (t todoRepository) GetTodos(includeDeleted bool) ([]Todo, error) {
 	todos := make([]Todo, 0)
-	err := t.db.Select(&todos, "SELECT id, task, done FROM todos")
+	err := sqlx.Select(t.q, &todos, `
+		SELECT `+todoColumns+` FROM todos WHERE $1 OR deleted_at IS NULL
+		ORDER BY priority, position, id`, includeDeleted)
 	return todos, err
 }
 
-func (t todoRepository) AddTodo(task string) (Todo, error) {
+func (t todoRepository) GetTodo(id int) (Todo, error) {
 	var todo Todo
-	err := t.db.Get(&todo, "INSERT INTO todos (task) VALUES ($1) RETURNING id, task, done", task)
+	err := sqlx.Get(t.q, &todo, "SELECT "+todoColumns+" FROM todos WHERE id = $1 AND deleted_at IS NULL", id)
+	if errors.Is(err, sql.ErrNoRows) {
+		return todo, ErrTodoNotFound
+	}
 	return todo, err
 }
 
-func (t todoRepository) markTodoDone(id int) (Todo, error) {
+func (t todoRepository) GetSubtree(id int) ([]Todo, error) {
+	todos := make([]Todo, 0)
+	err := sqlx.Select(t.q, &todos, `
+		WITH RECURSIVE subtree AS (
+			SELECT `+todoColumns+`, 0 AS depth FROM todos WHERE id = $1 AND deleted_at IS NULL
+			UNION ALL
+			SELECT `+prefixColumns("t")+`, s.depth + 1 FROM todos t
+			JOIN subtree s ON t.parent_id = s.id
+			WHERE t.deleted_at IS NULL
+		)
+		SELECT `+todoColumns+` FROM subtree ORDER BY depth, priority, position, id`, id)
+	if err == nil && len(todos) == 0 {
+		return todos, ErrTodoNotFound
+	}
+	return todos, err
+}
+
+func (t todoRepository) AddTodo(todo NewTodo) (Todo, error) {
+	return t.audited(0, ActionCreated, func(repo todoRepository) (Todo, error) {
+		var created Todo
+		if todo.ParentID != nil {
+			if err := repo.checkParent(0, *todo.ParentID); err != nil {
+				return created, err
+			}
+		}
+		listID, err := repo.newTodoList(todo)
+		if err != nil {
+			return created, err
+		}
+		if todo.Status == "" {
+			// Picked here rather than by the trigger so that its WIP
+			// limit applies.
+			if todo.Status, err = repo.defaultStatus(listID, false); err != nil {
+				return created, err
+			}
+		}
+		if _, err := repo.checkStatus(listID, "", todo.Status, true); err != nil {
+			return created, err
+		}
+		position, err := repo.endPosition()
+		if err != nil {
+			return created, err
+		}
+		// A recurring todo starts a series named after its own ID, so the
+		// ID is taken up front.
+		err = sqlx.Get(repo.q, &created, `
+			WITH next AS (SELECT nextval(pg_get_serial_sequence('todos', 'id'))::integer AS id)
+			INSERT INTO todos (id, task, parent_id, auto_complete, due_at, recurrence, series_id, priority, position, list_id, status, tags, client_id)
+			SELECT id, $1, $2, $3, $4, $5, CASE WHEN $5 <> '' THEN id END, COALESCE($6, $7), $8, $9, NULLIF($10, ''),
+				COALESCE($11::text[], '{}'), NULLIF($12, '') FROM next
+			RETURNING `+todoColumns, todo.Task, todo.ParentID, todo.AutoComplete, todo.DueAt, todo.Recurrence,
+			todo.Priority, defaultPriority, position, listID, todo.Status, todo.Tags, todo.ClientID)
+		var pqErr *pq.Error
+		if errors.As(err, &pqErr) && pqErr.Code.Name() == "unique_violation" && pqErr.Constraint == "todos_client_id_idx" {
+			return created, ErrDuplicateClientID
+		}
+		return created, err
+	})
+}
+
+func (t todoRepository) GetTodoByClientID(clientID string) (Todo, error) {
 	var todo Todo
-	err := t.db.Get(&todo, "UPDATE todos SET done = TRUE WHERE id = $1 RETURNING id, task, done", id)
+	err := sqlx.Get(t.q, &todo, "SELECT "+todoColumns+" FROM todos WHERE client_id = $1", clientID)
+	if errors.Is(err, sql.ErrNoRows) {
+		return todo, ErrTodoNotFound
+	}
 	return todo, err
 }
+
+// preconditionSQL matches rows satisfying a Precondition passed as the
+// parameters $2 (revision) and $3 (versions).
+const preconditionSQL = "($2::bigint = 0 OR revision = $2) AND ($3::bigint[] IS NULL OR version = ANY($3))"
+
+func (t todoRepository) UpdateTodo(id int, pre Precondition, update TodoUpdate) (Todo, Completion, error) {
+	var completion Completion
+	todo, err := t.auditedChanges(id, ActionUpdated, func(repo todoRepository) (Todo, map[string]FieldChange, error) {
+		var todo Todo
+		completing := update.Done != nil && *update.Done
+		if update.Status != nil {
+			done, err := repo.checkStatusChange(id, *update.Status, !update.SkipWorkflow)
+			if err != nil {
+				return todo, nil, err
+			}
+			completing = done
+		} else if update.Done != nil {
+			if err := repo.checkDoneChange(id, *update.Done, !update.SkipWorkflow); err != nil {
+				return todo, nil, err
+			}
+		}
+		if completing {
+			if err := repo.checkUnblocked(id); err != nil {
+				return todo, nil, err
+			}
+		}
+		if update.ParentID.ID != nil {
+			if err := repo.checkParent(id, *update.ParentID.ID); err != nil {
+				return todo, nil, err
+			}
+		}
+		wasDone, err := repo.wasDone(id)
+		if err != nil {
+			return todo, nil, err
+		}
+		err = sqlx.Get(repo.q, &todo, `
+			UPDATE todos SET task = COALESCE($4, task), done = COALESCE($5, done),
+				parent_id = CASE WHEN $6 THEN $7::integer ELSE parent_id END,
+				auto_complete = COALESCE($8, auto_complete),
+				due_at = CASE WHEN $9 THEN $10::timestamptz ELSE due_at END,
+				recurrence = COALESCE($11, recurrence),
+				series_id = CASE WHEN COALESCE($11, recurrence) <> '' THEN COALESCE(series_id, id) ELSE series_id END,
+				priority = COALESCE($12, priority), position = COALESCE($13, position),
+				status = COALESCE($14, status), tags = COALESCE($15, tags)
+			WHERE id = $1 AND deleted_at IS NULL AND `+preconditionSQL+`
+			RETURNING `+todoColumns, id, pre.Revision, pq.Array(pre.Versions), update.Task, update.Done,
+			update.ParentID.Set, update.ParentID.ID, update.AutoComplete, update.DueAt.Set, update.DueAt.Time,
+			update.Recurrence, update.Priority, update.Position, update.Status, update.Tags)
+		if errors.Is(err, sql.ErrNoRows) {
+			return todo, nil, repo.missOrConflict(id)
+		}
+		if err == nil && update.Recurrence != nil {
+			err = repo.updateSeries(todo)
+		}
+		if err == nil && todo.Done && !wasDone {
+			completion, err = repo.completed(todo)
+		}
+		return todo, completion.changes(), err
+	})
+	return todo, completion, err
+}
+
+// wasDone reports whether id is done before a write that may complete it,
+// as only completing a todo runs completed: editing a done one does not.
+// A missing todo is left for the write to report.
+func (t todoRepository) wasDone(id int) (bool, error) {
+	var done bool
+	err := sqlx.Get(t.q, &done, "SELECT done FROM todos WHERE id = $1", id)
+	if errors.Is(err, sql.ErrNoRows) {
+		return false, nil
+	}
+	return done, err
+}
+
+func (t todoRepository) DeleteTodo(id int, pre Precondition) (Todo, []Todo, Completion, error) {
+	var subtasks []Todo
+	var completion Completion
+	todo, err := t.auditedChanges(id, ActionTrashed, func(repo todoRepository) (Todo, map[string]FieldChange, error) {
+		var todo Todo
+		err := sqlx.Get(repo.q, &todo, `
+			UPDATE todos SET deleted_at = CURRENT_TIMESTAMP
+			WHERE id = $1 AND deleted_at IS NULL AND `+preconditionSQL+`
+			RETURNING `+todoColumns, id, pre.Revision, pq.Array(pre.Versions))
+		if errors.Is(err, sql.ErrNoRows) {
+			return todo, nil, repo.missOrConflict(id)
+		}
+		if err == nil {
+			subtasks, err = repo.trashSubtasks(todo)
+		}
+		if err == nil {
+			// The parent may now have only finished subtasks left.
+			completion, err = repo.completeAncestors(todo.ParentID)
+		}
+		return todo, completion.changes(), err
+	})
+	return todo, subtasks, completion, err
+}
+
+func (t todoRepository) GetTrash() ([]Todo, error) {
+	todos := make([]Todo, 0)
+	err := sqlx.Select(t.q, &todos, "SELECT "+todoColumns+" FROM todos WHERE deleted_at IS NOT NULL ORDER BY deleted_at DESC")
+	return todos, err
+}
+
+func (t todoRepository) RestoreTodo(id int, pre Precondition) (Todo, []Todo, error) {
+	var subtasks []Todo
+	todo, err := t.audited(id, ActionRestored, func(repo todoRepository) (Todo, error) {
+		var todo Todo
+		var state struct {
+			DeletedAt     *time.Time `db:"deleted_at"`
+			ParentTrashed bool       `db:"parent_trashed"`
+		}
+		err := sqlx.Get(repo.q, &state, `
+			SELECT c.deleted_at, COALESCE(p.deleted_at IS NOT NULL, FALSE) AS parent_trashed
+			FROM todos c LEFT JOIN todos p ON p.id = c.parent_id
+			WHERE c.id = $1`, id)
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return todo, ErrTodoNotFound
+		case err != nil:
+			return todo, err
+		case state.DeletedAt == nil:
+			return todo, ErrTodoNotTrashed
+		case state.ParentTrashed:
+			return todo, ErrParentNotFound
+		}
+
+		err = sqlx.Get(repo.q, &todo, `
+			UPDATE todos SET deleted_at = NULL
+			WHERE id = $1 AND deleted_at IS NOT NULL AND `+preconditionSQL+`
+			RETURNING `+todoColumns, id, pre.Revision, pq.Array(pre.Versions))
+		if errors.Is(err, sql.ErrNoRows) {
+			return todo, ErrPreconditionFailed
+		}
+		if err == nil {
+			subtasks, err = repo.restoreSubtasks(todo.ID, *state.DeletedAt)
+		}
+		return todo, err
+	})
+	return todo, subtasks, err
+}
+
+func (t todoRepository) PurgeTrash(cutoff time.Time) ([]int, error) {
+	ids := make([]int, 0)
+	err := t.transaction(func(repo todoRepository) error {
+		if err := sqlx.Select(repo.q, &ids, "DELETE FROM todos WHERE deleted_at < $1 RETURNING id", cutoff); err != nil {
+			return err
+		}
+		for _, id := range ids {
+			if err := repo.recordEvent(id, ActionPurged, 0, nil); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	return ids, err
+}
+
+// missOrConflict explains why a guarded write matched no row.
+func (t todoRepository) missOrConflict(id int) error {
+	if _, err := t.GetTodo(id); err != nil {
+		return err
+	}
+	return ErrPreconditionFailed
+}
+
+// LatestRevision returns the newest revision of any todo or tombstone, which
+// changes whenever the todo list does.
+func (t todoRepository) LatestRevision() (int64, error) {
+	var revision int64
+	err := sqlx.Get(t.q, &revision, `
+		SELECT GREATEST(
+			(SELECT COALESCE(MAX(revision), 0) FROM todos),
+			(SELECT COALESCE(MAX(revision), 0) FROM todo_tombstones)
+		)`)
+	return revision, err
+}
+
+// GetChanges returns todos and tombstones with a revision greater than
+// sinceRevision, oldest first. Trashed todos are reported as deletes.
+func (t todoRepository) GetChanges(sinceRevision int64, limit int) ([]TodoChange, error) {
+	changes := make([]TodoChange, 0)
+	err := sqlx.Select(t.q, &changes, `
+		SELECT op, `+todoColumns+` FROM (
+			SELECT CASE WHEN deleted_at IS NULL THEN 'upsert' ELSE 'delete' END AS op, `+todoColumns+`
+			FROM todos WHERE revision > $1
+			UNION ALL
+			SELECT 'delete' AS op, todo_id AS id, '' AS task, FALSE AS done, revision, 0 AS version,
+				deleted_at, NULL::integer AS parent_id, FALSE AS auto_complete, FALSE AS blocked,
+				NULL::timestamptz AS due_at, '' AS recurrence, NULL::integer AS series_id, NULL::integer AS previous_id,
+				0::smallint AS priority, '' AS position, 0 AS list_id, '' AS status, '{}'::text[] AS tags
+			FROM todo_tombstones WHERE revision > $1
+		) AS changes
+		ORDER BY revision
+		LIMIT $2`, sinceRevision, limit)
+	return changes, err
+}
+
+func (t todoRepository) markTodoDone(id int, pre Precondition) (Todo, Completion, error) {
+	var completion Completion
+	todo, err := t.auditedChanges(id, ActionCompleted, func(repo todoRepository) (Todo, map[string]FieldChange, error) {
+		var todo Todo
+		if err := repo.checkUnblocked(id); err != nil {
+			return todo, nil, err
+		}
+		if err := repo.checkDoneChange(id, true, true); err != nil {
+			return todo, nil, err
+		}
+		wasDone, err := repo.wasDone(id)
+		if err != nil {
+			return todo, nil, err
+		}
+		err = sqlx.Get(repo.q, &todo, "UPDATE todos SET done = TRUE WHERE id = $1 AND deleted_at IS NULL AND "+preconditionSQL+" RETURNING "+todoColumns,
+			id, pre.Revision, pq.Array(pre.Versions))
+		if errors.Is(err, sql.ErrNoRows) {
+			return todo, nil, repo.missOrConflict(id)
+		}
+		if err == nil && !wasDone {
+			completion, err = repo.completed(todo)
+		}
+		return todo, completion.changes(), err
+	})
+	return todo, completion, err
+}
+
+// defaultPriority is P2, leaving P3 for todos below the usual.
+const defaultPriority = 2
+
+// endPosition returns a position after every todo. Concurrent inserts may
+// get the same one; the list breaks ties by ID.
+func (t todoRepository) endPosition() (string, error) {
+	var last string
+	if err := sqlx.Get(t.q, &last, "SELECT COALESCE(MAX(position), '') FROM todos"); err != nil {
+		return "", err
+	}
+	return positionBetween(last, "")
+}
+
+func (t todoRepository) MoveTodo(id int, pre Precondition, targetID int, after bool) (Todo, error) {
+	return t.audited(id, ActionUpdated, func(repo todoRepository) (Todo, error) {
+		var todo, target Todo
+		err := sqlx.Get(repo.q, &target, "SELECT "+todoColumns+" FROM todos WHERE id = $1 AND deleted_at IS NULL", targetID)
+		if errors.Is(err, sql.ErrNoRows) {
+			return todo, ErrMoveTargetNotFound
+		}
+		if err != nil {
+			return todo, err
+		}
+
+		// The new position goes between the target and its neighbour on
+		// the chosen side, among the todos of the same priority.
+		var low, high string
+		if after {
+			low = target.Position
+			err = sqlx.Get(repo.q, &high, `
+				SELECT COALESCE(MIN(position), '') FROM todos
+				WHERE priority = $1 AND position > $2 AND id <> $3 AND deleted_at IS NULL`,
+				target.Priority, target.Position, id)
+		} else {
+			high = target.Position
+			err = sqlx.Get(repo.q, &low, `
+				SELECT COALESCE(MAX(position), '') FROM todos
+				WHERE priority = $1 AND position < $2 AND id <> $3 AND deleted_at IS NULL`,
+				target.Priority, target.Position, id)
+		}
+		if err != nil {
+			return todo, err
+		}
+		position, err := positionBetween(low, high)
+		if err != nil {
+			return todo, err
+		}
+
+		err = sqlx.Get(repo.q, &todo, `
+			UPDATE todos SET priority = $4, position = $5
+			WHERE id = $1 AND deleted_at IS NULL AND `+preconditionSQL+`
+			RETURNING `+todoColumns, id, pre.Revision, pq.Array(pre.Versions), target.Priority, position)
+		if errors.Is(err, sql.ErrNoRows) {
+			return todo, repo.missOrConflict(id)
+		}
+		return todo, err
+	})
+}
+
+// completed runs what follows from todo being done: a recurring todo gets
+// its next occurrence and auto-completing parents may complete in turn. It
+// returns what completing the parents did; the todo's own occurrence is
+// left to GetNextOccurrence.
+func (t todoRepository) completed(todo Todo) (Completion, error) {
+	if _, err := t.scheduleNext(todo); err != nil {
+		return Completion{}, err
+	}
+	return t.completeAncestors(todo.ParentID)
+}
@@ -0,0 +1,73 @@
+package main
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/rs/zerolog/log"
+)
+
+func (c *TodosController) getTrash(ctx *gin.Context) {
+	todos, err := c.repo.GetTrash()
+	if err != nil {
+		log.Error().Err(err).Msg("Failed to get trash")
+		respondError(ctx, errInternal(err))
+		return
+	}
+	log.Info().
+		Str("path", ctx.FullPath()).
+		Int("count", len(todos)).
+		Msg("Trash received")
+	respondWithMeta(ctx, http.StatusOK, todos, gin.H{"count": len(todos)}, nil)
+}
+
+func (c *TodosController) restoreTodo(ctx *gin.Context) {
+	id, ok := todoIDParam(ctx)
+	if !ok {
+		return
+	}
+
+	pre, apiErr := c.ifMatchPrecondition(ctx, id)
+	if apiErr != nil {
+		respondError(ctx, apiErr)
+		return
+	}
+
+	todo, subtasks, err := c.repoFor(ctx).RestoreTodo(id, pre)
+	if err != nil {
+		c.respondTodoError(ctx, id, err, "restore todo")
+		return
+	}
+
+	log.Info().
+		Str("path", ctx.FullPath()).
+		Int("id", id).
+		Msg("Todo restored")
+
+	c.sendNatsMessage("todo.restored", todo)
+	c.publishSubtasks("todo.restored", subtasks)
+
+	c.respondWithUndo(ctx, http.StatusOK, todo, gin.H{"Todo restored": todo})
+}
+
+// purgeTrash permanently deletes todos that have been in the trash longer
+// than retention, checking every interval until the process exits.
+func purgeTrash(repo TodoRepository, events *EventBus, retention, interval time.Duration) {
+	repo = repo.WithAudit(AuditMeta{Actor: "system", Source: "trash purge"})
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for range ticker.C {
+		ids, err := repo.PurgeTrash(time.Now().Add(-retention))
+		if err != nil {
+			log.Error().Err(err).Msg("Failed to purge trash")
+			continue
+		}
+		for _, id := range ids {
+			events.Publish("todo.purged", Todo{ID: id})
+		}
+		if len(ids) > 0 {
+			log.Info().Int("count", len(ids)).Msg("Purged trashed todos")
+		}
+	}
+}
@@ -0,0 +1,168 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/rs/zerolog/log"
+)
+
+const undoTokenHeader = "Undo-Token"
+
+// undoSubjects maps the action an undo performed to the event it emits.
+var undoSubjects = map[string]string{
+	ActionTrashed:  "todo.deleted",
+	ActionRestored: "todo.restored",
+	ActionUpdated:  "todo.updated",
+}
+
+// respondWithUndo writes a changed todo along with a token that reverts the
+// change, sent in the Undo-Token header and, on v2, in meta.undo_token.
+func (c *TodosController) respondWithUndo(ctx *gin.Context, status int, todo Todo, legacy any) {
+	setTodoETag(ctx, todo)
+	var meta any
+	if token := c.issueUndoToken(ctx, todo); token != "" {
+		meta = gin.H{"undo_token": token}
+	}
+	respondWithMeta(ctx, status, todo, meta, legacy)
+}
+
+// respondWithBatchUndo writes the result of a request that changed several
+// todos along with a token that reverts all of targets, in the order the
+// request applied them. On v2 the token joins meta.
+func (c *TodosController) respondWithBatchUndo(ctx *gin.Context, status int, data any, meta gin.H, targets []UndoTarget, legacy any) {
+	if token := c.issueBatchUndoToken(ctx, targets); token != "" {
+		if meta == nil {
+			meta = gin.H{}
+		}
+		meta["undo_token"] = token
+	}
+	if meta == nil {
+		respondWithMeta(ctx, status, data, nil, legacy)
+		return
+	}
+	respondWithMeta(ctx, status, data, meta, legacy)
+}
+
+// createdTargets returns the undo targets of the todos a request created.
+// Trashing a todo trashes its subtasks, so those whose parent was created
+// too are left out.
+func createdTargets(created []Todo) []UndoTarget {
+	ids := make(map[int]bool, len(created))
+	for _, todo := range created {
+		ids[todo.ID] = true
+	}
+	targets := make([]UndoTarget, 0, len(created))
+	for _, todo := range created {
+		if todo.ParentID == nil || !ids[*todo.ParentID] {
+			targets = append(targets, UndoTarget{Todo: todo, Created: true})
+		}
+	}
+	return targets
+}
+
+// issueBatchUndoToken is issueUndoToken for several todos. A request that
+// changed nothing gets no token.
+func (c *TodosController) issueBatchUndoToken(ctx *gin.Context, targets []UndoTarget) string {
+	if len(targets) == 0 {
+		return ""
+	}
+	token, err := c.repo.IssueBatchUndoToken(targets, c.config.Undo.Window())
+	if err != nil {
+		log.Error().Err(err).Int("count", len(targets)).Msg("Failed to issue undo token")
+		return ""
+	}
+	ctx.Header(undoTokenHeader, token)
+	return token
+}
+
+// issueUndoToken sets the Undo-Token header. Undo is a convenience, so a
+// failure is logged and the response goes out without a token.
+func (c *TodosController) issueUndoToken(ctx *gin.Context, todo Todo) string {
+	token, err := c.repo.IssueUndoToken(todo, c.config.Undo.Window())
+	if err != nil {
+		log.Error().Err(err).Int("id", todo.ID).Msg("Failed to issue undo token")
+		return ""
+	}
+	ctx.Header(undoTokenHeader, token)
+	return token
+}
+
+func (c *TodosController) undo(ctx *gin.Context) {
+	results, batch, err := c.repoFor(ctx).Undo(ctx.Param("token"))
+	switch {
+	case errors.Is(err, ErrUndoTokenInvalid):
+		log.Warn().
+			Str("path", ctx.FullPath()).
+			Msg("undo rejected: token unknown, used or expired")
+		respondError(ctx, errUndoTokenInvalid())
+		return
+	case errors.Is(err, ErrUndoConflict):
+		log.Warn().
+			Str("path", ctx.FullPath()).
+			Msg("undo rejected: todo changed since the token was issued")
+		respondError(ctx, errUndoConflict())
+		return
+	case err != nil:
+		log.Error().Err(err).Msg("undo failed")
+		respondError(ctx, errInternal(err))
+		return
+	}
+
+	// Results come newest change first; the redo token and a batch
+	// response list them in the order they are now applied.
+	todos := make([]Todo, len(results))
+	targets := make([]UndoTarget, len(results))
+	for i, result := range results {
+		log.Info().
+			Str("path", ctx.FullPath()).
+			Int("id", result.Todo.ID).
+			Str("action", result.Action).
+			Msg("Change undone")
+
+		c.sendNatsMessage(undoSubjects[result.Action], result.Todo)
+		c.publishSubtasks(undoSubjects[result.Action], result.Subtasks)
+		for _, id := range result.Removed {
+			c.sendNatsMessage("todo.deleted", Todo{ID: id})
+		}
+		c.publishStatusChange(result.Todo)
+		if result.Action == ActionUpdated {
+			// Redoing a completion schedules the next occurrence again.
+			c.publishCompletion(result.Todo)
+		}
+		for _, ancestor := range result.Reopened {
+			c.sendNatsMessage("todo.updated", ancestor)
+			c.publishStatusChange(ancestor)
+		}
+		c.publishAncestors(result.Completion)
+
+		todos[len(results)-1-i] = result.Todo
+		targets[len(results)-1-i] = UndoTarget{Todo: result.Todo}
+	}
+
+	// The undo is itself a change, so its token redoes the original one.
+	if !batch {
+		c.respondWithUndo(ctx, http.StatusOK, todos[0], nil)
+		return
+	}
+	c.respondWithBatchUndo(ctx, http.StatusOK, todos, nil, targets, nil)
+}
+
+// purgeUndoTokens removes expired undo tokens every interval until the
+// process exits.
+func purgeUndoTokens(repo TodoRepository, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for range ticker.C {
+		n, err := repo.PurgeUndoTokens()
+		if err != nil {
+			log.Error().Err(err).Msg("Failed to purge expired undo tokens")
+			continue
+		}
+		if n > 0 {
+			log.Info().Int64("count", n).Msg("Purged expired undo tokens")
+		}
+	}
+}
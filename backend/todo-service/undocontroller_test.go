@@ -0,0 +1,154 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+)
+
+// postContext returns a context for a v2 POST of body to path.
+func postContext(path, body string) (*gin.Context, *httptest.ResponseRecorder) {
+	ctx, w := versionedContext(2, path)
+	ctx.Request = httptest.NewRequest(http.MethodPost, path, strings.NewReader(body))
+	ctx.Request.Header.Set("Content-Type", "application/json")
+	return ctx, w
+}
+
+// undoTargets lists targets as "id@version", with "+" for created todos.
+func undoTargets(targets []UndoTarget) []string {
+	var listed []string
+	for _, target := range targets {
+		s := strconv.Itoa(target.Todo.ID) + "@" + strconv.FormatInt(target.Todo.Version, 10)
+		if target.Created {
+			s += "+"
+		}
+		listed = append(listed, s)
+	}
+	return listed
+}
+
+func TestBulkIssuesUndoToken(t *testing.T) {
+	repo := newMemoryRepository()
+	addSubtree(t, repo)
+	c, _ := newTestController(t, repo)
+
+	ctx, w := postContext("/api/v2/todos/bulk", `{"operations": [
+		{"op": "create", "task": "Pack boxes"},
+		{"op": "update", "id": 4, "task": "Water the plants"},
+		{"op": "delete", "id": 1}
+	]}`)
+	c.bulkTodos(ctx)
+
+	token := w.Header().Get(undoTokenHeader)
+	var body struct {
+		Meta struct {
+			UndoToken string `json:"undo_token"`
+		} `json:"meta"`
+	}
+	if err := json.Unmarshal(w.Body.Bytes(), &body); err != nil {
+		t.Fatal(err)
+	}
+	if token == "" || body.Meta.UndoToken != token {
+		t.Fatalf("Undo-Token = %q, meta.undo_token = %q, want the same token", token, body.Meta.UndoToken)
+	}
+	// The subtasks trashed with 1 are restored with it, so only the
+	// operations are targets.
+	want := []string{"5@1+", "4@2", "1@2"}
+	if got := undoTargets(repo.batches[token]); !slices.Equal(got, want) {
+		t.Errorf("targets = %v, want %v", got, want)
+	}
+	if repo.batches[token][2].Todo.DeletedAt == nil {
+		t.Error("the delete target is not the trashed todo")
+	}
+}
+
+func TestBulkRolledBackIssuesNoUndoToken(t *testing.T) {
+	repo := newMemoryRepository()
+	c, _ := newTestController(t, repo)
+
+	ctx, w := postContext("/api/v2/todos/bulk", `{"operations": [
+		{"op": "create", "task": "Pack boxes"},
+		{"op": "delete", "id": 9}
+	]}`)
+	c.bulkTodos(ctx)
+
+	if token := w.Header().Get(undoTokenHeader); token != "" || len(repo.batches) != 0 {
+		t.Errorf("Undo-Token = %q after a rolled back request, want none", token)
+	}
+}
+
+func TestSyncIssuesUndoToken(t *testing.T) {
+	repo := newMemoryRepository()
+	addSubtree(t, repo)
+	c, _ := newTestController(t, repo)
+
+	ctx, w := postContext("/api/v2/todos/sync", `{"mutations": [
+		{"op": "create", "client_id": "6f1c", "task": "Pack boxes"},
+		{"op": "update", "id": 4, "base_revision": 1, "task": "Water the plants"},
+		{"op": "delete", "id": 2, "base_revision": 2}
+	]}`)
+	c.syncTodos(ctx)
+
+	// The update has a stale base revision, so it conflicts and has
+	// nothing to undo.
+	want := []string{"5@1+", "2@2"}
+	if got := undoTargets(repo.batches[w.Header().Get(undoTokenHeader)]); !slices.Equal(got, want) {
+		t.Errorf("targets = %v, want %v", got, want)
+	}
+}
+
+func TestCreatedTargets(t *testing.T) {
+	parent, other := 1, 9
+	created := []Todo{{ID: 1}, {ID: 2, ParentID: &parent}, {ID: 3, ParentID: &other}}
+	want := []string{"1@0+", "3@0+"}
+	if got := undoTargets(createdTargets(created)); !slices.Equal(got, want) {
+		t.Errorf("createdTargets = %v, want %v", got, want)
+	}
+	if got := createdTargets(nil); len(got) != 0 {
+		t.Errorf("createdTargets(nil) = %v, want none", got)
+	}
+}
+
+func TestUndoBatch(t *testing.T) {
+	repo := newMemoryRepository()
+	c, events := newTestController(t, repo)
+	// Undoing a bulk request that created 5 and completed 4, which
+	// scheduled occurrence 6: newest change first.
+	repo.undone = []UndoResult{
+		{Action: ActionUpdated, Todo: Todo{ID: 4, Version: 3}, Removed: []int{6}},
+		{Action: ActionTrashed, Todo: Todo{ID: 5, Version: 2}},
+	}
+	repo.undoneBatch = true
+
+	ctx, w := postContext("/api/v2/undo/batch-0", "")
+	ctx.Params = gin.Params{{Key: "token", Value: "batch-0"}}
+	c.undo(ctx)
+
+	if w.Code != http.StatusOK {
+		t.Fatalf("status = %d, want 200", w.Code)
+	}
+	wantEvents := []string{"todo.updated 4", "todo.deleted 6", "todo.deleted 5"}
+	if got := publishedEvents(events); !slices.Equal(got, wantEvents) {
+		t.Errorf("events = %v, want %v", got, wantEvents)
+	}
+	var body struct {
+		Data []Todo `json:"data"`
+	}
+	if err := json.Unmarshal(w.Body.Bytes(), &body); err != nil {
+		t.Fatal(err)
+	}
+	if len(body.Data) != 2 || body.Data[0].ID != 5 || body.Data[1].ID != 4 {
+		t.Errorf("data = %+v, want 5 then 4", body.Data)
+	}
+	// The redo token reapplies the changes in their original order.
+	want := []string{"5@2", "4@3"}
+	if got := undoTargets(repo.batches[w.Header().Get(undoTokenHeader)]); !slices.Equal(got, want) {
+		t.Errorf("redo targets = %v, want %v", got, want)
+	}
+}
@@ -0,0 +1,320 @@
+package main
+
+import (
+	"crypto/rand"
+	"database/sql"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/lib/pq"
+)
+
+var (
+	ErrUndoTokenInvalid = errors.New("undo token is unknown, used or expired")
+	ErrUndoConflict     = errors.New("todo has changed since the undo token was issued")
+)
+
+// UndoResult is the todo after an undo and the action that reverted it.
+type UndoResult struct {
+	Action string
+	Todo   Todo
+	// Subtasks are those trashed or restored along with Todo.
+	Subtasks []Todo
+	// Removed are the occurrences deleted along with the completion that
+	// scheduled them.
+	Removed []int
+	// Reopened are the ancestors reopened along with the completion that
+	// auto-completed them.
+	Reopened []Todo
+	// Completion is what the revert completed in turn, as redoing a
+	// completion or trashing a last open subtask does.
+	Completion Completion
+}
+
+// UndoTarget is one change a batch token reverts: the one that brought
+// Todo to its version. Created marks a todo the request created, which
+// undo trashes instead.
+type UndoTarget struct {
+	Todo    Todo
+	Created bool
+}
+
+func (t todoRepository) IssueUndoToken(todo Todo, ttl time.Duration) (string, error) {
+	return t.issueUndoToken([]UndoTarget{{Todo: todo}}, false, ttl)
+}
+
+func (t todoRepository) IssueBatchUndoToken(targets []UndoTarget, ttl time.Duration) (string, error) {
+	return t.issueUndoToken(targets, true, ttl)
+}
+
+// issueUndoToken stores targets in the order the request applied them.
+func (t todoRepository) issueUndoToken(targets []UndoTarget, batch bool, ttl time.Duration) (string, error) {
+	b := make([]byte, 18)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	token := base64.RawURLEncoding.EncodeToString(b)
+	err := t.transaction(func(repo todoRepository) error {
+		_, err := repo.q.Exec(`
+			INSERT INTO todo_undo_tokens (token, batch, expires_at)
+			VALUES ($1, $2, CURRENT_TIMESTAMP + make_interval(secs => $3::double precision))`,
+			token, batch, ttl.Seconds())
+		if err != nil {
+			return err
+		}
+		for i, target := range targets {
+			_, err := repo.q.Exec(`
+				INSERT INTO todo_undo_targets (token, position, todo_id, version, created)
+				VALUES ($1, $2, $3, $4, $5)`,
+				token, i, target.Todo.ID, target.Todo.Version, target.Created)
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	return token, err
+}
+
+func (t todoRepository) PurgeUndoTokens() (int64, error) {
+	res, err := t.q.Exec("DELETE FROM todo_undo_tokens WHERE expires_at < CURRENT_TIMESTAMP")
+	if err != nil {
+		return 0, err
+	}
+	return res.RowsAffected()
+}
+
+// Undo reverts the targets of a token newest first. A request may have
+// changed a todo more than once, so after the first revert of a todo the
+// next one is guarded by the version that revert produced.
+func (t todoRepository) Undo(token string) ([]UndoResult, bool, error) {
+	var (
+		results []UndoResult
+		batch   bool
+	)
+	err := t.transaction(func(repo todoRepository) error {
+		err := sqlx.Get(repo.q, &batch, `
+			UPDATE todo_undo_tokens SET used_at = CURRENT_TIMESTAMP
+			WHERE token = $1 AND used_at IS NULL AND expires_at > CURRENT_TIMESTAMP
+			RETURNING batch`, token)
+		if errors.Is(err, sql.ErrNoRows) {
+			return ErrUndoTokenInvalid
+		}
+		if err != nil {
+			return err
+		}
+
+		var targets []struct {
+			TodoID  int   `db:"todo_id"`
+			Version int64 `db:"version"`
+			Created bool  `db:"created"`
+		}
+		err = sqlx.Select(repo.q, &targets, `
+			SELECT todo_id, version, created FROM todo_undo_targets
+			WHERE token = $1 ORDER BY position DESC`, token)
+		if err != nil {
+			return err
+		}
+		if len(targets) == 0 {
+			return ErrUndoTokenInvalid
+		}
+
+		reverted := make(map[int]int64)
+		for _, target := range targets {
+			pre := Precondition{Versions: []int64{target.Version}}
+			if version, ok := reverted[target.TodoID]; ok {
+				pre.Versions = []int64{version}
+			}
+
+			var result UndoResult
+			if target.Created {
+				todo, subtasks, completion, err := repo.DeleteTodo(target.TodoID, pre)
+				if err != nil {
+					return undoError(err)
+				}
+				result = UndoResult{Action: ActionTrashed, Todo: todo, Subtasks: subtasks, Completion: completion}
+			} else {
+				var event TodoEvent
+				err := sqlx.Get(repo.q, &event, `
+					SELECT `+todoEventColumns+` FROM todo_events
+					WHERE todo_id = $1 AND version = $2
+					ORDER BY id DESC LIMIT 1`, target.TodoID, target.Version)
+				if errors.Is(err, sql.ErrNoRows) {
+					return ErrUndoTokenInvalid
+				}
+				if err != nil {
+					return err
+				}
+				if result, err = repo.revert(event, pre); err != nil {
+					return undoError(err)
+				}
+			}
+			reverted[target.TodoID] = result.Todo.Version
+			results = append(results, result)
+		}
+		return nil
+	})
+	return results, batch, err
+}
+
+// undoError reports the errors of a guarded revert as a conflict.
+func undoError(err error) error {
+	switch {
+	case errors.Is(err, ErrPreconditionFailed), errors.Is(err, ErrTodoNotFound), errors.Is(err, ErrTodoNotTrashed),
+		errors.Is(err, ErrBlockerNotFound), errors.Is(err, ErrDependencyCycle):
+		return ErrUndoConflict
+	}
+	return err
+}
+
+// revert applies the inverse of event, guarded by pre so it only succeeds
+// if nothing has touched the todo since. The ancestors the change
+// auto-completed are reopened with it.
+func (t todoRepository) revert(event TodoEvent, pre Precondition) (UndoResult, error) {
+	result, err := t.revertTodo(event, pre)
+	if err != nil {
+		return result, err
+	}
+	return result, t.reopenAncestors(event, &result)
+}
+
+// revertTodo is revert for the todo of event alone.
+func (t todoRepository) revertTodo(event TodoEvent, pre Precondition) (UndoResult, error) {
+	switch event.Action {
+	case ActionCreated, ActionRestored:
+		todo, subtasks, completion, err := t.DeleteTodo(event.TodoID, pre)
+		return UndoResult{Action: ActionTrashed, Todo: todo, Subtasks: subtasks, Completion: completion}, err
+
+	case ActionTrashed:
+		todo, subtasks, err := t.RestoreTodo(event.TodoID, pre)
+		return UndoResult{Action: ActionRestored, Todo: todo, Subtasks: subtasks}, err
+
+	case ActionUpdated, ActionCompleted:
+		var changes struct {
+			Task *struct {
+				From *string `json:"from"`
+			} `json:"task"`
+			Done *struct {
+				From *bool `json:"from"`
+			} `json:"done"`
+			ParentID *struct {
+				From NullableID `json:"from"`
+			} `json:"parent_id"`
+			AutoComplete *struct {
+				From *bool `json:"from"`
+			} `json:"auto_complete"`
+			DueAt *struct {
+				From NullableTime `json:"from"`
+			} `json:"due_at"`
+			Recurrence *struct {
+				From *string `json:"from"`
+			} `json:"recurrence"`
+			Priority *struct {
+				From *int `json:"from"`
+			} `json:"priority"`
+			Position *struct {
+				From *string `json:"from"`
+			} `json:"position"`
+			Status *struct {
+				From *string `json:"from"`
+			} `json:"status"`
+			Tags *struct {
+				From *pq.StringArray `json:"from"`
+			} `json:"tags"`
+			Blockers *struct {
+				From []int `json:"from"`
+			} `json:"blockers"`
+		}
+		if err := json.Unmarshal(event.Changes, &changes); err != nil {
+			return UndoResult{}, err
+		}
+		if changes.Blockers != nil {
+			// Blocker changes are recorded on their own.
+			todo, err := t.revertBlockers(event.TodoID, pre, changes.Blockers.From)
+			return UndoResult{Action: ActionUpdated, Todo: todo}, err
+		}
+
+		var result UndoResult
+		if changes.Done != nil && changes.Done.From != nil && !*changes.Done.From {
+			removed, err := t.unscheduleNext(event.TodoID)
+			if err != nil {
+				return result, err
+			}
+			result.Removed = removed
+		}
+
+		update := TodoUpdate{SkipWorkflow: true}
+		if changes.Task != nil {
+			update.Task = changes.Task.From
+		}
+		if changes.Status != nil {
+			// The status sets done as well.
+			update.Status = changes.Status.From
+		} else if changes.Done != nil {
+			update.Done = changes.Done.From
+		}
+		if changes.ParentID != nil {
+			update.ParentID = changes.ParentID.From
+		}
+		if changes.AutoComplete != nil {
+			update.AutoComplete = changes.AutoComplete.From
+		}
+		if changes.DueAt != nil {
+			update.DueAt = changes.DueAt.From
+		}
+		if changes.Recurrence != nil {
+			update.Recurrence = changes.Recurrence.From
+		}
+		if changes.Priority != nil {
+			update.Priority = changes.Priority.From
+		}
+		if changes.Position != nil {
+			update.Position = changes.Position.From
+		}
+		if changes.Tags != nil {
+			update.Tags = changes.Tags.From
+		}
+		todo, completion, err := t.UpdateTodo(event.TodoID, pre, update)
+		result.Action, result.Todo, result.Completion = ActionUpdated, todo, completion
+		return result, err
+
+	default:
+		return UndoResult{}, fmt.Errorf("cannot undo %q", event.Action)
+	}
+}
+
+// reopenAncestors reverts the completion of each ancestor event records
+// completing, guarded by the version that completion gave it, and adds
+// them and their removed occurrences to result.
+func (t todoRepository) reopenAncestors(event TodoEvent, result *UndoResult) error {
+	var changes struct {
+		CompletedAncestors *struct {
+			To []completedAncestor `json:"to"`
+		} `json:"completed_ancestors"`
+	}
+	if err := json.Unmarshal(event.Changes, &changes); err != nil {
+		return err
+	}
+	if changes.CompletedAncestors == nil {
+		return nil
+	}
+	for _, ancestor := range changes.CompletedAncestors.To {
+		removed, err := t.unscheduleNext(ancestor.ID)
+		if err != nil {
+			return err
+		}
+		open := false
+		pre := Precondition{Versions: []int64{ancestor.Version}}
+		todo, _, err := t.UpdateTodo(ancestor.ID, pre, TodoUpdate{Done: &open, SkipWorkflow: true})
+		if err != nil {
+			return err
+		}
+		result.Removed = append(result.Removed, removed...)
+		result.Reopened = append(result.Reopened, todo)
+	}
+	return nil
+}
@@ -13,6 +13,6 @@ IMAGE_SERVICE_NAME="sakuheinonen/image-service"
 TODO_SERVICE_NAME="sakuheinonen/todo-service"
 
 docker build -t ${FRONTEND_IMAGE_NAME}:${TAG} ./frontend && docker push ${FRONTEND_IMAGE_NAME}:${TAG}
-docker build -t ${IMAGE_SERVICE_NAME}:${TAG} ./backend/image-todo-service/ && docker push ${IMAGE_SERVICE_NAME}:${TAG}
-docker build -t ${TODO_SERVICE_NAME}:${TAG} ./backend/todo-service/ && docker push ${TODO_SERVICE_NAME}:${TAG}
+docker build -t ${IMAGE_SERVICE_NAME}:${TAG} -f ./backend/image-service/Dockerfile ./backend && docker push ${IMAGE_SERVICE_NAME}:${TAG}
+docker build -t ${TODO_SERVICE_NAME}:${TAG} -f ./backend/todo-service/Dockerfile ./backend && docker push ${TODO_SERVICE_NAME}:${TAG}
 